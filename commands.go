@@ -0,0 +1,676 @@
+//
+// dwasm subcommands
+//
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"wasm"
+)
+
+
+// Print the list of commands, or the options of a single command
+func helpCommand(command Command, args []string) error {
+	flags := command.flagSet()
+	err := command.parse(flags, args, 0, true)
+	if (err != nil) {
+		return err
+	}
+	if (flags.NArg() == 0) {
+		usage()
+		return nil
+	}
+
+	target, ok := commands[flags.Arg(0)]
+	if !ok {
+		return fail(exitUsage, "Unknown command '%s'", flags.Arg(0))
+	}
+	return target.run(target, []string{ "-h" })
+}
+
+
+//
+// Inspection commands
+//
+
+func dumpCommand(command Command, args []string) error {
+	flags := command.flagSet()
+	format := formatFlag(flags)
+	err := command.parse(flags, args, 1, false)
+	if (err != nil) {
+		return err
+	}
+	module, err := loadModule(flags.Arg(0))
+	if (err != nil) {
+		return reportFailure(*format, flags.Arg(0), err)
+	}
+	if (*format == formatJSON) {
+		return writeJSON(module.Describe())
+	}
+	return writeOutput(func(w io.Writer) error {
+		_, err := fmt.Fprint(w, module)
+		return err
+	})
+}
+
+func inspectCommand(command Command, args []string) error {
+	flags := command.flagSet()
+	format := formatFlag(flags)
+	err := command.parse(flags, args, 1, false)
+	if (err != nil) {
+		return err
+	}
+	module, err := loadModule(flags.Arg(0))
+	if (err != nil) {
+		return reportFailure(*format, flags.Arg(0), err)
+	}
+	if (*format == formatJSON) {
+		return writeJSON(module.Inspect())
+	}
+	return writeOutput(func(w io.Writer) error {
+		_, err := fmt.Fprint(w, module.Inspect())
+		return err
+	})
+}
+
+func disasmCommand(command Command, args []string) error {
+	flags := command.flagSet()
+	err := command.parse(flags, args, 1, false)
+	if (err != nil) {
+		return err
+	}
+	module, err := loadModule(flags.Arg(0))
+	if (err != nil) {
+		return err
+	}
+	return writeOutput(module.Disassemble)
+}
+
+func watCommand(command Command, args []string) error {
+	flags := command.flagSet()
+	folded := flags.Bool("folded", false, "Fold block, loop + if instructions")
+	err := command.parse(flags, args, 1, false)
+	if (err != nil) {
+		return err
+	}
+	module, err := loadModule(flags.Arg(0))
+	if (err != nil) {
+		return err
+	}
+
+	style := wasm.WATFlat
+	if (*folded) {
+		style = wasm.WATFolded
+	}
+	return writeOutput(func(w io.Writer) error {
+		return module.WriteWAT(w, style)
+	})
+}
+
+//
+// Silent on success, like other validators; the exit status is the result.
+// The JSON report also covers modules that fail to decode
+//
+func validateCommand(command Command, args []string) error {
+	flags := command.flagSet()
+	format := formatFlag(flags)
+	err := command.parse(flags, args, 1, false)
+	if (err != nil) {
+		return err
+	}
+	report := validationReport{ File: flags.Arg(0), Errors: []diagnostic{} }
+	module, err := loadModule(flags.Arg(0))
+	var cliErr CLIError
+	if (err != nil && (*format != formatJSON || !errors.As(err, &cliErr) ||
+		cliErr.status != exitMalformed)) {
+		return reportFailure(*format, flags.Arg(0), err)
+	} else if (err != nil) {
+		report.Errors = append(report.Errors, diagnose("malformed", err))
+	} else {
+		err = module.Validate()
+		if (err != nil) {
+			report.Errors = append(report.Errors, diagnose("invalid", err))
+			err = fail(exitInvalid, "Module validation failed: %w", err)
+		}
+	}
+
+	if (*format == formatJSON) {
+		report.Valid = (err == nil)
+		writeErr := writeJSON(report)
+		if (writeErr != nil) {
+			return writeErr
+		} else if (errors.As(err, &cliErr)) {
+			return CLIError{ cliErr.status, reportedError{ cliErr.err } }
+		}
+	}
+	return err
+}
+
+
+//
+// Transformation commands
+//
+
+func assembleCommand(command Command, args []string) error {
+	flags := command.flagSet()
+	output := flags.String("o", "",
+		"Output `file` (default: the input, with a .wasm extension)")
+	err := command.parse(flags, args, 1, false)
+	if (err != nil) {
+		return err
+	}
+	input := flags.Arg(0)
+	if (!strings.HasSuffix(input, ".wat")) {
+		return fail(exitUsage, "%s: expected a .wat source", input)
+	}
+	module, err := loadModule(input)
+	if (err != nil) {
+		return err
+	}
+
+	if (*output == "") {
+		*output = strings.TrimSuffix(input, ".wat") + ".wasm"
+	}
+	return writeModule(*output, module)
+}
+
+func stripCommand(command Command, args []string) error {
+	flags := command.flagSet()
+	output := flags.String("o", "", "Output `file` (default: the input)")
+	var keep []string
+	flags.Func("keep", "Retain the custom section with this `name`",
+		func(name string) error {
+			keep = append(keep, name)
+			return nil
+		})
+	err := command.parse(flags, args, 1, false)
+	if (err != nil) {
+		return err
+	}
+	input := flags.Arg(0)
+	module, err := loadModule(input)
+	if (err != nil) {
+		return err
+	}
+
+	// Binaries are stripped in place by default, but never overwrite source
+	if (*output == "") {
+		if (strings.HasSuffix(input, ".wat")) {
+			return fail(exitUsage, "%s: output file required", input)
+		}
+		*output = input
+	}
+	return writeModule(*output, module.Strip(keep...))
+}
+
+
+//
+// Execution commands
+//
+
+// VM options shared by the execution commands
+func executionFlags(flags *flag.FlagSet, config *wasm.VMConfig) {
+	flags.StringVar(&config.StartFn, "f", "", "Start/entry `function`")
+	flags.Uint64Var(&config.MaxInstructions, "max-instructions", 0,
+		"Stop after executing `n` instructions (default: unlimited)")
+}
+
+//
+// Typed arguments of the start function, following the module, e.g.
+// "dwasm run -f fac input.wasm -- 5".  An optional "--" separates the
+// arguments from the module, so that negative values are not taken as flags
+//
+func invocationArgs(flags *flag.FlagSet, module wasm.Module,
+	config wasm.VMConfig) ([]interface{}, error) {
+	args := flags.Args()[1:]
+	if (len(args) > 0 && args[0] == "--") {
+		args = args[1:]
+	}
+	values, err := module.ParseArguments(config.StartFn, args)
+	if (errors.Is(err, wasm.MissingFunction)) {
+		return nil, fail(exitRuntime, "VM error: %s '%s'", err, config.StartFn)
+	} else if (err != nil) {
+		return nil, fail(exitUsage, "%s", err)
+	}
+	return values, nil
+}
+
+//
+// Execution tracing options, e.g. "-trace out.jsonl -trace-format json"
+//
+type traceOptions struct {
+	file		string
+	format		string
+	functions	[]string
+	maxLines	uint64
+}
+
+func traceFlags(flags *flag.FlagSet) *traceOptions {
+	options := &traceOptions{}
+	flags.StringVar(&options.file, "trace", "",
+		"Log each instruction executed to this `file` (- for stderr)")
+	flags.StringVar(&options.format, "trace-format", formatText,
+		"Trace `format`: text or json (JSON Lines)")
+	flags.Func("trace-function", "Only trace this `function` (repeatable)",
+		func(name string) error {
+			options.functions = append(options.functions, name)
+			return nil
+		})
+	flags.Uint64Var(&options.maxLines, "trace-max-lines", 0,
+		"Stop tracing after `n` lines (default: unlimited)")
+	return options
+}
+
+//
+// Create the tracer, if any, plus a function for closing the trace once
+// execution is complete
+//
+func (options *traceOptions) tracer(module wasm.Module) (*wasm.Tracer,
+	func() error, error) {
+	if (options.file == "") {
+		return nil, func() error { return nil }, nil
+	}
+
+	format := wasm.TraceText
+	switch(options.format) {
+		case formatText:
+		case formatJSON:	format = wasm.TraceJSON
+		default:
+			return nil, nil, fail(exitUsage, "Unknown trace format '%s'",
+				options.format)
+	}
+	functions := make([]uint32, len(options.functions))
+	for i, name := range options.functions {
+		function, err := module.FunctionIndex(name)
+		if (err != nil) {
+			return nil, nil, fail(exitUsage, "%s", err)
+		}
+		functions[i] = function
+	}
+
+	var output io.Writer = os.Stderr
+	var file *os.File
+	if (options.file != "-") {
+		var err error
+		file, err = os.Create(options.file)
+		if (err != nil) {
+			return nil, nil, fail(exitIO, "Unable to write %s: %s",
+				options.file, err)
+		}
+		output = file
+	}
+	writer := bufio.NewWriter(output)
+	tracer := wasm.CreateTracer(writer, format)
+	for _, function := range functions {
+		tracer.Filter(function)
+	}
+	tracer.SetMaxLines(options.maxLines)
+
+	done := func() error {
+		err := tracer.Err()
+		if (err == nil) {
+			err = writer.Flush()
+		}
+		if (file != nil) {
+			closeErr := file.Close()
+			if (err == nil) {
+				err = closeErr
+			}
+		}
+		if (err != nil) {
+			return fail(exitIO, "Unable to write trace: %s", err)
+		}
+		if (tracer.Truncated()) {
+			fmt.Fprintf(os.Stderr, "Trace truncated after %d lines\n",
+				tracer.Lines())
+		}
+		return nil
+	}
+	return tracer, done, nil
+}
+
+//
+// Profiling options, e.g. "-cpuprofile out.pb.gz -profile-mode instrument"
+//
+type profileOptions struct {
+	file		string
+	mode		string
+	interval	time.Duration
+}
+
+func profileFlags(flags *flag.FlagSet) *profileOptions {
+	options := &profileOptions{}
+	flags.StringVar(&options.file, "cpuprofile", "",
+		"Write a pprof profile of WASM call stacks to this `file`")
+	flags.StringVar(&options.mode, "profile-mode", "sample",
+		"Profile `mode`: sample, or instrument (exact, but slower)")
+	flags.DurationVar(&options.interval, "profile-interval",
+		wasm.DefaultProfileInterval, "Sampling `interval`")
+	return options
+}
+
+// Create the profiler, if any
+func (options *profileOptions) profiler() (*wasm.Profiler, error) {
+	if (options.file == "") {
+		return nil, nil
+	}
+	mode := wasm.ProfileSample
+	switch(options.mode) {
+		case "sample":
+		case "instrument":	mode = wasm.ProfileInstrument
+		default:
+			return nil, fail(exitUsage, "Unknown profile mode '%s'",
+				options.mode)
+	}
+	if (options.interval <= 0) {
+		return nil, fail(exitUsage, "Invalid profile interval %s",
+			options.interval)
+	}
+	profiler := wasm.CreateProfiler(mode)
+	profiler.SetInterval(options.interval)
+	return profiler, nil
+}
+
+// Write the profile, once execution is complete
+func (options *profileOptions) write(profiler *wasm.Profiler) error {
+	if (profiler == nil) {
+		return nil
+	}
+	file, err := os.Create(options.file)
+	if (err == nil) {
+		_, err = profiler.WriteTo(file)
+		closeErr := file.Close()
+		if (err == nil) {
+			err = closeErr
+		}
+	}
+	if (err != nil) {
+		return fail(exitIO, "Unable to write %s: %s", options.file, err)
+	}
+	return nil
+}
+
+//
+// Fuel metering options, e.g. "-fuel 100000 -fuel-cost i32.add=2"
+//
+type fuelOptions struct {
+	fuel		uint64
+	costs		map[string]uint64
+}
+
+func fuelFlags(flags *flag.FlagSet) *fuelOptions {
+	options := &fuelOptions{ costs: make(map[string]uint64) }
+	flags.Uint64Var(&options.fuel, "fuel", 0,
+		"Stop with an out-of-fuel trap after `n` units of fuel (default: " +
+		"unmetered)")
+	flags.Func("fuel-cost", "Set the cost of an instruction, as `name=n` " +
+		"(default 1; repeatable)",
+		func(text string) error {
+			name, cost, ok := strings.Cut(text, "=")
+			value, err := strconv.ParseUint(cost, 0, 64)
+			if (!ok || name == "" || err != nil) {
+				return errors.New("expected name=n")
+			}
+			options.costs[name] = value
+			return nil
+		})
+	return options
+}
+
+// Create the fuel meter, if any
+func (options *fuelOptions) meter() *wasm.Fuel {
+	if (options.fuel == 0) {
+		return nil
+	}
+	fuel := wasm.CreateFuel(options.fuel)
+	for name, cost := range options.costs {
+		fuel.SetCost(name, cost)
+	}
+	return fuel
+}
+
+// Warnings about any features of the module that the VM does not support
+func unsupportedWarnings(module wasm.Module) []string {
+	var warnings []string
+	for _, feature := range module.UnsupportedFeatures() {
+		warnings = append(warnings,
+			fmt.Sprintf("module uses unsupported feature '%s'", feature))
+	}
+	return warnings
+}
+
+// Describe a failed invocation, plus the call stack of any trap
+func invocationError(err error, trap wasm.Trap) error {
+	if (trap.Err == nil) {
+		return fail(exitRuntime, "VM error: %s", err)
+	}
+	var builder strings.Builder
+	for _, frame := range trap.Backtrace {
+		builder.WriteString("\n    at " + frame)
+	}
+	return fail(exitRuntime, "VM error: %s%s", trap, builder.String())
+}
+
+//
+// With -format=json, every failure is reported as JSON on stdout: either as
+// the trap of the invocation report, or as a failure report
+//
+func runCommand(command Command, args []string) (err error) {
+	flags := command.flagSet()
+	config := wasm.VMConfig{}
+	executionFlags(flags, &config)
+	format := formatFlag(flags)
+	trace := traceFlags(flags)
+	profile := profileFlags(flags)
+	fuel := fuelFlags(flags)
+	showStats := flags.Bool("stats", false,
+		"Report execution statistics (on stderr, unless -format=json)")
+	err = command.parse(flags, args, 1, true)
+	if (err != nil) {
+		return err
+	}
+	defer func() {
+		err = reportFailure(*format, flags.Arg(0), err)
+	}()
+
+	module, err := loadValidModule(flags.Arg(0))
+	if (err != nil) {
+		return err
+	}
+	warnings := unsupportedWarnings(module)
+	if (*format != formatJSON) {
+		for _, warning := range warnings {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+		}
+	}
+	values, err := invocationArgs(flags, module, config)
+	if (err != nil) {
+		return err
+	}
+	var closeTrace func() error
+	config.Tracer, closeTrace, err = trace.tracer(module)
+	if (err != nil) {
+		return err
+	}
+	config.Profiler, err = profile.profiler()
+	if (err != nil) {
+		return err
+	}
+	config.Fuel = fuel.meter()
+
+	var stats wasm.ExecutionStats
+	if (*showStats) {
+		config.Stats = &stats
+	}
+	var trap wasm.Trap
+	config.Trap = &trap
+
+	// Instantiate (running any start function), then invoke.  Results are
+	// printed one per line, in WAT literal form
+	var results []interface{}
+	instance, err := wasm.CreateInstance(module, config)
+	if (err == nil) {
+		results, err = instance.Invoke(config.StartFn, values)
+	}
+	traceErr := closeTrace()
+	if (traceErr != nil) {
+		return traceErr
+	}
+	profileErr := profile.write(config.Profiler)
+	if (profileErr != nil) {
+		return profileErr
+	}
+	var statistics *statsReport
+	if (*showStats) {
+		report := reportStats(stats, module.Names())
+		if (config.Fuel != nil) {
+			report.Fuel = &fuelReport{ config.Fuel.Consumed(),
+				config.Fuel.Remaining() }
+		}
+		statistics = &report
+	}
+	if (*format == formatJSON) {
+		report := invocationReport{ File: flags.Arg(0),
+			Function: config.StartFn, Args: typedValues(values),
+			Results: typedValues(results), Stats: statistics,
+			Warnings: warnings }
+		if (err != nil) {
+			report.Trap = diagnoseTrap(err, trap)
+		}
+		writeErr := writeJSON(report)
+		if (writeErr != nil) {
+			return writeErr
+		} else if (err != nil) {
+			return CLIError{ exitRuntime, reportedError{ err } }
+		}
+		return nil
+	}
+
+	if (statistics != nil) {
+		fmt.Fprint(os.Stderr, statistics)
+	}
+	if (err != nil) {
+		return invocationError(err, trap)
+	}
+	return writeOutput(func(w io.Writer) error {
+		for _, result := range results {
+			_, err := fmt.Fprintln(w, wasm.FormatValue(result))
+			if (err != nil) {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func benchCommand(command Command, args []string) error {
+	flags := command.flagSet()
+	config := wasm.VMConfig{}
+	executionFlags(flags, &config)
+	iterations := flags.Int("n", 1000, "Number of `iterations`")
+	err := command.parse(flags, args, 1, true)
+	if (err != nil) {
+		return err
+	}
+	if (*iterations <= 0) {
+		return fail(exitUsage, "bench: invalid iteration count %d",
+			*iterations)
+	}
+	module, err := loadValidModule(flags.Arg(0))
+	if (err != nil) {
+		return err
+	}
+	for _, warning := range unsupportedWarnings(module) {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+	values, err := invocationArgs(flags, module, config)
+	if (err != nil) {
+		return err
+	}
+
+	// Instantiated once, so globals persist across iterations
+	var trap wasm.Trap
+	config.Trap = &trap
+	instance, err := wasm.CreateInstance(module, config)
+	if (err != nil) {
+		return invocationError(err, trap)
+	}
+	start := time.Now()
+	for i := 0; i < *iterations; i++ {
+		_, err = instance.Invoke(config.StartFn, values)
+		if (err != nil) {
+			break
+		}
+	}
+	elapsed := time.Since(start)
+	if (err != nil) {
+		return invocationError(err, trap)
+	}
+
+	fmt.Printf("%s: %d iterations in %s, %s/op\n", config.StartFn,
+		*iterations, elapsed, elapsed / time.Duration(*iterations))
+	return nil
+}
+
+
+// Run each .wast script, reporting pass/fail per file
+func wastCommand(command Command, args []string) error {
+	flags := command.flagSet()
+	err := command.parse(flags, args, 1, true)
+	if (err != nil) {
+		return err
+	}
+
+	var passed, failed int
+	var failure error
+	for _, filename := range flags.Args() {
+		result, err := runScript(filename)
+		for _, description := range result.Failures {
+			fmt.Printf("%s: %s\n", filename, description)
+		}
+		if (err != nil) {
+			fmt.Printf("%s: %s\n", filename, err)
+			failure = err
+			continue
+		}
+		fmt.Printf("%s: %d passed, %d failed, %d skipped\n", filename,
+			result.Passed, result.Failed, result.Skipped)
+
+		passed += result.Passed
+		failed += result.Failed
+	}
+
+	if (flags.NArg() > 1) {
+		fmt.Printf("Total: %d passed, %d failed\n", passed, failed)
+	}
+	if (failure != nil) {
+		return failure
+	} else if (failed > 0) {
+		return fail(exitFailure, "%d spec test(s) failed", failed)
+	}
+	return nil
+}
+
+func runScript(filename string) (wasm.WASTResult, error) {
+	script, err := os.Open(filename)
+	if (err != nil) {
+		return wasm.WASTResult{}, fail(exitIO, "Unable to open %s: %s",
+			filename, err)
+	}
+	defer script.Close()
+
+	result, err := wasm.RunWAST(script)
+	if (errors.Is(err, wasm.InvalidWAT)) {
+		return result, CLIError{ exitMalformed, err }
+	}
+	return result, err
+}
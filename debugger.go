@@ -0,0 +1,295 @@
+//
+// Interactive step debugger: run a function, pausing at breakpoints + after
+// each step to inspect the thread state
+//
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"wasm"
+)
+
+
+// Session state, while paused
+type debugSession struct {
+	instance	*wasm.Instance
+	debugger	*wasm.Debugger
+	input		*lineReader
+	output		io.Writer
+	state		wasm.DebugState
+	last		string		// Previous command, repeated by an empty line
+}
+
+//
+// A single debugger action, e.g. "step".  Actions that resume execution
+// return the corresponding DebugAction; others return -1 to stay paused
+//
+type debugAction struct {
+	args	string
+	summary	string
+	run		func(session *debugSession, args []string) (wasm.DebugAction,
+				error)
+}
+
+const debugPaused = wasm.DebugAction(-1)
+
+var debugActions map[string]debugAction
+
+func init() {
+	debugActions = map[string]debugAction {
+		"backtrace":	{ "", "Show the call stack", (*debugSession).backtrace },
+		"break":		{ "<function>[+offset]", "Set a breakpoint",
+							(*debugSession).setBreakpoint },
+		"breakpoints":	{ "", "List the breakpoints",
+							(*debugSession).breakpoints },
+		"continue":		{ "", "Run until the next breakpoint",
+							resume(wasm.DebugContinue) },
+		"delete":		{ "<function>[+offset]", "Clear a breakpoint",
+							(*debugSession).clearBreakpoint },
+		"finish":		{ "", "Run until the current function returns",
+							resume(wasm.DebugStepOut) },
+		"help":			{ "", "List the commands", (*debugSession).help },
+		"locals":		{ "", "Show the locals of the current function",
+							(*debugSession).locals },
+		"memory":		{ "<offset> [length] [memory]",
+							"Hexdump a range of memory",
+							(*debugSession).memory },
+		"next":			{ "", "Step over calls", resume(wasm.DebugStepOver) },
+		"quit":			{ "", "Abort execution", resume(wasm.DebugAbort) },
+		"stack":		{ "", "Show the operand stack, top first",
+							(*debugSession).stack },
+		"step":			{ "", "Execute a single instruction",
+							resume(wasm.DebugStepInto) },
+	}
+}
+
+// Abbreviations, as in gdb
+var debugAliases = map[string]string {
+	"b":	"break",
+	"bt":	"backtrace",
+	"c":	"continue",
+	"n":	"next",
+	"q":	"quit",
+	"s":	"step",
+	"x":	"memory",
+}
+
+//
+// Usage: dwasm debug -f function [-b breakpoint ...] module [--] [args...].
+// Execution pauses at the first instruction, unless any breakpoints are set
+//
+func debugCommand(command Command, args []string) error {
+	flags := command.flagSet()
+	config := wasm.VMConfig{}
+	executionFlags(flags, &config)
+	var breakpoints []string
+	flags.Func("b", "Set a breakpoint at `function[+offset]`",
+		func(text string) error {
+			breakpoints = append(breakpoints, text)
+			return nil
+		})
+	historyFile := flags.String("history", defaultHistoryFile(),
+		"Save the command history to this `file`, if not empty")
+	err := command.parse(flags, args, 1, true)
+	if (err != nil) {
+		return err
+	}
+	module, err := loadValidModule(flags.Arg(0))
+	if (err != nil) {
+		return err
+	}
+	for _, warning := range unsupportedWarnings(module) {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+	values, err := invocationArgs(flags, module, config)
+	if (err != nil) {
+		return err
+	}
+
+	session := &debugSession{ output: os.Stdout,
+		input: createLineReader(os.Stdin, os.Stdout, *historyFile) }
+	session.debugger = wasm.CreateDebugger(session.pause)
+	for _, text := range breakpoints {
+		breakpoint, err := module.ParseBreakpoint(text)
+		if (err != nil) {
+			return fail(exitUsage, "%s", err)
+		}
+		session.debugger.SetBreakpoint(breakpoint)
+	}
+	if (len(breakpoints) > 0) {
+		session.debugger.Resume(wasm.DebugContinue)
+	}
+
+	// Any start function also runs under the debugger
+	config.Debugger = session.debugger
+	session.instance, err = wasm.CreateInstance(module, config)
+	if (err != nil) {
+		return fail(exitRuntime, "Unable to instantiate %s: %s", flags.Arg(0),
+			err)
+	}
+	results, err := session.instance.Invoke(config.StartFn, values)
+	if (err != nil) {
+		return fail(exitRuntime, "VM error: %s", err)
+	}
+	fmt.Fprintf(session.output, "%s returned\n", config.StartFn)
+	for _, result := range results {
+		fmt.Fprintf(session.output, "%s: %s\n", wasm.ValueTypeName(result),
+			wasm.FormatValue(result))
+	}
+	return nil
+}
+
+// Pause callback: show the location, then read commands until resumed
+func (session *debugSession) pause(state wasm.DebugState) wasm.DebugAction {
+	session.state = state
+	frame := state.Frames[0]
+	if (state.Breakpoint) {
+		fmt.Fprint(session.output, "Breakpoint, ")
+	}
+	fmt.Fprintf(session.output, "%s+%#x: %s\n", frame.Name, frame.Offset,
+		state.Instruction)
+
+	for {
+		line, err := session.input.read("(dwasm) ")
+		if (err == io.EOF) {
+			fmt.Fprintln(session.output)
+			return wasm.DebugAbort
+		} else if (err != nil) {
+			fmt.Fprintln(session.output, err)
+			continue
+		}
+		if (line == "") {
+			line = session.last
+		}
+		fields := strings.Fields(line)
+		if (len(fields) == 0) {
+			continue
+		}
+		session.last = line
+
+		name := fields[0]
+		if alias, ok := debugAliases[name]; ok {
+			name = alias
+		}
+		action, ok := debugActions[name]
+		if !ok {
+			fmt.Fprintf(session.output, "Unknown command '%s'\n", fields[0])
+			continue
+		}
+		next, err := action.run(session, fields[1:])
+		if (err != nil) {
+			fmt.Fprintln(session.output, err)
+		} else if (next != debugPaused) {
+			return next
+		}
+	}
+}
+
+func resume(next wasm.DebugAction) func(*debugSession, []string) (
+	wasm.DebugAction, error) {
+	return func(session *debugSession, args []string) (wasm.DebugAction,
+		error) {
+		return next, nil
+	}
+}
+
+
+//
+// Commands
+//
+
+func (session *debugSession) help(args []string) (wasm.DebugAction, error) {
+	names := make([]string, 0, len(debugActions))
+	for name := range debugActions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		action := debugActions[name]
+		fmt.Fprintf(session.output, "  %-34s %s\n",
+			strings.TrimSpace(name + " " + action.args), action.summary)
+	}
+	return debugPaused, nil
+}
+
+func (session *debugSession) backtrace(args []string) (wasm.DebugAction,
+	error) {
+	for i, frame := range session.state.Frames {
+		fmt.Fprintf(session.output, "  #%d  %s+%#x\n", i, frame.Name,
+			frame.Offset)
+	}
+	return debugPaused, nil
+}
+
+func (session *debugSession) stack(args []string) (wasm.DebugAction, error) {
+	if (len(session.state.Stack) == 0) {
+		fmt.Fprintln(session.output, "  (empty)")
+	}
+	for i, value := range session.state.Stack {
+		fmt.Fprintf(session.output, "  %d  %s: %s\n", i,
+			wasm.ValueTypeName(value), wasm.FormatValue(value))
+	}
+	return debugPaused, nil
+}
+
+func (session *debugSession) locals(args []string) (wasm.DebugAction, error) {
+	names := session.instance.Module().Names()
+	function := session.state.Frames[0].Function
+	for i, value := range session.state.Locals {
+		fmt.Fprintf(session.output, "  %d  %-12s %s: %s\n", i,
+			names.Local(function, uint32(i)), wasm.ValueTypeName(value),
+			wasm.FormatValue(value))
+	}
+	return debugPaused, nil
+}
+
+func (session *debugSession) memory(args []string) (wasm.DebugAction, error) {
+	return debugPaused, dumpMemory(session.output, session.instance, args)
+}
+
+
+//
+// Breakpoints
+//
+
+func (session *debugSession) setBreakpoint(args []string) (wasm.DebugAction,
+	error) {
+	breakpoint, err := session.breakpoint(args)
+	if (err == nil) {
+		session.debugger.SetBreakpoint(breakpoint)
+	}
+	return debugPaused, err
+}
+
+func (session *debugSession) clearBreakpoint(args []string) (wasm.DebugAction,
+	error) {
+	breakpoint, err := session.breakpoint(args)
+	if (err == nil) {
+		session.debugger.ClearBreakpoint(breakpoint)
+	}
+	return debugPaused, err
+}
+
+func (session *debugSession) breakpoint(args []string) (wasm.Breakpoint,
+	error) {
+	if (len(args) != 1) {
+		return wasm.Breakpoint{}, errors.New("expected <function>[+offset]")
+	}
+	return session.instance.Module().ParseBreakpoint(args[0])
+}
+
+func (session *debugSession) breakpoints(args []string) (wasm.DebugAction,
+	error) {
+	names := session.instance.Module().Names()
+	for _, breakpoint := range session.debugger.Breakpoints() {
+		fmt.Fprintf(session.output, "  %s+%#x\n",
+			names.Function(breakpoint.Function), breakpoint.Offset)
+	}
+	return debugPaused, nil
+}
@@ -5,116 +5,320 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"io"
 	"os"
-	"strconv"
+	"sort"
+	"strings"
 
 	"wasm"
 )
 
 
-// Top-level CLI configuration
-type CLIConfig struct {
-	//@logging level
-	//@disassemble?
+//
+// Exit status of the CLI, by category of failure, so that dwasm can be
+// scripted
+//
+const (
+	exitSuccess		= 0
+	exitFailure		= 1		// Command failed, e.g. spec test failures
+	exitUsage		= 2		// Invalid command line
+	exitIO			= 3		// Unable to read or write a file
+	exitMalformed	= 4		// Unable to decode or assemble the module
+	exitInvalid		= 5		// Module validation failed
+	exitRuntime		= 6		// Execution failed or trapped
+)
 
-	dumpSections	bool
-	execute			bool
-	filename		string //@list of files/modules
-	validate		bool
-	vm				wasm.VMConfig
+// Failure of a command, plus the corresponding exit status
+type CLIError struct {
+	status	int
+	err		error
 }
 
+func (e CLIError) Error() string {
+	return e.err.Error()
+}
 
-// Parse + return any CLI configuration.  No side effects.
-func initialize() CLIConfig {
-	var config = CLIConfig{}
+func (e CLIError) Unwrap() error {
+	return e.err
+}
 
-	// Describe all flags
-	flag.BoolVar(&config.dumpSections, "d", false, "Dump .wasm sections")
-	flag.StringVar(&config.vm.StartFn, "f", "",    "Start/entry `function`")
-	flag.BoolVar(&config.validate,     "v", false, "Validate .wasm sections")
-	flag.BoolVar(&config.execute,      "x", false, "Start VM + execute")
+// Factory function for generating a CLIError.  No side effects.
+func fail(status int, format string, args ...interface{}) error {
+	return CLIError{ status, fmt.Errorf(format, args...) }
+}
 
-	// Preload the thread with command-line args for easier testing
-	var stack []int32
-	flag.Func("p", "Preload int32 `value` on stack", func(arg string) error {
-		value, err := strconv.Atoi(arg)
-		if (err != nil) {
-			return err
-		}
-		stack = append(stack, int32(value))
-		return nil
-	})
+// Failure already reported on stdout (e.g., as JSON), so not on stderr
+type reportedError struct {
+	err		error
+}
+
+func (e reportedError) Error() string {
+	return e.err.Error()
+}
+
+func (e reportedError) Unwrap() error {
+	return e.err
+}
+
+// Help was explicitly requested, e.g. via "-h"
+var errHelp = errors.New("help requested")
+
+// Invalid command line, already described by the usage message
+var errUsage = errors.New("invalid command line")
+
+
+//
+// Subcommands, e.g. "dwasm validate".  Each command parses its own flags
+//
+type Command struct {
+	name	string
+	args	string		// Positional arguments, for usage messages
+	summary	string
+	run		func(command Command, args []string) error
+}
+
+var commands map[string]Command
 
-	// Custom usage message
-	flag.Usage = func() {
-		fmt.Fprintf(flag.CommandLine.Output(),
-			"Usage: %s [options] /path/to/input.wasm\n", os.Args[0])
-		flag.PrintDefaults()
-		os.Exit(1)
+func init() {
+	commands = map[string]Command {
+		"assemble":	{ "assemble", "/path/to/input.wat",
+					  "Assemble a .wat module into a .wasm binary",
+					  assembleCommand },
+		"bench":	{ "bench", "/path/to/input.{wasm,wat} [--] [args...]",
+					  "Execute a function repeatedly + report timing",
+					  benchCommand },
+		"debug":	{ "debug", "/path/to/input.{wasm,wat} [--] [args...]",
+					  "Execute a function in the step debugger",
+					  debugCommand },
+		"disasm":	{ "disasm", "/path/to/input.{wasm,wat}",
+					  "Disassemble all functions",
+					  disasmCommand },
+		"dump":		{ "dump", "/path/to/input.{wasm,wat}",
+					  "Dump all sections",
+					  dumpCommand },
+		"help":		{ "help", "[command]",
+					  "Describe a command",
+					  helpCommand },
+		"inspect":	{ "inspect", "/path/to/input.{wasm,wat}",
+					  "Summarize section sizes, contents + metadata",
+					  inspectCommand },
+		"repl":		{ "repl", "/path/to/input.{wasm,wat}",
+					  "Instantiate a module + explore it interactively",
+					  replCommand },
+		"run":		{ "run", "/path/to/input.{wasm,wat} [--] [args...]",
+					  "Start VM + execute a function",
+					  runCommand },
+		"strip":	{ "strip", "/path/to/input.{wasm,wat}",
+					  "Remove custom sections (names, debug info, etc)",
+					  stripCommand },
+		"validate":	{ "validate", "/path/to/input.{wasm,wat}",
+					  "Validate a module",
+					  validateCommand },
+		"wast":		{ "wast", "/path/to/script.wast ...",
+					  "Run spec test scripts",
+					  wastCommand },
+		"wat":		{ "wat", "/path/to/input.{wasm,wat}",
+					  "Print a module as WAT",
+					  watCommand },
 	}
+}
 
-	// Parse + validate any command-line arguments
-	flag.Parse()
-	if (flag.NArg() != 1) {
-		flag.Usage()
+// Print the list of commands
+func usage() {
+	output := flag.CommandLine.Output()
+	fmt.Fprintf(output, "Usage: %s <command> [options] [arguments]\n\n",
+		os.Args[0])
+	fmt.Fprintf(output, "Commands:\n")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(output, "  %-10s %s\n", name, commands[name].summary)
 	}
-	config.filename = flag.Args()[0]
-	config.vm.StartStack = stack
+	fmt.Fprintf(output, "\nUse \"%s help <command>\" for the options of "+
+		"each command\n", os.Args[0])
+}
 
-	return config
+// Flags of a single command, with a usage message covering its arguments
+func (command Command) flagSet() *flag.FlagSet {
+	flags := flag.NewFlagSet(command.name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s [options] %s\n\n%s\n",
+			os.Args[0], command.name, command.args, command.summary)
+		flags.PrintDefaults()
+	}
+	return flags
 }
 
+//
+// Parse the flags of a command, which requires at least the given number of
+// positional arguments, plus any more if variadic
+//
+func (command Command) parse(flags *flag.FlagSet, args []string, count int,
+	variadic bool) error {
+	err := flags.Parse(args)
+	if (err == flag.ErrHelp) {
+		return errHelp
+	} else if (err != nil) {
+		return CLIError{ exitUsage, errUsage }
+	}
+
+	if (flags.NArg() < count || (flags.NArg() > count && !variadic)) {
+		flags.Usage()
+		return CLIError{ exitUsage, errUsage }
+	}
+	return nil
+}
 
-func main() {
-	//
-	// Parse any CLI options
-	//
-	config := initialize()
 
-	//
-	// Load any modules
-	//
-	wasmfile, err := os.Open(config.filename)
+//
+// Decode or assemble a single module, based on its file extension.  WAT
+// sources retain their $identifiers as debug names
+//
+func loadModule(filename string) (wasm.Module, error) {
+	file, err := os.Open(filename)
 	if (err != nil) {
-		log.Fatalf("Unable to open %s: %s\n", config.filename, err)
+		return wasm.Module{}, fail(exitIO, "Unable to open %s: %s", filename,
+			err)
 	}
-	defer wasmfile.Close()
+	defer file.Close()
 
-	reader := bufio.NewReader(wasmfile)
-	module, err := wasm.ReadModule(reader)
+	var module wasm.Module
+	reader := bufio.NewReader(file)
+	if (strings.HasSuffix(filename, ".wat")) {
+		module, err = wasm.ReadWATWithOptions(reader,
+			wasm.WATOptions{ DebugNames: true })
+	} else {
+		module, err = wasm.ReadModule(reader)
+	}
+	if (err != nil) {
+		return module, fail(exitMalformed, "Unable to load module %s: %w",
+			filename, err)
+	}
+	return module, nil
+}
+
+// Load a module for execution, which requires that it be valid
+func loadValidModule(filename string) (wasm.Module, error) {
+	module, err := loadModule(filename)
+	if (err != nil) {
+		return module, err
+	}
+	err = module.Validate()
+	if (err != nil) {
+		return module, fail(exitInvalid, "Module validation failed: %w", err)
+	}
+	return module, nil
+}
+
+// Write the binary encoding of a module to the given file
+func writeModule(filename string, module wasm.Module) error {
+	file, err := os.Create(filename)
+	if (err != nil) {
+		return fail(exitIO, "Unable to write %s: %s", filename, err)
+	}
+	_, err = module.WriteTo(file)
+	if (err == nil) {
+		err = file.Close()
+	} else {
+		file.Close()
+	}
+	if (err != nil) {
+		return fail(exitIO, "Unable to write %s: %s", filename, err)
+	}
+	return nil
+}
+
+
+//
+// Output formats.  Commands with machine-readable output accept
+// "-format=json", in addition to the default text format
+//
+const (
+	formatText	= "text"
+	formatJSON	= "json"
+)
+
+func formatFlag(flags *flag.FlagSet) *string {
+	format := formatText
+	flags.Func("format", "Output `format`: text or json", func(arg string) error {
+		if (arg != formatText && arg != formatJSON) {
+			return fmt.Errorf("unknown format '%s'", arg)
+		}
+		format = arg
+		return nil
+	})
+	return &format
+}
+
+// Write a single JSON document, e.g. a module description
+func writeJSON(value interface{}) error {
+	return writeOutput(func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	})
+}
+
+// Write text output, e.g. WAT or disassembly
+func writeOutput(output func(io.Writer) error) error {
+	writer := bufio.NewWriter(os.Stdout)
+	err := output(writer)
+	if (err == nil) {
+		err = writer.Flush()
+	}
 	if (err != nil) {
-		log.Fatalf("Unable to load module %s: %s\n", config.filename, err)
+		return fail(exitIO, "Unable to write output: %s", err)
 	}
+	return nil
+}
 
+
+func main() {
 	//
-	// Dispatch any CLI options
+	// Locate the command, e.g. "dwasm validate ..."
 	//
-	if (config.dumpSections) {
-		log.Println(module)
+	if (len(os.Args) < 2) {
+		usage()
+		os.Exit(exitUsage)
 	}
-	if (config.validate) {
-		err = module.Validate()
-		if (err != nil) {
-			log.Fatalf("Module validation failed: %s\n", err)
-		}
+	name := os.Args[1]
+	if (name == "-h" || name == "-help" || name == "--help") {
+		usage()
+		os.Exit(exitSuccess)
+	}
+	command, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command '%s'\n\n", name)
+		usage()
+		os.Exit(exitUsage)
 	}
-	//@disassemble
-	if (config.execute) {
-		vm, err := wasm.CreateVM(config.vm)
-		if (err != nil) {
-			log.Fatalf("Unable to initialize VM: %s\n", err)
+
+	//
+	// Run the command, and map any failure to the corresponding exit status
+	//
+	err := command.run(command, os.Args[2:])
+	if (err == errHelp) {
+		os.Exit(exitSuccess)
+	} else if (err != nil) {
+		status := exitFailure
+		var cliErr CLIError
+		if (errors.As(err, &cliErr)) {
+			status = cliErr.status
 		}
-		err = vm.Execute(module, config.vm)
-		if (err == nil) {
-			log.Printf("VM exited cleanly")
-		} else {
-			log.Fatalf("VM error: %s\n", err)
+		var reported reportedError
+		if (!errors.Is(err, errUsage) && !errors.As(err, &reported)) {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
 		}
+		os.Exit(status)
 	}
 
-	return
+	os.Exit(exitSuccess)
 }
@@ -1,6 +1,6 @@
 module dwasm
 
-go 1.16
+go 1.18
 
 replace wasm => ./wasm
 require wasm v0.0.0
@@ -0,0 +1,440 @@
+//
+// Interactive REPL: instantiate a module once, then invoke its exports,
+// inspect + update its globals and memory, etc
+//
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"wasm"
+)
+
+
+// Number of bytes shown by "memory", if no length is given
+const defaultDumpLength = 128
+
+// Session state
+type repl struct {
+	instance	*wasm.Instance
+	input		*lineReader
+	output		io.Writer
+	done		bool
+}
+
+// A single REPL action, e.g. "invoke"
+type replAction struct {
+	args	string
+	summary	string
+	run		func(session *repl, args []string) error
+}
+
+var replActions map[string]replAction
+
+func init() {
+	replActions = map[string]replAction {
+		"exports":	{ "", "List the exports", (*repl).exports },
+		"get":		{ "<global>", "Show the value of a global", (*repl).get },
+		"globals":	{ "", "List all globals + their values", (*repl).globals },
+		"help":		{ "", "List the commands", (*repl).help },
+		"history":	{ "", "List the command history; !! or !<n> repeats " +
+						"a command", (*repl).history },
+		"imports":	{ "", "List the imports", (*repl).imports },
+		"invoke":	{ "<function> [args...]", "Invoke an exported function " +
+						"(globals persist; memory ops unsupported)",
+						(*repl).invoke },
+		"memory":	{ "<offset> [length] [memory]",
+						"Hexdump a range of memory", (*repl).memory },
+		"quit":		{ "", "Exit the REPL", (*repl).quit },
+		"reset":	{ "", "Instantiate the module again", (*repl).reset },
+		"set":		{ "<global> <value>", "Update a mutable global",
+						(*repl).set },
+	}
+}
+
+//
+// Usage: dwasm repl [options] module.  Globals may be named by export name,
+// $name or index
+//
+func replCommand(command Command, args []string) error {
+	flags := command.flagSet()
+	config := wasm.VMConfig{}
+	flags.Uint64Var(&config.MaxInstructions, "max-instructions", 0,
+		"Stop after executing `n` instructions (default: unlimited)")
+	historyFile := flags.String("history", defaultHistoryFile(),
+		"Save the command history to this `file`, if not empty")
+	err := command.parse(flags, args, 1, false)
+	if (err != nil) {
+		return err
+	}
+	module, err := loadValidModule(flags.Arg(0))
+	if (err != nil) {
+		return err
+	}
+	for _, warning := range unsupportedWarnings(module) {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+	instance, err := wasm.CreateInstance(module, config)
+	if (err != nil) {
+		return fail(exitRuntime, "Unable to instantiate %s: %s", flags.Arg(0),
+			err)
+	}
+
+	session := &repl{ instance: instance, output: os.Stdout,
+		input: createLineReader(os.Stdin, os.Stdout, *historyFile) }
+	fmt.Printf("Instantiated %s; \"help\" lists the commands\n", flags.Arg(0))
+	return session.run()
+}
+
+// Read + execute commands until "quit" or end of input
+func (session *repl) run() error {
+	for !session.done {
+		line, err := session.input.read("dwasm> ")
+		if (err == io.EOF) {
+			fmt.Fprintln(session.output)
+			break
+		} else if (err != nil) {
+			fmt.Fprintln(session.output, err)
+			continue
+		}
+		fields := strings.Fields(line)
+		if (len(fields) == 0) {
+			continue
+		}
+
+		action, ok := replActions[fields[0]]
+		if !ok {
+			fmt.Fprintf(session.output, "Unknown command '%s'\n", fields[0])
+			continue
+		}
+		err = action.run(session, fields[1:])
+		if (err != nil) {
+			fmt.Fprintln(session.output, err)
+		}
+	}
+	return nil
+}
+
+
+//
+// Commands
+//
+
+func (session *repl) help(args []string) error {
+	names := make([]string, 0, len(replActions))
+	for name := range replActions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		action := replActions[name]
+		fmt.Fprintf(session.output, "  %-34s %s\n",
+			strings.TrimSpace(name + " " + action.args), action.summary)
+	}
+	return nil
+}
+
+func (session *repl) quit(args []string) error {
+	session.done = true
+	return nil
+}
+
+func (session *repl) history(args []string) error {
+	for i, line := range session.input.history {
+		fmt.Fprintf(session.output, "%5d  %s\n", i + 1, line)
+	}
+	return nil
+}
+
+func (session *repl) reset(args []string) error {
+	return session.instance.Reset()
+}
+
+func (session *repl) exports(args []string) error {
+	for _, export := range session.instance.Module().Describe().Exports {
+		fmt.Fprintf(session.output, "  %-8s %d  %s\n", export.Kind,
+			export.Index, export.Name)
+	}
+	return nil
+}
+
+func (session *repl) imports(args []string) error {
+	for _, imp := range session.instance.Module().Describe().Imports {
+		fmt.Fprintf(session.output, "  %-8s %s.%s\n", imp.Kind, imp.Module,
+			imp.Name)
+	}
+	return nil
+}
+
+// Arguments are parsed according to the function signature
+func (session *repl) invoke(args []string) error {
+	if (len(args) == 0) {
+		return errors.New("usage: invoke <function> [args...]")
+	}
+	module := session.instance.Module()
+	values, err := module.ParseArguments(args[0], args[1:])
+	if (err != nil) {
+		return err
+	}
+	results, err := session.instance.Invoke(args[0], values)
+	if (err != nil) {
+		return fmt.Errorf("VM error: %w", err)
+	}
+	for _, result := range results {
+		fmt.Fprintf(session.output, "%s: %s\n", wasm.ValueTypeName(result),
+			wasm.FormatValue(result))
+	}
+	return nil
+}
+
+
+//
+// Globals
+//
+
+func (session *repl) get(args []string) error {
+	if (len(args) != 1) {
+		return errors.New("usage: get <global>")
+	}
+	index, err := session.instance.GlobalIndex(args[0])
+	if (err != nil) {
+		return err
+	}
+	return session.showGlobal(index)
+}
+
+func (session *repl) set(args []string) error {
+	if (len(args) < 2) {
+		return errors.New("usage: set <global> <value>")
+	}
+	index, err := session.instance.GlobalIndex(args[0])
+	if (err != nil) {
+		return err
+	}
+	vtype, _, err := session.instance.GlobalType(index)
+	if (err != nil) {
+		return err
+	}
+
+	// v128 values span several fields, e.g. "i32x4 1 2 3 4"
+	value, err := wasm.ParseValue(vtype, strings.Join(args[1:], " "))
+	if (err != nil) {
+		return err
+	}
+	return session.instance.SetGlobal(index, value)
+}
+
+func (session *repl) globals(args []string) error {
+	for i := 0; i < session.instance.Globals(); i++ {
+		err := session.showGlobal(uint32(i))
+		if (err != nil) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (session *repl) showGlobal(index uint32) error {
+	value, err := session.instance.Global(index)
+	if (err != nil) {
+		return err
+	}
+	vtype, mutable, err := session.instance.GlobalType(index)
+	if (err != nil) {
+		return err
+	}
+	kind := wasm.TypeMap[int(vtype)]
+	if (mutable) {
+		kind = "mut " + kind
+	}
+	fmt.Fprintf(session.output, "  %d  %-20s %-8s %s\n", index,
+		session.instance.Module().Names().Global(index), kind,
+		wasm.FormatValue(value))
+	return nil
+}
+
+
+//
+// Memory
+//
+
+func (session *repl) memory(args []string) error {
+	return dumpMemory(session.output, session.instance, args)
+}
+
+// Hexdump a range of memory, given the offset + optional length and memory
+func dumpMemory(w io.Writer, instance *wasm.Instance, args []string) error {
+	if (len(args) < 1 || len(args) > 3) {
+		return errors.New("usage: memory <offset> [length] [memory]")
+	}
+	numbers := []uint64{ 0, defaultDumpLength, 0 }
+	for i, arg := range args {
+		value, err := strconv.ParseUint(arg, 0, 32)
+		if (err != nil) {
+			return fmt.Errorf("invalid number '%s'", arg)
+		}
+		numbers[i] = value
+	}
+
+	offset := uint32(numbers[0])
+	data, err := instance.ReadMemory(uint32(numbers[2]), offset,
+		uint32(numbers[1]))
+	if (err != nil) {
+		return err
+	}
+	hexdump(w, offset, data)
+	return nil
+}
+
+// Print a range of memory, 16 bytes per line, with offsets + ASCII
+func hexdump(w io.Writer, offset uint32, data []byte) {
+	for start := 0; start < len(data); start += 16 {
+		end := start + 16
+		if (end > len(data)) {
+			end = len(data)
+		}
+		line := data[start:end]
+
+		var ascii strings.Builder
+		for _, b := range line {
+			if (b >= 0x20 && b < 0x7F) {
+				ascii.WriteByte(b)
+			} else {
+				ascii.WriteByte('.')
+			}
+		}
+		fmt.Fprintf(w, "%08x  %-47s  |%s|\n", offset + uint32(start),
+			fmt.Sprintf("% x", line), ascii.String())
+	}
+}
+
+
+//
+// Line input with history, like readline: "!!" repeats the previous command,
+// and "!<n>" repeats command n.  The history is saved across sessions
+//
+type lineReader struct {
+	scanner		*bufio.Scanner
+	output		io.Writer
+	history		[]string
+	file		string		// History file, if any
+}
+
+// Upper bound on the commands in the history (+ history file)
+const maxHistory = 1000
+
+// Factory function for generating a lineReader, including any saved history
+func createLineReader(input io.Reader, output io.Writer,
+	file string) *lineReader {
+	reader := &lineReader{ scanner: bufio.NewScanner(input), output: output,
+		file: file }
+	if (file == "") {
+		return reader
+	}
+	saved, err := os.ReadFile(file)
+	if (err == nil) {
+		for _, line := range strings.Split(string(saved), "\n") {
+			if (line != "") {
+				reader.history = append(reader.history, line)
+			}
+		}
+	}
+
+	// Discard the oldest commands of an overlong file
+	if (len(reader.history) > maxHistory) {
+		reader.history = reader.history[len(reader.history) - maxHistory:]
+		reader.rewrite()
+	}
+	return reader
+}
+
+// Default history file, in the home directory
+func defaultHistoryFile() string {
+	home, err := os.UserHomeDir()
+	if (err != nil) {
+		return ""
+	}
+	return filepath.Join(home, ".dwasm_history")
+}
+
+// Read the next line, expanding any history reference
+func (reader *lineReader) read(prompt string) (string, error) {
+	fmt.Fprint(reader.output, prompt)
+	if (!reader.scanner.Scan()) {
+		err := reader.scanner.Err()
+		if (err == nil) {
+			err = io.EOF
+		}
+		return "", err
+	}
+	line := strings.TrimSpace(reader.scanner.Text())
+
+	if (strings.HasPrefix(line, "!")) {
+		expanded, err := reader.expand(line)
+		if (err != nil) {
+			return "", err
+		}
+		fmt.Fprintln(reader.output, expanded)
+		line = expanded
+	}
+
+	// Record each command, but not immediate repeats.  Once full, the
+	// oldest command is discarded, which renumbers the rest
+	count := len(reader.history)
+	if (line != "" && (count == 0 || reader.history[count - 1] != line)) {
+		reader.history = append(reader.history, line)
+		if (len(reader.history) > maxHistory) {
+			reader.history = reader.history[1:]
+			reader.rewrite()
+		} else {
+			reader.save(line)
+		}
+	}
+	return line, nil
+}
+
+func (reader *lineReader) expand(line string) (string, error) {
+	count := len(reader.history)
+	if (line == "!!" && count > 0) {
+		return reader.history[count - 1], nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if (err != nil || n < 1 || n > count) {
+		return "", fmt.Errorf("%s: event not found", line)
+	}
+	return reader.history[n - 1], nil
+}
+
+// Append a single line to the history file.  Failures are not fatal
+func (reader *lineReader) save(line string) {
+	if (reader.file == "") {
+		return
+	}
+	file, err := os.OpenFile(reader.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY,
+		0600)
+	if (err != nil) {
+		return
+	}
+	fmt.Fprintln(file, line)
+	file.Close()
+}
+
+// Replace the history file with the current history.  Failures are not fatal
+func (reader *lineReader) rewrite() {
+	if (reader.file == "") {
+		return
+	}
+	var builder strings.Builder
+	for _, line := range reader.history {
+		builder.WriteString(line + "\n")
+	}
+	os.WriteFile(reader.file, []byte(builder.String()), 0600)
+}
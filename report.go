@@ -0,0 +1,234 @@
+//
+// Machine-readable (JSON) reports, for "-format=json".  Field names are
+// stable, so that other tools can consume dwasm output
+//
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"wasm"
+)
+
+
+// A single decoding, assembly or validation failure
+type diagnostic struct {
+	Kind		string	`json:"kind"`		// "malformed" or "invalid"
+	Message		string	`json:"message"`
+	Section		*int	`json:"section,omitempty"`
+	Offset		*int64	`json:"offset,omitempty"`
+	Function	*uint32	`json:"function,omitempty"`
+	Name		string	`json:"name,omitempty"`
+	Line		int		`json:"line,omitempty"`		// WAT sources only
+	Column		int		`json:"column,omitempty"`
+	Backtrace	[]string	`json:"backtrace,omitempty"`	// Traps only
+}
+
+// Describe a failure, including its location, if known
+func diagnose(kind string, err error) diagnostic {
+	result := diagnostic{ Kind: kind, Message: err.Error() }
+
+	var decodeErr wasm.DecodeError
+	var validationErr wasm.ValidationError
+	var watErr wasm.WATError
+	if (errors.As(err, &decodeErr)) {
+		result.Message = fmt.Sprintf("%s: %s", decodeErr.Reason, decodeErr.Err)
+		if (decodeErr.Section >= 0) {
+			result.Section = &decodeErr.Section
+		}
+		result.Offset = &decodeErr.Offset
+	} else if (errors.As(err, &validationErr)) {
+		offset := int64(validationErr.Offset)
+		result.Message = validationErr.Reason
+		result.Function = &validationErr.Function
+		result.Offset = &offset
+		result.Name = validationErr.Name
+	} else if (errors.As(err, &watErr)) {
+		result.Message = watErr.Reason
+		result.Line = watErr.Line
+		result.Column = watErr.Column
+	}
+	return result
+}
+
+//
+// Describe a trap, including its location + call stack, if known (i.e., if
+// the VM recorded the trap)
+//
+func diagnoseTrap(err error, trap wasm.Trap) *diagnostic {
+	result := diagnostic{ Kind: "trap", Message: err.Error() }
+	if (trap.Err != nil) {
+		offset := int64(trap.Offset)
+		result.Message = trap.Err.Error()
+		result.Function = &trap.Function
+		result.Offset = &offset
+		result.Name = trap.Name
+		result.Backtrace = trap.Backtrace
+	}
+	return &result
+}
+
+// Any other failure of a command, in place of its usual report
+type failureReport struct {
+	File		string		`json:"file"`
+	Status		int			`json:"status"`
+	Error		diagnostic	`json:"error"`
+}
+
+// Kind of diagnostic, by exit status
+var failureKinds = map[int]string {
+	exitFailure:	"failure",
+	exitUsage:		"usage",
+	exitIO:			"io",
+	exitMalformed:	"malformed",
+	exitInvalid:	"invalid",
+	exitRuntime:	"runtime",
+}
+
+//
+// With -format=json, report a failure as a JSON document on stdout, rather
+// than as text on stderr.  Failures that were already reported (e.g., as a
+// trap in an invocation report) are returned as-is
+//
+func reportFailure(format string, file string, err error) error {
+	var reported reportedError
+	if (err == nil || format != formatJSON || errors.Is(err, errHelp) ||
+		errors.Is(err, errUsage) || errors.As(err, &reported)) {
+		return err
+	}
+	status := exitFailure
+	var cliErr CLIError
+	if (errors.As(err, &cliErr)) {
+		status = cliErr.status
+		err = cliErr.err
+	}
+	writeErr := writeJSON(failureReport{ file, status,
+		diagnose(failureKinds[status], err) })
+	if (writeErr != nil) {
+		return writeErr
+	}
+	return CLIError{ status, reportedError{ err } }
+}
+
+type validationReport struct {
+	File		string			`json:"file"`
+	Valid		bool			`json:"valid"`
+	Errors		[]diagnostic	`json:"errors"`
+}
+
+// A single argument or result, in WAT literal form, e.g. "nan:0x1"
+type typedValue struct {
+	Type	string	`json:"type"`
+	Value	string	`json:"value"`
+}
+
+func typedValues(values []interface{}) []typedValue {
+	typed := make([]typedValue, len(values))
+	for i, value := range values {
+		typed[i] = typedValue{ wasm.ValueTypeName(value),
+			wasm.FormatValue(value) }
+	}
+	return typed
+}
+
+// Results of a single invocation, or the trap that ended it
+type invocationReport struct {
+	File		string			`json:"file"`
+	Function	string			`json:"function"`
+	Args		[]typedValue	`json:"args"`
+	Results		[]typedValue	`json:"results"`
+	Trap		*diagnostic		`json:"trap,omitempty"`
+	Warnings	[]string		`json:"warnings,omitempty"`
+	Stats		*statsReport	`json:"stats,omitempty"`
+}
+
+// Execution statistics, see wasm.ExecutionStats
+type statsReport struct {
+	Elapsed			int64				`json:"elapsedNanoseconds"`
+	Instructions	uint64				`json:"instructions"`
+	Opcodes			map[string]uint64	`json:"opcodes"`
+	Calls			[]callCount			`json:"calls"`
+	MaxCallDepth	int					`json:"maxCallDepth"`
+	MaxStackDepth	int					`json:"maxStackDepth"`
+	MemoryHighWater	int					`json:"memoryHighWater"`
+	Fuel			*fuelReport			`json:"fuel,omitempty"`
+}
+
+// Fuel metering, if enabled
+type fuelReport struct {
+	Consumed	uint64	`json:"consumed"`
+	Remaining	uint64	`json:"remaining"`
+}
+
+type callCount struct {
+	Function	uint32	`json:"function"`
+	Name		string	`json:"name"`
+	Calls		uint64	`json:"calls"`
+}
+
+func reportStats(stats wasm.ExecutionStats,
+	names wasm.NameSection) statsReport {
+	report := statsReport{
+		Elapsed:			stats.Elapsed().Nanoseconds(),
+		Instructions:		stats.Instructions,
+		Opcodes:			stats.Opcodes,
+		Calls:				[]callCount{},
+		MaxCallDepth:		stats.MaxCallDepth,
+		MaxStackDepth:		stats.MaxStackDepth,
+		MemoryHighWater:	stats.MemoryHighWater,
+	}
+	for function, calls := range stats.Calls {
+		report.Calls = append(report.Calls,
+			callCount{ function, names.Function(function), calls })
+	}
+	sort.Slice(report.Calls, func(i, j int) bool {
+		return report.Calls[i].Function < report.Calls[j].Function
+	})
+	return report
+}
+
+// Human-readable statistics, with the opcodes by frequency
+func (report statsReport) String() string {
+	var builder strings.Builder
+	builder.WriteString("Statistics:\n")
+	builder.WriteString(fmt.Sprintf("    time: %s\n",
+		time.Duration(report.Elapsed)))
+	builder.WriteString(fmt.Sprintf("    instructions: %d\n",
+		report.Instructions))
+	builder.WriteString(fmt.Sprintf("    max call depth: %d\n",
+		report.MaxCallDepth))
+	builder.WriteString(fmt.Sprintf("    max stack depth: %d\n",
+		report.MaxStackDepth))
+	builder.WriteString(fmt.Sprintf("    memory high-water mark: %d bytes\n",
+		report.MemoryHighWater))
+	if (report.Fuel != nil) {
+		builder.WriteString(fmt.Sprintf("    fuel: %d consumed, %d remaining\n",
+			report.Fuel.Consumed, report.Fuel.Remaining))
+	}
+
+	builder.WriteString("Calls (entry function only, as call is not yet " +
+		"executed):\n")
+	for _, count := range report.Calls {
+		builder.WriteString(fmt.Sprintf("    %-24s %d\n", count.Name,
+			count.Calls))
+	}
+
+	opcodes := make([]string, 0, len(report.Opcodes))
+	for name := range report.Opcodes {
+		opcodes = append(opcodes, name)
+	}
+	sort.Slice(opcodes, func(i, j int) bool {
+		a, b := report.Opcodes[opcodes[i]], report.Opcodes[opcodes[j]]
+		return (a > b || (a == b && opcodes[i] < opcodes[j]))
+	})
+	builder.WriteString("Opcodes:\n")
+	for _, name := range opcodes {
+		builder.WriteString(fmt.Sprintf("    %-24s %d\n", name,
+			report.Opcodes[name]))
+	}
+	return builder.String()
+}
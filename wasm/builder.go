@@ -0,0 +1,355 @@
+package wasm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+)
+
+
+//
+// Programmatic module construction, for tests + code generation.  Each method
+// appends a single entity and returns the builder itself, so that an entire
+// module reads as one chained expression, e.g.:
+//
+//	encoded := CreateModuleBuilder().
+//		Function("addTwo",
+//			FunctionType{ ResultType{ NumTypei32, NumTypei32 },
+//				ResultType{ NumTypei32 } }, nil,
+//			CreateExpression().LocalGet(0).LocalGet(1).Op(0x6A)).
+//		Bytes()
+//
+// Entities are indexed in the order they are added.  Imported functions
+// precede all defined functions in the function index space, so any imports
+// should be added first.  The builder does not validate its output; see
+// Module.Validate()
+//
+type ModuleBuilder struct {
+	types		[]FunctionType
+	imports		[]Import
+	functions	[]uint32		// Type index of each defined function
+	code		[]Function
+	tables		[]Table
+	memories	[]Memory
+	globals		[]Global
+	exports		[]Export
+	start		*uint32
+	elements	[]Element
+	data		[]Data
+}
+
+// Factory function for generating an empty ModuleBuilder.  No side effects.
+func CreateModuleBuilder() *ModuleBuilder {
+	return &ModuleBuilder{}
+}
+
+//
+// Return the index of the given function type, adding it to the type section
+// if necessary.  Identical types share a single index
+//
+func (builder *ModuleBuilder) typeIndex(ftype FunctionType) uint32 {
+	for i, existing := range builder.types {
+		if (equalTypes(existing.parameter, ftype.parameter) &&
+			equalTypes(existing.result, ftype.result)) {
+			return uint32(i)
+		}
+	}
+	builder.types = append(builder.types, ftype)
+	return uint32(len(builder.types) - 1)
+}
+
+// Number of functions in the function index space, imported or defined
+func (builder *ModuleBuilder) functionCount() uint32 {
+	count := uint32(len(builder.functions))
+	for _, imp := range builder.imports {
+		if (imp.itype == ExportTypeFunction) {
+			count++
+		}
+	}
+	return count
+}
+
+// Add a function type, regardless of whether any function uses it
+func (builder *ModuleBuilder) Type(ftype FunctionType) *ModuleBuilder {
+	builder.typeIndex(ftype)
+	return builder
+}
+
+// Import a function with the given signature
+func (builder *ModuleBuilder) ImportFunction(module string, name string,
+	ftype FunctionType) *ModuleBuilder {
+	builder.imports = append(builder.imports, Import{
+		module:		module,
+		name:		name,
+		itype:		ExportTypeFunction,
+		function:	builder.typeIndex(ftype) })
+	return builder
+}
+
+// Import a global of the given type
+func (builder *ModuleBuilder) ImportGlobal(module string, name string,
+	vtype ValueType, mutable bool) *ModuleBuilder {
+	builder.imports = append(builder.imports, Import{
+		module:	module,
+		name:	name,
+		itype:	ExportTypeGlobal,
+		global:	GlobalType{ vtype, mutable } })
+	return builder
+}
+
+//
+// Define a function with the given signature, additional locals and body.
+// The final "end" of the body is implicit.  If the name is non-empty, the
+// function is also exported under this name
+//
+func (builder *ModuleBuilder) Function(name string, ftype FunctionType,
+	locals []ValueType, body *ExpressionBuilder) *ModuleBuilder {
+	if (name != "") {
+		builder.Export(name, ExportTypeFunction, builder.functionCount())
+	}
+
+	builder.functions = append(builder.functions, builder.typeIndex(ftype))
+	builder.code = append(builder.code, Function{
+		body:	append(append([]byte{}, body.code...), 0x0B),
+		local:	append([]ValueType{}, locals...) })
+	return builder
+}
+
+// Define a funcref table with the given limits.  A max of zero is unbounded
+func (builder *ModuleBuilder) Table(min uint32, max uint32) *ModuleBuilder {
+	builder.tables = append(builder.tables,
+		Table{ createLimit(min, max), RefTypeFunction })
+	return builder
+}
+
+//
+// Define a linear memory with the given limits, in 64KB pages.  A max of zero
+// is unbounded
+//
+func (builder *ModuleBuilder) Memory(min uint32, max uint32) *ModuleBuilder {
+	builder.memories = append(builder.memories,
+		Memory{ createLimit(min, max) })
+	return builder
+}
+
+// Define a global, with the given constant initializer
+func (builder *ModuleBuilder) Global(vtype ValueType, mutable bool,
+	init *ExpressionBuilder) *ModuleBuilder {
+	builder.globals = append(builder.globals, Global{
+		gtype:	GlobalType{ vtype, mutable },
+		init:	append(append([]byte{}, init.code...), 0x0B) })
+	return builder
+}
+
+// Export an arbitrary entity, by type (ExportType*) and index
+func (builder *ModuleBuilder) Export(name string, etype uint8,
+	index uint32) *ModuleBuilder {
+	builder.exports = append(builder.exports, Export{ name, etype, index })
+	return builder
+}
+
+// Designate the start function, by index
+func (builder *ModuleBuilder) Start(function uint32) *ModuleBuilder {
+	builder.start = &function
+	return builder
+}
+
+//
+// Add an active element segment, initializing table 0 at the given offset
+// with the given function indices
+//
+func (builder *ModuleBuilder) Element(offset uint32,
+	functions ...uint32) *ModuleBuilder {
+	builder.elements = append(builder.elements, Element{
+		flags:		0,
+		mode:		SegmentModeActive,
+		offset:		constantOffset(offset),
+		reftype:	RefTypeFunction,
+		function:	append([]uint32{}, functions...) })
+	return builder
+}
+
+//
+// Add an active data segment, initializing memory 0 at the given offset with
+// the given bytes
+//
+func (builder *ModuleBuilder) Data(offset uint32, init []byte) *ModuleBuilder {
+	builder.data = append(builder.data, Data{
+		flags:	0,
+		mode:	SegmentModeActive,
+		offset:	constantOffset(offset),
+		init:	append([]byte{}, init...) })
+	return builder
+}
+
+// Generate the Module described so far.  No side effects.
+func (builder *ModuleBuilder) Module() Module {
+	exports := ExportSection{ export: make(map[string]Export),
+		list: builder.exports }
+	for _, export := range builder.exports {
+		exports.export[export.name] = export
+	}
+	start := StartSection{}
+	if (builder.start != nil) {
+		start.function = *builder.start
+	}
+
+	// Emit each non-empty section, in the prescribed order
+	candidates := []struct{
+		present	bool
+		section	Section
+	}{
+		{ len(builder.types) > 0,		TypeSection{ builder.types } },
+		{ len(builder.imports) > 0,		ImportSection{ builder.imports } },
+		{ len(builder.functions) > 0,	FunctionSection{ builder.functions } },
+		{ len(builder.tables) > 0,		TableSection{ builder.tables } },
+		{ len(builder.memories) > 0,	MemorySection{ builder.memories } },
+		{ len(builder.globals) > 0,		GlobalSection{ builder.globals } },
+		{ len(builder.exports) > 0,		exports },
+		{ builder.start != nil,			start },
+		{ len(builder.elements) > 0,	ElementSection{ builder.elements } },
+		{ len(builder.code) > 0,		CodeSection{ builder.code } },
+		{ len(builder.data) > 0,		DataSection{ builder.data } },
+	}
+
+	module := Module{}
+	for _, candidate := range candidates {
+		if (candidate.present) {
+			module.sections = append(module.sections, candidate.section)
+		}
+	}
+
+	return module
+}
+
+// Generate the binary encoding of the Module described so far.  No side
+// effects.
+func (builder *ModuleBuilder) Bytes() []byte {
+	var buffer bytes.Buffer
+	builder.Module().WriteTo(&buffer)
+	return buffer.Bytes()
+}
+
+// Limit with the given min + max.  A max of zero is unbounded
+func createLimit(min uint32, max uint32) Limit {
+	if (max == 0) {
+		return Limit{ min, 0, 0x00 }
+	}
+	return Limit{ min, max, 0x01 }
+}
+
+// Constant expression for an i32 segment offset
+func constantOffset(offset uint32) []byte {
+	return append(CreateExpression().I32Const(int32(offset)).code, 0x0B)
+}
+
+
+//
+// Instruction sequence builder, for function bodies + constant expressions.
+// Each method appends a single instruction, with its immediates encoded as
+// necessary.  Any instruction without a dedicated method can be appended via
+// Op() and Bytes()
+//
+type ExpressionBuilder struct {
+	code []byte
+}
+
+// Factory function for generating an empty ExpressionBuilder.  No side
+// effects.
+func CreateExpression() *ExpressionBuilder {
+	return &ExpressionBuilder{}
+}
+
+// Append raw bytes: an opcode and/or pre-encoded immediates
+func (expr *ExpressionBuilder) Bytes(code ...byte) *ExpressionBuilder {
+	expr.code = append(expr.code, code...)
+	return expr
+}
+
+// Append an instruction without immediates, e.g. Op(0x6A) for i32.add
+func (expr *ExpressionBuilder) Op(opcode byte) *ExpressionBuilder {
+	return expr.Bytes(opcode)
+}
+
+// Append an instruction with a single unsigned (index) immediate
+func (expr *ExpressionBuilder) indexed(opcode byte,
+	index uint32) *ExpressionBuilder {
+	expr.code = appendULEB128(append(expr.code, opcode), uint64(index))
+	return expr
+}
+
+// Control instructions.  Block types are either a ValueType or the empty
+// block type (0x40)
+func (expr *ExpressionBuilder) Unreachable() *ExpressionBuilder {
+	return expr.Op(0x00)
+}
+func (expr *ExpressionBuilder) Nop() *ExpressionBuilder {
+	return expr.Op(0x01)
+}
+func (expr *ExpressionBuilder) Block(btype ValueType) *ExpressionBuilder {
+	return expr.Bytes(0x02, byte(btype))
+}
+func (expr *ExpressionBuilder) Loop(btype ValueType) *ExpressionBuilder {
+	return expr.Bytes(0x03, byte(btype))
+}
+func (expr *ExpressionBuilder) If(btype ValueType) *ExpressionBuilder {
+	return expr.Bytes(0x04, byte(btype))
+}
+func (expr *ExpressionBuilder) Else() *ExpressionBuilder {
+	return expr.Op(0x05)
+}
+func (expr *ExpressionBuilder) End() *ExpressionBuilder {
+	return expr.Op(0x0B)
+}
+func (expr *ExpressionBuilder) Br(depth uint32) *ExpressionBuilder {
+	return expr.indexed(0x0C, depth)
+}
+func (expr *ExpressionBuilder) BrIf(depth uint32) *ExpressionBuilder {
+	return expr.indexed(0x0D, depth)
+}
+func (expr *ExpressionBuilder) Return() *ExpressionBuilder {
+	return expr.Op(0x0F)
+}
+func (expr *ExpressionBuilder) Call(function uint32) *ExpressionBuilder {
+	return expr.indexed(0x10, function)
+}
+func (expr *ExpressionBuilder) Drop() *ExpressionBuilder {
+	return expr.Op(0x1A)
+}
+
+// Variable instructions
+func (expr *ExpressionBuilder) LocalGet(index uint32) *ExpressionBuilder {
+	return expr.indexed(0x20, index)
+}
+func (expr *ExpressionBuilder) LocalSet(index uint32) *ExpressionBuilder {
+	return expr.indexed(0x21, index)
+}
+func (expr *ExpressionBuilder) LocalTee(index uint32) *ExpressionBuilder {
+	return expr.indexed(0x22, index)
+}
+func (expr *ExpressionBuilder) GlobalGet(index uint32) *ExpressionBuilder {
+	return expr.indexed(0x23, index)
+}
+func (expr *ExpressionBuilder) GlobalSet(index uint32) *ExpressionBuilder {
+	return expr.indexed(0x24, index)
+}
+
+// Numeric constants
+func (expr *ExpressionBuilder) I32Const(value int32) *ExpressionBuilder {
+	expr.code = appendSLEB128(append(expr.code, 0x41), int64(value))
+	return expr
+}
+func (expr *ExpressionBuilder) I64Const(value int64) *ExpressionBuilder {
+	expr.code = appendSLEB128(append(expr.code, 0x42), value)
+	return expr
+}
+func (expr *ExpressionBuilder) F32Const(value float32) *ExpressionBuilder {
+	var bits [4]byte
+	binary.LittleEndian.PutUint32(bits[:], math.Float32bits(value))
+	return expr.Bytes(0x43).Bytes(bits[:]...)
+}
+func (expr *ExpressionBuilder) F64Const(value float64) *ExpressionBuilder {
+	var bits [8]byte
+	binary.LittleEndian.PutUint64(bits[:], math.Float64bits(value))
+	return expr.Bytes(0x44).Bytes(bits[:]...)
+}
@@ -0,0 +1,97 @@
+package wasm
+
+import(
+	"bytes"
+	"testing"
+	)
+
+
+//
+// Test programmatic module construction.  Each built module must match its
+// hand-assembled equivalent, byte-for-byte, and must validate
+//
+func TestModuleBuilder(t *testing.T) {
+	binaryi32 := FunctionType{ ResultType{ NumTypei32, NumTypei32 },
+		ResultType{ NumTypei32 } }
+
+	testCases := []struct{
+		name		string
+		builder		*ModuleBuilder
+		expected	[]byte
+	}{
+		{ "empty.wat",	CreateModuleBuilder(),	fuzzSeeds["empty.wat"] },
+
+		{ "fnop.wat",
+		  CreateModuleBuilder().
+			Function("fnop", FunctionType{}, nil,
+				CreateExpression().Nop().Nop()),
+		  fuzzSeeds["fnop.wat"] },
+
+		{ "simple.wat",
+		  CreateModuleBuilder().
+			Function("addTwo", binaryi32, nil,
+				CreateExpression().LocalGet(0).LocalGet(1).Op(0x6A)),
+		  fuzzSeeds["simple.wat"] },
+
+		{ "multi-value.wat",
+		  CreateModuleBuilder().
+			Function("", FunctionType{ ResultType{ NumTypei32, NumTypei32 },
+				ResultType{ NumTypei32, NumTypei32 } }, nil,
+				CreateExpression().LocalGet(1).LocalGet(0)).
+			Function("reverseSub", binaryi32, nil,
+				CreateExpression().LocalGet(0).LocalGet(1).Call(0).Op(0x6B)),
+		  fuzzSeeds["multi-value.wat"] },
+
+		{ "mutable-globals.wat",
+		  CreateModuleBuilder().
+			ImportGlobal("env", "g", NumTypei32, true).
+			Function("f", FunctionType{}, nil,
+				CreateExpression().I32Const(100).GlobalSet(0)),
+		  fuzzSeeds["mutable-globals.wat"] },
+
+		{ "factorial.wat",
+		  CreateModuleBuilder().
+			Function("fac", FunctionType{ ResultType{ NumTypef64 },
+				ResultType{ NumTypef64 } }, nil,
+				CreateExpression().
+					LocalGet(0).F64Const(1).Op(0x63).
+					If(NumTypef64).
+						F64Const(1).
+					Else().
+						LocalGet(0).LocalGet(0).F64Const(1).Op(0xA1).
+						Call(0).Op(0xA2).
+					End()),
+		  fuzzSeeds["factorial.wat"] },
+
+		{ "memory-data-table",
+		  CreateModuleBuilder().
+			Function("", FunctionType{}, nil, CreateExpression()).
+			Table(1, 0).
+			Memory(1, 2).
+			Global(NumTypei64, true, CreateExpression().I64Const(-1)).
+			Start(0).
+			Element(0, 0).
+			Data(16, []byte("hello")),
+		  nil },
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			encoded := test.builder.Bytes()
+			if (test.expected != nil && !bytes.Equal(encoded, test.expected)) {
+				t.Errorf("Unexpected encoding:\n% x\n% x", encoded,
+					test.expected)
+			}
+
+			// The encoding must decode + validate
+			module, err := ReadModule(bytes.NewReader(encoded))
+			if (err != nil) {
+				t.Fatal("Unable to decode built module: ", err)
+			}
+			err = module.Validate()
+			if (err != nil) {
+				t.Error("Unexpected validation error: ", err)
+			}
+		})
+	}
+}
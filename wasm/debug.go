@@ -0,0 +1,256 @@
+package wasm
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+
+// Execution stopped at the request of the debugger
+var DebugAborted = errors.New("Execution aborted by debugger")
+
+
+//
+// Step debugger.  The interpreter consults the debugger before executing each
+// instruction, and pauses at any breakpoint or at the end of a step.  While
+// paused, the Pause callback receives a snapshot of the thread state, and
+// returns the next action.  The callback runs on the interpreter goroutine,
+// so an IDE integration can block it (e.g., on a channel) until the user
+// decides how to proceed.  Not safe for concurrent use.  The interpreter does
+// not execute call yet, so there is only ever a single frame: step over is
+// the same as step into, and step out runs to the end of the invocation
+//
+type DebugAction int
+
+const (
+	DebugContinue	DebugAction = iota	// Run until the next breakpoint
+	DebugStepInto						// Pause at the next instruction
+	DebugStepOver						// Pause at the next instruction in
+										// this function or its callers
+	DebugStepOut						// Pause on return to the caller
+	DebugAbort							// Stop execution with DebugAborted
+)
+
+// A breakpoint, by function index + byte offset within the function body
+type Breakpoint struct {
+	Function	uint32
+	Offset		int
+}
+
+// A single call frame, innermost first
+type DebugFrame struct {
+	Function	uint32
+	Name		string
+	Offset		int
+}
+
+//
+// Snapshot of a paused thread.  Values are ordered as by the interpreter:
+// Stack is the operand stack of the current frame, top first; Locals are
+// indexed as by local.get
+//
+type DebugState struct {
+	Frames		[]DebugFrame
+	Instruction	string			// Next instruction, e.g. "local.get 0 <$n>"
+	Stack		[]interface{}
+	Locals		[]interface{}
+	Breakpoint	bool			// Whether paused at a breakpoint
+}
+
+type Debugger struct {
+	Pause		func(state DebugState) DebugAction
+
+	breakpoints	map[Breakpoint]bool
+	action		DebugAction		// Current step, if any
+	depth		int				// Call depth at the start of the step
+}
+
+//
+// Factory function for generating a Debugger.  Execution pauses at the first
+// instruction, then as directed by the Pause callback.  No side effects.
+//
+func CreateDebugger(pause func(state DebugState) DebugAction) *Debugger {
+	return &Debugger{ Pause: pause, breakpoints: make(map[Breakpoint]bool),
+		action: DebugStepInto }
+}
+
+//
+// Set the next action, e.g. DebugContinue so that execution only pauses at
+// breakpoints, rather than at the first instruction
+//
+func (debugger *Debugger) Resume(action DebugAction) {
+	debugger.action = action
+}
+
+func (debugger *Debugger) SetBreakpoint(breakpoint Breakpoint) {
+	debugger.breakpoints[breakpoint] = true
+}
+
+func (debugger *Debugger) ClearBreakpoint(breakpoint Breakpoint) {
+	delete(debugger.breakpoints, breakpoint)
+}
+
+// Current breakpoints, by function + offset.  No side effects.
+func (debugger *Debugger) Breakpoints() []Breakpoint {
+	breakpoints := make([]Breakpoint, 0, len(debugger.breakpoints))
+	for breakpoint := range debugger.breakpoints {
+		breakpoints = append(breakpoints, breakpoint)
+	}
+	sort.Slice(breakpoints, func(i, j int) bool {
+		if (breakpoints[i].Function != breakpoints[j].Function) {
+			return breakpoints[i].Function < breakpoints[j].Function
+		}
+		return breakpoints[i].Offset < breakpoints[j].Offset
+	})
+	return breakpoints
+}
+
+//
+// Called by the interpreter before executing the current instruction.
+// Pauses if necessary, and returns DebugAborted if the debugger stops
+// execution
+//
+func (debugger *Debugger) check(thread *WASMInterpreterThread,
+	names NameSection) error {
+	depth := thread.callStack.Top() + 1
+	here := Breakpoint{ uint32(thread.current.function),
+		thread.current.offset() }
+	breakpoint := debugger.breakpoints[here]
+
+	pause := breakpoint
+	switch(debugger.action) {
+		case DebugStepInto:	pause = true
+		case DebugStepOver:	pause = pause || (depth <= debugger.depth)
+		case DebugStepOut:	pause = pause || (depth < debugger.depth)
+	}
+	if (!pause || debugger.Pause == nil) {
+		return nil
+	}
+
+	state := thread.debugState(names)
+	state.Breakpoint = breakpoint
+	debugger.action = debugger.Pause(state)
+	debugger.depth = depth
+	if (debugger.action == DebugAbort) {
+		return DebugAborted
+	}
+	return nil
+}
+
+// Snapshot of the thread state, for the debugger.  No side effects.
+func (thread *WASMInterpreterThread) debugState(
+	names NameSection) DebugState {
+	function := uint32(thread.current.function)
+	state := DebugState{ Instruction: formatInstruction(
+		thread.current.code[thread.current.ip], names, function) }
+
+	state.Frames = append(state.Frames, DebugFrame{ function,
+		names.Function(function), thread.current.offset() })
+	for i := thread.callStack.Top(); i > 0; i-- {
+		value, err := thread.callStack.Peek(i)
+		if (err != nil) {
+			break
+		}
+		caller := value.(StackFrame).caller
+		state.Frames = append(state.Frames, DebugFrame{
+			uint32(caller.function), names.Function(uint32(caller.function)),
+			caller.offset() })
+	}
+
+	// The operand stack of this frame sits above its locals
+	frame := StackFrame{}
+	value, err := thread.callStack.Peek(thread.callStack.Top())
+	if (err == nil) {
+		frame = value.(StackFrame)
+	}
+	for i := thread.dataStack.Top(); i >= frame.locals + frame.count; i-- {
+		value, _ := thread.dataStack.Peek(i)
+		state.Stack = append(state.Stack, value)
+	}
+
+	// Parameters, then any declared locals
+	for i := 0; i < frame.count; i++ {
+		local, err := thread.dataStack.Peek(frame.locals + i)
+		if (err != nil) {
+			break
+		}
+		state.Locals = append(state.Locals, local)
+	}
+	return state
+}
+
+
+//
+// Locate a function by export name, $name (from the name section) or index in
+// the function index space.  No side effects.
+//
+func (module Module) FunctionIndex(name string) (uint32, error) {
+	function, err := exportedFunction(module, name)
+	if (err == nil) {
+		return function, nil
+	}
+
+	count := uint32(len(newModuleContext(module).functions))
+	names := module.Names()
+	for index := uint32(0); index < count; index++ {
+		if (names.Function(index) == name) {
+			return index, nil
+		}
+	}
+
+	index, err := strconv.ParseUint(name, 10, 32)
+	if (err != nil || index >= uint64(count)) {
+		return 0, fmt.Errorf("%w '%s'", MissingFunction, name)
+	}
+	return uint32(index), nil
+}
+
+//
+// Parse a breakpoint, e.g. "fac", "$fac+0x1a" or "3+12": a function, plus an
+// optional byte offset within the function body, which must be the start of
+// an instruction.  No side effects.
+//
+func (module Module) ParseBreakpoint(text string) (Breakpoint, error) {
+	name, offsetText, hasOffset := strings.Cut(text, "+")
+	function, err := module.FunctionIndex(name)
+	if (err != nil) {
+		return Breakpoint{}, err
+	}
+	breakpoint := Breakpoint{ Function: function }
+	if (hasOffset) {
+		offset, err := strconv.ParseUint(offsetText, 0, 31)
+		if (err != nil) {
+			return Breakpoint{}, fmt.Errorf("%w: invalid offset '%s'",
+				InvalidArgument, offsetText)
+		}
+		breakpoint.Offset = int(offset)
+		if (!module.isInstruction(function, breakpoint.Offset)) {
+			return Breakpoint{}, fmt.Errorf("%w: offset %#x is not an " +
+				"instruction of '%s'", InvalidArgument, offset, name)
+		}
+	}
+	return breakpoint, nil
+}
+
+// Whether an instruction of the function starts at the offset.  Imported
+// functions have no instructions.  No side effects.
+func (module Module) isInstruction(function uint32, offset int) bool {
+	imported := newModuleContext(module).imported
+	codeSection, ok := module.section(CodeSectionId).(CodeSection)
+	index := int(function) - imported
+	if (!ok || index < 0 || index >= len(codeSection.function)) {
+		return false
+	}
+
+	// Any malformed tail is never executed, so cannot be a breakpoint
+	code, _ := codeSection.function[index].Instructions()
+	for _, instr := range code {
+		if (instr.Offset == offset) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,154 @@
+package wasm
+
+import(
+	"errors"
+	"strings"
+	"testing"
+	)
+
+
+//
+// Test pausing, stepping + breakpoints
+//
+func TestDebugger(t *testing.T) {
+	module, err := ReadWATWithOptions(strings.NewReader(`(module
+		(func $add (export "add") (param i32 i32) (result i32)
+			local.get 0 local.get 1 i32.add))`),
+		WATOptions{ DebugNames: true })
+	if (err != nil) {
+		t.Fatal("Unexpected assembly error: ", err)
+	}
+	add, err := module.ParseBreakpoint("$add+4")
+	if (err != nil) {
+		t.Fatal("Unexpected breakpoint error: ", err)
+	}
+
+	testCases := []struct{
+		name		string
+		actions		[]DebugAction		// Response to each pause
+		breakpoints	[]Breakpoint
+		offsets		[]int				// Offset of each pause
+		status		error
+	}{
+		{ "step-into",	[]DebugAction{ DebugStepInto, DebugStepInto,
+							DebugStepInto, DebugStepInto },
+						nil,	[]int{ 0, 2, 4, 5 },	nil },
+		// Without call, step over is the same as step into, and step out
+		// leaves the entry function, i.e. runs to the end
+		{ "step-over",	[]DebugAction{ DebugStepOver, DebugStepOver,
+							DebugStepOver, DebugStepOver },
+						nil,	[]int{ 0, 2, 4, 5 },	nil },
+		{ "step-out",	[]DebugAction{ DebugStepOut },
+						nil,	[]int{ 0 },				nil },
+		{ "continue",	[]DebugAction{ DebugContinue },
+						nil,	[]int{ 0 },				nil },
+		{ "breakpoint",	[]DebugAction{ DebugContinue, DebugContinue },
+						[]Breakpoint{ add },	[]int{ 0, 4 },	nil },
+		{ "abort",		[]DebugAction{ DebugAbort },
+						nil,	[]int{ 0 },				DebugAborted },
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			var states []DebugState
+			debugger := CreateDebugger(func(state DebugState) DebugAction {
+				states = append(states, state)
+				if (len(states) > len(test.actions)) {
+					return DebugAbort
+				}
+				return test.actions[len(states) - 1]
+			})
+			for _, breakpoint := range test.breakpoints {
+				debugger.SetBreakpoint(breakpoint)
+			}
+
+			_, err := WASMInterpreter{}.Invoke(module, "add",
+				[]interface{}{ int32(3), int32(4) },
+				VMConfig{ Debugger: debugger })
+			if (!errors.Is(err, test.status)) {
+				t.Fatal("Unexpected VM status: ", err)
+			}
+			if (len(states) != len(test.offsets)) {
+				t.Fatalf("Unexpected pauses: %+v", states)
+			}
+			for i, state := range states {
+				if (state.Frames[0].Offset != test.offsets[i] ||
+					state.Frames[0].Name != "$add") {
+					t.Errorf("Unexpected pause %d: %+v", i, state)
+				}
+			}
+		})
+	}
+}
+
+//
+// Test the thread state at a breakpoint
+//
+func TestDebugState(t *testing.T) {
+	module, err := ReadWAT(strings.NewReader(`(module
+		(func (export "add") (param i32 i32) (result i32)
+			local.get 0 local.get 1 i32.add))`))
+	if (err != nil) {
+		t.Fatal("Unexpected assembly error: ", err)
+	}
+
+	var state DebugState
+	debugger := CreateDebugger(func(paused DebugState) DebugAction {
+		state = paused
+		return DebugContinue
+	})
+	debugger.SetBreakpoint(Breakpoint{ 0, 4 })
+	debugger.Resume(DebugContinue)
+
+	_, err = WASMInterpreter{}.Invoke(module, "add",
+		[]interface{}{ int32(3), int32(4) }, VMConfig{ Debugger: debugger })
+	if (err != nil) {
+		t.Fatal("Unexpected VM status: ", err)
+	}
+	if (!state.Breakpoint || state.Instruction != "i32.add" ||
+		len(state.Frames) != 1 || len(state.Stack) != 2 ||
+		len(state.Locals) != 2) {
+		t.Errorf("Unexpected state: %+v", state)
+	}
+	if (state.Locals[0] != int32(3) || state.Locals[1] != int32(4)) {
+		t.Errorf("Unexpected locals: %+v", state.Locals)
+	}
+	if (state.Stack[0] != int32(4) || state.Stack[1] != int32(3)) {
+		t.Errorf("Unexpected stack (top first): %+v", state.Stack)
+	}
+}
+
+//
+// Test parsing of breakpoints by export name, $name or index
+//
+func TestParseBreakpoint(t *testing.T) {
+	module := watSampleModule(t)
+
+	testCases := []struct{
+		text		string
+		breakpoint	Breakpoint
+		status		error
+	}{
+		{ "fac",		Breakpoint{ 1, 0 },		nil },
+		{ "$fac+0x1a",	Breakpoint{ 1, 0x1a },	nil },
+		{ "1+0xb",		Breakpoint{ 1, 0xb },	nil },
+		{ "2+0",		Breakpoint{ 2, 0 },		nil },
+		{ "0",			Breakpoint{ 0, 0 },		nil },
+		{ "fac+3",		Breakpoint{},			InvalidArgument },
+		{ "2+12",		Breakpoint{},			InvalidArgument },
+		{ "0+0",		Breakpoint{},			InvalidArgument },
+		{ "$nope",		Breakpoint{},			MissingFunction },
+		{ "3",			Breakpoint{},			MissingFunction },
+		{ "fac+x",		Breakpoint{},			InvalidArgument },
+	}
+
+	for _, test := range testCases {
+		t.Run(test.text, func(t *testing.T) {
+			breakpoint, err := module.ParseBreakpoint(test.text)
+			if (!errors.Is(err, test.status) ||
+				breakpoint != test.breakpoint) {
+				t.Errorf("Unexpected breakpoint %+v: %v", breakpoint, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package wasm
+
+import (
+	"errors"
+	"fmt"
+)
+
+
+// Decoding error due to a module that exceeds the configured DecodeLimits
+var LimitExceeded = errors.New("Decode limit exceeded")
+
+
+//
+// Resource caps for decoding untrusted modules.  Each limit bounds the
+// memory consumed by the decoder, regardless of the lengths claimed by the
+// module itself.  Any zero-valued limit is treated as unlimited
+//
+type DecodeLimits struct {
+	MaxModuleSize	int64	// Total size of the module, in bytes
+	MaxSectionSize	uint32	// Size of any single section, in bytes
+	MaxSections		uint32	// Total sections, including custom sections
+	MaxTypes		uint32
+	MaxImports		uint32
+	MaxFunctions	uint32	// Declared functions + function bodies
+	MaxFunctionSize	uint32	// Size of any single function body, in bytes
+	MaxLocals		uint32	// Locals per function
+	MaxTotalLocals	uint32	// Locals across all functions
+	MaxTables		uint32
+	MaxMemories		uint32
+	MaxGlobals		uint32
+	MaxExports		uint32
+	MaxSegments		uint32	// Element or data segments
+	MaxNameLength	uint32	// Import, export and custom section names
+}
+
+//
+// Default limits.  Generous enough for any realistic module, but small
+// enough to bound the decoder's memory footprint on hostile input
+//
+var DefaultDecodeLimits = DecodeLimits{
+	MaxModuleSize:		256 << 20,
+	MaxSectionSize:		256 << 20,
+	MaxSections:		100000,
+	MaxTypes:			1000000,
+	MaxImports:			100000,
+	MaxFunctions:		1000000,
+	MaxFunctionSize:	7654321,
+	MaxLocals:			50000,
+	MaxTotalLocals:		10000000,
+	MaxTables:			100000,
+	MaxMemories:		100000,
+	MaxGlobals:			1000000,
+	MaxExports:			100000,
+	MaxSegments:		100000,
+	MaxNameLength:		100000,
+}
+
+
+// Return an error if the given count exceeds the limit (if any)
+func checkLimit(what string, count uint64, limit uint64) error {
+	if (limit > 0 && count > limit) {
+		return fmt.Errorf("%w: %s (%d > %d)", LimitExceeded, what, count, limit)
+	}
+	return nil
+}
+
+//
+// Apply any count-based limits to a decoded section.  Allocations while
+// decoding are already bounded by the section size, so these limits mostly
+// protect later consumers (validation, instantiation, etc).  No side effects.
+//
+func checkSectionLimits(section Section, limits DecodeLimits) error {
+	switch section := section.(type) {
+		case CodeSection:
+			return checkLimit("functions", uint64(len(section.function)),
+				uint64(limits.MaxFunctions))
+
+		case CustomSection:
+			return checkLimit("name length", uint64(len(section.name)),
+				uint64(limits.MaxNameLength))
+
+		case DataSection:
+			return checkLimit("data segments", uint64(len(section.data)),
+				uint64(limits.MaxSegments))
+
+		case ElementSection:
+			return checkLimit("element segments", uint64(len(section.element)),
+				uint64(limits.MaxSegments))
+
+		case ExportSection:
+			for _, export := range section.list {
+				err := checkLimit("name length", uint64(len(export.name)),
+					uint64(limits.MaxNameLength))
+				if (err != nil) {
+					return err
+				}
+			}
+			return checkLimit("exports", uint64(len(section.list)),
+				uint64(limits.MaxExports))
+
+		case FunctionSection:
+			return checkLimit("functions", uint64(len(section.function)),
+				uint64(limits.MaxFunctions))
+
+		case GlobalSection:
+			return checkLimit("globals", uint64(len(section.global)),
+				uint64(limits.MaxGlobals))
+
+		case ImportSection:
+			for _, imp := range section.imports {
+				err := checkLimit("name length",
+					uint64(len(imp.module) + len(imp.name)),
+					uint64(limits.MaxNameLength))
+				if (err != nil) {
+					return err
+				}
+			}
+			return checkLimit("imports", uint64(len(section.imports)),
+				uint64(limits.MaxImports))
+
+		case MemorySection:
+			return checkLimit("memories", uint64(len(section.memory)),
+				uint64(limits.MaxMemories))
+
+		case TableSection:
+			return checkLimit("tables", uint64(len(section.table)),
+				uint64(limits.MaxTables))
+
+		case TypeSection:
+			return checkLimit("types", uint64(len(section.ftype)),
+				uint64(limits.MaxTypes))
+	}
+
+	return nil
+}
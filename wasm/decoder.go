@@ -0,0 +1,807 @@
+package wasm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+
+// Decoding error due to an unknown opcode or malformed immediate
+var InvalidInstruction = errors.New("Invalid instruction")
+
+
+//
+// Bytecode instruction decoder.  Translates a raw function body (or constant
+// expression) into a sequence of DecodedInstructions, with all immediates
+// decoded + typed.  Shared by the validator, the interpreter and the
+// disassembler, so that no consumer needs to parse raw bytecode.  See section
+// 5.4 of WASM spec
+//
+
+// Immediate operand encodings
+const (
+	ImmediateNone			= iota
+	ImmediateBlockType				// block, loop, if
+	ImmediateIndex					// Single u32 index: label, function, local, etc
+	ImmediateIndex2					// Two u32 indices, e.g. call_indirect type + table
+	ImmediateBranchTable			// br_table: vector of labels + default label
+	ImmediateMemarg					// Alignment + offset
+	ImmediateMemory					// Memory index (reserved zero byte)
+	ImmediateMemory2				// memory.copy: destination + source memory
+	ImmediateIndexMemory			// memory.init: data index + memory
+	ImmediateI32
+	ImmediateI64
+	ImmediateF32
+	ImmediateF64
+	ImmediateRefType				// ref.null
+	ImmediateValueTypes				// Typed select
+	ImmediateV128					// v128.const: 16 bytes
+	ImmediateShuffle				// i8x16.shuffle: 16 lane indices
+	ImmediateLane					// Extract/replace lane: lane index
+	ImmediateMemargLane				// Load/store lane: memarg + lane index
+)
+
+// Name + immediate encoding of a single opcode
+type opcodeInfo struct {
+	name		string
+	immediate	uint8
+}
+
+// Single-byte opcodes
+var opcodeTable = map[uint8]opcodeInfo {
+	0x00:	{ "unreachable", ImmediateNone },
+	0x01:	{ "nop", ImmediateNone },
+	0x02:	{ "block", ImmediateBlockType },
+	0x03:	{ "loop", ImmediateBlockType },
+	0x04:	{ "if", ImmediateBlockType },
+	0x05:	{ "else", ImmediateNone },
+	0x0B:	{ "end", ImmediateNone },
+	0x0C:	{ "br", ImmediateIndex },
+	0x0D:	{ "br_if", ImmediateIndex },
+	0x0E:	{ "br_table", ImmediateBranchTable },
+	0x0F:	{ "return", ImmediateNone },
+	0x10:	{ "call", ImmediateIndex },
+	0x11:	{ "call_indirect", ImmediateIndex2 },
+	0x1A:	{ "drop", ImmediateNone },
+	0x1B:	{ "select", ImmediateNone },
+	0x1C:	{ "select", ImmediateValueTypes },
+	0x20:	{ "local.get", ImmediateIndex },
+	0x21:	{ "local.set", ImmediateIndex },
+	0x22:	{ "local.tee", ImmediateIndex },
+	0x23:	{ "global.get", ImmediateIndex },
+	0x24:	{ "global.set", ImmediateIndex },
+	0x25:	{ "table.get", ImmediateIndex },
+	0x26:	{ "table.set", ImmediateIndex },
+	0x28:	{ "i32.load", ImmediateMemarg },
+	0x29:	{ "i64.load", ImmediateMemarg },
+	0x2A:	{ "f32.load", ImmediateMemarg },
+	0x2B:	{ "f64.load", ImmediateMemarg },
+	0x2C:	{ "i32.load8_s", ImmediateMemarg },
+	0x2D:	{ "i32.load8_u", ImmediateMemarg },
+	0x2E:	{ "i32.load16_s", ImmediateMemarg },
+	0x2F:	{ "i32.load16_u", ImmediateMemarg },
+	0x30:	{ "i64.load8_s", ImmediateMemarg },
+	0x31:	{ "i64.load8_u", ImmediateMemarg },
+	0x32:	{ "i64.load16_s", ImmediateMemarg },
+	0x33:	{ "i64.load16_u", ImmediateMemarg },
+	0x34:	{ "i64.load32_s", ImmediateMemarg },
+	0x35:	{ "i64.load32_u", ImmediateMemarg },
+	0x36:	{ "i32.store", ImmediateMemarg },
+	0x37:	{ "i64.store", ImmediateMemarg },
+	0x38:	{ "f32.store", ImmediateMemarg },
+	0x39:	{ "f64.store", ImmediateMemarg },
+	0x3A:	{ "i32.store8", ImmediateMemarg },
+	0x3B:	{ "i32.store16", ImmediateMemarg },
+	0x3C:	{ "i64.store8", ImmediateMemarg },
+	0x3D:	{ "i64.store16", ImmediateMemarg },
+	0x3E:	{ "i64.store32", ImmediateMemarg },
+	0x3F:	{ "memory.size", ImmediateMemory },
+	0x40:	{ "memory.grow", ImmediateMemory },
+	0x41:	{ "i32.const", ImmediateI32 },
+	0x42:	{ "i64.const", ImmediateI64 },
+	0x43:	{ "f32.const", ImmediateF32 },
+	0x44:	{ "f64.const", ImmediateF64 },
+	0x45:	{ "i32.eqz", ImmediateNone },
+	0x46:	{ "i32.eq", ImmediateNone },
+	0x47:	{ "i32.ne", ImmediateNone },
+	0x48:	{ "i32.lt_s", ImmediateNone },
+	0x49:	{ "i32.lt_u", ImmediateNone },
+	0x4A:	{ "i32.gt_s", ImmediateNone },
+	0x4B:	{ "i32.gt_u", ImmediateNone },
+	0x4C:	{ "i32.le_s", ImmediateNone },
+	0x4D:	{ "i32.le_u", ImmediateNone },
+	0x4E:	{ "i32.ge_s", ImmediateNone },
+	0x4F:	{ "i32.ge_u", ImmediateNone },
+	0x50:	{ "i64.eqz", ImmediateNone },
+	0x51:	{ "i64.eq", ImmediateNone },
+	0x52:	{ "i64.ne", ImmediateNone },
+	0x53:	{ "i64.lt_s", ImmediateNone },
+	0x54:	{ "i64.lt_u", ImmediateNone },
+	0x55:	{ "i64.gt_s", ImmediateNone },
+	0x56:	{ "i64.gt_u", ImmediateNone },
+	0x57:	{ "i64.le_s", ImmediateNone },
+	0x58:	{ "i64.le_u", ImmediateNone },
+	0x59:	{ "i64.ge_s", ImmediateNone },
+	0x5A:	{ "i64.ge_u", ImmediateNone },
+	0x5B:	{ "f32.eq", ImmediateNone },
+	0x5C:	{ "f32.ne", ImmediateNone },
+	0x5D:	{ "f32.lt", ImmediateNone },
+	0x5E:	{ "f32.gt", ImmediateNone },
+	0x5F:	{ "f32.le", ImmediateNone },
+	0x60:	{ "f32.ge", ImmediateNone },
+	0x61:	{ "f64.eq", ImmediateNone },
+	0x62:	{ "f64.ne", ImmediateNone },
+	0x63:	{ "f64.lt", ImmediateNone },
+	0x64:	{ "f64.gt", ImmediateNone },
+	0x65:	{ "f64.le", ImmediateNone },
+	0x66:	{ "f64.ge", ImmediateNone },
+	0x67:	{ "i32.clz", ImmediateNone },
+	0x68:	{ "i32.ctz", ImmediateNone },
+	0x69:	{ "i32.popcnt", ImmediateNone },
+	0x6A:	{ "i32.add", ImmediateNone },
+	0x6B:	{ "i32.sub", ImmediateNone },
+	0x6C:	{ "i32.mul", ImmediateNone },
+	0x6D:	{ "i32.div_s", ImmediateNone },
+	0x6E:	{ "i32.div_u", ImmediateNone },
+	0x6F:	{ "i32.rem_s", ImmediateNone },
+	0x70:	{ "i32.rem_u", ImmediateNone },
+	0x71:	{ "i32.and", ImmediateNone },
+	0x72:	{ "i32.or", ImmediateNone },
+	0x73:	{ "i32.xor", ImmediateNone },
+	0x74:	{ "i32.shl", ImmediateNone },
+	0x75:	{ "i32.shr_s", ImmediateNone },
+	0x76:	{ "i32.shr_u", ImmediateNone },
+	0x77:	{ "i32.rotl", ImmediateNone },
+	0x78:	{ "i32.rotr", ImmediateNone },
+	0x79:	{ "i64.clz", ImmediateNone },
+	0x7A:	{ "i64.ctz", ImmediateNone },
+	0x7B:	{ "i64.popcnt", ImmediateNone },
+	0x7C:	{ "i64.add", ImmediateNone },
+	0x7D:	{ "i64.sub", ImmediateNone },
+	0x7E:	{ "i64.mul", ImmediateNone },
+	0x7F:	{ "i64.div_s", ImmediateNone },
+	0x80:	{ "i64.div_u", ImmediateNone },
+	0x81:	{ "i64.rem_s", ImmediateNone },
+	0x82:	{ "i64.rem_u", ImmediateNone },
+	0x83:	{ "i64.and", ImmediateNone },
+	0x84:	{ "i64.or", ImmediateNone },
+	0x85:	{ "i64.xor", ImmediateNone },
+	0x86:	{ "i64.shl", ImmediateNone },
+	0x87:	{ "i64.shr_s", ImmediateNone },
+	0x88:	{ "i64.shr_u", ImmediateNone },
+	0x89:	{ "i64.rotl", ImmediateNone },
+	0x8A:	{ "i64.rotr", ImmediateNone },
+	0x8B:	{ "f32.abs", ImmediateNone },
+	0x8C:	{ "f32.neg", ImmediateNone },
+	0x8D:	{ "f32.ceil", ImmediateNone },
+	0x8E:	{ "f32.floor", ImmediateNone },
+	0x8F:	{ "f32.trunc", ImmediateNone },
+	0x90:	{ "f32.nearest", ImmediateNone },
+	0x91:	{ "f32.sqrt", ImmediateNone },
+	0x92:	{ "f32.add", ImmediateNone },
+	0x93:	{ "f32.sub", ImmediateNone },
+	0x94:	{ "f32.mul", ImmediateNone },
+	0x95:	{ "f32.div", ImmediateNone },
+	0x96:	{ "f32.min", ImmediateNone },
+	0x97:	{ "f32.max", ImmediateNone },
+	0x98:	{ "f32.copysign", ImmediateNone },
+	0x99:	{ "f64.abs", ImmediateNone },
+	0x9A:	{ "f64.neg", ImmediateNone },
+	0x9B:	{ "f64.ceil", ImmediateNone },
+	0x9C:	{ "f64.floor", ImmediateNone },
+	0x9D:	{ "f64.trunc", ImmediateNone },
+	0x9E:	{ "f64.nearest", ImmediateNone },
+	0x9F:	{ "f64.sqrt", ImmediateNone },
+	0xA0:	{ "f64.add", ImmediateNone },
+	0xA1:	{ "f64.sub", ImmediateNone },
+	0xA2:	{ "f64.mul", ImmediateNone },
+	0xA3:	{ "f64.div", ImmediateNone },
+	0xA4:	{ "f64.min", ImmediateNone },
+	0xA5:	{ "f64.max", ImmediateNone },
+	0xA6:	{ "f64.copysign", ImmediateNone },
+	0xA7:	{ "i32.wrap_i64", ImmediateNone },
+	0xA8:	{ "i32.trunc_f32_s", ImmediateNone },
+	0xA9:	{ "i32.trunc_f32_u", ImmediateNone },
+	0xAA:	{ "i32.trunc_f64_s", ImmediateNone },
+	0xAB:	{ "i32.trunc_f64_u", ImmediateNone },
+	0xAC:	{ "i64.extend_i32_s", ImmediateNone },
+	0xAD:	{ "i64.extend_i32_u", ImmediateNone },
+	0xAE:	{ "i64.trunc_f32_s", ImmediateNone },
+	0xAF:	{ "i64.trunc_f32_u", ImmediateNone },
+	0xB0:	{ "i64.trunc_f64_s", ImmediateNone },
+	0xB1:	{ "i64.trunc_f64_u", ImmediateNone },
+	0xB2:	{ "f32.convert_i32_s", ImmediateNone },
+	0xB3:	{ "f32.convert_i32_u", ImmediateNone },
+	0xB4:	{ "f32.convert_i64_s", ImmediateNone },
+	0xB5:	{ "f32.convert_i64_u", ImmediateNone },
+	0xB6:	{ "f32.demote_f64", ImmediateNone },
+	0xB7:	{ "f64.convert_i32_s", ImmediateNone },
+	0xB8:	{ "f64.convert_i32_u", ImmediateNone },
+	0xB9:	{ "f64.convert_i64_s", ImmediateNone },
+	0xBA:	{ "f64.convert_i64_u", ImmediateNone },
+	0xBB:	{ "f64.promote_f32", ImmediateNone },
+	0xBC:	{ "i32.reinterpret_f32", ImmediateNone },
+	0xBD:	{ "i64.reinterpret_f64", ImmediateNone },
+	0xBE:	{ "f32.reinterpret_i32", ImmediateNone },
+	0xBF:	{ "f64.reinterpret_i64", ImmediateNone },
+	0xC0:	{ "i32.extend8_s", ImmediateNone },
+	0xC1:	{ "i32.extend16_s", ImmediateNone },
+	0xC2:	{ "i64.extend8_s", ImmediateNone },
+	0xC3:	{ "i64.extend16_s", ImmediateNone },
+	0xC4:	{ "i64.extend32_s", ImmediateNone },
+	0xD0:	{ "ref.null", ImmediateRefType },
+	0xD1:	{ "ref.is_null", ImmediateNone },
+	0xD2:	{ "ref.func", ImmediateIndex },
+}
+
+// 0xFC-prefixed opcodes: saturating truncation, bulk memory + table operations
+const PrefixMisc = 0xFC
+var miscOpcodeTable = map[uint32]opcodeInfo {
+	0:	{ "i32.trunc_sat_f32_s", ImmediateNone },
+	1:	{ "i32.trunc_sat_f32_u", ImmediateNone },
+	2:	{ "i32.trunc_sat_f64_s", ImmediateNone },
+	3:	{ "i32.trunc_sat_f64_u", ImmediateNone },
+	4:	{ "i64.trunc_sat_f32_s", ImmediateNone },
+	5:	{ "i64.trunc_sat_f32_u", ImmediateNone },
+	6:	{ "i64.trunc_sat_f64_s", ImmediateNone },
+	7:	{ "i64.trunc_sat_f64_u", ImmediateNone },
+	8:	{ "memory.init", ImmediateIndexMemory },
+	9:	{ "data.drop", ImmediateIndex },
+	10:	{ "memory.copy", ImmediateMemory2 },
+	11:	{ "memory.fill", ImmediateMemory },
+	12:	{ "table.init", ImmediateIndex2 },
+	13:	{ "elem.drop", ImmediateIndex },
+	14:	{ "table.copy", ImmediateIndex2 },
+	15:	{ "table.grow", ImmediateIndex },
+	16:	{ "table.size", ImmediateIndex },
+	17:	{ "table.fill", ImmediateIndex },
+}
+
+// 0xFD-prefixed opcodes: 128-bit SIMD
+const PrefixSIMD = 0xFD
+var simdOpcodeTable = map[uint32]opcodeInfo {
+	0x00:	{ "v128.load", ImmediateMemarg },
+	0x01:	{ "v128.load8x8_s", ImmediateMemarg },
+	0x02:	{ "v128.load8x8_u", ImmediateMemarg },
+	0x03:	{ "v128.load16x4_s", ImmediateMemarg },
+	0x04:	{ "v128.load16x4_u", ImmediateMemarg },
+	0x05:	{ "v128.load32x2_s", ImmediateMemarg },
+	0x06:	{ "v128.load32x2_u", ImmediateMemarg },
+	0x07:	{ "v128.load8_splat", ImmediateMemarg },
+	0x08:	{ "v128.load16_splat", ImmediateMemarg },
+	0x09:	{ "v128.load32_splat", ImmediateMemarg },
+	0x0A:	{ "v128.load64_splat", ImmediateMemarg },
+	0x0B:	{ "v128.store", ImmediateMemarg },
+	0x0C:	{ "v128.const", ImmediateV128 },
+	0x0D:	{ "i8x16.shuffle", ImmediateShuffle },
+	0x0E:	{ "i8x16.swizzle", ImmediateNone },
+	0x0F:	{ "i8x16.splat", ImmediateNone },
+	0x10:	{ "i16x8.splat", ImmediateNone },
+	0x11:	{ "i32x4.splat", ImmediateNone },
+	0x12:	{ "i64x2.splat", ImmediateNone },
+	0x13:	{ "f32x4.splat", ImmediateNone },
+	0x14:	{ "f64x2.splat", ImmediateNone },
+	0x15:	{ "i8x16.extract_lane_s", ImmediateLane },
+	0x16:	{ "i8x16.extract_lane_u", ImmediateLane },
+	0x17:	{ "i8x16.replace_lane", ImmediateLane },
+	0x18:	{ "i16x8.extract_lane_s", ImmediateLane },
+	0x19:	{ "i16x8.extract_lane_u", ImmediateLane },
+	0x1A:	{ "i16x8.replace_lane", ImmediateLane },
+	0x1B:	{ "i32x4.extract_lane", ImmediateLane },
+	0x1C:	{ "i32x4.replace_lane", ImmediateLane },
+	0x1D:	{ "i64x2.extract_lane", ImmediateLane },
+	0x1E:	{ "i64x2.replace_lane", ImmediateLane },
+	0x1F:	{ "f32x4.extract_lane", ImmediateLane },
+	0x20:	{ "f32x4.replace_lane", ImmediateLane },
+	0x21:	{ "f64x2.extract_lane", ImmediateLane },
+	0x22:	{ "f64x2.replace_lane", ImmediateLane },
+	0x23:	{ "i8x16.eq", ImmediateNone },
+	0x24:	{ "i8x16.ne", ImmediateNone },
+	0x25:	{ "i8x16.lt_s", ImmediateNone },
+	0x26:	{ "i8x16.lt_u", ImmediateNone },
+	0x27:	{ "i8x16.gt_s", ImmediateNone },
+	0x28:	{ "i8x16.gt_u", ImmediateNone },
+	0x29:	{ "i8x16.le_s", ImmediateNone },
+	0x2A:	{ "i8x16.le_u", ImmediateNone },
+	0x2B:	{ "i8x16.ge_s", ImmediateNone },
+	0x2C:	{ "i8x16.ge_u", ImmediateNone },
+	0x2D:	{ "i16x8.eq", ImmediateNone },
+	0x2E:	{ "i16x8.ne", ImmediateNone },
+	0x2F:	{ "i16x8.lt_s", ImmediateNone },
+	0x30:	{ "i16x8.lt_u", ImmediateNone },
+	0x31:	{ "i16x8.gt_s", ImmediateNone },
+	0x32:	{ "i16x8.gt_u", ImmediateNone },
+	0x33:	{ "i16x8.le_s", ImmediateNone },
+	0x34:	{ "i16x8.le_u", ImmediateNone },
+	0x35:	{ "i16x8.ge_s", ImmediateNone },
+	0x36:	{ "i16x8.ge_u", ImmediateNone },
+	0x37:	{ "i32x4.eq", ImmediateNone },
+	0x38:	{ "i32x4.ne", ImmediateNone },
+	0x39:	{ "i32x4.lt_s", ImmediateNone },
+	0x3A:	{ "i32x4.lt_u", ImmediateNone },
+	0x3B:	{ "i32x4.gt_s", ImmediateNone },
+	0x3C:	{ "i32x4.gt_u", ImmediateNone },
+	0x3D:	{ "i32x4.le_s", ImmediateNone },
+	0x3E:	{ "i32x4.le_u", ImmediateNone },
+	0x3F:	{ "i32x4.ge_s", ImmediateNone },
+	0x40:	{ "i32x4.ge_u", ImmediateNone },
+	0x41:	{ "f32x4.eq", ImmediateNone },
+	0x42:	{ "f32x4.ne", ImmediateNone },
+	0x43:	{ "f32x4.lt", ImmediateNone },
+	0x44:	{ "f32x4.gt", ImmediateNone },
+	0x45:	{ "f32x4.le", ImmediateNone },
+	0x46:	{ "f32x4.ge", ImmediateNone },
+	0x47:	{ "f64x2.eq", ImmediateNone },
+	0x48:	{ "f64x2.ne", ImmediateNone },
+	0x49:	{ "f64x2.lt", ImmediateNone },
+	0x4A:	{ "f64x2.gt", ImmediateNone },
+	0x4B:	{ "f64x2.le", ImmediateNone },
+	0x4C:	{ "f64x2.ge", ImmediateNone },
+	0x4D:	{ "v128.not", ImmediateNone },
+	0x4E:	{ "v128.and", ImmediateNone },
+	0x4F:	{ "v128.andnot", ImmediateNone },
+	0x50:	{ "v128.or", ImmediateNone },
+	0x51:	{ "v128.xor", ImmediateNone },
+	0x52:	{ "v128.bitselect", ImmediateNone },
+	0x53:	{ "v128.any_true", ImmediateNone },
+	0x54:	{ "v128.load8_lane", ImmediateMemargLane },
+	0x55:	{ "v128.load16_lane", ImmediateMemargLane },
+	0x56:	{ "v128.load32_lane", ImmediateMemargLane },
+	0x57:	{ "v128.load64_lane", ImmediateMemargLane },
+	0x58:	{ "v128.store8_lane", ImmediateMemargLane },
+	0x59:	{ "v128.store16_lane", ImmediateMemargLane },
+	0x5A:	{ "v128.store32_lane", ImmediateMemargLane },
+	0x5B:	{ "v128.store64_lane", ImmediateMemargLane },
+	0x5C:	{ "v128.load32_zero", ImmediateMemarg },
+	0x5D:	{ "v128.load64_zero", ImmediateMemarg },
+	0x5E:	{ "f32x4.demote_f64x2_zero", ImmediateNone },
+	0x5F:	{ "f64x2.promote_low_f32x4", ImmediateNone },
+	0x60:	{ "i8x16.abs", ImmediateNone },
+	0x61:	{ "i8x16.neg", ImmediateNone },
+	0x62:	{ "i8x16.popcnt", ImmediateNone },
+	0x63:	{ "i8x16.all_true", ImmediateNone },
+	0x64:	{ "i8x16.bitmask", ImmediateNone },
+	0x65:	{ "i8x16.narrow_i16x8_s", ImmediateNone },
+	0x66:	{ "i8x16.narrow_i16x8_u", ImmediateNone },
+	0x67:	{ "f32x4.ceil", ImmediateNone },
+	0x68:	{ "f32x4.floor", ImmediateNone },
+	0x69:	{ "f32x4.trunc", ImmediateNone },
+	0x6A:	{ "f32x4.nearest", ImmediateNone },
+	0x6B:	{ "i8x16.shl", ImmediateNone },
+	0x6C:	{ "i8x16.shr_s", ImmediateNone },
+	0x6D:	{ "i8x16.shr_u", ImmediateNone },
+	0x6E:	{ "i8x16.add", ImmediateNone },
+	0x6F:	{ "i8x16.add_sat_s", ImmediateNone },
+	0x70:	{ "i8x16.add_sat_u", ImmediateNone },
+	0x71:	{ "i8x16.sub", ImmediateNone },
+	0x72:	{ "i8x16.sub_sat_s", ImmediateNone },
+	0x73:	{ "i8x16.sub_sat_u", ImmediateNone },
+	0x74:	{ "f64x2.ceil", ImmediateNone },
+	0x75:	{ "f64x2.floor", ImmediateNone },
+	0x76:	{ "i8x16.min_s", ImmediateNone },
+	0x77:	{ "i8x16.min_u", ImmediateNone },
+	0x78:	{ "i8x16.max_s", ImmediateNone },
+	0x79:	{ "i8x16.max_u", ImmediateNone },
+	0x7A:	{ "f64x2.trunc", ImmediateNone },
+	0x7B:	{ "i8x16.avgr_u", ImmediateNone },
+	0x7C:	{ "i16x8.extadd_pairwise_i8x16_s", ImmediateNone },
+	0x7D:	{ "i16x8.extadd_pairwise_i8x16_u", ImmediateNone },
+	0x7E:	{ "i32x4.extadd_pairwise_i16x8_s", ImmediateNone },
+	0x7F:	{ "i32x4.extadd_pairwise_i16x8_u", ImmediateNone },
+	0x80:	{ "i16x8.abs", ImmediateNone },
+	0x81:	{ "i16x8.neg", ImmediateNone },
+	0x82:	{ "i16x8.q15mulr_sat_s", ImmediateNone },
+	0x83:	{ "i16x8.all_true", ImmediateNone },
+	0x84:	{ "i16x8.bitmask", ImmediateNone },
+	0x85:	{ "i16x8.narrow_i32x4_s", ImmediateNone },
+	0x86:	{ "i16x8.narrow_i32x4_u", ImmediateNone },
+	0x87:	{ "i16x8.extend_low_i8x16_s", ImmediateNone },
+	0x88:	{ "i16x8.extend_high_i8x16_s", ImmediateNone },
+	0x89:	{ "i16x8.extend_low_i8x16_u", ImmediateNone },
+	0x8A:	{ "i16x8.extend_high_i8x16_u", ImmediateNone },
+	0x8B:	{ "i16x8.shl", ImmediateNone },
+	0x8C:	{ "i16x8.shr_s", ImmediateNone },
+	0x8D:	{ "i16x8.shr_u", ImmediateNone },
+	0x8E:	{ "i16x8.add", ImmediateNone },
+	0x8F:	{ "i16x8.add_sat_s", ImmediateNone },
+	0x90:	{ "i16x8.add_sat_u", ImmediateNone },
+	0x91:	{ "i16x8.sub", ImmediateNone },
+	0x92:	{ "i16x8.sub_sat_s", ImmediateNone },
+	0x93:	{ "i16x8.sub_sat_u", ImmediateNone },
+	0x94:	{ "f64x2.nearest", ImmediateNone },
+	0x95:	{ "i16x8.mul", ImmediateNone },
+	0x96:	{ "i16x8.min_s", ImmediateNone },
+	0x97:	{ "i16x8.min_u", ImmediateNone },
+	0x98:	{ "i16x8.max_s", ImmediateNone },
+	0x99:	{ "i16x8.max_u", ImmediateNone },
+	0x9B:	{ "i16x8.avgr_u", ImmediateNone },
+	0x9C:	{ "i16x8.extmul_low_i8x16_s", ImmediateNone },
+	0x9D:	{ "i16x8.extmul_high_i8x16_s", ImmediateNone },
+	0x9E:	{ "i16x8.extmul_low_i8x16_u", ImmediateNone },
+	0x9F:	{ "i16x8.extmul_high_i8x16_u", ImmediateNone },
+	0xA0:	{ "i32x4.abs", ImmediateNone },
+	0xA1:	{ "i32x4.neg", ImmediateNone },
+	0xA3:	{ "i32x4.all_true", ImmediateNone },
+	0xA4:	{ "i32x4.bitmask", ImmediateNone },
+	0xA7:	{ "i32x4.extend_low_i16x8_s", ImmediateNone },
+	0xA8:	{ "i32x4.extend_high_i16x8_s", ImmediateNone },
+	0xA9:	{ "i32x4.extend_low_i16x8_u", ImmediateNone },
+	0xAA:	{ "i32x4.extend_high_i16x8_u", ImmediateNone },
+	0xAB:	{ "i32x4.shl", ImmediateNone },
+	0xAC:	{ "i32x4.shr_s", ImmediateNone },
+	0xAD:	{ "i32x4.shr_u", ImmediateNone },
+	0xAE:	{ "i32x4.add", ImmediateNone },
+	0xB1:	{ "i32x4.sub", ImmediateNone },
+	0xB5:	{ "i32x4.mul", ImmediateNone },
+	0xB6:	{ "i32x4.min_s", ImmediateNone },
+	0xB7:	{ "i32x4.min_u", ImmediateNone },
+	0xB8:	{ "i32x4.max_s", ImmediateNone },
+	0xB9:	{ "i32x4.max_u", ImmediateNone },
+	0xBA:	{ "i32x4.dot_i16x8_s", ImmediateNone },
+	0xBC:	{ "i32x4.extmul_low_i16x8_s", ImmediateNone },
+	0xBD:	{ "i32x4.extmul_high_i16x8_s", ImmediateNone },
+	0xBE:	{ "i32x4.extmul_low_i16x8_u", ImmediateNone },
+	0xBF:	{ "i32x4.extmul_high_i16x8_u", ImmediateNone },
+	0xC0:	{ "i64x2.abs", ImmediateNone },
+	0xC1:	{ "i64x2.neg", ImmediateNone },
+	0xC3:	{ "i64x2.all_true", ImmediateNone },
+	0xC4:	{ "i64x2.bitmask", ImmediateNone },
+	0xC7:	{ "i64x2.extend_low_i32x4_s", ImmediateNone },
+	0xC8:	{ "i64x2.extend_high_i32x4_s", ImmediateNone },
+	0xC9:	{ "i64x2.extend_low_i32x4_u", ImmediateNone },
+	0xCA:	{ "i64x2.extend_high_i32x4_u", ImmediateNone },
+	0xCB:	{ "i64x2.shl", ImmediateNone },
+	0xCC:	{ "i64x2.shr_s", ImmediateNone },
+	0xCD:	{ "i64x2.shr_u", ImmediateNone },
+	0xCE:	{ "i64x2.add", ImmediateNone },
+	0xD1:	{ "i64x2.sub", ImmediateNone },
+	0xD5:	{ "i64x2.mul", ImmediateNone },
+	0xD6:	{ "i64x2.eq", ImmediateNone },
+	0xD7:	{ "i64x2.ne", ImmediateNone },
+	0xD8:	{ "i64x2.lt_s", ImmediateNone },
+	0xD9:	{ "i64x2.gt_s", ImmediateNone },
+	0xDA:	{ "i64x2.le_s", ImmediateNone },
+	0xDB:	{ "i64x2.ge_s", ImmediateNone },
+	0xDC:	{ "i64x2.extmul_low_i32x4_s", ImmediateNone },
+	0xDD:	{ "i64x2.extmul_high_i32x4_s", ImmediateNone },
+	0xDE:	{ "i64x2.extmul_low_i32x4_u", ImmediateNone },
+	0xDF:	{ "i64x2.extmul_high_i32x4_u", ImmediateNone },
+	0xE0:	{ "f32x4.abs", ImmediateNone },
+	0xE1:	{ "f32x4.neg", ImmediateNone },
+	0xE3:	{ "f32x4.sqrt", ImmediateNone },
+	0xE4:	{ "f32x4.add", ImmediateNone },
+	0xE5:	{ "f32x4.sub", ImmediateNone },
+	0xE6:	{ "f32x4.mul", ImmediateNone },
+	0xE7:	{ "f32x4.div", ImmediateNone },
+	0xE8:	{ "f32x4.min", ImmediateNone },
+	0xE9:	{ "f32x4.max", ImmediateNone },
+	0xEA:	{ "f32x4.pmin", ImmediateNone },
+	0xEB:	{ "f32x4.pmax", ImmediateNone },
+	0xEC:	{ "f64x2.abs", ImmediateNone },
+	0xED:	{ "f64x2.neg", ImmediateNone },
+	0xEF:	{ "f64x2.sqrt", ImmediateNone },
+	0xF0:	{ "f64x2.add", ImmediateNone },
+	0xF1:	{ "f64x2.sub", ImmediateNone },
+	0xF2:	{ "f64x2.mul", ImmediateNone },
+	0xF3:	{ "f64x2.div", ImmediateNone },
+	0xF4:	{ "f64x2.min", ImmediateNone },
+	0xF5:	{ "f64x2.max", ImmediateNone },
+	0xF6:	{ "f64x2.pmin", ImmediateNone },
+	0xF7:	{ "f64x2.pmax", ImmediateNone },
+	0xF8:	{ "i32x4.trunc_sat_f32x4_s", ImmediateNone },
+	0xF9:	{ "i32x4.trunc_sat_f32x4_u", ImmediateNone },
+	0xFA:	{ "f32x4.convert_i32x4_s", ImmediateNone },
+	0xFB:	{ "f32x4.convert_i32x4_u", ImmediateNone },
+	0xFC:	{ "i32x4.trunc_sat_f64x2_s_zero", ImmediateNone },
+	0xFD:	{ "i32x4.trunc_sat_f64x2_u_zero", ImmediateNone },
+	0xFE:	{ "f64x2.convert_low_i32x4_s", ImmediateNone },
+	0xFF:	{ "f64x2.convert_low_i32x4_u", ImmediateNone },
+}
+
+
+// Memory argument for loads + stores
+type Memarg struct {
+	Align	uint32		// log2 of alignment
+	Offset	uint32
+}
+
+//
+// Block type: empty, a single (result) value type, or an index into the type
+// section
+//
+type BlockType struct {
+	Index	int64		// Type index, or -1 for empty/single value types
+	Result	ValueType	// Single result type, or 0 if empty or indexed
+}
+
+//
+// A single decoded instruction.  Only the immediate fields that correspond to
+// the Immediate encoding are populated
+//
+type DecodedInstruction struct {
+	Offset		int			// Byte offset within the function body
+	Length		int			// Encoded size, including prefix + immediates
+	Opcode		uint8
+	Subopcode	uint32		// Prefixed (0xFC, 0xFD) instructions only
+	Name		string
+	Immediate	uint8		// Immediate encoding, see Immediate* constants
+
+	BlockType	BlockType
+	Indices		[]uint32	// Index immediates, in encoding order.  br_table
+							// labels are followed by the default label
+	Memarg		Memarg
+	Value		uint64		// Constants: sign-extended integer or IEEE bits
+	Types		[]ValueType	// ref.null, typed select
+	Bytes		[]byte		// v128.const, i8x16.shuffle
+	Lane		uint8
+}
+
+// Typed accessors for constant immediates
+func (instr DecodedInstruction) I32() int32		{ return int32(instr.Value) }
+func (instr DecodedInstruction) I64() int64		{ return int64(instr.Value) }
+func (instr DecodedInstruction) F32() float32 {
+	return math.Float32frombits(uint32(instr.Value))
+}
+func (instr DecodedInstruction) F64() float64 {
+	return math.Float64frombits(instr.Value)
+}
+
+// Return the first index immediate, if any.  No side effects.
+func (instr DecodedInstruction) Index() uint32 {
+	if (len(instr.Indices) == 0) {
+		return 0
+	}
+	return instr.Indices[0]
+}
+
+
+//
+// Decode an entire function body or constant expression.  On failure, returns
+// the instructions decoded so far, plus an error that identifies the offset
+// of the malformed instruction.  No side effects.
+//
+func DecodeInstructions(code []byte) ([]DecodedInstruction, error) {
+	var instructions []DecodedInstruction
+	reader := bytes.NewReader(code)
+
+	for reader.Len() > 0 {
+		instr, err := decodeInstruction(reader, len(code) - reader.Len())
+		if (err != nil) {
+			return instructions, err
+		}
+		instructions = append(instructions, instr)
+	}
+
+	return instructions, nil
+}
+
+// Decode all instructions of a single function body.  No side effects.
+func (function Function) Instructions() ([]DecodedInstruction, error) {
+	return DecodeInstructions(function.body)
+}
+
+//
+// Decode a single instruction, at the given offset, from a stream of bytes.
+// Any error is wrapped with the offset + opcode.  No side effects.
+//
+func decodeInstruction(reader *bytes.Reader,
+	offset int) (DecodedInstruction, error) {
+	remaining := reader.Len()
+	instr, err := readInstruction(reader)
+	instr.Offset = offset
+	instr.Length = remaining - reader.Len()
+	if (err != nil) {
+		if (err == io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return instr, fmt.Errorf("offset %#x, opcode %#x: %w", offset,
+			instr.Opcode, err)
+	}
+	return instr, nil
+}
+
+// Decode a single instruction: opcode + immediates.  No side effects.
+func readInstruction(reader *bytes.Reader) (DecodedInstruction, error) {
+	instr := DecodedInstruction{}
+
+	opcode, err := reader.ReadByte()
+	if (err != nil) {
+		return instr, err
+	}
+	instr.Opcode = opcode
+
+	// Locate the opcode, including any prefixed sub-opcode
+	var info opcodeInfo
+	var ok bool
+	switch(opcode) {
+		case PrefixMisc, PrefixSIMD:
+			instr.Subopcode, err = readULEB128(reader)
+			if (err != nil) {
+				return instr, err
+			}
+			if (opcode == PrefixMisc) {
+				info, ok = miscOpcodeTable[instr.Subopcode]
+			} else {
+				info, ok = simdOpcodeTable[instr.Subopcode]
+			}
+			if !ok {
+				return instr, fmt.Errorf("%w: unknown opcode %#x %d",
+					InvalidInstruction, opcode, instr.Subopcode)
+			}
+
+		default:
+			info, ok = opcodeTable[opcode]
+			if !ok {
+				return instr, fmt.Errorf("%w: unknown opcode %#x",
+					InvalidInstruction, opcode)
+			}
+	}
+	instr.Name = info.name
+	instr.Immediate = info.immediate
+
+	return instr, instr.readImmediates(reader)
+}
+
+// Decode the immediates of an instruction, per its encoding
+func (instr *DecodedInstruction) readImmediates(reader *bytes.Reader) error {
+	var err error
+
+	switch(instr.Immediate) {
+		case ImmediateNone:
+			return nil
+
+		case ImmediateBlockType:
+			instr.BlockType, err = readBlockType(reader)
+
+		case ImmediateIndex:
+			err = instr.readIndices(reader, 1)
+
+		case ImmediateIndex2:
+			err = instr.readIndices(reader, 2)
+
+		case ImmediateBranchTable:
+			var count uint32
+			count, err = readVectorLength(reader)
+			if (err == nil) {
+				err = instr.readIndices(reader, int(count) + 1)
+			}
+
+		case ImmediateMemarg:
+			instr.Memarg, err = readMemarg(reader)
+
+		case ImmediateMemory:
+			err = instr.readMemoryIndices(reader, 1)
+
+		case ImmediateMemory2:
+			err = instr.readMemoryIndices(reader, 2)
+
+		case ImmediateIndexMemory:
+			err = instr.readIndices(reader, 1)
+			if (err == nil) {
+				err = instr.readMemoryIndices(reader, 1)
+			}
+
+		case ImmediateI32:
+			var value int32
+			value, err = readSLEB32(reader)
+			instr.Value = uint64(int64(value))
+
+		case ImmediateI64:
+			var value int64
+			value, err = readSLEB64(reader)
+			instr.Value = uint64(value)
+
+		case ImmediateF32:
+			var bits uint32
+			err = binary.Read(reader, binary.LittleEndian, &bits)
+			instr.Value = uint64(bits)
+
+		case ImmediateF64:
+			err = binary.Read(reader, binary.LittleEndian, &instr.Value)
+
+		case ImmediateRefType:
+			var rtype byte
+			rtype, err = reader.ReadByte()
+			instr.Types = []ValueType{ ValueType(rtype) }
+
+		case ImmediateValueTypes:
+			var count uint32
+			count, err = readVectorLength(reader)
+			for i := uint32(0); i < count && err == nil; i++ {
+				var vtype byte
+				vtype, err = reader.ReadByte()
+				instr.Types = append(instr.Types, ValueType(vtype))
+			}
+
+		case ImmediateV128, ImmediateShuffle:
+			instr.Bytes = make([]byte, 16)
+			_, err = io.ReadFull(reader, instr.Bytes)
+
+		case ImmediateLane:
+			instr.Lane, err = reader.ReadByte()
+
+		case ImmediateMemargLane:
+			instr.Memarg, err = readMemarg(reader)
+			if (err == nil) {
+				instr.Lane, err = reader.ReadByte()
+			}
+	}
+
+	return err
+}
+
+// Decode the given number of u32 index immediates
+func (instr *DecodedInstruction) readIndices(reader *bytes.Reader,
+	count int) error {
+	for i := 0; i < count; i++ {
+		index, err := readULEB128(reader)
+		if (err != nil) {
+			return err
+		}
+		instr.Indices = append(instr.Indices, index)
+	}
+	return nil
+}
+
+//
+// Decode the given number of memory indices.  Each is a single reserved byte
+// in WASM 1.x, nominally zero; the validator rejects any other value
+//
+func (instr *DecodedInstruction) readMemoryIndices(reader *bytes.Reader,
+	count int) error {
+	for i := 0; i < count; i++ {
+		index, err := reader.ReadByte()
+		if (err != nil) {
+			return err
+		}
+		instr.Indices = append(instr.Indices, uint32(index))
+	}
+	return nil
+}
+
+// Factory function for decoding + returning a Memarg.  No side effects.
+func readMemarg(reader *bytes.Reader) (Memarg, error) {
+	align, err := readULEB128(reader)
+	if (err != nil) {
+		return Memarg{}, err
+	}
+	offset, err := readULEB128(reader)
+	if (err != nil) {
+		return Memarg{}, err
+	}
+	return Memarg{ align, offset }, nil
+}
+
+//
+// Factory function for decoding + returning a BlockType.  Encoded as a signed
+// 33-bit value: negative values are single-byte value types (or the empty
+// type), non-negative values are type indices.  No side effects.
+//
+func readBlockType(reader *bytes.Reader) (BlockType, error) {
+	remaining := reader.Len()
+	value, err := readSLEB33(reader)
+	if (err != nil) {
+		return BlockType{}, err
+	}
+	if (value >= 0) {
+		return BlockType{ value, 0 }, nil
+	}
+
+	// Inline types occupy exactly one byte
+	if (remaining - reader.Len() != 1) {
+		return BlockType{}, fmt.Errorf("%w: invalid block type", InvalidInstruction)
+	}
+	if (value == -0x40) {
+		return BlockType{ -1, 0 }, nil
+	}
+	return BlockType{ -1, ValueType(byte(value) & 0x7F) }, nil
+}
+
+// Is this the empty block type (no parameters, no results)?  No side effects.
+func (btype BlockType) Empty() bool {
+	return (btype.Index < 0 && btype.Result == 0)
+}
@@ -0,0 +1,146 @@
+package wasm
+
+import(
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	)
+
+
+//
+// Test decoding of individual instructions + immediates
+//
+func TestInstructionDecoding(t *testing.T) {
+	testCases := []struct{
+		name		string
+		encoded		[]byte
+		decoded		DecodedInstruction
+	}{
+		{ "nop",
+		  []byte{ 0x01 },
+		  DecodedInstruction{ Length: 1, Opcode: 0x01, Name: "nop" } },
+
+		// Block types: empty, inline value type, type index
+		{ "block-empty",
+		  []byte{ 0x02, 0x40 },
+		  DecodedInstruction{ Length: 2, Opcode: 0x02, Name: "block",
+			Immediate: ImmediateBlockType, BlockType: BlockType{ -1, 0 } } },
+		{ "loop-i32",
+		  []byte{ 0x03, 0x7F },
+		  DecodedInstruction{ Length: 2, Opcode: 0x03, Name: "loop",
+			Immediate: ImmediateBlockType,
+			BlockType: BlockType{ -1, NumTypei32 } } },
+		{ "if-typeidx",
+		  []byte{ 0x04, 0x05 },
+		  DecodedInstruction{ Length: 2, Opcode: 0x04, Name: "if",
+			Immediate: ImmediateBlockType, BlockType: BlockType{ 5, 0 } } },
+
+		// Multi-byte LEB128 indices
+		{ "local.get-256",
+		  []byte{ 0x20, 0x80, 0x02 },
+		  DecodedInstruction{ Length: 3, Opcode: 0x20, Name: "local.get",
+			Immediate: ImmediateIndex, Indices: []uint32{ 256 } } },
+		{ "call_indirect",
+		  []byte{ 0x11, 0x02, 0x00 },
+		  DecodedInstruction{ Length: 3, Opcode: 0x11, Name: "call_indirect",
+			Immediate: ImmediateIndex2, Indices: []uint32{ 2, 0 } } },
+
+		// Labels, followed by the default label
+		{ "br_table",
+		  []byte{ 0x0E, 0x02, 0x00, 0x01, 0x02 },
+		  DecodedInstruction{ Length: 5, Opcode: 0x0E, Name: "br_table",
+			Immediate: ImmediateBranchTable, Indices: []uint32{ 0, 1, 2 } } },
+
+		{ "i32.load",
+		  []byte{ 0x28, 0x02, 0x90, 0x01 },
+		  DecodedInstruction{ Length: 4, Opcode: 0x28, Name: "i32.load",
+			Immediate: ImmediateMemarg, Memarg: Memarg{ 2, 0x90 } } },
+		{ "memory.grow",
+		  []byte{ 0x40, 0x00 },
+		  DecodedInstruction{ Length: 2, Opcode: 0x40, Name: "memory.grow",
+			Immediate: ImmediateMemory, Indices: []uint32{ 0 } } },
+
+		// Constants
+		{ "i32.const-neg",
+		  []byte{ 0x41, 0x7F },
+		  DecodedInstruction{ Length: 2, Opcode: 0x41, Name: "i32.const",
+			Immediate: ImmediateI32, Value: 0xFFFFFFFFFFFFFFFF } },
+		{ "f32.const",
+		  []byte{ 0x43, 0x00, 0x00, 0x80, 0x3F },
+		  DecodedInstruction{ Length: 5, Opcode: 0x43, Name: "f32.const",
+			Immediate: ImmediateF32, Value: 0x3F800000 } },
+
+		{ "select-typed",
+		  []byte{ 0x1C, 0x01, 0x7E },
+		  DecodedInstruction{ Length: 3, Opcode: 0x1C, Name: "select",
+			Immediate: ImmediateValueTypes,
+			Types: []ValueType{ NumTypei64 } } },
+		{ "ref.null",
+		  []byte{ 0xD0, 0x70 },
+		  DecodedInstruction{ Length: 2, Opcode: 0xD0, Name: "ref.null",
+			Immediate: ImmediateRefType,
+			Types: []ValueType{ RefTypeFunction } } },
+
+		// Prefixed instructions
+		{ "memory.init",
+		  []byte{ 0xFC, 0x08, 0x03, 0x00 },
+		  DecodedInstruction{ Length: 4, Opcode: 0xFC, Subopcode: 8,
+			Name: "memory.init", Immediate: ImmediateIndexMemory,
+			Indices: []uint32{ 3, 0 } } },
+		{ "v128.const",
+		  []byte{ 0xFD, 0x0C, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+			0x09, 0x0A, 0x0B, 0x0C, 0x0D, 0x0E, 0x0F, 0x10 },
+		  DecodedInstruction{ Length: 18, Opcode: 0xFD, Subopcode: 12,
+			Name: "v128.const", Immediate: ImmediateV128,
+			Bytes: []byte{ 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+				0x09, 0x0A, 0x0B, 0x0C, 0x0D, 0x0E, 0x0F, 0x10 } } },
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			instructions, err := DecodeInstructions(test.encoded)
+			if (err != nil) {
+				t.Fatal("Unexpected decoding error: ", err)
+			}
+			if (len(instructions) != 1) {
+				t.Fatalf("Unexpected instruction count: %d", len(instructions))
+			}
+			if (!reflect.DeepEqual(instructions[0], test.decoded)) {
+				t.Errorf("Unexpected instruction: %+v", instructions[0])
+			}
+		})
+	}
+}
+
+
+//
+// Test decoding of malformed instruction streams
+//
+func TestMalformedInstructions(t *testing.T) {
+	testCases := []struct{
+		name		string
+		encoded		[]byte
+		count		int		// Instructions decoded before the error
+		status		error
+	}{
+		{ "unknown-opcode",		[]byte{ 0x01, 0xFF },			1,	InvalidInstruction },
+		{ "unknown-fc",			[]byte{ 0xFC, 0x7F },			0,	InvalidInstruction },
+		{ "truncated-index",	[]byte{ 0x20, 0x80 },			0,	io.ErrUnexpectedEOF },
+		{ "truncated-f64",		[]byte{ 0x44, 0x00, 0x00 },		0,	io.ErrUnexpectedEOF },
+		{ "truncated-br_table",	[]byte{ 0x0E, 0x02, 0x00 },		0,	io.ErrUnexpectedEOF },
+		{ "long-block-type",	[]byte{ 0x02, 0xFF, 0x7F },		0,	InvalidInstruction },
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			instructions, err := DecodeInstructions(test.encoded)
+			if (!errors.Is(err, test.status)) {
+				t.Error("Unexpected decoding status: ", err)
+			}
+			if (len(instructions) != test.count) {
+				t.Errorf("Unexpected instruction count: %d", len(instructions))
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package wasm
+
+import (
+	"encoding/json"
+)
+
+
+//
+// Structured description of a module, e.g. for "dwasm dump --format=json":
+// the sections, types, imports, exports + function bodies.  Unlike
+// String(), the layout of the description is stable, for consumption by
+// other tools
+//
+type TypeDescription struct {
+	Params	[]string	`json:"params"`
+	Results	[]string	`json:"results"`
+}
+
+type LimitDescription struct {
+	Min		uint32		`json:"min"`
+	Max		*uint32		`json:"max,omitempty"`	// Absent if unbounded
+}
+
+// Exactly one of Type, Limits or ValueType is set, depending on the kind
+type ImportDescription struct {
+	Module		string				`json:"module"`
+	Name		string				`json:"name"`
+	Kind		string				`json:"kind"`
+	Type		*uint32				`json:"type,omitempty"`
+	Limits		*LimitDescription	`json:"limits,omitempty"`
+	RefType		string				`json:"refType,omitempty"`
+	ValueType	string				`json:"valueType,omitempty"`
+	Mutable		bool				`json:"mutable,omitempty"`
+}
+
+type ExportDescription struct {
+	Name	string	`json:"name"`
+	Kind	string	`json:"kind"`
+	Index	uint32	`json:"index"`
+}
+
+// A module-defined function; imported functions have no body
+type FunctionDescription struct {
+	Index	uint32		`json:"index"`		// Including any imports
+	Name	string		`json:"name"`
+	Type	uint32		`json:"type"`
+	Locals	[]string	`json:"locals"`
+	Size	int			`json:"size"`		// Encoded body size, in bytes
+}
+
+type ModuleDescription struct {
+	Sections	[]SectionSummary		`json:"sections"`
+	Types		[]TypeDescription		`json:"types"`
+	Imports		[]ImportDescription		`json:"imports"`
+	Exports		[]ExportDescription		`json:"exports"`
+	Functions	[]FunctionDescription	`json:"functions"`
+	Start		*uint32					`json:"start,omitempty"`
+}
+
+// Describe this module.  No side effects.
+func (module Module) Describe() ModuleDescription {
+	description := ModuleDescription{
+		Sections:	module.Inspect().Sections,
+		Types:		[]TypeDescription{},
+		Imports:	[]ImportDescription{},
+		Exports:	[]ExportDescription{},
+		Functions:	[]FunctionDescription{},
+	}
+
+	context := newModuleContext(module)
+	for _, ftype := range context.types {
+		description.Types = append(description.Types,
+			TypeDescription{ typeNames(ftype.parameter),
+				typeNames(ftype.result) })
+	}
+
+	if section, ok := module.section(ImportSectionId).(ImportSection); ok {
+		for _, imp := range section.imports {
+			description.Imports = append(description.Imports,
+				describeImport(imp))
+		}
+	}
+
+	if section, ok := module.section(ExportSectionId).(ExportSection); ok {
+		for _, export := range section.list {
+			description.Exports = append(description.Exports,
+				ExportDescription{ export.name,
+					ExportTypeMap[ int(export.etype) ], export.index })
+		}
+	}
+
+	// Function bodies are listed in the function index space, i.e., following
+	// any imported functions
+	names := module.Names()
+	if section, ok := module.section(CodeSectionId).(CodeSection); ok {
+		for i, function := range section.function {
+			index := uint32(context.imported + i)
+			var tindex uint32
+			if (int(index) < len(context.functions)) {
+				tindex = context.functions[index]
+			}
+			description.Functions = append(description.Functions,
+				FunctionDescription{ index, names.Function(index), tindex,
+					typeNames(function.local), len(function.body) })
+		}
+	}
+
+	if section, ok := module.section(StartSectionId).(StartSection); ok {
+		start := section.function
+		description.Start = &start
+	}
+
+	return description
+}
+
+// Descriptor of a single import, by kind.  No side effects.
+func describeImport(imp Import) ImportDescription {
+	description := ImportDescription{ Module: imp.module, Name: imp.name,
+		Kind: ExportTypeMap[ int(imp.itype) ] }
+
+	switch(imp.itype) {
+		case ExportTypeFunction:
+			tindex := imp.function
+			description.Type = &tindex
+		case ExportTypeTable:
+			description.Limits = describeLimit(imp.table.limit)
+			description.RefType = typeName(ValueType(imp.table.reftype))
+		case ExportTypeMemory:
+			description.Limits = describeLimit(imp.memory.limit)
+		case ExportTypeGlobal:
+			description.ValueType = typeName(imp.global.vtype)
+			description.Mutable = imp.global.mutable
+	}
+	return description
+}
+
+func describeLimit(limit Limit) *LimitDescription {
+	description := &LimitDescription{ Min: limit.min }
+	if (limit.flags != 0) {
+		max := limit.max
+		description.Max = &max
+	}
+	return description
+}
+
+// Names of a list of types, e.g. ["i32", "f64"].  No side effects.
+func typeNames(types []ValueType) []string {
+	names := make([]string, len(types))
+	for i, vtype := range types {
+		names[i] = typeName(vtype)
+	}
+	return names
+}
+
+
+//
+// Target features are encoded with their prefix as a string, e.g. "+", rather
+// than as a byte value
+//
+func (feature TargetFeature) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct{
+		Prefix	string	`json:"prefix"`
+		Name	string	`json:"name"`
+	}{ string(feature.Prefix), feature.Name })
+}
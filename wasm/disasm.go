@@ -0,0 +1,246 @@
+package wasm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+
+//
+// Disassembler.  Prints the instructions of every function body, similar to
+// "wasm-objdump -d": byte offset + raw bytes + mnemonic + decoded immediates,
+// with any references annotated by name when the module carries a name
+// section.  Offsets are relative to the start of each function body (i.e.,
+// following the local declarations)
+//
+func (module Module) Disassemble(w io.Writer) error {
+	codeSection, ok := module.section(CodeSectionId).(CodeSection)
+	if !ok {
+		return nil
+	}
+	names := module.Names()
+	imported := newModuleContext(module).imported
+
+	for i, function := range codeSection.function {
+		index := uint32(imported + i)
+		_, err := fmt.Fprintf(w, "func[%d] %s:\n", index,
+			names.Function(index))
+		if (err != nil) {
+			return err
+		}
+
+		err = disassembleFunction(w, function, names, index)
+		if (err != nil) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Print the instructions of a single function body
+func disassembleFunction(w io.Writer, function Function, names NameSection,
+	index uint32) error {
+	instructions, decodeErr := function.Instructions()
+
+	depth := 0
+	for _, instr := range instructions {
+		// Nested blocks are indented; "else" + "end" close the current block
+		if (instr.Opcode == 0x05 || instr.Opcode == 0x0B) {
+			if (depth > 0) {
+				depth--
+			}
+		}
+
+		raw := function.body[instr.Offset:instr.Offset + instr.Length]
+		_, err := fmt.Fprintf(w, " %06x: %-30s| %s%s\n", instr.Offset,
+			hexBytes(raw), strings.Repeat("  ", depth),
+			formatInstruction(instr, names, index))
+		if (err != nil) {
+			return err
+		}
+
+		switch(instr.Opcode) {
+			case 0x02, 0x03, 0x04, 0x05:	// block, loop, if, else
+				depth++
+		}
+	}
+
+	// Print (rather than fail on) any malformed trailing bytes, so that the
+	// rest of the module is still visible
+	if (decodeErr != nil) {
+		_, err := fmt.Fprintf(w, " malformed: %s\n", decodeErr)
+		return err
+	}
+	return nil
+}
+
+// Space-separated hex bytes, e.g. "20 00".  No side effects.
+func hexBytes(raw []byte) string {
+	hex := make([]string, len(raw))
+	for i, b := range raw {
+		hex[i] = fmt.Sprintf("%02x", b)
+	}
+	return strings.Join(hex, " ")
+}
+
+
+//
+// Format a single instruction as its mnemonic plus immediates, e.g.
+// "local.get 0 <$n>".  Indices of named entities are annotated with their
+// names.  The given function index is used for resolving local names.  No
+// side effects.
+//
+func formatInstruction(instr DecodedInstruction, names NameSection,
+	function uint32) string {
+	immediates := formatImmediates(instr, names, function)
+	if (immediates == "") {
+		return instr.Name
+	}
+	return instr.Name + " " + immediates
+}
+
+func formatImmediates(instr DecodedInstruction, names NameSection,
+	function uint32) string {
+	switch(instr.Immediate) {
+		case ImmediateBlockType:
+			if (instr.BlockType.Empty()) {
+				return ""
+			} else if (instr.BlockType.Index < 0) {
+				return typeName(instr.BlockType.Result)
+			}
+			return fmt.Sprintf("type[%d]", instr.BlockType.Index)
+
+		case ImmediateIndex:
+			return formatIndex(instr, instr.Index(), names, function)
+
+		case ImmediateIndex2, ImmediateBranchTable, ImmediateMemory,
+			ImmediateMemory2, ImmediateIndexMemory:
+			indices := make([]string, len(instr.Indices))
+			for i, index := range instr.Indices {
+				indices[i] = strconv.FormatUint(uint64(index), 10)
+			}
+			return strings.Join(indices, " ")
+
+		case ImmediateMemarg:
+			return formatMemarg(instr.Memarg)
+
+		case ImmediateI32:
+			return strconv.FormatInt(int64(instr.I32()), 10)
+		case ImmediateI64:
+			return strconv.FormatInt(instr.I64(), 10)
+		case ImmediateF32:
+			return formatFloat(float64(instr.F32()), 32,
+				uint64(uint32(instr.Value)))
+		case ImmediateF64:
+			return formatFloat(instr.F64(), 64, instr.Value)
+
+		case ImmediateRefType:
+			return heapTypeName(instr.Types[0])
+
+		case ImmediateValueTypes:
+			types := make([]string, len(instr.Types))
+			for i, vtype := range instr.Types {
+				types[i] = typeName(vtype)
+			}
+			return strings.Join(types, " ")
+
+		case ImmediateV128:
+			// Displayed as 4 x i32 lanes, little-endian
+			lanes := make([]string, 4)
+			for i := range lanes {
+				lanes[i] = fmt.Sprintf("%#08x",
+					binary.LittleEndian.Uint32(instr.Bytes[i * 4:]))
+			}
+			return "i32x4 " + strings.Join(lanes, " ")
+
+		case ImmediateShuffle:
+			lanes := make([]string, len(instr.Bytes))
+			for i, lane := range instr.Bytes {
+				lanes[i] = strconv.Itoa(int(lane))
+			}
+			return strings.Join(lanes, " ")
+
+		case ImmediateLane:
+			return strconv.Itoa(int(instr.Lane))
+
+		case ImmediateMemargLane:
+			return fmt.Sprintf("%s %d", formatMemarg(instr.Memarg), instr.Lane)
+	}
+
+	return ""
+}
+
+// Format a single index immediate, annotated with the name of the referenced
+// entity, if known
+func formatIndex(instr DecodedInstruction, index uint32, names NameSection,
+	function uint32) string {
+	var name string
+	switch(instr.Opcode) {
+		case 0x10, 0xD2:			// call, ref.func
+			name = names.Functions[index]
+		case 0x20, 0x21, 0x22:		// local.*
+			name = names.Locals[function][index]
+		case 0x23, 0x24:			// global.*
+			name = names.Globals[index]
+		case 0x25, 0x26:			// table.*
+			name = names.Tables[index]
+	}
+
+	if (name == "") {
+		return strconv.FormatUint(uint64(index), 10)
+	}
+	return fmt.Sprintf("%d <$%s>", index, name)
+}
+
+// Memory argument, in WAT form.  Alignment is shown in bytes
+func formatMemarg(memarg Memarg) string {
+	if (memarg.Align >= 64) {
+		return fmt.Sprintf("offset=%d align=2**%d", memarg.Offset, memarg.Align)
+	}
+	return fmt.Sprintf("offset=%d align=%d", memarg.Offset,
+		uint64(1) << memarg.Align)
+}
+
+//
+// Format a floating-point constant in WAT form: "inf", "nan", "nan:0x..." for
+// non-canonical NaNs, or the shortest decimal form that round-trips.  No side
+// effects.
+//
+func formatFloat(value float64, bits int, raw uint64) string {
+	sign := ""
+	if ((raw >> (bits - 1)) & 1 != 0) {
+		sign = "-"
+	}
+
+	if (math.IsInf(value, 0)) {
+		return sign + "inf"
+	}
+	if (math.IsNaN(value)) {
+		// Payload is the mantissa; the canonical NaN has only its top bit set
+		mantissaBits := 52
+		if (bits == 32) {
+			mantissaBits = 23
+		}
+		payload := raw & ((uint64(1) << mantissaBits) - 1)
+		if (payload == uint64(1) << (mantissaBits - 1)) {
+			return sign + "nan"
+		}
+		return fmt.Sprintf("%snan:%#x", sign, payload)
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, bits)
+}
+
+// Heap type of a reference type, as used by ref.null: "func" or "extern"
+func heapTypeName(rtype ValueType) string {
+	switch(rtype) {
+		case RefTypeFunction:	return "func"
+		case RefTypeExtern:		return "extern"
+	}
+	return fmt.Sprintf("%#x", uint8(rtype))
+}
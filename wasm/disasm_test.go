@@ -0,0 +1,127 @@
+package wasm
+
+import(
+	"bytes"
+	"strings"
+	"testing"
+	)
+
+
+//
+// Test formatting of individual instructions + immediates
+//
+func TestInstructionFormatting(t *testing.T) {
+	names := NameSection{
+		Functions:	NameMap{ 0: "fac" },
+		Locals:		IndirectNameMap{ 0: NameMap{ 0: "n" } },
+	}
+
+	testCases := []struct{
+		name		string
+		encoded		[]byte
+		formatted	string
+	}{
+		{ "nop",			[]byte{ 0x01 },					"nop" },
+		{ "block-empty",	[]byte{ 0x02, 0x40 },			"block" },
+		{ "if-f64",			[]byte{ 0x04, 0x7C },			"if f64" },
+		{ "loop-typeidx",	[]byte{ 0x03, 0x02 },			"loop type[2]" },
+		{ "call-named",		[]byte{ 0x10, 0x00 },			"call 0 <$fac>" },
+		{ "call-unnamed",	[]byte{ 0x10, 0x01 },			"call 1" },
+		{ "local-named",	[]byte{ 0x20, 0x00 },			"local.get 0 <$n>" },
+		{ "local-256",		[]byte{ 0x21, 0x80, 0x02 },		"local.set 256" },
+		{ "br_table",		[]byte{ 0x0E, 0x02, 0x00, 0x01, 0x02 },
+															"br_table 0 1 2" },
+		{ "call_indirect",	[]byte{ 0x11, 0x02, 0x00 },		"call_indirect 2 0" },
+		{ "i32.load",		[]byte{ 0x28, 0x02, 0x10 },
+												"i32.load offset=16 align=4" },
+		{ "i32.const",		[]byte{ 0x41, 0x7F },			"i32.const -1" },
+		{ "i64.const",		[]byte{ 0x42, 0x80, 0x01 },		"i64.const 128" },
+		{ "f32.const",		[]byte{ 0x43, 0x00, 0x00, 0xC0, 0x3F },
+															"f32.const 1.5" },
+		{ "f32.inf",		[]byte{ 0x43, 0x00, 0x00, 0x80, 0xFF },
+															"f32.const -inf" },
+		{ "f32.nan",		[]byte{ 0x43, 0x00, 0x00, 0xC0, 0x7F },
+															"f32.const nan" },
+		{ "f64.nan-payload",
+		  []byte{ 0x44, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0xF0, 0x7F },
+		  "f64.const nan:0x1" },
+		{ "ref.null",		[]byte{ 0xD0, 0x6F },			"ref.null extern" },
+		{ "select-typed",	[]byte{ 0x1C, 0x01, 0x7F },		"select i32" },
+		{ "memory.copy",	[]byte{ 0xFC, 0x0A, 0x00, 0x00 },	"memory.copy 0 0" },
+		{ "v128.const",
+		  []byte{ 0xFD, 0x0C, 0x01, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00,
+			0x03, 0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00 },
+		  "v128.const i32x4 0x00000001 0x00000002 0x00000003 0x00000004" },
+		{ "i8x16.extract_lane_s",	[]byte{ 0xFD, 0x15, 0x03 },
+												"i8x16.extract_lane_s 3" },
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			instructions, err := DecodeInstructions(test.encoded)
+			if (err != nil || len(instructions) != 1) {
+				t.Fatal("Unexpected decoding status: ", err)
+			}
+			formatted := formatInstruction(instructions[0], names, 0)
+			if (formatted != test.formatted) {
+				t.Errorf("Unexpected formatting: %s (expected %s)", formatted,
+					test.formatted)
+			}
+		})
+	}
+}
+
+
+//
+// Test disassembly of an entire module, including offsets, raw bytes,
+// indentation + names
+//
+func TestDisassemble(t *testing.T) {
+	fac := FunctionType{ ResultType{ NumTypei32 }, ResultType{ NumTypei32 } }
+	encoded := append(CreateModuleBuilder().
+		Function("", fac, nil,
+			CreateExpression().
+				LocalGet(0).If(NumTypei32).I32Const(1).Else().
+				LocalGet(0).Call(0).End()).
+		Bytes(),
+		customSection(NameSectionName,
+			0x01, 0x06, 0x01, 0x00, 0x03, 'f', 'a', 'c',
+			0x02, 0x06, 0x01, 0x00, 0x01, 0x00, 0x01, 'n')...)
+
+	module, err := ReadModule(bytes.NewReader(encoded))
+	if (err != nil) {
+		t.Fatal("Unexpected decoding status: ", err)
+	}
+
+	var output bytes.Buffer
+	err = module.Disassemble(&output)
+	if (err != nil) {
+		t.Fatal("Unexpected disassembly error: ", err)
+	}
+
+	expected := []string{
+		"func[0] $fac:",
+		" 000000: 20 00                         | local.get 0 <$n>",
+		" 000002: 04 7f                         | if i32",
+		" 000004: 41 01                         |   i32.const 1",
+		" 000006: 05                            | else",
+		" 000007: 20 00                         |   local.get 0 <$n>",
+		" 000009: 10 00                         |   call 0 <$fac>",
+		" 00000b: 0b                            | end",
+		" 00000c: 0b                            | end",
+		"",
+	}
+	if (output.String() != strings.Join(expected, "\n")) {
+		t.Errorf("Unexpected disassembly:\n%s", output.String())
+	}
+
+	// Malformed bodies are disassembled up to the malformed instruction
+	module, _ = ReadModule(bytes.NewReader(CreateModuleBuilder().
+		Function("", FunctionType{}, nil, CreateExpression().Nop().Op(0xFF)).
+		Bytes()))
+	output.Reset()
+	module.Disassemble(&output)
+	if (!strings.Contains(output.String(), "| nop\n malformed: ")) {
+		t.Errorf("Unexpected disassembly:\n%s", output.String())
+	}
+}
@@ -0,0 +1,52 @@
+package wasm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+
+//
+// Read a single constant expression (e.g., a global initializer), up to and
+// including the trailing "end" opcode.  Any decodable instruction is accepted
+// here; whether the expression is actually constant is left to the validator.
+// Returns the raw bytes of the expression.  No side effects.
+//
+func readConstantExpression(reader *bytes.Reader) ([]byte, error) {
+	start := reader.Size() - int64(reader.Len())
+
+	for {
+		instr, err := readInstruction(reader)
+		if (errors.Is(err, InvalidInstruction)) {
+			return nil, fmt.Errorf("%w: %s", InvalidSection, err)
+		} else if (err != nil) {
+			return nil, err
+		}
+		if (instr.Opcode == 0x0B) {
+			break
+		}
+	}
+
+	// Recover the raw bytes of the expression
+	end := reader.Size() - int64(reader.Len())
+	expr := make([]byte, end - start)
+	reader.ReadAt(expr, start)
+	return expr, nil
+}
+
+//
+// Decode a constant expression into its instructions, excluding the trailing
+// "end".  No side effects.
+//
+func decodeConstantExpression(expr []byte) ([]DecodedInstruction, error) {
+	instructions, err := DecodeInstructions(expr)
+	if (err != nil) {
+		return nil, err
+	}
+	if (len(instructions) == 0 ||
+		instructions[len(instructions) - 1].Opcode != 0x0B) {
+		return nil, errors.New("missing end of constant expression")
+	}
+	return instructions[:len(instructions) - 1], nil
+}
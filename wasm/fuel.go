@@ -0,0 +1,128 @@
+package wasm
+
+import (
+	"errors"
+)
+
+
+// Execution stopped because the fuel ran out
+var OutOfFuel = errors.New("Out of fuel")
+
+
+//
+// Fuel metering, for bounding the work of untrusted code.  The interpreter
+// charges each instruction before executing it: by default one unit, or its
+// cost by name (e.g., "i32.add"), if set.  Calls to imported (host) functions
+// are charged similarly on entry, by import module + name.  The interpreter
+// does not execute call yet, so a host function is only charged when it is
+// itself the invoked function.  If the remaining fuel
+// cannot cover the next charge, the Exhausted callback (if any) may refuel so
+// that execution resumes; otherwise execution stops with OutOfFuel, before the
+// instruction executes.  Metering is deterministic: the same code + inputs
+// always consume the same fuel.  Fuel carries over between invocations.  Not
+// safe for concurrent use
+//
+type Fuel struct {
+	Exhausted	func(fuel *Fuel) bool	// Refuel + resume, if returns true
+
+	remaining	uint64
+	consumed	uint64
+	costs		map[string]uint64	// Instruction costs, by name
+	hostCosts	map[hostFunction]uint64	// Host function costs, by import
+	defaultCost	uint64
+	hostCost	uint64				// Default host function cost
+}
+
+//
+// Factory function for generating a Fuel meter with the given initial fuel.
+// Each instruction + host call costs one unit, unless set otherwise.  No side
+// effects.
+//
+func CreateFuel(fuel uint64) *Fuel {
+	return &Fuel{ remaining: fuel, costs: make(map[string]uint64),
+		hostCosts: make(map[hostFunction]uint64), defaultCost: 1,
+		hostCost: 1 }
+}
+
+// Imported (host) function, by import module + name
+type hostFunction struct {
+	module	string
+	name	string
+}
+
+// Remaining fuel.  No side effects.
+func (fuel *Fuel) Remaining() uint64 {
+	return fuel.remaining
+}
+
+// Total fuel charged so far.  No side effects.
+func (fuel *Fuel) Consumed() uint64 {
+	return fuel.consumed
+}
+
+// Add fuel, e.g. before resuming after OutOfFuel
+func (fuel *Fuel) Refuel(amount uint64) {
+	if (fuel.remaining + amount < fuel.remaining) {
+		fuel.remaining = ^uint64(0)
+		return
+	}
+	fuel.remaining += amount
+}
+
+// Cost of instructions without a specific cost
+func (fuel *Fuel) SetDefaultCost(cost uint64) {
+	fuel.defaultCost = cost
+}
+
+// Cost of a single instruction, by name, e.g. "memory.grow"
+func (fuel *Fuel) SetCost(name string, cost uint64) {
+	fuel.costs[name] = cost
+}
+
+// Cost of host functions without a specific cost
+func (fuel *Fuel) SetDefaultHostCost(cost uint64) {
+	fuel.hostCost = cost
+}
+
+// Cost of calling a single host function, by import module + name
+func (fuel *Fuel) SetHostCost(module string, name string, cost uint64) {
+	fuel.hostCosts[hostFunction{ module, name }] = cost
+}
+
+// Cost of an instruction.  No side effects.
+func (fuel *Fuel) Cost(name string) uint64 {
+	cost, ok := fuel.costs[name]
+	if !ok {
+		return fuel.defaultCost
+	}
+	return cost
+}
+
+// Cost of calling a host function, by import module + name.  No side effects.
+func (fuel *Fuel) HostCost(module string, name string) uint64 {
+	cost, ok := fuel.hostCosts[hostFunction{ module, name }]
+	if !ok {
+		return fuel.hostCost
+	}
+	return cost
+}
+
+//
+// Called by the interpreter before executing each instruction (and calling
+// each host function).  Returns OutOfFuel if the fuel cannot cover the cost,
+// even after any refuelling
+//
+func (fuel *Fuel) charge(cost uint64) error {
+	for (cost > fuel.remaining) {
+		if (fuel.Exhausted == nil) {
+			return OutOfFuel
+		}
+		before := fuel.remaining
+		if (!fuel.Exhausted(fuel) || fuel.remaining <= before) {
+			return OutOfFuel
+		}
+	}
+	fuel.remaining -= cost
+	fuel.consumed += cost
+	return nil
+}
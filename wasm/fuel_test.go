@@ -0,0 +1,100 @@
+package wasm
+
+import(
+	"errors"
+	"strings"
+	"testing"
+	)
+
+
+//
+// Test fuel metering: per-instruction costs, exhaustion + refuelling
+//
+func TestFuel(t *testing.T) {
+	module, err := ReadWAT(strings.NewReader(`(module
+		(func (export "add") (param i32 i32) (result i32)
+			local.get 0 local.get 1 i32.add))`))
+	if (err != nil) {
+		t.Fatal("Unexpected assembly error: ", err)
+	}
+	args := []interface{}{ int32(3), int32(4) }
+
+	tests := []struct {
+		fuel		uint64
+		costs		map[string]uint64
+		err			error
+		remaining	uint64
+	}{
+		{ 4,	nil,								nil,		0 },
+		{ 10,	nil,								nil,		6 },
+		{ 3,	nil,								OutOfFuel,	0 },
+		{ 13,	map[string]uint64{ "i32.add": 10 },	nil,		0 },
+		{ 11,	map[string]uint64{ "i32.add": 10 },	OutOfFuel,	9 },
+		{ 1,	map[string]uint64{ "local.get": 0,
+			"end": 0 },								nil,		0 },
+	}
+	for i, test := range tests {
+		fuel := CreateFuel(test.fuel)
+		for name, cost := range test.costs {
+			fuel.SetCost(name, cost)
+		}
+		_, err := WASMInterpreter{}.Invoke(module, "add", args,
+			VMConfig{ Fuel: fuel })
+		if (!errors.Is(err, test.err) || (test.err == nil && err != nil) ||
+			fuel.Remaining() != test.remaining ||
+			fuel.Consumed() != test.fuel - test.remaining) {
+			t.Errorf("Test %d: unexpected status %v, remaining %d, consumed %d",
+				i, err, fuel.Remaining(), fuel.Consumed())
+		}
+	}
+
+	// Refuelling from the callback resumes execution
+	fuel := CreateFuel(2)
+	refuels := 0
+	fuel.Exhausted = func(fuel *Fuel) bool {
+		refuels++
+		fuel.Refuel(1)
+		return true
+	}
+	results, err := WASMInterpreter{}.Invoke(module, "add", args,
+		VMConfig{ Fuel: fuel })
+	if (err != nil || len(results) != 1 || results[0] != int32(7) ||
+		refuels != 2 || fuel.Remaining() != 0 || fuel.Consumed() != 4) {
+		t.Errorf("Unexpected status %v, results %v, refuels %d", err, results,
+			refuels)
+	}
+
+	// Host function costs, charged on entry (although the call then fails,
+	// since imports are not linked)
+	fuel.SetDefaultHostCost(100)
+	fuel.SetHostCost("env", "log", 5)
+	if (fuel.HostCost("env", "log") != 5 || fuel.HostCost("env", "x") != 100) {
+		t.Errorf("Unexpected host costs")
+	}
+	fuel.SetHostCost("a.b", "c", 7)
+	if (fuel.HostCost("a", "b.c") != 100 || fuel.HostCost("a.b", "c") != 7) {
+		t.Errorf("Unexpected host costs for dotted names")
+	}
+	module, err = ReadWAT(strings.NewReader(`(module
+		(import "env" "x" (func))
+		(import "env" "log" (func))
+		(export "x" (func 0))
+		(export "log" (func 1)))`))
+	if (err != nil) {
+		t.Fatal("Unexpected assembly error: ", err)
+	}
+	fuel.Exhausted = nil
+	fuel.Refuel(10)
+	_, err = WASMInterpreter{}.Invoke(module, "log", nil,
+		VMConfig{ Fuel: fuel })
+	if (!errors.Is(err, MissingFunction) || fuel.Remaining() != 5 ||
+		fuel.Consumed() != 9) {
+		t.Errorf("Unexpected status %v, fuel %d consumed, %d remaining", err,
+			fuel.Consumed(), fuel.Remaining())
+	}
+	_, err = WASMInterpreter{}.Invoke(module, "x", nil,
+		VMConfig{ Fuel: fuel })
+	if (!errors.Is(err, OutOfFuel) || fuel.Remaining() != 5) {
+		t.Errorf("Unexpected status %v, %d remaining", err, fuel.Remaining())
+	}
+}
@@ -0,0 +1,236 @@
+package wasm
+
+import(
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	)
+
+
+//
+// Seed corpus for all fuzz targets: hand-assembled equivalents of the
+// samples/*.wat modules.  Any samples built via "make" are added as well.
+// Crashers found by the fuzzer live under testdata/fuzz/<target>, and are
+// replayed as regression tests by every "go test" run
+//
+var fuzzSeeds = map[string][]byte {
+	"empty.wat": {
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00 },
+
+	"fnop.wat": {
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+		0x01, 0x04, 0x01, 0x60, 0x00, 0x00,
+		0x03, 0x02, 0x01, 0x00,
+		0x07, 0x08, 0x01, 0x04, 'f', 'n', 'o', 'p', 0x00, 0x00,
+		0x0a, 0x06, 0x01, 0x04, 0x00, 0x01, 0x01, 0x0b },
+
+	"simple.wat": {
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+		0x01, 0x07, 0x01, 0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7f,
+		0x03, 0x02, 0x01, 0x00,
+		0x07, 0x0a, 0x01, 0x06, 'a', 'd', 'd', 'T', 'w', 'o', 0x00, 0x00,
+		0x0a, 0x09, 0x01, 0x07, 0x00, 0x20, 0x00, 0x20, 0x01, 0x6a, 0x0b },
+
+	"factorial.wat": {
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+		0x01, 0x06, 0x01, 0x60, 0x01, 0x7c, 0x01, 0x7c,
+		0x03, 0x02, 0x01, 0x00,
+		0x07, 0x07, 0x01, 0x03, 'f', 'a', 'c', 0x00, 0x00,
+		0x0a, 0x2e, 0x01, 0x2c, 0x00,
+		0x20, 0x00,
+		0x44, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf0, 0x3f,
+		0x63,
+		0x04, 0x7c,
+		0x44, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf0, 0x3f,
+		0x05,
+		0x20, 0x00, 0x20, 0x00,
+		0x44, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf0, 0x3f,
+		0xa1, 0x10, 0x00, 0xa2,
+		0x0b, 0x0b },
+
+	"multi-value.wat": {
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+		0x01, 0x0e, 0x02,
+		0x60, 0x02, 0x7f, 0x7f, 0x02, 0x7f, 0x7f,
+		0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7f,
+		0x03, 0x03, 0x02, 0x00, 0x01,
+		0x07, 0x0e, 0x01, 0x0a,
+		'r', 'e', 'v', 'e', 'r', 's', 'e', 'S', 'u', 'b', 0x00, 0x01,
+		0x0a, 0x12, 0x02,
+		0x06, 0x00, 0x20, 0x01, 0x20, 0x00, 0x0b,
+		0x09, 0x00, 0x20, 0x00, 0x20, 0x01, 0x10, 0x00, 0x6b, 0x0b },
+
+	"mutable-globals.wat": {
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+		0x01, 0x04, 0x01, 0x60, 0x00, 0x00,
+		0x02, 0x0a, 0x01, 0x03, 'e', 'n', 'v', 0x01, 'g', 0x03, 0x7f, 0x01,
+		0x03, 0x02, 0x01, 0x00,
+		0x07, 0x05, 0x01, 0x01, 'f', 0x00, 0x00,
+		0x0a, 0x09, 0x01, 0x07, 0x00, 0x41, 0xe4, 0x00, 0x24, 0x00, 0x0b },
+
+	"sign-extension.wat": {
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+		0x01, 0x06, 0x01, 0x60, 0x01, 0x7f, 0x01, 0x7f,
+		0x03, 0x02, 0x01, 0x00,
+		0x07, 0x05, 0x01, 0x01, 'f', 0x00, 0x00,
+		0x0a, 0x07, 0x01, 0x05, 0x00, 0x20, 0x00, 0xc0, 0x0b },
+
+	"saturating-float-to-int.wat": {
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+		0x01, 0x06, 0x01, 0x60, 0x01, 0x7d, 0x01, 0x7f,
+		0x03, 0x02, 0x01, 0x00,
+		0x07, 0x05, 0x01, 0x01, 'f', 0x00, 0x00,
+		0x0a, 0x08, 0x01, 0x06, 0x00, 0x20, 0x00, 0xfc, 0x00, 0x0b },
+
+	"bulk-mem.wat": {
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+		0x01, 0x07, 0x01, 0x60, 0x03, 0x7f, 0x7f, 0x7f, 0x00,
+		0x03, 0x02, 0x01, 0x00,
+		0x05, 0x03, 0x01, 0x00, 0x01,
+		0x07, 0x0e, 0x02,
+		0x03, 'm', 'e', 'm', 0x02, 0x00,
+		0x04, 'f', 'i', 'l', 'l', 0x00, 0x00,
+		0x0a, 0x0d, 0x01, 0x0b, 0x00,
+		0x20, 0x00, 0x20, 0x01, 0x20, 0x02, 0xfc, 0x0b, 0x00, 0x0b },
+}
+
+// Upper bound on instructions executed per fuzzed invocation, so that no
+// input can hang the fuzzer
+const fuzzInstructionLimit = 10000
+
+func addFuzzSeeds(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+
+	paths, _ := filepath.Glob("../samples/*.wasm")
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if (err == nil) {
+			f.Add(content)
+		}
+	}
+}
+
+
+//
+// Decoding arbitrary input never panics, and every failure is a DecodeError
+//
+func FuzzReadModule(f *testing.F) {
+	addFuzzSeeds(f)
+
+	f.Fuzz(func(t *testing.T, encoded []byte) {
+		module, err := ReadModule(bytes.NewReader(encoded))
+		if (err != nil) {
+			var decodeError DecodeError
+			if (!errors.As(err, &decodeError)) {
+				t.Fatal("Unexpected (non-DecodeError) failure: ", err)
+			}
+			return
+		}
+
+		// Rendering the module is also safe
+		_ = module.String()
+	})
+}
+
+
+//
+// Validating any decodable module never panics
+//
+func FuzzValidate(f *testing.F) {
+	addFuzzSeeds(f)
+
+	f.Fuzz(func(t *testing.T, encoded []byte) {
+		module, err := ReadModule(bytes.NewReader(encoded))
+		if (err != nil) {
+			return
+		}
+
+		err = module.Validate()
+		if (err != nil &&
+			!errors.Is(err, InvalidModule) &&
+			!errors.Is(err, InvalidFunction) &&
+			!errors.Is(err, InvalidSection)) {
+			t.Fatal("Unexpected validation failure: ", err)
+		}
+	})
+}
+
+
+//
+// Executing every exported function of any valid module never panics or
+// hangs
+//
+func FuzzExecute(f *testing.F) {
+	addFuzzSeeds(f)
+
+	// The VM logs any results; suppress these while fuzzing
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	f.Fuzz(func(t *testing.T, encoded []byte) {
+		module, err := ReadModule(bytes.NewReader(encoded))
+		if (err != nil || module.Validate() != nil) {
+			return
+		}
+		exportSection, ok := module.section(ExportSectionId).(ExportSection)
+		if !ok {
+			return
+		}
+		context := newModuleContext(module)
+
+		for _, export := range exportSection.list {
+			if (export.etype != ExportTypeFunction) {
+				continue
+			}
+
+			// Preload one (zero) argument per parameter
+			ftype, _ := context.functionType(export.index)
+			config := VMConfig{
+				StartFn:			export.name,
+				StartStack:			make([]int32, len(ftype.parameter)),
+				MaxInstructions:	fuzzInstructionLimit,
+			}
+
+			vm, err := CreateVM(config)
+			if (err != nil) {
+				t.Fatal("Unexpected VM creation error: ", err)
+			}
+			vm.Execute(module, config)
+		}
+	})
+}
+
+
+//
+// Re-encoding any decodable module yields a decodable module, and encoding
+// is stable thereafter
+//
+func FuzzRoundTrip(f *testing.F) {
+	addFuzzSeeds(f)
+
+	f.Fuzz(func(t *testing.T, encoded []byte) {
+		module, err := ReadModule(bytes.NewReader(encoded))
+		if (err != nil) {
+			return
+		}
+
+		var first, second bytes.Buffer
+		module.WriteTo(&first)
+		module, err = ReadModule(bytes.NewReader(first.Bytes()))
+		if (err != nil) {
+			t.Fatal("Unable to decode re-encoded module: ", err)
+		}
+		module.WriteTo(&second)
+
+		if (!bytes.Equal(first.Bytes(), second.Bytes())) {
+			t.Fatalf("Unstable encoding:\n% x\n% x", first.Bytes(),
+				second.Bytes())
+		}
+	})
+}
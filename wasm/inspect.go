@@ -0,0 +1,173 @@
+package wasm
+
+import (
+	"fmt"
+	"strings"
+)
+
+
+//
+// Module summary, e.g. for "dwasm inspect": the encoded size of each
+// section, the number of entities in each index space, plus any metadata from
+// the well-known custom sections
+//
+type SectionSummary struct {
+	Id		uint8		`json:"id"`
+	Name	string		`json:"name"`	// Kind, or the name of a custom section
+	Size	int			`json:"size"`	// Encoded content size, in bytes
+}
+
+type ModuleSummary struct {
+	Size		int					`json:"size"`	// Encoded size, in bytes
+	Sections	[]SectionSummary	`json:"sections"`
+
+	// Number of entities in each index space, including any imports
+	Types		int		`json:"types"`
+	Imports		int		`json:"imports"`
+	Functions	int		`json:"functions"`
+	Tables		int		`json:"tables"`
+	Memories	int		`json:"memories"`
+	Globals		int		`json:"globals"`
+	Exports		int		`json:"exports"`
+	Elements	int		`json:"elements"`
+	Data		int		`json:"data"`
+
+	Start		string	`json:"start,omitempty"`	// Name of the start function
+
+	Producers			ProducersSection		`json:"producers"`
+	TargetFeatures		TargetFeaturesSection	`json:"targetFeatures"`
+	SourceMappingURL	string		`json:"sourceMappingURL,omitempty"`
+	ExternalDebugInfo	string		`json:"externalDebugInfo,omitempty"`
+	Unsupported			[]string	`json:"unsupported"`	// Not supported by the VM
+}
+
+// Kind of each known section, by id
+var sectionKinds = map[uint8]string {
+	CustomSectionId:	"custom",
+	TypeSectionId:		"type",
+	ImportSectionId:	"import",
+	FunctionSectionId:	"function",
+	TableSectionId:		"table",
+	MemorySectionId:	"memory",
+	GlobalSectionId:	"global",
+	ExportSectionId:	"export",
+	StartSectionId:		"start",
+	ElementSectionId:	"element",
+	CodeSectionId:		"code",
+	DataSectionId:		"data",
+	DataCountSectionId:	"data count",
+}
+
+// Summarize this module.  No side effects.
+func (module Module) Inspect() ModuleSummary {
+	summary := ModuleSummary{ Size: 8 }	// Preamble: magic + version
+
+	for _, section := range module.sections {
+		id := sectionId(section)
+		size := len(section.encode())
+		name, ok := sectionKinds[id]
+		if custom, isCustom := section.(CustomSection); isCustom {
+			name = fmt.Sprintf("custom '%s'", custom.name)
+		} else if !ok {
+			name = fmt.Sprintf("unknown %#x", id)
+		}
+		summary.Sections = append(summary.Sections,
+			SectionSummary{ id, name, size })
+		summary.Size += 1 + len(appendULEB128(nil, uint64(size))) + size
+	}
+
+	context := newModuleContext(module)
+	summary.Types = len(context.types)
+	summary.Functions = len(context.functions)
+	summary.Tables = len(context.tables)
+	summary.Memories = len(context.memories)
+	summary.Globals = len(context.globals)
+	summary.Elements = len(context.elements)
+	if section, ok := module.section(ImportSectionId).(ImportSection); ok {
+		summary.Imports = len(section.imports)
+	}
+	if section, ok := module.section(ExportSectionId).(ExportSection); ok {
+		summary.Exports = len(section.list)
+	}
+	if section, ok := module.section(DataSectionId).(DataSection); ok {
+		summary.Data = len(section.data)
+	}
+	if section, ok := module.section(StartSectionId).(StartSection); ok {
+		summary.Start = module.Names().Function(section.function)
+	}
+
+	summary.Producers, _ = module.Producers()
+	summary.TargetFeatures, _ = module.TargetFeatures()
+	summary.SourceMappingURL, _ = module.SourceMappingURL()
+	summary.ExternalDebugInfo, _ = module.ExternalDebugInfo()
+	summary.Unsupported = module.UnsupportedFeatures()
+
+	// Empty lists rather than null, for consumers of the JSON encoding
+	if (summary.Producers.Fields == nil) {
+		summary.Producers.Fields = []ProducerField{}
+	}
+	if (summary.TargetFeatures.Features == nil) {
+		summary.TargetFeatures.Features = []TargetFeature{}
+	}
+	if (summary.Unsupported == nil) {
+		summary.Unsupported = []string{}
+	}
+
+	return summary
+}
+
+func (summary ModuleSummary) String() string {
+	var builder strings.Builder
+
+	builder.WriteString(fmt.Sprintf("Size: %d bytes\n", summary.Size))
+	builder.WriteString("Sections:\n")
+	for _, section := range summary.Sections {
+		builder.WriteString(fmt.Sprintf("    %-24s %8d bytes\n", section.Name,
+			section.Size))
+	}
+
+	builder.WriteString("Contents:\n")
+	counts := []struct{
+		kind	string
+		count	int
+	}{
+		{ "types",		summary.Types },
+		{ "imports",	summary.Imports },
+		{ "functions",	summary.Functions },
+		{ "tables",		summary.Tables },
+		{ "memories",	summary.Memories },
+		{ "globals",	summary.Globals },
+		{ "exports",	summary.Exports },
+		{ "elements",	summary.Elements },
+		{ "data",		summary.Data },
+	}
+	for _, c := range counts {
+		builder.WriteString(fmt.Sprintf("    %s: %d\n", c.kind, c.count))
+	}
+	if (summary.Start != "") {
+		builder.WriteString(fmt.Sprintf("    start: %s\n", summary.Start))
+	}
+
+	// Metadata, if any
+	if (len(summary.Producers.Fields) > 0) {
+		builder.WriteString("Producers:\n" + summary.Producers.String())
+	}
+	if (len(summary.TargetFeatures.Features) > 0) {
+		builder.WriteString("Target features:\n" +
+			summary.TargetFeatures.String())
+	}
+	if (summary.SourceMappingURL != "") {
+		builder.WriteString(fmt.Sprintf("Source map: %s\n",
+			summary.SourceMappingURL))
+	}
+	if (summary.ExternalDebugInfo != "") {
+		builder.WriteString(fmt.Sprintf("External debug info: %s\n",
+			summary.ExternalDebugInfo))
+	}
+	if (len(summary.Unsupported) > 0) {
+		builder.WriteString(fmt.Sprintf("Unsupported features: %s\n",
+			strings.Join(summary.Unsupported, ", ")))
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,103 @@
+package wasm
+
+import(
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	)
+
+
+//
+// Test module summaries: sizes, counts + metadata
+//
+func TestInspect(t *testing.T) {
+	module := watSampleModule(t)
+	summary := module.Inspect()
+
+	var encoded bytes.Buffer
+	module.WriteTo(&encoded)
+	if (summary.Size != encoded.Len()) {
+		t.Errorf("Unexpected size %d (expected %d)", summary.Size,
+			encoded.Len())
+	}
+
+	counts := []struct{
+		kind		string
+		count		int
+		expected	int
+	}{
+		{ "types",		summary.Types,		3 },
+		{ "imports",	summary.Imports,	2 },
+		{ "functions",	summary.Functions,	3 },
+		{ "tables",		summary.Tables,		1 },
+		{ "memories",	summary.Memories,	1 },
+		{ "globals",	summary.Globals,	2 },
+		{ "exports",	summary.Exports,	1 },
+		{ "elements",	summary.Elements,	1 },
+		{ "data",		summary.Data,		1 },
+	}
+	for _, c := range counts {
+		if (c.count != c.expected) {
+			t.Errorf("Unexpected %s count %d (expected %d)", c.kind, c.count,
+				c.expected)
+		}
+	}
+
+	if (summary.Start != "$func2") {
+		t.Error("Unexpected start function: ", summary.Start)
+	}
+	last := summary.Sections[len(summary.Sections) - 1]
+	if (last.Id != CustomSectionId || last.Name != "custom 'name'") {
+		t.Error("Unexpected custom section: ", last)
+	}
+	if (!strings.Contains(summary.String(), "    code ")) {
+		t.Error("Unexpected summary:\n", summary)
+	}
+}
+
+
+//
+// Test structured module descriptions + their JSON encoding
+//
+func TestDescribe(t *testing.T) {
+	description := watSampleModule(t).Describe()
+
+	if (len(description.Types) != 3 || len(description.Imports) != 2 ||
+		len(description.Exports) != 1 || len(description.Functions) != 2) {
+		t.Fatalf("Unexpected description: %+v", description)
+	}
+	fac := description.Functions[0]
+	if (fac.Index != 1 || fac.Name != "$fac" || fac.Type != 1 ||
+		len(fac.Locals) != 1 || fac.Locals[0] != "i32") {
+		t.Errorf("Unexpected function: %+v", fac)
+	}
+	imp := description.Imports[1]
+	if (imp.Kind != "global" || imp.ValueType != "i32" || imp.Type != nil) {
+		t.Errorf("Unexpected import: %+v", imp)
+	}
+	if (description.Start == nil || *description.Start != 2) {
+		t.Error("Unexpected start function: ", description.Start)
+	}
+
+	encoded, err := json.Marshal(description)
+	if (err != nil) {
+		t.Fatal("Unexpected encoding error: ", err)
+	}
+	for _, expected := range []string{
+		`"types":[{"params":["i32"],"results":[]}`,
+		`{"module":"env","name":"log","kind":"function","type":0}`,
+		`"exports":[{"name":"fac","kind":"function","index":1}]`,
+	} {
+		if (!strings.Contains(string(encoded), expected)) {
+			t.Errorf("Missing %s in %s", expected, encoded)
+		}
+	}
+
+	// Metadata lists are always present, even if empty
+	encoded, err = json.Marshal(watSampleModule(t).Inspect())
+	if (err != nil ||
+		!strings.Contains(string(encoded), `"producers":{"fields":[]}`)) {
+		t.Errorf("Unexpected summary: %s, %v", encoded, err)
+	}
+}
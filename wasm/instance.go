@@ -0,0 +1,372 @@
+package wasm
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+
+var MissingGlobal = errors.New("Unable to find global")
+var ImmutableGlobal = errors.New("Global is immutable")
+var OutOfBounds = errors.New("Out of bounds memory access")
+
+// Linking failure, e.g., import of an unknown or incompatible export
+var UnlinkableImport = errors.New("Unlinkable import")
+
+// Size of a single page of linear memory, in bytes
+const PageSize = 65536
+
+// Upper bound on the initial size of each memory, in pages (i.e., 1 GiB)
+const maxInstancePages = 16384
+
+
+//
+// Module instance: the runtime state of a single module, i.e. the current
+// value of each global + the contents of each linear memory.  Imported
+// globals + memories are shared with the exporting instance, if linked (see
+// CreateLinkedInstance), and are otherwise zero-initialized
+//
+type Instance struct {
+	module		Module
+	config		VMConfig
+	vm			WASMInterpreter
+	imports		map[string]*Instance	// Exporting instances, by module name
+
+	globals		[]*instanceGlobal	// Global index space, imports first
+	memories	[][]byte			// Memory index space, imports first
+	//@tables
+	//@imported functions cannot be called, even if linked
+}
+
+// The current value of a global, shared by any instances that import it
+type instanceGlobal struct {
+	value	interface{}
+}
+
+//
+// Factory function for instantiating a module: initializes the globals +
+// memories, copies any active data segments into memory and runs the start
+// function, if any.  Imports are not linked
+//
+func CreateInstance(module Module, config VMConfig) (*Instance, error) {
+	return CreateLinkedInstance(module, config, nil)
+}
+
+//
+// Factory function for instantiating a module, with its imported globals +
+// memories resolved against the exports of the given instances, by import
+// module name.  Imports from any other module are zero-initialized
+//
+func CreateLinkedInstance(module Module, config VMConfig,
+	imports map[string]*Instance) (*Instance, error) {
+	instance := &Instance{ module: module, config: config, imports: imports }
+	err := instance.Reset()
+	if (err != nil) {
+		return nil, err
+	}
+	return instance, nil
+}
+
+func (instance *Instance) Module() Module {
+	return instance.module
+}
+
+//
+// Discard all runtime state, and instantiate the module again.  Any linked
+// imports are resolved again, but instances that import from this one keep
+// the previous state
+//
+func (instance *Instance) Reset() error {
+	context := newModuleContext(instance.module)
+	module := instance.module
+
+	instance.globals = make([]*instanceGlobal, 0, len(context.globals))
+	instance.memories = make([][]byte, 0, len(context.memories))
+	if section, ok := module.section(ImportSectionId).(ImportSection); ok {
+		for _, imp := range section.imports {
+			err := instance.link(imp)
+			if (err != nil) {
+				return err
+			}
+		}
+	}
+
+	if section, ok := module.section(GlobalSectionId).(GlobalSection); ok {
+		for i, global := range section.global {
+			value, err := instance.evaluate(global.init)
+			if (err != nil) {
+				return fmt.Errorf("global %d: %w", context.importedGlobals + i,
+					err)
+			}
+			instance.globals = append(instance.globals,
+				&instanceGlobal{ value })
+		}
+	}
+
+	for i := len(instance.memories); i < len(context.memories); i++ {
+		memory := context.memories[i]
+		if (memory.limit.min > maxInstancePages) {
+			return fmt.Errorf("memory %d: %d pages exceeds limit of %d", i,
+				memory.limit.min, maxInstancePages)
+		}
+		instance.memories = append(instance.memories,
+			make([]byte, int(memory.limit.min) * PageSize))
+	}
+
+	// Copy the active data segments into memory
+	if section, ok := module.section(DataSectionId).(DataSection); ok {
+		for i, data := range section.data {
+			if (data.mode != SegmentModeActive) {
+				continue
+			}
+			err := instance.initializeMemory(data)
+			if (err != nil) {
+				return fmt.Errorf("data segment %d: %w", i, err)
+			}
+		}
+	}
+
+	section, ok := module.section(StartSectionId).(StartSection)
+	if !ok {
+		return nil
+	}
+	_, err := instance.vm.invoke(module, instance, section.function, nil,
+		instance.config)
+	return err
+}
+
+//
+// Resolve a single import against the linked instances, appending any global
+// or memory to the index spaces.  Imports from modules that are not linked
+// are zero-initialized
+//
+func (instance *Instance) link(imp Import) error {
+	exporter, linked := instance.imports[imp.module]
+	var index uint32
+	if (linked) {
+		section, _ := exporter.module.section(ExportSectionId).(ExportSection)
+		export, ok := section.export[imp.name]
+		if (!ok || export.etype != imp.itype) {
+			return fmt.Errorf("%w: unknown import \"%s\" \"%s\"",
+				UnlinkableImport, imp.module, imp.name)
+		}
+		index = export.index
+	}
+
+	switch(imp.itype) {
+		case ExportTypeGlobal:
+			global := &instanceGlobal{ zeroValue(imp.global.vtype) }
+			if (linked) {
+				global = exporter.globals[index]
+			}
+			instance.globals = append(instance.globals, global)
+
+		case ExportTypeMemory:
+			var memory []byte
+			if (linked) {
+				memory = exporter.memories[index]
+			} else if (imp.memory.limit.min > maxInstancePages) {
+				return fmt.Errorf("import \"%s\" \"%s\": %d pages exceeds " +
+					"limit of %d", imp.module, imp.name, imp.memory.limit.min,
+					maxInstancePages)
+			} else {
+				memory = make([]byte, int(imp.memory.limit.min) * PageSize)
+			}
+			instance.memories = append(instance.memories, memory)
+	}
+	return nil
+}
+
+// Copy a single active data segment into memory
+func (instance *Instance) initializeMemory(data Data) error {
+	if (int(data.memory) >= len(instance.memories)) {
+		return fmt.Errorf("unknown memory %d", data.memory)
+	}
+	value, err := instance.evaluate(data.offset)
+	if (err != nil) {
+		return err
+	}
+	offset, ok := value.(int32)
+	if !ok {
+		return fmt.Errorf("invalid offset %v", value)
+	}
+
+	memory := instance.memories[data.memory]
+	end := uint64(uint32(offset)) + uint64(len(data.init))
+	if (end > uint64(len(memory))) {
+		return OutOfBounds
+	}
+	copy(memory[uint32(offset):], data.init)
+	return nil
+}
+
+//
+// Evaluate a constant expression, e.g. a global initializer, in terms of the
+// globals initialized so far.  References are represented by their function
+// index, or nil if null.  No side effects.
+//
+func (instance *Instance) evaluate(expr []byte) (interface{}, error) {
+	instructions, err := decodeConstantExpression(expr)
+	if (err != nil) {
+		return nil, err
+	}
+	if (len(instructions) != 1) {
+		return nil, errors.New("constant expression required")
+	}
+
+	instr := instructions[0]
+	switch(instr.Opcode) {
+		case 0x41:	return instr.I32(), nil		// i32.const
+		case 0x42:	return instr.I64(), nil		// i64.const
+		case 0x43:	return instr.F32(), nil		// f32.const
+		case 0x44:	return instr.F64(), nil		// f64.const
+		case 0xD0:	return nil, nil				// ref.null
+		case 0xD2:	return instr.Index(), nil	// ref.func
+		case 0x23:	// global.get
+			index := instr.Index()
+			if (int(index) >= len(instance.globals)) {
+				return nil, fmt.Errorf("unknown global %d", index)
+			}
+			return instance.globals[index].value, nil
+	}
+	return nil, errors.New("constant expression required")
+}
+
+// Initial value of an imported global or declared local of the given type
+func zeroValue(vtype ValueType) interface{} {
+	switch(vtype) {
+		case NumTypei32:	return int32(0)
+		case NumTypei64:	return int64(0)
+		case NumTypef32:	return float32(0)
+		case NumTypef64:	return float64(0)
+		case VecTypev128:	return [16]byte{}
+	}
+	return nil
+}
+
+
+//
+// Invoke the exported function with the given name, against the state of
+// this instance, so that any updates (e.g., by global.set) persist across
+// invocations.  See WASMInterpreter.Invoke()
+//
+func (instance *Instance) Invoke(name string,
+	args []interface{}) ([]interface{}, error) {
+	return instance.vm.invokeExport(instance.module, instance, name, args,
+		instance.config)
+}
+
+
+//
+// Globals
+//
+
+//
+// Locate a global by export name, $name (from the name section) or index in
+// the global index space.  No side effects.
+//
+func (instance *Instance) GlobalIndex(name string) (uint32, error) {
+	section, ok := instance.module.section(ExportSectionId).(ExportSection)
+	if ok {
+		export, ok := section.export[name]
+		if (ok && export.etype == ExportTypeGlobal) {
+			return export.index, nil
+		}
+	}
+
+	names := instance.module.Names()
+	for index := range instance.globals {
+		if (names.Global(uint32(index)) == name) {
+			return uint32(index), nil
+		}
+	}
+
+	index, err := strconv.ParseUint(name, 10, 32)
+	if (err != nil || index >= uint64(len(instance.globals))) {
+		return 0, fmt.Errorf("%w '%s'", MissingGlobal, name)
+	}
+	return uint32(index), nil
+}
+
+// Number of globals, including any imports.  No side effects.
+func (instance *Instance) Globals() int {
+	return len(instance.globals)
+}
+
+// Type of a global.  No side effects.
+func (instance *Instance) GlobalType(index uint32) (ValueType, bool, error) {
+	context := newModuleContext(instance.module)
+	if (int(index) >= len(context.globals)) {
+		return unknownType, false, fmt.Errorf("%w %d", MissingGlobal, index)
+	}
+	gtype := context.globals[index]
+	return gtype.vtype, gtype.mutable, nil
+}
+
+// Current value of a global.  No side effects.
+func (instance *Instance) Global(index uint32) (interface{}, error) {
+	if (int(index) >= len(instance.globals)) {
+		return nil, fmt.Errorf("%w %d", MissingGlobal, index)
+	}
+	return instance.globals[index].value, nil
+}
+
+// Update a mutable global.  The value must match the type of the global
+func (instance *Instance) SetGlobal(index uint32, value interface{}) error {
+	vtype, mutable, err := instance.GlobalType(index)
+	if (err != nil) {
+		return err
+	}
+	if (!mutable) {
+		return fmt.Errorf("%w: global %d", ImmutableGlobal, index)
+	}
+	if (valueType(value) != vtype) {
+		return fmt.Errorf("%w: expected %s, found %T", InvalidArgument,
+			typeName(vtype), value)
+	}
+	instance.globals[index].value = value
+	return nil
+}
+
+
+//
+// Memory
+//
+
+// Number of memories, including any imports.  No side effects.
+func (instance *Instance) Memories() int {
+	return len(instance.memories)
+}
+
+//
+// Read a range of a linear memory.  The result is a copy, so unaffected by
+// any later updates.  No side effects.
+//
+func (instance *Instance) ReadMemory(index uint32, offset uint32,
+	length uint32) ([]byte, error) {
+	if (int(index) >= len(instance.memories)) {
+		return nil, fmt.Errorf("unknown memory %d", index)
+	}
+	memory := instance.memories[index]
+	if (uint64(offset) + uint64(length) > uint64(len(memory))) {
+		return nil, fmt.Errorf("%w: %#x + %#x exceeds size %#x", OutOfBounds,
+			offset, length, len(memory))
+	}
+	return append([]byte(nil), memory[offset:offset + length]...), nil
+}
+
+// Overwrite a range of a linear memory
+func (instance *Instance) WriteMemory(index uint32, offset uint32,
+	data []byte) error {
+	if (int(index) >= len(instance.memories)) {
+		return fmt.Errorf("unknown memory %d", index)
+	}
+	memory := instance.memories[index]
+	if (uint64(offset) + uint64(len(data)) > uint64(len(memory))) {
+		return fmt.Errorf("%w: %#x + %#x exceeds size %#x", OutOfBounds,
+			offset, len(data), len(memory))
+	}
+	copy(memory[offset:], data)
+	return nil
+}
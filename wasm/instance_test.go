@@ -0,0 +1,245 @@
+package wasm
+
+import(
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	)
+
+
+const instanceTestModule = `(module
+	(import "env" "base" (global $base i32))
+	(global $counter (export "counter") (mut i32) (i32.const 7))
+	(global $limit f64 (f64.const 1.5))
+	(global $copy i32 (global.get $base))
+	(memory 1)
+	(data (i32.const 16) "hi")
+	(func (export "nop")))
+`
+
+//
+// Test instantiation, globals + memory
+//
+func TestInstance(t *testing.T) {
+	module, err := ReadWATWithOptions(strings.NewReader(instanceTestModule),
+		WATOptions{ DebugNames: true })
+	if (err != nil) {
+		t.Fatal("Unexpected assembly error: ", err)
+	}
+	instance, err := CreateInstance(module, VMConfig{})
+	if (err != nil) {
+		t.Fatal("Unexpected instantiation error: ", err)
+	}
+
+	// Globals, by export name, $name or index
+	globals := []struct{
+		name	string
+		index	uint32
+		value	interface{}
+	}{
+		{ "$base",		0,	int32(0) },
+		{ "counter",	1,	int32(7) },
+		{ "$limit",		2,	float64(1.5) },
+		{ "3",			3,	int32(0) },
+	}
+	for _, g := range globals {
+		index, err := instance.GlobalIndex(g.name)
+		if (err != nil || index != g.index) {
+			t.Errorf("Unexpected index %d for %s: %v", index, g.name, err)
+			continue
+		}
+		value, err := instance.Global(index)
+		if (err != nil || value != g.value) {
+			t.Errorf("Unexpected value %v for %s: %v", value, g.name, err)
+		}
+	}
+	_, err = instance.GlobalIndex("nope")
+	if (!errors.Is(err, MissingGlobal)) {
+		t.Error("Unexpected lookup status: ", err)
+	}
+
+	// Only mutable globals can be updated, with values of the same type
+	updates := []struct{
+		index	uint32
+		value	interface{}
+		status	error
+	}{
+		{ 1,	int32(-1),		nil },
+		{ 1,	int64(-1),		InvalidArgument },
+		{ 2,	float64(0),		ImmutableGlobal },
+		{ 9,	int32(0),		MissingGlobal },
+	}
+	for _, update := range updates {
+		err := instance.SetGlobal(update.index, update.value)
+		if (!errors.Is(err, update.status)) {
+			t.Errorf("Unexpected update status for %d: %v", update.index, err)
+		}
+	}
+
+	// Memory, as initialized by the data segment
+	data, err := instance.ReadMemory(0, 15, 4)
+	if (err != nil || !bytes.Equal(data, []byte{ 0, 'h', 'i', 0 })) {
+		t.Errorf("Unexpected memory contents: % x, %v", data, err)
+	}
+	err = instance.WriteMemory(0, 16, []byte("HI"))
+	if (err != nil) {
+		t.Error("Unexpected write status: ", err)
+	}
+	_, err = instance.ReadMemory(0, PageSize - 1, 2)
+	if (!errors.Is(err, OutOfBounds)) {
+		t.Error("Unexpected read status: ", err)
+	}
+
+	// Reset restores the initial state
+	err = instance.Reset()
+	if (err != nil) {
+		t.Fatal("Unexpected reset error: ", err)
+	}
+	value, _ := instance.Global(1)
+	data, _ = instance.ReadMemory(0, 16, 2)
+	if (value != int32(7) || string(data) != "hi") {
+		t.Errorf("Unexpected state after reset: %v, %s", value, data)
+	}
+
+	results, err := instance.Invoke("nop", nil)
+	if (err != nil || len(results) != 0) {
+		t.Errorf("Unexpected invocation results: %v, %v", results, err)
+	}
+}
+
+//
+// Test instantiation failures
+//
+func TestInstanceErrors(t *testing.T) {
+	testCases := []struct{
+		name	string
+		source	string
+		status	error
+	}{
+		{ "data-bounds",
+		  `(module (memory 1) (data (i32.const 65535) "hi"))`,	OutOfBounds },
+		{ "start-trap",
+		  `(module (func $f unreachable) (start $f))`,		UnreachableCode },
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			module, err := ReadWAT(strings.NewReader(test.source))
+			if (err != nil) {
+				t.Fatal("Unexpected assembly error: ", err)
+			}
+			_, err = CreateInstance(module, VMConfig{})
+			if (!errors.Is(err, test.status)) {
+				t.Error("Unexpected instantiation status: ", err)
+			}
+		})
+	}
+}
+
+
+//
+// Test that invocations (incl. the start function) share the instance state
+//
+func TestInstanceState(t *testing.T) {
+	module, err := ReadWAT(strings.NewReader(`(module
+		(global $counter (mut i32) (i32.const 7))
+		(global $step i32 (i32.const 3))
+		(func $bump (export "bump")
+			global.get $counter global.get $step i32.add global.set $counter)
+		(func (export "get") (result i32) global.get $counter)
+		(func (export "set") (param i32) local.get 0 global.set $counter)
+		(start $bump))`))
+	if (err != nil) {
+		t.Fatal("Unexpected assembly error: ", err)
+	}
+	instance, err := CreateInstance(module, VMConfig{})
+	if (err != nil) {
+		t.Fatal("Unexpected instantiation error: ", err)
+	}
+
+	get := func() interface{} {
+		results, err := instance.Invoke("get", nil)
+		if (err != nil || len(results) != 1) {
+			t.Fatalf("Unexpected invocation results: %v, %v", results, err)
+		}
+		return results[0]
+	}
+	if value, _ := instance.Global(0); (value != int32(10) || get() != value) {
+		t.Errorf("Unexpected value %v after start function", value)
+	}
+	_, err = instance.Invoke("bump", nil)
+	if value, _ := instance.Global(0); (err != nil || value != int32(13)) {
+		t.Errorf("Unexpected value %v after bump: %v", value, err)
+	}
+	_, err = instance.Invoke("set", []interface{}{ int32(-1) })
+	if value, _ := instance.Global(0); (err != nil || value != int32(-1)) {
+		t.Errorf("Unexpected value %v after set: %v", value, err)
+	}
+	instance.SetGlobal(0, int32(42))
+	if (get() != int32(42)) {
+		t.Error("Invocation does not see updated global")
+	}
+
+	// Without an instance, there are no globals
+	_, err = WASMInterpreter{}.Invoke(module, "get", nil, VMConfig{})
+	if (!errors.Is(err, MissingInstance)) {
+		t.Error("Unexpected status without instance: ", err)
+	}
+}
+
+
+//
+// Test linking of imported globals + memories to the exporting instance
+//
+func TestLinkedInstance(t *testing.T) {
+	env, err := ReadWAT(strings.NewReader(`(module
+		(global (export "base") i32 (i32.const 40))
+		(memory (export "memory") 1)
+		(data (i32.const 0) "env"))`))
+	if (err != nil) {
+		t.Fatal("Unexpected assembly error: ", err)
+	}
+	exporter, err := CreateInstance(env, VMConfig{})
+	if (err != nil) {
+		t.Fatal("Unexpected instantiation error: ", err)
+	}
+	module, err := ReadWAT(strings.NewReader(`(module
+		(import "env" "base" (global i32))
+		(import "env" "memory" (memory 1))
+		(global i32 (global.get 0))
+		(data (i32.const 3) "!"))`))
+	if (err != nil) {
+		t.Fatal("Unexpected assembly error: ", err)
+	}
+	instance, err := CreateLinkedInstance(module, VMConfig{},
+		map[string]*Instance{ "env": exporter })
+	if (err != nil) {
+		t.Fatal("Unexpected instantiation error: ", err)
+	}
+
+	// Imported values, incl. in constant expressions
+	base, _ := instance.Global(0)
+	copied, _ := instance.Global(1)
+	if (base != int32(40) || copied != int32(40)) {
+		t.Errorf("Unexpected globals %v, %v", base, copied)
+	}
+
+	// The memory is shared, in both directions
+	data, err := exporter.ReadMemory(0, 0, 4)
+	if (err != nil || string(data) != "env!") {
+		t.Errorf("Unexpected exporter memory %q: %v", data, err)
+	}
+	exporter.WriteMemory(0, 0, []byte("ENV"))
+	data, err = instance.ReadMemory(0, 0, 4)
+	if (err != nil || string(data) != "ENV!") {
+		t.Errorf("Unexpected importer memory %q: %v", data, err)
+	}
+
+	// Imports must be exported by the linked instance
+	_, err = CreateLinkedInstance(module, VMConfig{},
+		map[string]*Instance{ "env": instance })
+	if (!errors.Is(err, UnlinkableImport)) {
+		t.Error("Unexpected linking status: ", err)
+	}
+}
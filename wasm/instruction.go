@@ -2,6 +2,7 @@ package wasm
 
 import (
 	"errors"
+	"fmt"
 )
 
 
@@ -12,12 +13,15 @@ var ReloadBytecode		= errors.New("call/ret/jmp, must recache bytecode")
 // Runtime errors
 var InvalidOpcode		= errors.New("Invalid opcode")
 var UnreachableCode		= errors.New("Unexpected/unreachable code (opcode 0)")
+var TypeMismatch		= errors.New("Operand type mismatch (invalid module?)")
+var MissingInstance		= errors.New("No instance state (globals, memory)")
 
 
 
 //
-// Signature for all interpreted VM instructions:
-//	(VM handle, raw binary code, offset) => (status, new offset)
+// Signature for all interpreted VM instructions: (VM handle) => status.  The
+// current instruction, including any decoded immediates, is available via
+// thread.instruction()
 //
 type InstructionFunction func(*WASMInterpreterThread)(error)
 
@@ -39,12 +43,20 @@ var Opcode = map[uint8]Instruction {
 
 	// Variable instructions
 	0x20:	Instruction{"local.get",	localget},
+	0x23:	Instruction{"global.get",	globalget},
+	0x24:	Instruction{"global.set",	globalset},
 
 	// Numeric instructions
 	0x6A:	Instruction{"i32.add",		i32add},
 }
 
 
+// Current (decoded) instruction.  No side effects.
+func (thread *WASMInterpreterThread) instruction() DecodedInstruction {
+	return thread.current.code[thread.current.ip]
+}
+
+
 func end(thread *WASMInterpreterThread) error {
 	// End of block/function/execution
 	//@how to distinguish between return vs end of block?
@@ -61,7 +73,7 @@ func end(thread *WASMInterpreterThread) error {
 }
 
 func i32add(thread *WASMInterpreterThread) error {
-	// Consumed the opcode
+	// Consumed the instruction
 	thread.current.ip += 1
 
 	// i32.add takes two arguments
@@ -74,12 +86,21 @@ func i32add(thread *WASMInterpreterThread) error {
 		return err
 	}
 
-	thread.dataStack.Push(value0.(int32) + value1.(int32))
+	operand0, ok0 := value0.(int32)
+	operand1, ok1 := value1.(int32)
+	if (!ok0 || !ok1) {
+		return fmt.Errorf("%w: i32.add of %T, %T", TypeMismatch, value1,
+			value0)
+	}
+	thread.dataStack.Push(operand0 + operand1)
 	return nil
 }
 
 func localget(thread *WASMInterpreterThread) error {
-	// Consumed the opcode
+	// "local.get" takes one argument: an index into the function local parms
+	index := thread.instruction().Index()
+
+	// Consumed the instruction
 	thread.current.ip += 1
 
 	// Stack frame contains pointer to the local parameters
@@ -89,16 +110,15 @@ func localget(thread *WASMInterpreterThread) error {
 	}
 	stackFrame := value.(StackFrame)
 
-	// "local.get" takes one argument: an index into the function local parms
-	index := thread.current.bytecode[thread.current.ip]
-	local, err := thread.dataStack.Peek( stackFrame.locals - int(index) )
+	// Local 0 is deepest in the stack, at the frame base
+	if (int(index) >= stackFrame.count) {
+		return fmt.Errorf("%w: local %d", StackUnderflow, index)
+	}
+	local, err := thread.dataStack.Peek( stackFrame.locals + int(index) )
 	if (err != nil) {
 		return err
 	}
 
-	// Consumed the argument
-	thread.current.ip += 1
-
 	// Push the local parameter onto the immediate stack for later consumption
 	thread.dataStack.Push(local)
 
@@ -115,3 +135,46 @@ func unreachable(thread *WASMInterpreterThread) error {
 	// Somehow reached unexpected/non-executable code
 	return UnreachableCode
 }
+
+//
+// Globals live in the instance, so are only available when invoked via an
+// Instance, rather than directly on a Module
+//
+func globalget(thread *WASMInterpreterThread) error {
+	index := thread.instruction().Index()
+
+	// Consumed the instruction
+	thread.current.ip += 1
+
+	if (thread.instance == nil) {
+		return MissingInstance
+	}
+	value, err := thread.instance.Global(index)
+	if (err != nil) {
+		return err
+	}
+	thread.dataStack.Push(value)
+	return nil
+}
+
+func globalset(thread *WASMInterpreterThread) error {
+	index := thread.instruction().Index()
+
+	// Consumed the instruction
+	thread.current.ip += 1
+
+	if (thread.instance == nil) {
+		return MissingInstance
+	}
+	value, err := thread.dataStack.Pop()
+	if (err != nil) {
+		return err
+	}
+
+	// Mutability + type are already guaranteed by validation
+	if (int(index) >= len(thread.instance.globals)) {
+		return fmt.Errorf("%w %d", MissingGlobal, index)
+	}
+	thread.instance.globals[index].value = value
+	return nil
+}
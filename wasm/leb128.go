@@ -2,30 +2,96 @@ package wasm
 
 import (
 	"encoding/binary"
+	"errors"
 	"io"
 )
 
+
+// Decoding error due to an over-long or out-of-range LEB128 encoding
+var InvalidLEB128 = errors.New("Invalid LEB128 encoding")
+
+
 //
-// Parse and return a single uint32 value from a LEB128 sequence
+// LEB128 encoding + decoding.  WASM uses both unsigned and signed (two's
+// complement) variants, at several different widths (u32, u64, s32, s33,
+// s64).  Per the spec, an N-bit value is encoded in at most ceil(N/7) bytes,
+// and any unused bits in the final byte must be zero (unsigned) or must
+// match the sign bit (signed).  Padded (non-minimal) encodings are legal,
+// within the maximum length.
 //
 // See:
 // - https://en.wikipedia.org/wiki/LEB128 and
 // - https://en.wikipedia.org/wiki/Variable-length_quantity
+// - Section 5.2.2 of WASM spec
 //
-func readULEB128(reader io.Reader)(uint32, error) {
+
+//
+// Parse and return a single N-bit unsigned value from a LEB128 sequence
+//
+func readUnsignedLEB128(reader io.Reader, bits uint32) (uint64, error) {
+	maxLength := (bits + 6) / 7
+	unused := byte(0x7F) >> (bits - 7*(maxLength - 1)) << (bits - 7*(maxLength - 1))
+
 	var shift uint32
-	var value uint32
+	var value uint64
 
-	for {
+	for i := uint32(0); ; i++ {
 		// Consume the next byte
 		var b uint8
 		err := binary.Read(reader, binary.LittleEndian, &b)
 		if (err != nil) {
-			return uint32(0xFFFFFFFF), err
+			return 0, err
+		}
+
+		// The final byte may only contribute the remaining bits
+		if (i == maxLength - 1 && ((b & 0x80) != 0 || (b & unused) != 0)) {
+			return 0, InvalidLEB128
+		}
+
+		// This byte provides the next 7 bits of the result
+		value |= ( uint64(b & 0x7F) << shift )
+		shift += 7
+
+		// The high-order bit determines whether this is the last byte
+		if ( (b & 0x80) == 0 ) {
+			break
+		}
+	}
+
+	return value, nil
+}
+
+//
+// Parse and return a single N-bit signed value from a LEB128 sequence
+//
+func readSignedLEB128(reader io.Reader, bits uint32) (int64, error) {
+	maxLength := (bits + 6) / 7
+	used := bits - 7*(maxLength - 1)
+	signBits := byte(0x7F) >> (used - 1) << (used - 1)
+
+	var shift uint32
+	var value int64
+	var b uint8
+
+	for i := uint32(0); ; i++ {
+		// Consume the next byte
+		err := binary.Read(reader, binary.LittleEndian, &b)
+		if (err != nil) {
+			return 0, err
+		}
+
+		// The unused bits of the final byte must all match the sign bit
+		if (i == maxLength - 1) {
+			if ((b & 0x80) != 0) {
+				return 0, InvalidLEB128
+			}
+			if ((b & signBits) != 0 && (b & signBits) != signBits) {
+				return 0, InvalidLEB128
+			}
 		}
 
 		// This byte provides the next 7 bits of the result
-		value += ( uint32(b & 0x7F) << shift )
+		value |= ( int64(b & 0x7F) << shift )
 		shift += 7
 
 		// The high-order bit determines whether this is the last byte
@@ -34,6 +100,69 @@ func readULEB128(reader io.Reader)(uint32, error) {
 		}
 	}
 
+	// Sign-extend the result, based on the sign bit of the final byte
+	if (shift < 64 && (b & 0x40) != 0) {
+		value |= ( int64(-1) << shift )
+	}
+
 	return value, nil
 }
 
+// Parse and return a single uint32 value from a LEB128 sequence
+func readULEB128(reader io.Reader)(uint32, error) {
+	value, err := readUnsignedLEB128(reader, 32)
+	if (err != nil) {
+		return uint32(0xFFFFFFFF), err
+	}
+	return uint32(value), nil
+}
+
+// Parse and return a single int32 value from a LEB128 sequence
+func readSLEB32(reader io.Reader)(int32, error) {
+	value, err := readSignedLEB128(reader, 32)
+	return int32(value), err
+}
+
+// Parse and return a single signed 33-bit value from a LEB128 sequence.  Only
+// used for block types, where non-negative values are type indices
+func readSLEB33(reader io.Reader)(int64, error) {
+	return readSignedLEB128(reader, 33)
+}
+
+// Parse and return a single int64 value from a LEB128 sequence
+func readSLEB64(reader io.Reader)(int64, error) {
+	return readSignedLEB128(reader, 64)
+}
+
+
+//
+// Append the minimal unsigned LEB128 encoding of a value to a byte slice.
+// Suitable for u32 + u64 values.  No side effects.
+//
+func appendULEB128(buffer []byte, value uint64) []byte {
+	for {
+		b := byte(value & 0x7F)
+		value >>= 7
+		if (value == 0) {
+			return append(buffer, b)
+		}
+		buffer = append(buffer, b | 0x80)
+	}
+}
+
+//
+// Append the minimal signed LEB128 encoding of a value to a byte slice.
+// Suitable for s32, s33 + s64 values.  No side effects.
+//
+func appendSLEB128(buffer []byte, value int64) []byte {
+	for {
+		b := byte(value & 0x7F)
+		value >>= 7
+
+		// Done once the remaining bits are pure sign extension of this byte
+		if ((value == 0 && (b & 0x40) == 0) || (value == -1 && (b & 0x40) != 0)) {
+			return append(buffer, b)
+		}
+		buffer = append(buffer, b | 0x80)
+	}
+}
@@ -26,6 +26,13 @@ func TestULEB128Decoding(t *testing.T) {
 		{ "0x7F-extra",	[]byte{ 0x7F, 0xAA },	0x7F,	nil },
 		{ "0xFF-eof",	[]byte{ 0xFF },			0,		io.EOF },
 
+		// Maximum length + padding
+		{ "max",		[]byte{ 0xFF, 0xFF, 0xFF, 0xFF, 0x0F },	0xFFFFFFFF,	nil },
+		{ "padded",		[]byte{ 0x80, 0x80, 0x80, 0x80, 0x00 },	0x00,		nil },
+		{ "overflow",	[]byte{ 0xFF, 0xFF, 0xFF, 0xFF, 0x1F },	0,	InvalidLEB128 },
+		{ "over-long",	[]byte{ 0x80, 0x80, 0x80, 0x80, 0x80, 0x00 },
+																0,	InvalidLEB128 },
+
 	}
 
 	for _, test := range testCases {
@@ -41,3 +48,189 @@ func TestULEB128Decoding(t *testing.T) {
 		})
 	}
 }
+
+// Test decoding of packed unsigned 64-bit LEB128 values
+func TestULEB64Decoding(t *testing.T) {
+	testCases := []struct{
+		name		string
+		encoded		[]byte
+		decoded		uint64
+		status		error
+	}{
+		{ "0x00",		[]byte{ 0x00 },		0x00,	nil },
+		{ "0x7F",		[]byte{ 0x7F },		0x7F,	nil },
+		{ "2^32",		[]byte{ 0x80, 0x80, 0x80, 0x80, 0x10 },	1 << 32,	nil },
+		{ "max",
+		  []byte{ 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x01 },
+		  0xFFFFFFFFFFFFFFFF,	nil },
+		{ "padded",
+		  []byte{ 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x00 },
+		  0x00,	nil },
+		{ "overflow",
+		  []byte{ 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x02 },
+		  0,	InvalidLEB128 },
+		{ "over-long",
+		  []byte{ 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80,
+				  0x00 },
+		  0,	InvalidLEB128 },
+		{ "eof",		[]byte{ 0x80, 0x80 },	0,	io.EOF },
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			reader := bytes.NewReader(test.encoded)
+			value, err := readUnsignedLEB128(reader, 64)
+			if (err != test.status) {
+				t.Error("Unexpected decoding status: ", err)
+			}
+			if (err == nil && value != test.decoded) {
+				t.Error("Unexpected decoded value: ", value)
+			}
+		})
+	}
+}
+
+// Test decoding of packed signed 32-bit LEB128 values
+func TestSLEB32Decoding(t *testing.T) {
+	testCases := []struct{
+		name		string
+		encoded		[]byte
+		decoded		int32
+		status		error
+	}{
+		{ "0",			[]byte{ 0x00 },			0,		nil },
+		{ "1",			[]byte{ 0x01 },			1,		nil },
+		{ "-1",			[]byte{ 0x7F },			-1,		nil },
+		{ "63",			[]byte{ 0x3F },			63,		nil },
+		{ "64",			[]byte{ 0xC0, 0x00 },	64,		nil },
+		{ "-64",		[]byte{ 0x40 },			-64,	nil },
+		{ "-65",		[]byte{ 0xBF, 0x7F },	-65,	nil },
+		{ "100",		[]byte{ 0xE4, 0x00 },	100,	nil },
+		{ "max",		[]byte{ 0xFF, 0xFF, 0xFF, 0xFF, 0x07 },	0x7FFFFFFF,	nil },
+		{ "min",		[]byte{ 0x80, 0x80, 0x80, 0x80, 0x78 },	-0x80000000, nil },
+		{ "padded-0",	[]byte{ 0x80, 0x80, 0x80, 0x80, 0x00 },	0,		nil },
+		{ "padded-1",	[]byte{ 0xFF, 0xFF, 0xFF, 0xFF, 0x7F },	-1,		nil },
+
+		// Unused bits must match the sign bit
+		{ "overflow-positive",	[]byte{ 0xFF, 0xFF, 0xFF, 0xFF, 0x0F },
+								0,	InvalidLEB128 },
+		{ "overflow-negative",	[]byte{ 0x80, 0x80, 0x80, 0x80, 0x70 },
+								0,	InvalidLEB128 },
+		{ "over-long",	[]byte{ 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x7F },
+								0,	InvalidLEB128 },
+		{ "eof",		[]byte{ 0xFF },	0,	io.EOF },
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			reader := bytes.NewReader(test.encoded)
+			value, err := readSLEB32(reader)
+			if (err != test.status) {
+				t.Error("Unexpected decoding status: ", err)
+			}
+			if (err == nil && value != test.decoded) {
+				t.Error("Unexpected decoded value: ", value)
+			}
+		})
+	}
+}
+
+// Test decoding of packed signed 33- and 64-bit LEB128 values
+func TestSLEB64Decoding(t *testing.T) {
+	testCases := []struct{
+		name		string
+		encoded		[]byte
+		bits		uint32
+		decoded		int64
+		status		error
+	}{
+		{ "s33-max",	[]byte{ 0xFF, 0xFF, 0xFF, 0xFF, 0x0F },	33,
+						0xFFFFFFFF,	nil },
+		{ "s33-min",	[]byte{ 0x80, 0x80, 0x80, 0x80, 0x70 },	33,
+						-0x100000000,	nil },
+		{ "s33-overflow", []byte{ 0xFF, 0xFF, 0xFF, 0xFF, 0x1F },	33,
+						0,	InvalidLEB128 },
+
+		{ "s64--1",		[]byte{ 0x7F },	64,	-1,	nil },
+		{ "s64-max",
+		  []byte{ 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x00 },
+		  64,	0x7FFFFFFFFFFFFFFF,	nil },
+		{ "s64-min",
+		  []byte{ 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x7F },
+		  64,	-0x8000000000000000,	nil },
+		{ "s64-overflow",
+		  []byte{ 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x01 },
+		  64,	0,	InvalidLEB128 },
+		{ "s64-over-long",
+		  []byte{ 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF,
+				  0x7F },
+		  64,	0,	InvalidLEB128 },
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			reader := bytes.NewReader(test.encoded)
+			value, err := readSignedLEB128(reader, test.bits)
+			if (err != test.status) {
+				t.Error("Unexpected decoding status: ", err)
+			}
+			if (err == nil && value != test.decoded) {
+				t.Error("Unexpected decoded value: ", value)
+			}
+		})
+	}
+}
+
+// Test encoding of LEB128 values, plus round-trips through the decoders
+func TestLEB128Encoding(t *testing.T) {
+	unsignedCases := []struct{
+		value		uint64
+		encoded		[]byte
+	}{
+		{ 0,			[]byte{ 0x00 } },
+		{ 0x7F,			[]byte{ 0x7F } },
+		{ 0x80,			[]byte{ 0x80, 0x01 } },
+		{ 624485,		[]byte{ 0xE5, 0x8E, 0x26 } },
+		{ 0xFFFFFFFF,	[]byte{ 0xFF, 0xFF, 0xFF, 0xFF, 0x0F } },
+		{ 0xFFFFFFFFFFFFFFFF,
+		  []byte{ 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x01 } },
+	}
+	for _, test := range unsignedCases {
+		encoded := appendULEB128(nil, test.value)
+		if (!bytes.Equal(encoded, test.encoded)) {
+			t.Errorf("Unexpected encoding of %d: % x", test.value, encoded)
+		}
+		decoded, err := readUnsignedLEB128(bytes.NewReader(encoded), 64)
+		if (err != nil || decoded != test.value) {
+			t.Errorf("Unexpected round-trip of %d: %d, %v", test.value, decoded, err)
+		}
+	}
+
+	signedCases := []struct{
+		value		int64
+		encoded		[]byte
+	}{
+		{ 0,			[]byte{ 0x00 } },
+		{ -1,			[]byte{ 0x7F } },
+		{ 63,			[]byte{ 0x3F } },
+		{ 64,			[]byte{ 0xC0, 0x00 } },
+		{ -64,			[]byte{ 0x40 } },
+		{ -65,			[]byte{ 0xBF, 0x7F } },
+		{ -123456,		[]byte{ 0xC0, 0xBB, 0x78 } },
+		{ -0x80000000,	[]byte{ 0x80, 0x80, 0x80, 0x80, 0x78 } },
+		{ -0x8000000000000000,
+		  []byte{ 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x7F } },
+		{ 0x7FFFFFFFFFFFFFFF,
+		  []byte{ 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x00 } },
+	}
+	for _, test := range signedCases {
+		encoded := appendSLEB128(nil, test.value)
+		if (!bytes.Equal(encoded, test.encoded)) {
+			t.Errorf("Unexpected encoding of %d: % x", test.value, encoded)
+		}
+		decoded, err := readSLEB64(bytes.NewReader(encoded))
+		if (err != nil || decoded != test.value) {
+			t.Errorf("Unexpected round-trip of %d: %d, %v", test.value, decoded, err)
+		}
+	}
+}
@@ -11,6 +11,7 @@ import (
 type Limit struct {
 	min uint32
 	max uint32
+	flags uint8	// Encoding flags: nonzero if the max field is present
 }
 
 func readLimit(reader io.Reader)(Limit, error) {
@@ -21,6 +22,7 @@ func readLimit(reader io.Reader)(Limit, error) {
 	if (err != nil) {
 		return limit, err
 	}
+	limit.flags = flag
 
 	// Min field is always present
 	limit.min, err = readULEB128(reader)
@@ -35,3 +37,13 @@ func readLimit(reader io.Reader)(Limit, error) {
 
 	return limit, err
 }
+
+// Append the binary encoding of a Limit to a byte slice.  No side effects.
+func appendLimit(buffer []byte, limit Limit) []byte {
+	buffer = append(buffer, limit.flags)
+	buffer = appendULEB128(buffer, uint64(limit.min))
+	if (limit.flags != 0) {
+		buffer = appendULEB128(buffer, uint64(limit.max))
+	}
+	return buffer
+}
@@ -0,0 +1,282 @@
+package wasm
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+
+//
+// Well-known tool-convention custom sections, describing how the module was
+// built: producers, target_features, sourceMappingURL + external_debug_info.
+// Like the name section, these are advisory only, so a malformed section is
+// simply ignored.  See https://github.com/WebAssembly/tool-conventions
+//
+const (
+	ProducersSectionName			= "producers"
+	TargetFeaturesSectionName		= "target_features"
+	SourceMappingURLSectionName		= "sourceMappingURL"
+	ExternalDebugInfoSectionName	= "external_debug_info"
+)
+
+
+//
+// Producers section.  Toolchain used to generate the module, as a list of
+// fields ("language", "processed-by" or "sdk"), each with a list of
+// name/version pairs
+//
+type ProducerValue struct {
+	Name	string	`json:"name"`
+	Version	string	`json:"version"`
+}
+
+type ProducerField struct {
+	Name	string			`json:"name"`
+	Values	[]ProducerValue	`json:"values"`
+}
+
+type ProducersSection struct {
+	Fields []ProducerField	`json:"fields"`
+}
+
+// Factory function for decoding + returning a ProducersSection from the
+// payload of the "producers" custom section.  No side effects.
+func readProducersSection(payload []byte) (ProducersSection, error) {
+	section := ProducersSection{}
+	reader := bytes.NewReader(payload)
+
+	fieldCount, err := readVectorLength(reader)
+	if (err != nil) {
+		return section, err
+	}
+	for i := uint32(0); i < fieldCount; i++ {
+		field := ProducerField{}
+		field.Name, err = readName(reader)
+		if (err != nil) {
+			return section, err
+		}
+
+		valueCount, err := readVectorLength(reader)
+		if (err != nil) {
+			return section, err
+		}
+		for j := uint32(0); j < valueCount; j++ {
+			value := ProducerValue{}
+			value.Name, err = readName(reader)
+			if (err != nil) {
+				return section, err
+			}
+			value.Version, err = readName(reader)
+			if (err != nil) {
+				return section, err
+			}
+			field.Values = append(field.Values, value)
+		}
+
+		section.Fields = append(section.Fields, field)
+	}
+
+	if (reader.Len() > 0) {
+		return section, fmt.Errorf("%w: producers: trailing bytes",
+			InvalidSection)
+	}
+	return section, nil
+}
+
+// Return the values of the given field (e.g., "language"), if any.  No side
+// effects.
+func (section ProducersSection) Field(name string) []ProducerValue {
+	for _, field := range section.Fields {
+		if (field.Name == name) {
+			return field.Values
+		}
+	}
+	return nil
+}
+
+func (section ProducersSection) String() string {
+	var builder strings.Builder
+	for _, field := range section.Fields {
+		values := make([]string, 0, len(field.Values))
+		for _, value := range field.Values {
+			values = append(values, strings.TrimSpace(value.Name + " " +
+				value.Version))
+		}
+		builder.WriteString(fmt.Sprintf("    %s: %s\n", field.Name,
+			strings.Join(values, ", ")))
+	}
+	return builder.String()
+}
+
+
+//
+// Target features section.  WASM proposals/features used (+), required (=)
+// or explicitly not used (-) by the module
+//
+const (
+	FeatureUsed			= '+'
+	FeatureRequired		= '='
+	FeatureDisallowed	= '-'
+)
+
+type TargetFeature struct {
+	Prefix	byte
+	Name	string
+}
+
+type TargetFeaturesSection struct {
+	Features []TargetFeature	`json:"features"`
+}
+
+// Factory function for decoding + returning a TargetFeaturesSection from the
+// payload of the "target_features" custom section.  No side effects.
+func readTargetFeaturesSection(payload []byte) (TargetFeaturesSection, error) {
+	section := TargetFeaturesSection{}
+	reader := bytes.NewReader(payload)
+
+	count, err := readVectorLength(reader)
+	if (err != nil) {
+		return section, err
+	}
+	for i := uint32(0); i < count; i++ {
+		prefix, err := reader.ReadByte()
+		if (err != nil) {
+			return section, err
+		}
+		if (prefix != FeatureUsed && prefix != FeatureRequired &&
+			prefix != FeatureDisallowed) {
+			return section, fmt.Errorf("%w: target_features: prefix %#x",
+				InvalidSection, prefix)
+		}
+		name, err := readName(reader)
+		if (err != nil) {
+			return section, err
+		}
+		section.Features = append(section.Features, TargetFeature{ prefix, name })
+	}
+
+	if (reader.Len() > 0) {
+		return section, fmt.Errorf("%w: target_features: trailing bytes",
+			InvalidSection)
+	}
+	return section, nil
+}
+
+func (section TargetFeaturesSection) String() string {
+	features := make([]string, 0, len(section.Features))
+	for _, feature := range section.Features {
+		features = append(features, string(feature.Prefix) + feature.Name)
+	}
+	return fmt.Sprintf("    features: %s\n", strings.Join(features, " "))
+}
+
+
+//
+// URL sections: sourceMappingURL + external_debug_info.  Each is a single
+// name, referencing a source map or separate DWARF file, respectively
+//
+func readURLSection(payload []byte) (string, error) {
+	reader := bytes.NewReader(payload)
+	url, err := readName(reader)
+	if (err != nil) {
+		return "", err
+	}
+	if (reader.Len() > 0) {
+		return "", fmt.Errorf("%w: url: trailing bytes", InvalidSection)
+	}
+	return url, nil
+}
+
+
+//
+// Module-level queries.  Each returns the decoded content of the (first)
+// corresponding custom section, plus whether the section is present and well-
+// formed.  No side effects.
+//
+func (module Module) Producers() (ProducersSection, bool) {
+	custom, ok := module.CustomSection(ProducersSectionName)
+	if !ok {
+		return ProducersSection{}, false
+	}
+	section, err := readProducersSection(custom.Payload())
+	return section, (err == nil)
+}
+
+func (module Module) TargetFeatures() (TargetFeaturesSection, bool) {
+	custom, ok := module.CustomSection(TargetFeaturesSectionName)
+	if !ok {
+		return TargetFeaturesSection{}, false
+	}
+	section, err := readTargetFeaturesSection(custom.Payload())
+	return section, (err == nil)
+}
+
+func (module Module) SourceMappingURL() (string, bool) {
+	return module.urlSection(SourceMappingURLSectionName)
+}
+
+func (module Module) ExternalDebugInfo() (string, bool) {
+	return module.urlSection(ExternalDebugInfoSectionName)
+}
+
+func (module Module) urlSection(name string) (string, bool) {
+	custom, ok := module.CustomSection(name)
+	if !ok {
+		return "", false
+	}
+	url, err := readURLSection(custom.Payload())
+	return url, (err == nil)
+}
+
+//
+// Features used or required by this module (per its target_features
+// section), but not supported by the VM.  No side effects.
+//
+func (module Module) UnsupportedFeatures() []string {
+	var unsupported []string
+
+	section, _ := module.TargetFeatures()
+	for _, feature := range section.Features {
+		if (feature.Prefix != FeatureDisallowed &&
+			!SupportedFeatures[feature.Name]) {
+			unsupported = append(unsupported, feature.Name)
+		}
+	}
+
+	sort.Strings(unsupported)
+	return unsupported
+}
+
+
+//
+// Describe the decoded content of a well-known custom section, if any.  No
+// side effects.
+//
+func describeCustomSection(section CustomSection) string {
+	var description fmt.Stringer
+	var err error
+
+	switch(section.name) {
+		case NameSectionName:
+			description, err = readNameSection(section.Payload())
+		case ProducersSectionName:
+			description, err = readProducersSection(section.Payload())
+		case TargetFeaturesSectionName:
+			description, err = readTargetFeaturesSection(section.Payload())
+		case SourceMappingURLSectionName, ExternalDebugInfoSectionName:
+			url, err := readURLSection(section.Payload())
+			if (err == nil) {
+				return fmt.Sprintf("    url: %s\n", url)
+			}
+			return fmt.Sprintf("    malformed: %s\n", err)
+		default:
+			return ""
+	}
+
+	if (err != nil) {
+		return fmt.Sprintf("    malformed: %s\n", err)
+	}
+	return description.String()
+}
@@ -0,0 +1,121 @@
+package wasm
+
+import(
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	)
+
+
+// Encode a single custom section, with the given name + payload
+func customSection(name string, payload ...byte) []byte {
+	content := append(appendName(nil, name), payload...)
+	return append(appendULEB128([]byte{ CustomSectionId },
+		uint64(len(content))), content...)
+}
+
+
+//
+// Test decoding of the tool-convention custom sections
+//
+func TestMetadataSections(t *testing.T) {
+	encoded := append([]byte{}, fuzzSeeds["empty.wat"]...)
+	encoded = append(encoded, customSection(ProducersSectionName,
+		0x02,
+		0x08, 'l', 'a', 'n', 'g', 'u', 'a', 'g', 'e',
+			0x01, 0x04, 'R', 'u', 's', 't', 0x00,
+		0x0c, 'p', 'r', 'o', 'c', 'e', 's', 's', 'e', 'd', '-', 'b', 'y',
+			0x01, 0x05, 'r', 'u', 's', 't', 'c', 0x04, '1', '.', '7', '0')...)
+	encoded = append(encoded, customSection(TargetFeaturesSectionName,
+		0x03,
+		'+', 0x08, 's', 'i', 'g', 'n', '-', 'e', 'x', 't',
+		'=', 0x07, 's', 'i', 'm', 'd', '1', '2', '8',
+		'-', 0x07, 'a', 't', 'o', 'm', 'i', 'c', 's')...)
+	encoded = append(encoded, customSection(SourceMappingURLSectionName,
+		0x06, 'a', '.', 'm', 'a', 'p', 's')...)
+	encoded = append(encoded, customSection(ExternalDebugInfoSectionName,
+		0x07, 'a', '.', 'd', 'w', 'a', 'r', 'f')...)
+
+	module, err := ReadModule(bytes.NewReader(encoded))
+	if (err != nil) {
+		t.Fatal("Unexpected decoding status: ", err)
+	}
+
+	producers, ok := module.Producers()
+	if (!ok ||
+		!reflect.DeepEqual(producers.Field("language"),
+			[]ProducerValue{ { "Rust", "" } }) ||
+		!reflect.DeepEqual(producers.Field("processed-by"),
+			[]ProducerValue{ { "rustc", "1.70" } }) ||
+		producers.Field("sdk") != nil) {
+		t.Error("Unexpected producers: ", producers)
+	}
+
+	features, ok := module.TargetFeatures()
+	if (!ok || len(features.Features) != 3 ||
+		features.Features[1] != (TargetFeature{ FeatureRequired, "simd128" })) {
+		t.Error("Unexpected target features: ", features)
+	}
+
+	// Disallowed features are never reported as unsupported
+	unsupported := module.UnsupportedFeatures()
+	if (!reflect.DeepEqual(unsupported, []string{ "sign-ext", "simd128" })) {
+		t.Error("Unexpected unsupported features: ", unsupported)
+	}
+
+	url, ok := module.SourceMappingURL()
+	if (!ok || url != "a.maps") {
+		t.Error("Unexpected source map URL: ", url)
+	}
+	url, ok = module.ExternalDebugInfo()
+	if (!ok || url != "a.dwarf") {
+		t.Error("Unexpected debug info URL: ", url)
+	}
+
+	// All of these are included in the module dump
+	dump := module.String()
+	for _, expected := range []string{ "language: Rust",
+		"processed-by: rustc 1.70", "features: +sign-ext =simd128 -atomics",
+		"url: a.maps", "url: a.dwarf" } {
+		if (!strings.Contains(dump, expected)) {
+			t.Errorf("Missing '%s' in module dump: %s", expected, dump)
+		}
+	}
+}
+
+
+//
+// Malformed or missing metadata sections are ignored
+//
+func TestMalformedMetadataSections(t *testing.T) {
+	encoded := append([]byte{}, fuzzSeeds["empty.wat"]...)
+	encoded = append(encoded, customSection(ProducersSectionName, 0x01)...)
+	encoded = append(encoded, customSection(TargetFeaturesSectionName,
+		0x01, '?', 0x01, 'x')...)
+	encoded = append(encoded, customSection(SourceMappingURLSectionName,
+		0x01, 'a', 'b')...)
+
+	module, err := ReadModule(bytes.NewReader(encoded))
+	if (err != nil) {
+		t.Fatal("Unexpected decoding status: ", err)
+	}
+	if _, ok := module.Producers(); ok {
+		t.Error("Expected malformed producers section")
+	}
+	if _, ok := module.TargetFeatures(); ok {
+		t.Error("Expected malformed target_features section")
+	}
+	if _, ok := module.SourceMappingURL(); ok {
+		t.Error("Expected malformed sourceMappingURL section")
+	}
+	if _, ok := module.ExternalDebugInfo(); ok {
+		t.Error("Unexpected external_debug_info section")
+	}
+	if (len(module.UnsupportedFeatures()) != 0) {
+		t.Error("Unexpected unsupported features")
+	}
+	if (!strings.Contains(module.String(), "malformed")) {
+		t.Error("Malformed sections not flagged in module dump: ", module)
+	}
+}
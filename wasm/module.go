@@ -1,16 +1,91 @@
 package wasm
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"strings"
 )
 
 var InvalidModule = errors.New("Invalid module")
 
+
+//
+// Detailed decoding error.  Identifies the section (if any) and the byte
+// offset, relative to the start of the module, where decoding failed.  Wraps
+// the underlying cause (io.ErrUnexpectedEOF, InvalidSection, etc), and
+// always matches InvalidModule via errors.Is()
+//
+type DecodeError struct {
+	Section	int		// Section id, or -1 if not within a section
+	Offset	int64
+	Reason	string
+	Err		error
+}
+
+// Factory function for generating a DecodeError.  Running out of input
+// anywhere after the preamble implies a truncated module.  No side effects.
+func newDecodeError(section int, offset int64, reason string,
+	err error) DecodeError {
+	if (err == io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return DecodeError{ section, offset, reason, err }
+}
+
+func (e DecodeError) Error() string {
+	if (e.Section < 0) {
+		return fmt.Sprintf("offset %#x: %s: %s", e.Offset, e.Reason, e.Err)
+	}
+	return fmt.Sprintf("section %#x, offset %#x: %s: %s",
+		e.Section, e.Offset, e.Reason, e.Err)
+}
+
+func (e DecodeError) Unwrap() error {
+	return e.Err
+}
+
+func (e DecodeError) Is(target error) bool {
+	return (target == InvalidModule)
+}
+
+// Decoding failure at a known offset within the content of a single section.
+// Converted into a DecodeError once the offset of the section is known
+type sectionFailure struct {
+	offset	int64
+	err		error
+}
+
+func (failure sectionFailure) Error() string {
+	return failure.err.Error()
+}
+
+func (failure sectionFailure) Unwrap() error {
+	return failure.err
+}
+
+// Record the current position of the reader alongside a decoding error
+func failureAt(reader *bytes.Reader, err error) error {
+	return sectionFailure{ reader.Size() - int64(reader.Len()), err }
+}
+
+
+//
+// Wrapper for tracking the current offset into the module while decoding
+//
+type offsetReader struct {
+	reader	io.Reader
+	offset	int64
+}
+
+func (reader *offsetReader) Read(buffer []byte) (int, error) {
+	n, err := reader.reader.Read(buffer)
+	reader.offset += int64(n)
+	return n, err
+}
+
 // Preamble/header constants
 const (
 	MagicSignature	= 0x6d736100	// "\0asm"
@@ -21,45 +96,128 @@ const (
 // Module structure.  Describes the contents of one complete WASM module.
 //
 type Module struct {
-	section []Section
+	sections []Section	// All sections, in the order they were decoded
+}
+
+// All sections, including any custom or unknown sections, in order.  No side
+// effects.
+func (module Module) Sections() []Section {
+	return module.sections
+}
+
+//
+// Return the (first) section with the given id, or nil if the module has no
+// such section.  Custom sections are addressable by name instead; see
+// CustomSection().  No side effects.
+//
+func (module Module) section(id uint32) Section {
+	for _, section := range module.sections {
+		if (section.id() == id && id != CustomSectionId) {
+			return section
+		}
+	}
+	return nil
+}
+
+// Return the first custom section with the given name, if any.  No side
+// effects.
+func (module Module) CustomSection(name string) (CustomSection, bool) {
+	for _, section := range module.CustomSections() {
+		if (section.name == name) {
+			return section, true
+		}
+	}
+	return CustomSection{}, false
+}
+
+// All custom sections, in order.  No side effects.
+func (module Module) CustomSections() []CustomSection {
+	var sections []CustomSection
+	for _, section := range module.sections {
+		if custom, ok := section.(CustomSection); ok {
+			sections = append(sections, custom)
+		}
+	}
+	return sections
+}
+
+//
+// Return a copy of this module without any custom sections, except those
+// with the given names (e.g., "name" for debugging).  Custom sections never
+// affect the semantics of a module.  No side effects.
+//
+func (module Module) Strip(keep ...string) Module {
+	stripped := Module{ sections: []Section{} }
+	for _, section := range module.sections {
+		if custom, ok := section.(CustomSection); ok {
+			retain := false
+			for _, name := range keep {
+				retain = retain || (custom.name == name)
+			}
+			if (!retain) {
+				continue
+			}
+		}
+		stripped.sections = append(stripped.sections, section)
+	}
+	return stripped
 }
 
 // Validate the module structure.  See chapter 3 of WASM spec.  No side effects.
 func (module Module) Validate() error {
-	// Validate each non-empty section
-	for _, section := range module.section {
-		if (section != nil) {
-			err := section.validate()
-			if (err != nil) {
-				return err
-			}
+	// Validate each individual section
+	for _, section := range module.sections {
+		err := section.validate()
+		if (err != nil) {
+			return err
 		}
 	}
 
-	//@module-level validation: function sections + code sections should
-	//correspond, etc
+	// Cross-section consistency: section ordering, index spaces, etc
+	err := validateModule(module)
+	if (err != nil) {
+		return err
+	}
 
-	return nil
+	// Type-check the individual function bodies
+	return validateCode(module)
 }
 
 func (module Module) String() string {
 	var builder strings.Builder
 
 	builder.WriteString("Module:\n")
-	for _, section := range module.section {
-		if (section != nil) {
-			builder.WriteString(fmt.Sprintf("%s\n", section))
+	names := module.Names()
+	imported := newModuleContext(module).imported
+	for _, section := range module.sections {
+		if code, ok := section.(CodeSection); ok {
+			// Annotate the function bodies with any names
+			builder.WriteString(fmt.Sprintf("%s\n",
+				code.describe(names, imported)))
+			continue
 		}
+		builder.WriteString(fmt.Sprintf("%s\n", section))
 	}
 
 	return builder.String()
 }
 
 //
-// Load and return an entire WASM module
+// Load and return an entire WASM module, subject to the default decoding
+// limits
+//
+func ReadModule(input io.Reader) (Module, error) {
+	return ReadModuleWithLimits(input, DefaultDecodeLimits)
+}
+
+//
+// Load and return an entire WASM module, subject to the given decoding limits.
+// Suitable for untrusted input
 //
-func ReadModule(reader io.Reader) (Module, error) {
+func ReadModuleWithLimits(input io.Reader, limits DecodeLimits) (Module,
+	error) {
 	module := Module{}
+	reader := &offsetReader{ reader: input }
 
 	//
 	// Read through the preamble
@@ -67,42 +225,77 @@ func ReadModule(reader io.Reader) (Module, error) {
 	var magic, version uint32
 	err := binary.Read(reader, binary.LittleEndian, &magic)
 	if (err != nil) {
-		log.Printf("Unable to read module signature: %s\n", err)
-		return module, InvalidModule
+		return module, newDecodeError(-1, 0,
+			"unable to read module signature", err)
 	}
 	if (magic != MagicSignature) {
-		log.Printf("Unexpected magic signature: %#x\n", magic)
-		return module, InvalidModule
+		return module, newDecodeError(-1, 0,
+			fmt.Sprintf("unexpected magic signature %#x", magic), InvalidModule)
 	}
 	err = binary.Read(reader, binary.LittleEndian, &version)
 	if (err != nil) {
-		log.Printf("Unable to read module version: %s\n", err)
-		return module, InvalidModule
+		return module, newDecodeError(-1, 4,
+			"unable to read module version", err)
 	}
 	if (version != Version1) {
-		log.Printf("Unexpected module version: %#x\n", version)
-		return module, InvalidModule
+		return module, newDecodeError(-1, 4,
+			fmt.Sprintf("unexpected module version %#x", version), InvalidModule)
 	}
 
 	//
 	// Parse the individual sections
 	//
-	sections := make([]Section, SectionCountMax)
 	for {
-		section, err := readSection(reader)
+		section, err := readSection(reader, limits)
 		if (err == io.EOF) {
 			break
 		}
 		if (err != nil) {
-			log.Printf("Invalid section")
 			return module, err
 		}
 
-		// Each type of section can occur at most once, except custom sections,
-		// so just track by section id/type
-		sections[ section.id() ] = section
+		// Retain every section, in order.  Any duplicate or misplaced sections
+		// are detected during validation
+		err = checkLimit("sections", uint64(len(module.sections) + 1),
+			uint64(limits.MaxSections))
+		if (err != nil) {
+			return module, newDecodeError(int(sectionId(section)), reader.offset,
+				"too many sections", err)
+		}
+		module.sections = append(module.sections, section)
 	}
-	module.section = sections
 
 	return module, nil
 }
+
+
+//
+// Serialize the entire module, in binary format.  Sections are emitted in
+// order, so any canonical input round-trips byte-for-byte.  Implements
+// io.WriterTo
+//
+func (module Module) WriteTo(writer io.Writer) (int64, error) {
+	var buffer bytes.Buffer
+
+	// Preamble
+	binary.Write(&buffer, binary.LittleEndian, uint32(MagicSignature))
+	binary.Write(&buffer, binary.LittleEndian, uint32(Version1))
+
+	for _, section := range module.sections {
+		content := section.encode()
+		buffer.WriteByte(sectionId(section))
+		buffer.Write(appendULEB128(nil, uint64(len(content))))
+		buffer.Write(content)
+	}
+
+	return buffer.WriteTo(writer)
+}
+
+// Raw (encoded) id of the given section, including unknown sections.  No side
+// effects.
+func sectionId(section Section) uint8 {
+	if unknown, ok := section.(UnknownSection); ok {
+		return unknown.unknownId
+	}
+	return uint8(section.id())
+}
@@ -2,6 +2,8 @@ package wasm
 
 import(
     "bytes"
+    "errors"
+    "io"
     "testing"
     )
 
@@ -18,22 +20,38 @@ func TestModule(t *testing.T) {
     }{
         { "empty-module",
           []byte{ 0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00 },
-          Module{ []Section{ nil } },
+          Module{ sections: []Section{} },
           nil },
 
         { "single-custom-section",
           []byte{ 0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, 0x00, 0x05, 0x04, 't', 'e', 's', 't' },
-          Module{ []Section{ CustomSection{ []byte("test"), "test" } } },
+          Module{ sections: []Section{ CustomSection{ []byte("\x04test"), "test" } } },
           nil },
 
         { "single-unknown-section",
           []byte{ 0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, 0xEF, 0x01, 0xAB },
-          Module{ []Section{ UnknownSection{ []byte{0xAB}, uint8(0xEF) } } },
+          Module{ sections: []Section{ UnknownSection{ []byte{0xAB}, uint8(0xEF) } } },
+          nil },
+
+        { "multiple-custom-sections",
+          []byte{ 0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+                  0x00, 0x02, 0x01, 'a',
+                  0x01, 0x01, 0x00,
+                  0x00, 0x02, 0x01, 'b' },
+          Module{ sections: []Section{ CustomSection{ []byte("\x01a"), "a" },
+                  TypeSection{}, CustomSection{ []byte("\x01b"), "b" } } },
+          nil },
+
+        { "multiple-unknown-sections",
+          []byte{ 0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+                  0xEF, 0x01, 0xAB, 0xEE, 0x00 },
+          Module{ sections: []Section{ UnknownSection{ []byte{0xAB}, uint8(0xEF) },
+                  UnknownSection{ []byte{}, uint8(0xEE) } } },
           nil },
 
         { "bad-preamble",
           []byte{ 0x00 },
-          Module{ []Section{} },
+          Module{},
           InvalidModule },
     }
 
@@ -41,16 +59,302 @@ func TestModule(t *testing.T) {
         t.Run(test.name, func(t *testing.T) {
             reader := bytes.NewReader(test.encoded)
             module, err := ReadModule(reader)
-            if (err != test.status) {
+            if (!errors.Is(err, test.status)) {
                 t.Error("Unexpected decoding status: ", err)
             }
-            if (err == nil && module.section[0] != nil) {
-                //@deeper comparison would be more meaningful here
-                if (module.section[0].id() != test.decoded.section[0].id()) {
-                    t.Error("Unexpected section[0]: ", module)
+            if (err != nil) {
+                return
+            }
+
+            //@deeper comparison would be more meaningful here
+            if (len(module.Sections()) != len(test.decoded.sections)) {
+                t.Fatal("Unexpected section count: ", module)
+            }
+            for i, section := range module.Sections() {
+                if (sectionId(section) != sectionId(test.decoded.sections[i])) {
+                    t.Errorf("Unexpected section[%d]: %s", i, module)
                 }
             }
         })
     }
 }
 
+
+
+//
+// Test the details of decoding errors for truncated/malformed modules
+//
+func TestDecodeError(t *testing.T) {
+    testCases := []struct{
+        name        string
+        encoded     []byte
+        section     int
+        offset      int64
+        cause       error
+    }{
+        { "truncated-signature",
+          []byte{ 0x00, 0x61 },
+          -1, 0, io.ErrUnexpectedEOF },
+
+        { "bad-version",
+          []byte{ 0x00, 0x61, 0x73, 0x6d, 0x02, 0x00, 0x00, 0x00 },
+          -1, 4, InvalidModule },
+
+        { "truncated-section-size",
+          []byte{ 0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, 0x01, 0x80 },
+          TypeSectionId, 8, io.ErrUnexpectedEOF },
+
+        { "truncated-section-content",
+          []byte{ 0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, 0x01, 0x04, 0x01 },
+          TypeSectionId, 10, io.ErrUnexpectedEOF },
+
+        { "bad-ftype-delimiter",
+          []byte{ 0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+                  0x01, 0x04, 0x01, 0xAA, 0x00, 0x00 },
+          TypeSectionId, 12, InvalidSection },
+
+        { "truncated-export",
+          []byte{ 0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+                  0x07, 0x03, 0x01, 0x01, 'e' },
+          ExportSectionId, 13, io.ErrUnexpectedEOF },
+    }
+
+    for _, test := range testCases {
+        t.Run(test.name, func(t *testing.T) {
+            _, err := ReadModule(bytes.NewReader(test.encoded))
+
+            var decodeError DecodeError
+            if (!errors.As(err, &decodeError)) {
+                t.Fatal("Expected decode error, got: ", err)
+            }
+            if (!errors.Is(err, InvalidModule)) {
+                t.Error("Decode error does not match InvalidModule: ", err)
+            }
+            if (!errors.Is(err, test.cause)) {
+                t.Error("Unexpected decode error cause: ", err)
+            }
+            if (decodeError.Section != test.section) {
+                t.Error("Unexpected decode error section: ", err)
+            }
+            if (decodeError.Offset != test.offset) {
+                t.Error("Unexpected decode error offset: ", err)
+            }
+        })
+    }
+}
+
+
+//
+// Test decoding limits + hardening against hostile modules
+//
+func TestDecodeLimits(t *testing.T) {
+    preamble := []byte{ 0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00 }
+
+    testCases := []struct{
+        name        string
+        section     []byte
+        limits      DecodeLimits
+        cause       error
+    }{
+        // Section claims 4GB of content
+        { "huge-section",
+          []byte{ 0x0A, 0xFF, 0xFF, 0xFF, 0xFF, 0x0F },
+          DefaultDecodeLimits, LimitExceeded },
+
+        // Section claims more content than is present
+        { "truncated-section",
+          []byte{ 0x0A, 0xFF, 0xFF, 0x0F },
+          DefaultDecodeLimits, io.ErrUnexpectedEOF },
+
+        // Code section claims 4 billion functions
+        { "huge-vector",
+          []byte{ 0x0A, 0x05, 0xFF, 0xFF, 0xFF, 0xFF, 0x0F },
+          DefaultDecodeLimits, io.ErrUnexpectedEOF },
+
+        // Single function declares 4 billion locals
+        { "locals-bomb",
+          []byte{ 0x0A, 0x0A, 0x01, 0x08, 0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0x0F,
+                  0x7F, 0x0B },
+          DefaultDecodeLimits, LimitExceeded },
+
+        // Functions within the per-function limit, but too many locals in
+        // total for a tight limit
+        { "total-locals",
+          []byte{ 0x0A, 0x0B, 0x02, 0x04, 0x01, 0x03, 0x7F, 0x0B,
+                  0x04, 0x01, 0x03, 0x7F, 0x0B },
+          DecodeLimits{ MaxLocals: 3, MaxTotalLocals: 5 }, LimitExceeded },
+
+        // Too many types for a tight limit
+        { "type-limit",
+          []byte{ 0x01, 0x07, 0x02, 0x60, 0x00, 0x00, 0x60, 0x00, 0x00 },
+          DecodeLimits{ MaxTypes: 1 }, LimitExceeded },
+
+        // Module larger than a tight limit
+        { "module-limit",
+          []byte{ 0x00, 0x05, 0x04, 't', 'e', 's', 't' },
+          DecodeLimits{ MaxModuleSize: 12 }, LimitExceeded },
+
+        // Export name is not valid UTF-8
+        { "invalid-utf8",
+          []byte{ 0x07, 0x05, 0x01, 0x01, 0xFF, 0x00, 0x00 },
+          DefaultDecodeLimits, InvalidName },
+
+        // Too many (empty) custom sections for a tight limit
+        { "section-limit",
+          []byte{ 0x00, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x01, 0x00 },
+          DecodeLimits{ MaxSections: 2 }, LimitExceeded },
+
+        // Over-long LEB128 section size
+        { "over-long-leb128",
+          []byte{ 0x00, 0x80, 0x80, 0x80, 0x80, 0x80, 0x00 },
+          DefaultDecodeLimits, InvalidLEB128 },
+    }
+
+    for _, test := range testCases {
+        t.Run(test.name, func(t *testing.T) {
+            encoded := append(append([]byte{}, preamble...), test.section...)
+            _, err := ReadModuleWithLimits(bytes.NewReader(encoded), test.limits)
+            if (!errors.Is(err, test.cause)) {
+                t.Error("Unexpected decoding status: ", err)
+            }
+        })
+    }
+}
+
+
+//
+// Test re-encoding of Module blocks.  Canonical input round-trips exactly,
+// including any custom or unknown sections
+//
+func TestModuleEncoding(t *testing.T) {
+    preamble := []byte{ 0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00 }
+
+    testCases := map[string][]byte{
+        // One of every section type, plus a custom + unknown section
+        "all-sections": append(append([]byte{}, preamble...),
+            0x00, 0x05, 0x04, 'n', 'o', 't', 'e',
+            0x01, 0x04, 0x01, 0x60, 0x00, 0x00,
+            0x02, 0x09, 0x01, 0x03, 'e', 'n', 'v', 0x01, 'f', 0x00, 0x00,
+            0x03, 0x02, 0x01, 0x00,
+            0x04, 0x04, 0x01, 0x70, 0x00, 0x01,
+            0x05, 0x04, 0x01, 0x01, 0x01, 0x02,
+            0x06, 0x06, 0x01, 0x7f, 0x01, 0x41, 0x2a, 0x0b,
+            0x07, 0x05, 0x01, 0x01, 'g', 0x03, 0x00,
+            0x08, 0x01, 0x01,
+            0x09, 0x0b, 0x02,
+                0x00, 0x41, 0x00, 0x0b, 0x01, 0x01,
+                0x01, 0x00, 0x01, 0x00,
+            0x0c, 0x01, 0x02,
+            0x0a, 0x07, 0x01, 0x05, 0x01, 0x02, 0x7e, 0x01, 0x0b,
+            0x0b, 0x0a, 0x02,
+                0x00, 0x41, 0x00, 0x0b, 0x01, 'x',
+                0x01, 0x01, 'y',
+            0x20, 0x02, 0xab, 0xcd,
+            0x00, 0x03, 0x02, 'h', 'i'),
+    }
+    for name, seed := range fuzzSeeds {
+        testCases[name] = seed
+    }
+
+    for name, encoded := range testCases {
+        t.Run(name, func(t *testing.T) {
+            module, err := ReadModule(bytes.NewReader(encoded))
+            if (err != nil) {
+                t.Fatal("Unexpected decoding status: ", err)
+            }
+
+            // Re-encode in the original order
+            var buffer bytes.Buffer
+            count, err := module.WriteTo(&buffer)
+            if (err != nil || count != int64(buffer.Len())) {
+                t.Fatal("Unexpected encoding status: ", err)
+            }
+            if (!bytes.Equal(buffer.Bytes(), encoded)) {
+                t.Errorf("Unexpected encoding:\n% x\n% x", buffer.Bytes(),
+                    encoded)
+            }
+        })
+    }
+}
+
+
+//
+// Test lookup of custom sections by name
+//
+func TestCustomSection(t *testing.T) {
+    encoded := []byte{ 0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+        0x00, 0x06, 0x04, 'n', 'a', 'm', 'e', 0x01,
+        0x00, 0x06, 0x05, 'o', 't', 'h', 'e', 'r',
+        0x00, 0x06, 0x04, 'n', 'a', 'm', 'e', 0x02 }
+
+    module, err := ReadModule(bytes.NewReader(encoded))
+    if (err != nil) {
+        t.Fatal("Unexpected decoding status: ", err)
+    }
+    if (len(module.CustomSections()) != 3) {
+        t.Error("Unexpected custom sections: ", module)
+    }
+
+    // First matching section wins
+    section, ok := module.CustomSection("name")
+    if (!ok || section.Name() != "name" ||
+        !bytes.Equal(section.Payload(), []byte{ 0x01 })) {
+        t.Error("Unexpected 'name' section: ", section)
+    }
+    section, ok = module.CustomSection("other")
+    if (!ok || len(section.Payload()) != 0) {
+        t.Error("Unexpected 'other' section: ", section)
+    }
+    _, ok = module.CustomSection("missing")
+    if (ok) {
+        t.Error("Unexpected 'missing' section")
+    }
+}
+
+
+//
+// Test removal of custom sections
+//
+func TestStrip(t *testing.T) {
+    encoded := []byte{ 0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+        0x00, 0x06, 0x04, 'n', 'a', 'm', 'e', 0x01,
+        0x01, 0x01, 0x00,
+        0x00, 0x06, 0x05, 'o', 't', 'h', 'e', 'r' }
+
+    module, err := ReadModule(bytes.NewReader(encoded))
+    if (err != nil) {
+        t.Fatal("Unexpected decoding status: ", err)
+    }
+
+    testCases := []struct{
+        name        string
+        keep        []string
+        sections    int
+    }{
+        { "all",            nil,                            1 },
+        { "keep-name",      []string{ "name" },             2 },
+        { "keep-both",      []string{ "other", "name" },    3 },
+        { "keep-missing",   []string{ "missing" },          1 },
+    }
+
+    for _, test := range testCases {
+        t.Run(test.name, func(t *testing.T) {
+            stripped := module.Strip(test.keep...)
+            if (len(stripped.Sections()) != test.sections) {
+                t.Error("Unexpected sections: ", stripped)
+            }
+            if (len(module.Sections()) != 3) {
+                t.Error("Unexpected modification of original: ", module)
+            }
+        })
+    }
+
+    // Stripped modules remain encodable
+    var buffer bytes.Buffer
+    module.Strip().WriteTo(&buffer)
+    expected := []byte{ 0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+        0x01, 0x01, 0x00 }
+    if (!bytes.Equal(buffer.Bytes(), expected)) {
+        t.Error("Unexpected encoding: ", buffer.Bytes())
+    }
+}
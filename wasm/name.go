@@ -1,9 +1,14 @@
 package wasm
 
 import (
+	"errors"
 	"io"
+	"unicode/utf8"
 )
 
+// Decoding error due to a name that is not valid UTF-8
+var InvalidName = errors.New("Invalid name: malformed UTF-8")
+
 // Read a single name/string from a stream of bytes.  No side effects.
 func readName(reader io.Reader) (string, error) {
 	// Strings are encoded as a vector of characters
@@ -12,10 +17,21 @@ func readName(reader io.Reader) (string, error) {
 		return "", err
 	}
 	name := make([]byte, nameLength)
-	_, err = reader.Read(name)
+	_, err = io.ReadFull(reader, name)
 	if (err != nil) {
 		return "", err
 	}
 
+	// Names are always UTF-8.  See section 5.2.4 of WASM spec
+	if (!utf8.Valid(name)) {
+		return "", InvalidName
+	}
+
 	return string(name), nil
 }
+
+// Append the binary encoding of a name to a byte slice.  No side effects.
+func appendName(buffer []byte, name string) []byte {
+	buffer = appendVectorLength(buffer, len(name))
+	return append(buffer, name...)
+}
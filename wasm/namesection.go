@@ -0,0 +1,360 @@
+package wasm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+
+//
+// Name section.  Optional custom section ("name") for annotating the module
+// and its entities with human-readable names, for debugging, disassembly,
+// etc.  Names are purely advisory: a malformed name section never invalidates
+// the module.  See appendix 7.4 of WASM spec, plus the extended-name-section
+// proposal for the label, type, table, memory, global, elem + data subsections
+//
+const NameSectionName = "name"
+
+// Subsection ids within the name section
+const (
+	NameSubsectionModule	= 0
+	NameSubsectionFunction	= 1
+	NameSubsectionLocal		= 2
+	NameSubsectionLabel		= 3
+	NameSubsectionType		= 4
+	NameSubsectionTable		= 5
+	NameSubsectionMemory	= 6
+	NameSubsectionGlobal	= 7
+	NameSubsectionElement	= 8
+	NameSubsectionData		= 9
+)
+
+// Map of entity index => name
+type NameMap map[uint32]string
+
+// Map of outer index (e.g., function) => names of inner entities (e.g., locals)
+type IndirectNameMap map[uint32]NameMap
+
+type NameSection struct {
+	Module		string
+	Functions	NameMap
+	Locals		IndirectNameMap		// By function, then by local
+	Labels		IndirectNameMap		// By function, then by label
+	Types		NameMap
+	Tables		NameMap
+	Memories	NameMap
+	Globals		NameMap
+	Elements	NameMap
+	Data		NameMap
+}
+
+// Factory function for decoding + returning a NameMap.  No side effects.
+func readNameMap(reader *bytes.Reader) (NameMap, error) {
+	count, err := readVectorLength(reader)
+	if (err != nil) {
+		return nil, err
+	}
+
+	names := make(NameMap)
+	for i := uint32(0); i < count; i++ {
+		index, err := readULEB128(reader)
+		if (err != nil) {
+			return nil, err
+		}
+		name, err := readName(reader)
+		if (err != nil) {
+			return nil, err
+		}
+		names[index] = name
+	}
+
+	return names, nil
+}
+
+// Factory function for decoding + returning an IndirectNameMap.  No side
+// effects.
+func readIndirectNameMap(reader *bytes.Reader) (IndirectNameMap, error) {
+	count, err := readVectorLength(reader)
+	if (err != nil) {
+		return nil, err
+	}
+
+	names := make(IndirectNameMap)
+	for i := uint32(0); i < count; i++ {
+		index, err := readULEB128(reader)
+		if (err != nil) {
+			return nil, err
+		}
+		names[index], err = readNameMap(reader)
+		if (err != nil) {
+			return nil, err
+		}
+	}
+
+	return names, nil
+}
+
+//
+// Factory function for decoding + returning a NameSection from the payload of
+// the "name" custom section (i.e., following the section name).  Unknown
+// subsections are skipped.  No side effects.
+//
+func readNameSection(payload []byte) (NameSection, error) {
+	section := NameSection{}
+	reader := bytes.NewReader(payload)
+
+	for reader.Len() > 0 {
+		// Each subsection is a TLV structure
+		id, err := reader.ReadByte()
+		if (err != nil) {
+			return section, err
+		}
+		size, err := readVectorLength(reader)
+		if (err != nil) {
+			return section, err
+		}
+		content := make([]byte, size)
+		_, err = io.ReadFull(reader, content)
+		if (err != nil) {
+			return section, err
+		}
+
+		subsection := bytes.NewReader(content)
+		switch(id) {
+			case NameSubsectionModule:
+				section.Module, err = readName(subsection)
+			case NameSubsectionFunction:
+				section.Functions, err = readNameMap(subsection)
+			case NameSubsectionLocal:
+				section.Locals, err = readIndirectNameMap(subsection)
+			case NameSubsectionLabel:
+				section.Labels, err = readIndirectNameMap(subsection)
+			case NameSubsectionType:
+				section.Types, err = readNameMap(subsection)
+			case NameSubsectionTable:
+				section.Tables, err = readNameMap(subsection)
+			case NameSubsectionMemory:
+				section.Memories, err = readNameMap(subsection)
+			case NameSubsectionGlobal:
+				section.Globals, err = readNameMap(subsection)
+			case NameSubsectionElement:
+				section.Elements, err = readNameMap(subsection)
+			case NameSubsectionData:
+				section.Data, err = readNameMap(subsection)
+			default:
+				//@other proposals (fields, tags, etc); ignore for now
+				continue
+		}
+		if (err != nil) {
+			return section, err
+		}
+		if (subsection.Len() > 0) {
+			return section, fmt.Errorf("%w: name subsection %d: trailing bytes",
+				InvalidSection, id)
+		}
+	}
+
+	return section, nil
+}
+
+//
+// Return the names of this module, decoded from its (first) name section, if
+// any.  A missing or malformed name section yields no names, so every entity
+// falls back to its synthetic name.  No side effects.
+//
+func (module Module) Names() NameSection {
+	custom, ok := module.CustomSection(NameSectionName)
+	if !ok {
+		return NameSection{}
+	}
+	names, err := readNameSection(custom.Payload())
+	if (err != nil) {
+		return NameSection{}
+	}
+	return names
+}
+
+
+//
+// Encode the payload of the name section (i.e., following the section name),
+// the inverse of readNameSection().  Subsections are emitted in id order, and
+// empty subsections are omitted.  No side effects.
+//
+func (names NameSection) encode() []byte {
+	var payload []byte
+	subsection := func(id uint8, content []byte) {
+		payload = append(payload, id)
+		payload = appendVectorLength(payload, len(content))
+		payload = append(payload, content...)
+	}
+
+	if (names.Module != "") {
+		subsection(NameSubsectionModule, appendName(nil, names.Module))
+	}
+
+	maps := []struct{
+		id			uint8
+		names		NameMap
+		indirect	IndirectNameMap
+	}{
+		{ NameSubsectionFunction,	names.Functions,	nil },
+		{ NameSubsectionLocal,		nil,				names.Locals },
+		{ NameSubsectionLabel,		nil,				names.Labels },
+		{ NameSubsectionType,		names.Types,		nil },
+		{ NameSubsectionTable,		names.Tables,		nil },
+		{ NameSubsectionMemory,		names.Memories,		nil },
+		{ NameSubsectionGlobal,		names.Globals,		nil },
+		{ NameSubsectionElement,	names.Elements,		nil },
+		{ NameSubsectionData,		names.Data,			nil },
+	}
+	for _, m := range maps {
+		if (len(m.names) > 0) {
+			subsection(m.id, m.names.encode())
+		} else if (len(m.indirect) > 0) {
+			var content []byte
+			content = appendVectorLength(content, len(m.indirect))
+			for _, index := range m.indirect.indices() {
+				content = appendULEB128(content, uint64(index))
+				content = append(content, m.indirect[index].encode()...)
+			}
+			subsection(m.id, content)
+		}
+	}
+
+	return payload
+}
+
+// Encode a NameMap, in ascending index order.  No side effects.
+func (names NameMap) encode() []byte {
+	var content []byte
+	content = appendVectorLength(content, len(names))
+	for _, index := range names.indices() {
+		content = appendULEB128(content, uint64(index))
+		content = appendName(content, names[index])
+	}
+	return content
+}
+
+
+//
+// Name lookup.  Each method returns the name of the given entity in WAT
+// (identifier) form, e.g., "$fac".  Unnamed entities receive a synthetic name
+// based on their index, e.g., "$func12".  No side effects.
+//
+func lookupName(names NameMap, index uint32, kind string) string {
+	name, ok := names[index]
+	if (ok && name != "") {
+		return "$" + name
+	}
+	return fmt.Sprintf("$%s%d", kind, index)
+}
+
+func (names NameSection) Function(index uint32) string {
+	return lookupName(names.Functions, index, "func")
+}
+
+func (names NameSection) Local(function uint32, index uint32) string {
+	return lookupName(names.Locals[function], index, "local")
+}
+
+func (names NameSection) Label(function uint32, index uint32) string {
+	return lookupName(names.Labels[function], index, "label")
+}
+
+func (names NameSection) Type(index uint32) string {
+	return lookupName(names.Types, index, "type")
+}
+
+func (names NameSection) Table(index uint32) string {
+	return lookupName(names.Tables, index, "table")
+}
+
+func (names NameSection) Memory(index uint32) string {
+	return lookupName(names.Memories, index, "memory")
+}
+
+func (names NameSection) Global(index uint32) string {
+	return lookupName(names.Globals, index, "global")
+}
+
+func (names NameSection) Element(index uint32) string {
+	return lookupName(names.Elements, index, "elem")
+}
+
+func (names NameSection) DataSegment(index uint32) string {
+	return lookupName(names.Data, index, "data")
+}
+
+// Indices of a NameMap, in ascending order.  No side effects.
+func (names NameMap) indices() []uint32 {
+	indices := make([]uint32, 0, len(names))
+	for index := range names {
+		indices = append(indices, index)
+	}
+	return sortIndices(indices)
+}
+
+// Outer indices of an IndirectNameMap, in ascending order.  No side effects.
+func (names IndirectNameMap) indices() []uint32 {
+	indices := make([]uint32, 0, len(names))
+	for index := range names {
+		indices = append(indices, index)
+	}
+	return sortIndices(indices)
+}
+
+func sortIndices(indices []uint32) []uint32 {
+	sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+	return indices
+}
+
+func (names NameSection) String() string {
+	var builder strings.Builder
+
+	if (names.Module != "") {
+		builder.WriteString(fmt.Sprintf("    module: $%s\n", names.Module))
+	}
+
+	// Direct name maps
+	maps := []struct{
+		kind	string
+		names	NameMap
+	}{
+		{ "function",	names.Functions },
+		{ "type",		names.Types },
+		{ "table",		names.Tables },
+		{ "memory",		names.Memories },
+		{ "global",		names.Globals },
+		{ "elem",		names.Elements },
+		{ "data",		names.Data },
+	}
+	for _, m := range maps {
+		for _, index := range m.names.indices() {
+			builder.WriteString(fmt.Sprintf("    %s %d: $%s\n",
+				m.kind, index, m.names[index]))
+		}
+	}
+
+	// Indirect name maps, by function
+	indirect := []struct{
+		kind	string
+		names	IndirectNameMap
+	}{
+		{ "local",	names.Locals },
+		{ "label",	names.Labels },
+	}
+	for _, m := range indirect {
+		for _, function := range m.names.indices() {
+			inner := m.names[function]
+			for _, index := range inner.indices() {
+				builder.WriteString(fmt.Sprintf("    %s %s.%d: $%s\n", m.kind,
+					names.Function(function), index, inner[index]))
+			}
+		}
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,104 @@
+package wasm
+
+import(
+	"bytes"
+	"strings"
+	"testing"
+	)
+
+
+//
+// Test decoding of the "name" custom section + name lookup
+//
+func TestNameSection(t *testing.T) {
+	payload := []byte{
+		0x00, 0x04, 0x03, 'm', 'o', 'd',
+		0x01, 0x06, 0x01, 0x00, 0x03, 'f', 'a', 'c',
+		0x02, 0x06, 0x01, 0x00, 0x01, 0x00, 0x01, 'n',
+		0x07, 0x04, 0x01, 0x00, 0x01, 'g',
+		0x0a, 0x01, 0xff }
+
+	names, err := readNameSection(payload)
+	if (err != nil) {
+		t.Fatal("Unexpected decoding status: ", err)
+	}
+
+	testCases := []struct{
+		name		string
+		actual		string
+		expected	string
+	}{
+		{ "module",				names.Module,			"mod" },
+		{ "function",			names.Function(0),		"$fac" },
+		{ "function-fallback",	names.Function(12),		"$func12" },
+		{ "local",				names.Local(0, 0),		"$n" },
+		{ "local-fallback",		names.Local(0, 1),		"$local1" },
+		{ "local-no-function",	names.Local(3, 0),		"$local0" },
+		{ "label-fallback",		names.Label(0, 2),		"$label2" },
+		{ "type-fallback",		names.Type(1),			"$type1" },
+		{ "table-fallback",		names.Table(0),			"$table0" },
+		{ "memory-fallback",	names.Memory(0),		"$memory0" },
+		{ "global",				names.Global(0),		"$g" },
+		{ "elem-fallback",		names.Element(4),		"$elem4" },
+		{ "data-fallback",		names.DataSegment(5),	"$data5" },
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			if (test.actual != test.expected) {
+				t.Errorf("Unexpected name: %s (expected %s)", test.actual,
+					test.expected)
+			}
+		})
+	}
+
+	// Malformed name sections are rejected
+	for _, malformed := range [][]byte{
+		{ 0x01, 0x05, 0x01, 0x00 },					// Truncated subsection
+		{ 0x01, 0x03, 0x01, 0x00, 0x05 },			// Truncated name
+		{ 0x00, 0x03, 0x01, 'm', 0x00 },			// Trailing bytes
+		{ 0x01, 0x04, 0x01, 0x00, 0x01, 0xff },	// Invalid UTF-8
+	} {
+		_, err := readNameSection(malformed)
+		if (err == nil) {
+			t.Errorf("Expected error for malformed name section: % x",
+				malformed)
+		}
+	}
+}
+
+
+//
+// Names are applied to module dumps + validation errors
+//
+func TestModuleNames(t *testing.T) {
+	// Single invalid function, named "broken"
+	encoded := append(CreateModuleBuilder().
+		Function("", FunctionType{ ResultType{}, ResultType{ NumTypei32 } },
+			nil, CreateExpression()).
+		Bytes(),
+		0x00, 0x10, 0x04, 'n', 'a', 'm', 'e',
+		0x01, 0x09, 0x01, 0x00, 0x06, 'b', 'r', 'o', 'k', 'e', 'n')
+
+	module, err := ReadModule(bytes.NewReader(encoded))
+	if (err != nil) {
+		t.Fatal("Unexpected decoding status: ", err)
+	}
+	if (module.Names().Function(0) != "$broken") {
+		t.Error("Unexpected function name: ", module.Names())
+	}
+	if (!strings.Contains(module.String(), "$broken function: length")) {
+		t.Error("Missing function name in module dump: ", module)
+	}
+
+	err = module.Validate()
+	if (err == nil || !strings.Contains(err.Error(), "$broken")) {
+		t.Error("Missing function name in validation error: ", err)
+	}
+
+	// Unnamed functions fall back to synthetic names
+	module, _ = ReadModule(bytes.NewReader(fuzzSeeds["fnop.wat"]))
+	if (!strings.Contains(module.String(), "$func0 function: length")) {
+		t.Error("Missing synthetic name in module dump: ", module)
+	}
+}
@@ -0,0 +1,300 @@
+package wasm
+
+import (
+	"compress/gzip"
+	"io"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+
+//
+// Profiler, with output in pprof format (see github.com/google/pprof), e.g.
+// for "go tool pprof".  The stacks of the profile are WASM call stacks, with
+// functions named by the name section, and "line numbers" given by the byte
+// offset of each instruction within its function body.  Profiles are either:
+//     * Instrumented: every instruction executed is counted, so the profile
+//       is exact, but execution is much slower
+//     * Sampled: the call stack is recorded at a fixed interval of wall time,
+//       at a cost of a single atomic load per instruction
+//
+type ProfileMode int
+
+const (
+	ProfileSample		ProfileMode = iota
+	ProfileInstrument
+)
+
+// Default sampling interval, as for Go CPU profiles (i.e., 100 Hz)
+const DefaultProfileInterval = 10 * time.Millisecond
+
+type Profiler struct {
+	mode		ProfileMode
+	interval	time.Duration
+
+	samples		map[string]*profileSample	// By encoded stack
+	functions	map[uint32]string			// Function names, by index
+	pending		int32						// Sample due, if nonzero
+	start		time.Time
+	duration	time.Duration				// Total profiled time
+}
+
+// A single (leaf first) call stack + the number of times it was seen
+type profileSample struct {
+	stack	[]profileLocation
+	count	int64
+}
+
+type profileLocation struct {
+	function	uint32
+	offset		int
+}
+
+//
+// Factory function for generating a Profiler.  Samples accumulate across
+// invocations, until written.  No side effects.
+//
+func CreateProfiler(mode ProfileMode) *Profiler {
+	return &Profiler{ mode: mode, interval: DefaultProfileInterval,
+		samples: make(map[string]*profileSample),
+		functions: make(map[uint32]string) }
+}
+
+// Sampling interval, for ProfileSample mode
+func (profiler *Profiler) SetInterval(interval time.Duration) {
+	profiler.interval = interval
+}
+
+// Number of samples (or instructions, if instrumented).  No side effects.
+func (profiler *Profiler) Samples() int64 {
+	var total int64
+	for _, sample := range profiler.samples {
+		total += sample.count
+	}
+	return total
+}
+
+//
+// Called by the interpreter when starting an invocation.  Returns a function
+// for stopping the profiler once the invocation is complete
+//
+func (profiler *Profiler) begin() func() {
+	if (profiler.start.IsZero()) {
+		profiler.start = time.Now()
+	}
+	begin := time.Now()
+	if (profiler.mode != ProfileSample) {
+		return func() {
+			profiler.duration += time.Since(begin)
+		}
+	}
+
+	// Sampling: a ticker marks each sample as due, and the interpreter
+	// records its stack before executing the next instruction
+	ticker := time.NewTicker(profiler.interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+				case <-ticker.C:	atomic.StoreInt32(&profiler.pending, 1)
+				case <-done:		return
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+		profiler.duration += time.Since(begin)
+	}
+}
+
+// Called by the interpreter before executing each instruction
+func (profiler *Profiler) check(thread *WASMInterpreterThread,
+	names NameSection) {
+	if (profiler.mode == ProfileSample) {
+		if (atomic.LoadInt32(&profiler.pending) == 0) {
+			return
+		}
+		atomic.StoreInt32(&profiler.pending, 0)
+	}
+	profiler.record(thread, names)
+}
+
+// Count the current call stack, innermost frame first
+func (profiler *Profiler) record(thread *WASMInterpreterThread,
+	names NameSection) {
+	stack := []profileLocation{ { uint32(thread.current.function),
+		thread.current.offset() } }
+	for i := thread.callStack.Top(); i > 0; i-- {
+		value, err := thread.callStack.Peek(i)
+		if (err != nil) {
+			break
+		}
+		caller := value.(StackFrame).caller
+		stack = append(stack,
+			profileLocation{ uint32(caller.function), caller.offset() })
+	}
+
+	var key []byte
+	for _, location := range stack {
+		key = appendULEB128(key, uint64(location.function))
+		key = appendULEB128(key, uint64(location.offset))
+	}
+	sample, ok := profiler.samples[string(key)]
+	if !ok {
+		sample = &profileSample{ stack: stack }
+		profiler.samples[string(key)] = sample
+		for _, location := range stack {
+			if _, ok := profiler.functions[location.function]; !ok {
+				profiler.functions[location.function] =
+					names.Function(location.function)
+			}
+		}
+	}
+	sample.count++
+}
+
+
+//
+// Write the profile in pprof format: a gzip-compressed protocol buffer.  See
+// profile.proto in the pprof sources for the field numbers
+//
+func (profiler *Profiler) WriteTo(writer io.Writer) (int64, error) {
+	strings := []string{ "" }
+	indices := map[string]uint64{ "": 0 }
+	stringIndex := func(s string) uint64 {
+		index, ok := indices[s]
+		if !ok {
+			index = uint64(len(strings))
+			indices[s] = index
+			strings = append(strings, s)
+		}
+		return index
+	}
+
+	var profile []byte
+	valueType := func(field uint64, kind string, unit string) {
+		var message []byte
+		message = appendVarintField(message, 1, stringIndex(kind))
+		message = appendVarintField(message, 2, stringIndex(unit))
+		profile = appendBytesField(profile, field, message)
+	}
+	if (profiler.mode == ProfileSample) {
+		valueType(1, "samples", "count")
+		valueType(1, "cpu", "nanoseconds")
+	} else {
+		valueType(1, "instructions", "count")
+	}
+
+	// Each distinct function + offset is a location, in a stable order
+	keys := make([]string, 0, len(profiler.samples))
+	for key := range profiler.samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	locations := make(map[profileLocation]uint64)
+	var locationOrder []profileLocation
+	for _, key := range keys {
+		sample := profiler.samples[key]
+		var ids []byte
+		for _, location := range sample.stack {
+			id, ok := locations[location]
+			if !ok {
+				id = uint64(len(locations) + 1)
+				locations[location] = id
+				locationOrder = append(locationOrder, location)
+			}
+			ids = appendULEB128(ids, id)
+		}
+
+		values := appendULEB128(nil, uint64(sample.count))
+		if (profiler.mode == ProfileSample) {
+			values = appendULEB128(values,
+				uint64(sample.count * profiler.interval.Nanoseconds()))
+		}
+		var message []byte
+		message = appendBytesField(message, 1, ids)
+		message = appendBytesField(message, 2, values)
+		profile = appendBytesField(profile, 2, message)
+	}
+
+	for _, location := range locationOrder {
+		var line []byte
+		line = appendVarintField(line, 1, uint64(location.function) + 1)
+		line = appendVarintField(line, 2, uint64(location.offset))
+		var message []byte
+		message = appendVarintField(message, 1, locations[location])
+		message = appendBytesField(message, 4, line)
+		profile = appendBytesField(profile, 4, message)
+	}
+
+	// Function ids are offset by one, since zero is reserved
+	functions := make([]uint32, 0, len(profiler.functions))
+	for index := range profiler.functions {
+		functions = append(functions, index)
+	}
+	sort.Slice(functions, func(i, j int) bool {
+		return functions[i] < functions[j]
+	})
+	for _, index := range functions {
+		name := stringIndex(profiler.functions[index])
+		var message []byte
+		message = appendVarintField(message, 1, uint64(index) + 1)
+		message = appendVarintField(message, 2, name)
+		message = appendVarintField(message, 3, name)
+		profile = appendBytesField(profile, 5, message)
+	}
+
+	// Period, timing + finally the string table itself
+	if (profiler.mode == ProfileSample) {
+		valueType(11, "cpu", "nanoseconds")
+		profile = appendVarintField(profile, 12,
+			uint64(profiler.interval.Nanoseconds()))
+	} else {
+		valueType(11, "instructions", "count")
+		profile = appendVarintField(profile, 12, 1)
+	}
+	if (!profiler.start.IsZero()) {
+		profile = appendVarintField(profile, 9,
+			uint64(profiler.start.UnixNano()))
+	}
+	profile = appendVarintField(profile, 10,
+		uint64(profiler.duration.Nanoseconds()))
+	for _, s := range strings {
+		profile = appendBytesField(profile, 6, []byte(s))
+	}
+
+	counter := &countingWriter{ writer: writer }
+	compressor := gzip.NewWriter(counter)
+	_, err := compressor.Write(profile)
+	if (err == nil) {
+		err = compressor.Close()
+	}
+	return counter.count, err
+}
+
+// Protocol buffer encoding: each field is a tag (field number + wire type)
+// followed by a varint, or a length-prefixed run of bytes
+func appendVarintField(buffer []byte, field uint64, value uint64) []byte {
+	buffer = appendULEB128(buffer, field << 3)
+	return appendULEB128(buffer, value)
+}
+
+func appendBytesField(buffer []byte, field uint64, value []byte) []byte {
+	buffer = appendULEB128(buffer, field << 3 | 2)
+	buffer = appendULEB128(buffer, uint64(len(value)))
+	return append(buffer, value...)
+}
+
+// Writer that counts the bytes written, for WriteTo()
+type countingWriter struct {
+	writer	io.Writer
+	count	int64
+}
+
+func (w *countingWriter) Write(buffer []byte) (int, error) {
+	n, err := w.writer.Write(buffer)
+	w.count += int64(n)
+	return n, err
+}
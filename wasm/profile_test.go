@@ -0,0 +1,86 @@
+package wasm
+
+import(
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+	"time"
+	)
+
+
+//
+// Test instrumented profiles, decoding the pprof output just enough to check
+// the samples + function names
+//
+func TestProfiler(t *testing.T) {
+	module, err := ReadWATWithOptions(strings.NewReader(`(module
+		(func $add (export "add") (param i32 i32) (result i32)
+			local.get 0 local.get 1 i32.add))`),
+		WATOptions{ DebugNames: true })
+	if (err != nil) {
+		t.Fatal("Unexpected assembly error: ", err)
+	}
+	args := []interface{}{ int32(3), int32(4) }
+
+	profiler := CreateProfiler(ProfileInstrument)
+	for i := 0; i < 2; i++ {
+		_, err = WASMInterpreter{}.Invoke(module, "add", args,
+			VMConfig{ Profiler: profiler })
+		if (err != nil) {
+			t.Fatal("Unexpected VM status: ", err)
+		}
+	}
+	if (profiler.Samples() != 8) {
+		t.Errorf("Unexpected sample count %d", profiler.Samples())
+	}
+
+	var output bytes.Buffer
+	n, err := profiler.WriteTo(&output)
+	if (err != nil || n != int64(output.Len())) {
+		t.Fatal("Unexpected write status: ", n, err)
+	}
+	reader, err := gzip.NewReader(&output)
+	if (err != nil) {
+		t.Fatal("Unexpected gzip status: ", err)
+	}
+	profile, err := io.ReadAll(reader)
+	if (err != nil) {
+		t.Fatal("Unexpected gzip status: ", err)
+	}
+
+	// One sample + one location per instruction; a single function
+	fields := make(map[uint64]int)
+	var strings []string
+	for len(profile) > 0 {
+		tag, n := binary.Uvarint(profile)
+		value, m := binary.Uvarint(profile[n:])
+		if (n <= 0 || m <= 0) {
+			t.Fatal("Invalid profile field")
+		}
+		profile = profile[n + m:]
+		if (tag & 7 == 2) {
+			if (tag >> 3 == 6) {
+				strings = append(strings, string(profile[:value]))
+			}
+			profile = profile[value:]
+		}
+		fields[tag >> 3]++
+	}
+	if (fields[2] != 4 || fields[4] != 4 || fields[5] != 1 ||
+		len(strings) == 0 || strings[0] != "" ||
+		strings[len(strings) - 1] != "$add") {
+		t.Errorf("Unexpected profile fields %v, strings %q", fields, strings)
+	}
+
+	// Sampling at a long interval should record nothing
+	profiler = CreateProfiler(ProfileSample)
+	profiler.SetInterval(time.Hour)
+	WASMInterpreter{}.Invoke(module, "add", args,
+		VMConfig{ Profiler: profiler })
+	if (profiler.Samples() != 0) {
+		t.Errorf("Unexpected sample count %d", profiler.Samples())
+	}
+}
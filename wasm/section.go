@@ -6,7 +6,6 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"strings"
 )
 
@@ -44,6 +43,7 @@ const (
 type Section interface {
 	id() uint32
 	validate() error
+	encode() []byte		// Binary encoding of the section content
 }
 
 
@@ -55,12 +55,13 @@ type Section interface {
 type Function struct {
 	body	[]byte
 	local	[]ValueType
-	//@possible "name" annotation from custom section "name"?
 }
 
-// Factory function for decoding + returning a single Function body.  No
-// side effects.
-func readFunction(reader *bytes.Reader) (Function, error) {
+// Factory function for decoding + returning a single Function body, given
+// the number of locals already decoded in preceding functions.  No side
+// effects.
+func readFunction(reader *bytes.Reader, limits DecodeLimits,
+	previous uint64) (Function, error) {
 	function := Function{}
 
 	// Size of the entire function description, in bytes
@@ -68,11 +69,19 @@ func readFunction(reader *bytes.Reader) (Function, error) {
 	if (err != nil) {
 		return function, err
 	}
+	err = checkLimit("function size", uint64(functionSize),
+		uint64(limits.MaxFunctionSize))
+	if (err != nil) {
+		return function, err
+	}
+	if (int64(functionSize) > int64(reader.Len())) {
+		return function, io.ErrUnexpectedEOF
+	}
 
 	// Consume *only* this function, if multiple functions are present in this
 	// same Code section
 	functionBytes := make([]byte, functionSize)
-	_, err = reader.Read(functionBytes)
+	_, err = io.ReadFull(reader, functionBytes)
 	if (err != nil) {
 		return function, err
 	}
@@ -84,24 +93,41 @@ func readFunction(reader *bytes.Reader) (Function, error) {
 		return function, err
 	}
 
-	// Consume the actual local declarations
-	local := make([]ValueType, count)
+	// Consume the actual local declarations.  Each declaration is a run of N
+	// locals of the same type, so expand these into one entry per local.  The
+	// totals (per function + per module) are capped *before* expanding, since
+	// a single declaration can claim billions of locals in just a few bytes
+	local := make([]ValueType, 0)
+	total := uint64(0)
 	for i := uint32(0); i < count; i++ {
-		//@N instances of vtype. how are these consumed?
-		_, err = readULEB128(functionReader)
+		n, err := readULEB128(functionReader)
+		if (err != nil) {
+			return function, err
+		}
+		vtype, err := functionReader.ReadByte()
+		if (err != nil) {
+			return function, err
+		}
+
+		total += uint64(n)
+		err = checkLimit("locals", total, uint64(limits.MaxLocals))
 		if (err != nil) {
 			return function, err
 		}
-		_, err = functionReader.ReadByte()
+		err = checkLimit("total locals", previous + total,
+			uint64(limits.MaxTotalLocals))
 		if (err != nil) {
 			return function, err
 		}
+		for j := uint32(0); j < n; j++ {
+			local = append(local, ValueType(vtype))
+		}
 	}
 	function.local = local
 
 	// Consume the actual code/function body
 	body := make([]byte, functionReader.Len())
-	_, err = functionReader.Read(body)
+	_, err = io.ReadFull(functionReader, body)
 	if (err != nil) {
 		return function, err
 	}
@@ -110,6 +136,32 @@ func readFunction(reader *bytes.Reader) (Function, error) {
 	return function, err
 }
 
+// Binary encoding of a single function body, excluding the leading size.
+// Consecutive locals of the same type are grouped into a single declaration.
+// No side effects.
+func (function Function) encode() []byte {
+	// Count the runs of identical local types
+	runs := 0
+	for i := range function.local {
+		if (i == 0 || function.local[i] != function.local[i-1]) {
+			runs++
+		}
+	}
+
+	buffer := appendVectorLength(nil, runs)
+	for i := 0; i < len(function.local); {
+		j := i
+		for j < len(function.local) && function.local[j] == function.local[i] {
+			j++
+		}
+		buffer = appendULEB128(buffer, uint64(j - i))
+		buffer = append(buffer, byte(function.local[i]))
+		i = j
+	}
+
+	return append(buffer, function.body...)
+}
+
 func (function Function) String() string {
 	return fmt.Sprintf("function: length %d", len(function.body))//@
 }
@@ -121,23 +173,25 @@ type CodeSection struct {
 
 // Factory function for decoding and generating a CodeSection from a stream
 // of bytes.  No side effects.
-func readCodeSection(content []byte) (CodeSection, error) {
+func readCodeSection(content []byte, limits DecodeLimits) (CodeSection, error) {
 	section := CodeSection{}
 	reader  := bytes.NewReader(content)
 
 	// Code section is encoded as a vector of code block (function bodies)
 	count, err := readVectorLength(reader)
 	if (err != nil) {
-		return section, err
+		return section, failureAt(reader, err)
 	}
 
 	// Parse the individual bodies
 	function := make([]Function, count)
+	locals := uint64(0)
 	for i := uint32(0); i < count; i++ {
-		function[i], err = readFunction(reader)
+		function[i], err = readFunction(reader, limits, locals)
 		if (err != nil) {
-			return section, err
+			return section, failureAt(reader, err)
 		}
+		locals += uint64(len(function[i].local))
 	}
 	section.function = function
 
@@ -153,12 +207,32 @@ func (section CodeSection) validate() error {
 	return nil
 }
 
+func (section CodeSection) encode() []byte {
+	buffer := appendVectorLength(nil, len(section.function))
+	for _, function := range section.function {
+		encoded := function.encode()
+		buffer = appendULEB128(buffer, uint64(len(encoded)))
+		buffer = append(buffer, encoded...)
+	}
+	return buffer
+}
+
 func (section CodeSection) String() string {
+	return section.describe(NameSection{}, 0)
+}
+
+//
+// Describe each function body, annotated with its name (or synthetic name).
+// Function indices are offset by the number of imported functions.  No side
+// effects.
+//
+func (section CodeSection) describe(names NameSection, imported int) string {
 	var builder strings.Builder
 
 	builder.WriteString("Code section:\n")
-	for _, export := range section.function {
-		builder.WriteString(fmt.Sprintf("    %s\n", export))
+	for i, function := range section.function {
+		builder.WriteString(fmt.Sprintf("    %s %s\n",
+			names.Function(uint32(imported + i)), function))
 	}
 	return builder.String()
 }
@@ -182,7 +256,7 @@ func readCustomSection(content []byte) (CustomSection, error) {
 	reader := bytes.NewReader(content)
 	name, err := readName(reader)
 	if (err != nil) {
-		return section, err
+		return section, failureAt(reader, err)
 	}
 
 	// Rest of the section is opaque data and cannot be parsed.  Just consume
@@ -200,9 +274,401 @@ func (section CustomSection) validate() error {
 	return nil
 }
 
+func (section CustomSection) encode() []byte {
+	// Content already includes the section name
+	return section.content
+}
+
+// Name of this custom section.  No side effects.
+func (section CustomSection) Name() string {
+	return section.name
+}
+
+// Content of this custom section, following the name.  No side effects.
+func (section CustomSection) Payload() []byte {
+	return section.content[ len(appendName(nil, section.name)): ]
+}
+
 func (section CustomSection) String() string {
-	return fmt.Sprintf("Custom section:\n    custom: '%s', size %d\n",
-		section.name, len(section.content))
+	// Include the content of any well-known sections
+	return fmt.Sprintf("Custom section:\n    custom: '%s', size %d\n%s",
+		section.name, len(section.content), describeCustomSection(section))
+}
+
+
+//
+// Data section.  Initializers for linear memory
+//
+const (
+	SegmentModeActive		= 0x00
+	SegmentModePassive		= 0x01
+	SegmentModeDeclarative	= 0x02
+)
+
+var SegmentModeMap = map[int]string {
+	SegmentModeActive:		"active",
+	SegmentModePassive:		"passive",
+	SegmentModeDeclarative:	"declarative",
+}
+
+// A single data segment
+type Data struct {
+	flags	uint32	// Encoding variant, see section 5.5.14 of WASM spec
+	mode	uint8
+	memory	uint32	// Active segments only
+	offset	[]byte	// Active segments only: constant expression
+	init	[]byte
+}
+
+// Factory function for decoding + returning a single Data segment.  No side
+// effects.
+func readData(reader *bytes.Reader) (Data, error) {
+	data := Data{}
+
+	flags, err := readULEB128(reader)
+	if (err != nil) {
+		return data, err
+	}
+	data.flags = flags
+
+	switch(flags) {
+		case 0:		data.mode = SegmentModeActive
+		case 1:		data.mode = SegmentModePassive
+		case 2:
+			data.mode = SegmentModeActive
+			data.memory, err = readULEB128(reader)
+			if (err != nil) {
+				return data, err
+			}
+		default:
+			return data, InvalidSection
+	}
+
+	if (data.mode == SegmentModeActive) {
+		data.offset, err = readConstantExpression(reader)
+		if (err != nil) {
+			return data, err
+		}
+	}
+
+	// Initial memory content is a vector of bytes
+	size, err := readVectorLength(reader)
+	if (err != nil) {
+		return data, err
+	}
+	init := make([]byte, size)
+	_, err = io.ReadFull(reader, init)
+	if (err != nil) {
+		return data, err
+	}
+	data.init = init
+
+	return data, nil
+}
+
+func (data Data) String() string {
+	previewLength, suffix := preview(len(data.init), 8)
+	return fmt.Sprintf("data: %s, memory %d, size %d: % x%s",
+		SegmentModeMap[ int(data.mode) ], data.memory, len(data.init),
+		data.init[:previewLength], suffix)
+}
+
+// Top-level section for declaring Data segments
+type DataSection struct {
+	data []Data
+}
+
+func (section DataSection) id() uint32 {
+	return DataSectionId
+}
+
+// Factory function for decoding and generating a DataSection from a stream
+// of bytes.  No side effects.
+func readDataSection(content []byte) (DataSection, error) {
+	section := DataSection{}
+	reader  := bytes.NewReader(content)
+
+	// Data section is encoded as a vector of Data segments
+	count, err := readVectorLength(reader)
+	if (err != nil) {
+		return section, failureAt(reader, err)
+	}
+
+	// Parse the individual segments
+	data := make([]Data, count)
+	for i := uint32(0); i < count; i++ {
+		data[i], err = readData(reader)
+		if (err != nil) {
+			return section, failureAt(reader, err)
+		}
+	}
+	section.data = data
+
+	return section, nil
+}
+
+func (section DataSection) validate() error {
+	//@
+	return nil
+}
+
+func (section DataSection) encode() []byte {
+	buffer := appendVectorLength(nil, len(section.data))
+	for _, data := range section.data {
+		buffer = appendULEB128(buffer, uint64(data.flags))
+		if (data.flags == 2) {
+			buffer = appendULEB128(buffer, uint64(data.memory))
+		}
+		if (data.mode == SegmentModeActive) {
+			buffer = append(buffer, data.offset...)
+		}
+		buffer = appendVectorLength(buffer, len(data.init))
+		buffer = append(buffer, data.init...)
+	}
+	return buffer
+}
+
+func (section DataSection) String() string {
+	var builder strings.Builder
+
+	builder.WriteString("Data section:\n")
+	for _, data := range section.data {
+		builder.WriteString(fmt.Sprintf("    %s\n", data))
+	}
+	return builder.String()
+}
+
+
+//
+// Data count section.  Declares the number of Data segments up front, so that
+// function bodies can be validated before the Data section is decoded
+//
+type DataCountSection struct {
+	count uint32
+}
+
+func (section DataCountSection) id() uint32 {
+	return DataCountSectionId
+}
+
+// Factory function for decoding and generating a DataCountSection from a
+// stream of bytes.  No side effects.
+func readDataCountSection(content []byte) (DataCountSection, error) {
+	section := DataCountSection{}
+	reader  := bytes.NewReader(content)
+
+	count, err := readULEB128(reader)
+	if (err != nil) {
+		return section, failureAt(reader, err)
+	}
+	section.count = count
+
+	return section, nil
+}
+
+func (section DataCountSection) validate() error {
+	return nil
+}
+
+func (section DataCountSection) encode() []byte {
+	return appendULEB128(nil, uint64(section.count))
+}
+
+func (section DataCountSection) String() string {
+	return fmt.Sprintf("Data count section:\n    count: %d\n", section.count)
+}
+
+
+//
+// Element section.  Initializers for tables
+//
+
+// Element kind for segments that are encoded as function indices
+const ElementKindFunction = 0x00
+
+// A single element segment
+type Element struct {
+	flags		uint32		// Encoding variant, see section 5.5.12 of WASM spec
+	mode		uint8
+	table		uint32		// Active segments only
+	offset		[]byte		// Active segments only: constant expression
+	reftype		ValueType
+
+	// Exactly one of these is populated, depending on the encoding variant
+	function	[]uint32	// Function indices
+	init		[][]byte	// Constant expressions
+}
+
+// Factory function for decoding + returning a single Element segment.  No
+// side effects.
+func readElement(reader *bytes.Reader) (Element, error) {
+	element := Element{ reftype: RefTypeFunction }
+
+	// The flags field is a bitfield:
+	// - bit 0: passive or declarative (vs active)
+	// - bit 1: explicit table index (active), or declarative (vs passive)
+	// - bit 2: element expressions (vs function indices)
+	flags, err := readULEB128(reader)
+	if (err != nil) {
+		return element, err
+	}
+	if (flags > 7) {
+		return element, InvalidSection
+	}
+	element.flags = flags
+
+	switch {
+		case (flags & 0x1) == 0:	element.mode = SegmentModeActive
+		case (flags & 0x2) == 0:	element.mode = SegmentModePassive
+		default:					element.mode = SegmentModeDeclarative
+	}
+
+	if (element.mode == SegmentModeActive) {
+		if ((flags & 0x2) != 0) {
+			element.table, err = readULEB128(reader)
+			if (err != nil) {
+				return element, err
+			}
+		}
+		element.offset, err = readConstantExpression(reader)
+		if (err != nil) {
+			return element, err
+		}
+	}
+
+	// Element kind (function indices) or reference type (expressions).  Both
+	// are implicit for the legacy active encodings
+	if (flags != 0 && flags != 4) {
+		kind, err := reader.ReadByte()
+		if (err != nil) {
+			return element, err
+		}
+		if ((flags & 0x4) == 0) {
+			if (kind != ElementKindFunction) {
+				return element, InvalidSection
+			}
+		} else {
+			element.reftype = ValueType(kind)
+		}
+	}
+
+	count, err := readVectorLength(reader)
+	if (err != nil) {
+		return element, err
+	}
+	for i := uint32(0); i < count; i++ {
+		if ((flags & 0x4) == 0) {
+			index, err := readULEB128(reader)
+			if (err != nil) {
+				return element, err
+			}
+			element.function = append(element.function, index)
+		} else {
+			expr, err := readConstantExpression(reader)
+			if (err != nil) {
+				return element, err
+			}
+			element.init = append(element.init, expr)
+		}
+	}
+
+	return element, nil
+}
+
+// Number of references in this segment
+func (element Element) length() int {
+	if ((element.flags & 0x4) == 0) {
+		return len(element.function)
+	}
+	return len(element.init)
+}
+
+func (element Element) String() string {
+	return fmt.Sprintf("element: %s, table %d, type %s, count %d",
+		SegmentModeMap[ int(element.mode) ], element.table,
+		TypeMap[ int(element.reftype) ], element.length())
+}
+
+// Top-level section for declaring Element segments
+type ElementSection struct {
+	element []Element
+}
+
+func (section ElementSection) id() uint32 {
+	return ElementSectionId
+}
+
+// Factory function for decoding and generating an ElementSection from a
+// stream of bytes.  No side effects.
+func readElementSection(content []byte) (ElementSection, error) {
+	section := ElementSection{}
+	reader  := bytes.NewReader(content)
+
+	// Element section is encoded as a vector of Element segments
+	count, err := readVectorLength(reader)
+	if (err != nil) {
+		return section, failureAt(reader, err)
+	}
+
+	// Parse the individual segments
+	element := make([]Element, count)
+	for i := uint32(0); i < count; i++ {
+		element[i], err = readElement(reader)
+		if (err != nil) {
+			return section, failureAt(reader, err)
+		}
+	}
+	section.element = element
+
+	return section, nil
+}
+
+func (section ElementSection) validate() error {
+	//@
+	return nil
+}
+
+func (section ElementSection) encode() []byte {
+	buffer := appendVectorLength(nil, len(section.element))
+	for _, element := range section.element {
+		flags := element.flags
+		buffer = appendULEB128(buffer, uint64(flags))
+
+		if (element.mode == SegmentModeActive) {
+			if ((flags & 0x2) != 0) {
+				buffer = appendULEB128(buffer, uint64(element.table))
+			}
+			buffer = append(buffer, element.offset...)
+		}
+
+		// Element kind or reference type, except for the legacy encodings
+		if (flags != 0 && flags != 4) {
+			if ((flags & 0x4) == 0) {
+				buffer = append(buffer, ElementKindFunction)
+			} else {
+				buffer = append(buffer, byte(element.reftype))
+			}
+		}
+
+		buffer = appendVectorLength(buffer, element.length())
+		for _, index := range element.function {
+			buffer = appendULEB128(buffer, uint64(index))
+		}
+		for _, init := range element.init {
+			buffer = append(buffer, init...)
+		}
+	}
+	return buffer
+}
+
+func (section ElementSection) String() string {
+	var builder strings.Builder
+
+	builder.WriteString("Element section:\n")
+	for _, element := range section.element {
+		builder.WriteString(fmt.Sprintf("    %s\n", element))
+	}
+	return builder.String()
 }
 
 
@@ -267,6 +733,7 @@ func (export Export) String() string {
 // Top-level section for declaring Exported symbols/references
 type ExportSection struct {
 	export map[string]Export
+	list []Export	// In declaration order, including any duplicate names
 }
 
 func (section ExportSection) id() uint32 {
@@ -282,7 +749,7 @@ func readExportSection(content []byte) (ExportSection, error) {
 	// Export section is encoded as a vector of Export descriptors
 	count, err := readVectorLength(reader)
 	if (err != nil) {
-		return section, err
+		return section, failureAt(reader, err)
 	}
 
 	// Parse the individual export descriptors
@@ -290,9 +757,10 @@ func readExportSection(content []byte) (ExportSection, error) {
 	for i := uint32(0); i < count; i++ {
 		export, err := readExport(reader)
 		if (err != nil) {
-			return section, err
+			return section, failureAt(reader, err)
 		}
 		exportMap[export.name] = export
+		section.list = append(section.list, export)
 	}
 	section.export = exportMap
 
@@ -305,11 +773,21 @@ func (section ExportSection) validate() error {
 	return nil
 }
 
+func (section ExportSection) encode() []byte {
+	buffer := appendVectorLength(nil, len(section.list))
+	for _, export := range section.list {
+		buffer = appendName(buffer, export.name)
+		buffer = append(buffer, export.etype)
+		buffer = appendULEB128(buffer, uint64(export.index))
+	}
+	return buffer
+}
+
 func (section ExportSection) String() string {
 	var builder strings.Builder
 
 	builder.WriteString("Export section:\n")
-	for _, export := range section.export {
+	for _, export := range section.list {
 		builder.WriteString(fmt.Sprintf("    %s\n", export))
 	}
 	return builder.String()
@@ -338,7 +816,7 @@ func readFunctionSection(content []byte) (FunctionSection, error) {
 	// Function section is encoded as a vector of type indices
 	count, err := readVectorLength(reader)
 	if (err != nil) {
-		return section, err
+		return section, failureAt(reader, err)
 	}
 
 	// Parse the individual function descriptors
@@ -346,7 +824,7 @@ func readFunctionSection(content []byte) (FunctionSection, error) {
 	for i := uint32(0); i < count; i++ {
 		function[i], err = readULEB128(reader)
 		if (err != nil) {
-			return section, err
+			return section, failureAt(reader, err)
 		}
 	}
 	section.function = function
@@ -360,6 +838,14 @@ func (section FunctionSection) validate() error {
 	return nil
 }
 
+func (section FunctionSection) encode() []byte {
+	buffer := appendVectorLength(nil, len(section.function))
+	for _, index := range section.function {
+		buffer = appendULEB128(buffer, uint64(index))
+	}
+	return buffer
+}
+
 func (section FunctionSection) String() string {
 	// Include the first few indices
 	previewLength, suffix := preview(len(section.function), 8)
@@ -368,6 +854,284 @@ func (section FunctionSection) String() string {
 }
 
 
+//
+// Globals section
+//
+
+// Type of a single global variable: value type + mutability
+type GlobalType struct {
+	vtype	ValueType
+	mutable	bool
+}
+
+// Factory function for decoding + returning a single GlobalType.  No side
+// effects.
+func readGlobalType(reader *bytes.Reader) (GlobalType, error) {
+	gtype := GlobalType{}
+
+	vtype, err := reader.ReadByte()
+	if (err != nil) {
+		return gtype, err
+	}
+	gtype.vtype = ValueType(vtype)
+
+	// Mutability flag: 0x00 is const, 0x01 is var
+	mutable, err := reader.ReadByte()
+	if (err != nil) {
+		return gtype, err
+	}
+	if (mutable > 1) {
+		return gtype, InvalidSection
+	}
+	gtype.mutable = (mutable == 1)
+
+	return gtype, nil
+}
+
+// Append the binary encoding of a GlobalType to a byte slice.  No side
+// effects.
+func appendGlobalType(buffer []byte, gtype GlobalType) []byte {
+	mutable := byte(0)
+	if (gtype.mutable) {
+		mutable = 1
+	}
+	return append(buffer, byte(gtype.vtype), mutable)
+}
+
+func (gtype GlobalType) String() string {
+	if (gtype.mutable) {
+		return fmt.Sprintf("mut %s", TypeMap[ int(gtype.vtype) ])
+	}
+	return TypeMap[ int(gtype.vtype) ]
+}
+
+// A single global variable, with its initializer expression
+type Global struct {
+	gtype	GlobalType
+	init	[]byte	// Constant expression, including the trailing "end"
+}
+
+// Factory function for decoding + returning a single Global descriptor.  No
+// side effects.
+func readGlobal(reader *bytes.Reader) (Global, error) {
+	global := Global{}
+
+	gtype, err := readGlobalType(reader)
+	if (err != nil) {
+		return global, err
+	}
+	global.gtype = gtype
+
+	init, err := readConstantExpression(reader)
+	if (err != nil) {
+		return global, err
+	}
+	global.init = init
+
+	return global, nil
+}
+
+func (global Global) String() string {
+	return fmt.Sprintf("global: %s, init % x", global.gtype, global.init)
+}
+
+// Top-level section for declaring Global variables
+type GlobalSection struct {
+	global []Global
+}
+
+func (section GlobalSection) id() uint32 {
+	return GlobalSectionId
+}
+
+// Factory function for decoding and generating a GlobalSection from a stream
+// of bytes.  No side effects.
+func readGlobalSection(content []byte) (GlobalSection, error) {
+	section := GlobalSection{}
+	reader  := bytes.NewReader(content)
+
+	// Global section is encoded as a vector of Global descriptors
+	count, err := readVectorLength(reader)
+	if (err != nil) {
+		return section, failureAt(reader, err)
+	}
+
+	// Parse the individual globals
+	global := make([]Global, count)
+	for i := uint32(0); i < count; i++ {
+		global[i], err = readGlobal(reader)
+		if (err != nil) {
+			return section, failureAt(reader, err)
+		}
+	}
+	section.global = global
+
+	return section, nil
+}
+
+func (section GlobalSection) validate() error {
+	//@
+	return nil
+}
+
+func (section GlobalSection) encode() []byte {
+	buffer := appendVectorLength(nil, len(section.global))
+	for _, global := range section.global {
+		buffer = appendGlobalType(buffer, global.gtype)
+		buffer = append(buffer, global.init...)
+	}
+	return buffer
+}
+
+func (section GlobalSection) String() string {
+	var builder strings.Builder
+
+	builder.WriteString("Global section:\n")
+	for _, global := range section.global {
+		builder.WriteString(fmt.Sprintf("    %s\n", global))
+	}
+	return builder.String()
+}
+
+
+//
+// Imports section.  Import descriptors share the same type encoding as
+// Export descriptors (function, table, memory, global)
+//
+
+// A descriptor for a single imported symbol/reference
+type Import struct {
+	module		string
+	name		string
+	itype		uint8
+
+	// Exactly one of these is meaningful, depending on the import type
+	function	uint32		// Type index
+	table		Table
+	memory		Memory
+	global		GlobalType
+}
+
+// Factory function for decoding + returning a single Import descriptor.  No
+// side effects.
+func readImport(reader *bytes.Reader) (Import, error) {
+	imp := Import{}
+
+	// Two-level namespace: module name + symbol name
+	module, err := readName(reader)
+	if (err != nil) {
+		return imp, err
+	}
+	imp.module = module
+
+	name, err := readName(reader)
+	if (err != nil) {
+		return imp, err
+	}
+	imp.name = name
+
+	// Import descriptor, based on the type of resource
+	itype, err := reader.ReadByte()
+	if (err != nil) {
+		return imp, err
+	}
+	imp.itype = itype
+
+	switch(itype) {
+		case ExportTypeFunction:	imp.function, err = readULEB128(reader)
+		case ExportTypeTable:		imp.table, err = readTable(reader)
+		case ExportTypeMemory:		imp.memory, err = readMemory(reader)
+		case ExportTypeGlobal:		imp.global, err = readGlobalType(reader)
+		default:					err = InvalidSection
+	}
+
+	return imp, err
+}
+
+func (imp Import) String() string {
+	var desc string
+	switch(imp.itype) {
+		case ExportTypeFunction:	desc = fmt.Sprintf("type %d", imp.function)
+		case ExportTypeTable:		desc = imp.table.String()
+		case ExportTypeMemory:		desc = imp.memory.String()
+		case ExportTypeGlobal:		desc = imp.global.String()
+	}
+	return fmt.Sprintf("import: '%s.%s', type %s, %s",
+		imp.module, imp.name, ExportTypeMap[ int(imp.itype) ], desc)
+}
+
+// Top-level section for declaring Imported symbols/references
+type ImportSection struct {
+	imports []Import
+}
+
+func (section ImportSection) id() uint32 {
+	return ImportSectionId
+}
+
+// Factory function for decoding and generating an ImportSection from a stream
+// of bytes.  No side effects.
+func readImportSection(content []byte) (ImportSection, error) {
+	section := ImportSection{}
+	reader  := bytes.NewReader(content)
+
+	// Import section is encoded as a vector of Import descriptors
+	count, err := readVectorLength(reader)
+	if (err != nil) {
+		return section, failureAt(reader, err)
+	}
+
+	// Parse the individual import descriptors
+	imports := make([]Import, count)
+	for i := uint32(0); i < count; i++ {
+		imports[i], err = readImport(reader)
+		if (err != nil) {
+			return section, failureAt(reader, err)
+		}
+	}
+	section.imports = imports
+
+	return section, nil
+}
+
+func (section ImportSection) validate() error {
+	//@
+	return nil
+}
+
+func (section ImportSection) encode() []byte {
+	buffer := appendVectorLength(nil, len(section.imports))
+	for _, imp := range section.imports {
+		buffer = appendName(buffer, imp.module)
+		buffer = appendName(buffer, imp.name)
+		buffer = append(buffer, imp.itype)
+
+		switch(imp.itype) {
+			case ExportTypeFunction:
+				buffer = appendULEB128(buffer, uint64(imp.function))
+			case ExportTypeTable:
+				buffer = append(buffer, imp.table.reftype)
+				buffer = appendLimit(buffer, imp.table.limit)
+			case ExportTypeMemory:
+				buffer = appendLimit(buffer, imp.memory.limit)
+			case ExportTypeGlobal:
+				buffer = appendGlobalType(buffer, imp.global)
+		}
+	}
+	return buffer
+}
+
+func (section ImportSection) String() string {
+	var builder strings.Builder
+
+	builder.WriteString("Import section:\n")
+	for _, imp := range section.imports {
+		builder.WriteString(fmt.Sprintf("    %s\n", imp))
+	}
+	return builder.String()
+}
+
+
 //
 // Memory section
 //
@@ -405,7 +1169,7 @@ func readMemorySection(content []byte) (MemorySection, error) {
 	// Memory section is encoded as a vector of memory limits
 	count, err := readVectorLength(reader)
 	if (err != nil) {
-		return section, err
+		return section, failureAt(reader, err)
 	}
 
 	// Parse the individual memories
@@ -413,7 +1177,7 @@ func readMemorySection(content []byte) (MemorySection, error) {
 	for i := uint32(0); i < count; i++ {
 		memory[i], err = readMemory(reader)
 		if (err != nil) {
-			return section, err
+			return section, failureAt(reader, err)
 		}
 	}
 	section.memory = memory
@@ -434,6 +1198,14 @@ func (section MemorySection) validate() error {
 	return nil
 }
 
+func (section MemorySection) encode() []byte {
+	buffer := appendVectorLength(nil, len(section.memory))
+	for _, memory := range section.memory {
+		buffer = appendLimit(buffer, memory.limit)
+	}
+	return buffer
+}
+
 func (section MemorySection) String() string {
 	var builder strings.Builder
 
@@ -445,6 +1217,45 @@ func (section MemorySection) String() string {
 }
 
 
+//
+// Start section.  Identifies the function to run on module instantiation
+//
+type StartSection struct {
+	function uint32
+}
+
+func (section StartSection) id() uint32 {
+	return StartSectionId
+}
+
+// Factory function for decoding and generating a StartSection from a stream
+// of bytes.  No side effects.
+func readStartSection(content []byte) (StartSection, error) {
+	section := StartSection{}
+	reader  := bytes.NewReader(content)
+
+	function, err := readULEB128(reader)
+	if (err != nil) {
+		return section, failureAt(reader, err)
+	}
+	section.function = function
+
+	return section, nil
+}
+
+func (section StartSection) validate() error {
+	return nil
+}
+
+func (section StartSection) encode() []byte {
+	return appendULEB128(nil, uint64(section.function))
+}
+
+func (section StartSection) String() string {
+	return fmt.Sprintf("Start section:\n    function: %d\n", section.function)
+}
+
+
 //
 // Table section
 //
@@ -491,7 +1302,7 @@ func readTableSection(content []byte) (TableSection, error) {
 	// Table section is encoded as a vector of tables limits + types
 	count, err := readVectorLength(reader)
 	if (err != nil) {
-		return section, err
+		return section, failureAt(reader, err)
 	}
 
 	// Parse the individual tables
@@ -499,7 +1310,7 @@ func readTableSection(content []byte) (TableSection, error) {
 	for i := uint32(0); i < count; i++ {
 		table[i], err = readTable(reader)
 		if (err != nil) {
-			return section, err
+			return section, failureAt(reader, err)
 		}
 	}
 	section.table = table
@@ -513,6 +1324,15 @@ func (section TableSection) validate() error {
 	return nil
 }
 
+func (section TableSection) encode() []byte {
+	buffer := appendVectorLength(nil, len(section.table))
+	for _, table := range section.table {
+		buffer = append(buffer, table.reftype)
+		buffer = appendLimit(buffer, table.limit)
+	}
+	return buffer
+}
+
 func (section TableSection) String() string {
 	var builder strings.Builder
 
@@ -545,6 +1365,9 @@ const (
 	NumTypef32		= 0x7D
 	NumTypef64		= 0x7C
 
+	// Vector types
+	VecTypev128		= 0x7B
+
 	// Reference types
 	RefTypeFunction	= 0x70
 	RefTypeExtern	= 0x6F
@@ -555,6 +1378,7 @@ var TypeMap = map[int]string {
 	NumTypei64:			"i64",
 	NumTypef32:			"f32",
 	NumTypef64:			"f64",
+	VecTypev128:		"v128",
 
 	RefTypeFunction:	"function",
 	RefTypeExtern:		"extern",
@@ -656,7 +1480,7 @@ func readTypeSection(content []byte) (TypeSection, error) {
 	// Type section is encoded as a vector of FunctionType descriptors
 	count, err := readVectorLength(reader)
 	if (err != nil) {
-		return section, err
+		return section, failureAt(reader, err)
 	}
 
 	// Parse the individual function-type descriptors
@@ -664,7 +1488,7 @@ func readTypeSection(content []byte) (TypeSection, error) {
 	for i := uint32(0); i < count; i++ {
 		ftype[i], err = readFunctionType(reader)
 		if (err != nil) {
-			return section, err
+			return section, failureAt(reader, err)
 		}
 	}
 	section.ftype = ftype
@@ -678,6 +1502,23 @@ func (section TypeSection) validate() error {
 	return nil
 }
 
+func (section TypeSection) encode() []byte {
+	// Each FunctionType is introduced by the 0x60 delimiter
+	buffer := appendVectorLength(nil, len(section.ftype))
+	for _, ftype := range section.ftype {
+		buffer = append(buffer, 0x60)
+		buffer = appendVectorLength(buffer, len(ftype.parameter))
+		for _, vtype := range ftype.parameter {
+			buffer = append(buffer, byte(vtype))
+		}
+		buffer = appendVectorLength(buffer, len(ftype.result))
+		for _, vtype := range ftype.result {
+			buffer = append(buffer, byte(vtype))
+		}
+	}
+	return buffer
+}
+
 func (section TypeSection) String() string {
 	var builder strings.Builder
 
@@ -713,6 +1554,10 @@ func (section UnknownSection) validate() error {
 	return nil
 }
 
+func (section UnknownSection) encode() []byte {
+	return section.content
+}
+
 func (section UnknownSection) String() string {
 	// Include the first few bytes of the payload
 	contentLength := len(section.content)
@@ -726,22 +1571,24 @@ func (section UnknownSection) String() string {
 
 
 //
-// Parse and return a single Section from a wasm byte sequence.  Each
-// Section is basically encoded as a TLV structure, so use the leading tag (id)
-// field to determine how to consume the rest of the section.
+// Parse and return a single Section from a wasm byte sequence.  Each Section
+// is basically encoded as a TLV structure, so use the leading tag (id) field
+// to determine how to consume the rest of the section.
 //
-func readSection(reader io.Reader) (Section, error) {
+func readSection(reader *offsetReader, limits DecodeLimits) (Section, error) {
 	var section Section
 
 	// Read the section id.  This id determines the type of section (code,
 	// data, memory, etc)
 	var id uint8
+	offset := reader.offset
 	err := binary.Read(reader, binary.LittleEndian, &id)
 	if (err == io.EOF) {
 		return section, err
 	}
 	if (err != nil) {
-		log.Fatalf("Unable to read section id: %s\n", err)
+		return section, newDecodeError(-1, offset,
+			"unable to read section id", err)
 	}
 
 	// Read the section size. This determines the length of the remaining
@@ -749,30 +1596,66 @@ func readSection(reader io.Reader) (Section, error) {
 	var size uint32
 	size, err = readULEB128(reader)
 	if (err != nil) {
-		log.Fatalf("Unable to read section size: %s\n", err)
+		return section, newDecodeError(int(id), offset,
+			"unable to read section size", err)
+	}
+	err = checkLimit("section size", uint64(size), uint64(limits.MaxSectionSize))
+	if (err == nil) {
+		err = checkLimit("module size", uint64(reader.offset) + uint64(size),
+			uint64(limits.MaxModuleSize))
+	}
+	if (err != nil) {
+		return section, newDecodeError(int(id), offset,
+			"section too large", err)
 	}
 
 	// Read the actual content bytes.  The format will vary depending on the
-	// exact section id
-	content := make([]byte, size)
-	err = binary.Read(reader, binary.LittleEndian, &content)
+	// exact section id.  Buffer incrementally rather than trusting the
+	// declared size up front, so that a truncated module cannot force a large
+	// allocation
+	offset = reader.offset
+	var buffer bytes.Buffer
+	_, err = io.CopyN(&buffer, reader, int64(size))
 	if (err != nil) {
-		log.Fatalf("Unable to read section content: %s\n", err)
+		return section, newDecodeError(int(id), offset,
+			"unable to read section content", err)
 	}
+	content := buffer.Bytes()
 
 	// Delegate the remaining parsing to the Section itself, based on the
 	// Section id above
 	switch(id) {
-		case CodeSectionId:		section, err = readCodeSection(content)
+		case CodeSectionId:		section, err = readCodeSection(content, limits)
 		case CustomSectionId:	section, err = readCustomSection(content)
+		case DataSectionId:		section, err = readDataSection(content)
+		case DataCountSectionId: section, err = readDataCountSection(content)
+		case ElementSectionId:	section, err = readElementSection(content)
 		case ExportSectionId:	section, err = readExportSection(content)
 		case FunctionSectionId:	section, err = readFunctionSection(content)
+		case GlobalSectionId:	section, err = readGlobalSection(content)
+		case ImportSectionId:	section, err = readImportSection(content)
 		case MemorySectionId:	section, err = readMemorySection(content)
+		case StartSectionId:	section, err = readStartSection(content)
 		case TableSectionId:	section, err = readTableSection(content)
 		case TypeSectionId:		section, err = readTypeSection(content)
 
 		default:				section, err = readUnknownSection(id, content)
 	}
+	if (err != nil) {
+		// Locate the failure within the section content, if possible
+		var failure sectionFailure
+		if (errors.As(err, &failure)) {
+			offset += failure.offset
+			err = failure.err
+		}
+		return section, newDecodeError(int(id), offset,
+			"invalid section content", err)
+	}
+	err = checkSectionLimits(section, limits)
+	if (err != nil) {
+		return section, newDecodeError(int(id), offset,
+			"section exceeds decode limits", err)
+	}
 
 	return section, nil
 }
@@ -2,6 +2,7 @@ package wasm
 
 import(
 	"bytes"
+	"errors"
 	"testing"
     )
 
@@ -20,7 +21,7 @@ func TestExportSection(t *testing.T) {
         { "export1",
           []byte{ 1,
 				  7, 'e', 'x', 'p', 'o', 'r', 't', '1', 0, 0 },
-          ExportSection{
+          ExportSection{ export:
 			map[string]Export{
 				"export1": Export{ "export1", 0, 0 },
 			},
@@ -32,7 +33,7 @@ func TestExportSection(t *testing.T) {
           []byte{ 2,
 				  7, 'e', 'x', 'p', 'o', 'r', 't', '1', 0, 0,
                   7, 'e', 'x', 'p', 'o', 'r', 't', '2', 2, 0xF },
-          ExportSection{
+          ExportSection{ export:
 			map[string]Export{
 				"export1": Export{ "export1", 0, 0 },
 				"export2": Export{ "export2", 2, 0xF },
@@ -44,7 +45,7 @@ func TestExportSection(t *testing.T) {
     for _, test := range testCases {
         t.Run(test.name, func(t *testing.T) {
             section, err := readExportSection(test.encoded)
-            if (err != test.status) {
+            if (!errors.Is(err, test.status)) {
                 t.Error("Unexpected decoding status: ", err)
             }
             if (err == nil) {
@@ -99,8 +100,8 @@ func TestCodeSection(t *testing.T) {
 
     for _, test := range testCases {
         t.Run(test.name, func(t *testing.T) {
-            section, err := readCodeSection(test.encoded)
-            if (err != test.status) {
+            section, err := readCodeSection(test.encoded, DefaultDecodeLimits)
+            if (!errors.Is(err, test.status)) {
                 t.Error("Unexpected decoding status: ", err)
             }
             if (err == nil) {
@@ -144,7 +145,7 @@ func TestFunctionSection(t *testing.T) {
     for _, test := range testCases {
         t.Run(test.name, func(t *testing.T) {
             section, err := readFunctionSection(test.encoded)
-            if (err != test.status) {
+            if (!errors.Is(err, test.status)) {
                 t.Error("Unexpected decoding status: ", err)
             }
             if (err == nil) {
@@ -176,7 +177,7 @@ func TestMemorySection(t *testing.T) {
           []byte{ 0x01, 0x00, 0x01 },
           MemorySection{
 			[]Memory{
-				Memory { Limit{ 0x01, 0 } },
+				Memory { Limit{ 0x01, 0, 0x00 } },
 			},
 		  },
           nil },
@@ -184,7 +185,7 @@ func TestMemorySection(t *testing.T) {
           []byte{ 0x01, 0x00, 0x0F },
           MemorySection{
 			[]Memory{
-				Memory { Limit{ 0x0F, 0 } },
+				Memory { Limit{ 0x0F, 0, 0x00 } },
 			},
 		  },
           nil },
@@ -194,7 +195,7 @@ func TestMemorySection(t *testing.T) {
           []byte{ 0x01, 0x01, 0x0A, 0x0B },
           MemorySection{
 			[]Memory{
-				Memory { Limit{ 0x0A, 0x0B } },
+				Memory { Limit{ 0x0A, 0x0B, 0x01 } },
 			},
 		  },
           nil },
@@ -203,7 +204,7 @@ func TestMemorySection(t *testing.T) {
     for _, test := range testCases {
         t.Run(test.name, func(t *testing.T) {
             section, err := readMemorySection(test.encoded)
-            if (err != test.status) {
+            if (!errors.Is(err, test.status)) {
                 t.Error("Unexpected decoding status: ", err)
             }
             if (err == nil) {
@@ -236,7 +237,7 @@ func TestTableSection(t *testing.T) {
           []byte{ 0x01, 0x70, 0x00, 0x01 },
           TableSection{
             []Table{
-                { Limit{ 0x01, 0 }, 0x70 },
+                { Limit{ 0x01, 0, 0x00 }, 0x70 },
             },
           },
           nil },
@@ -246,7 +247,7 @@ func TestTableSection(t *testing.T) {
           []byte{ 0x01, 0x70, 0x01, 0x0A, 0x0B },
           TableSection{
             []Table{
-                { Limit{ 0x0A, 0x0B }, 0x70 },
+                { Limit{ 0x0A, 0x0B, 0x01 }, 0x70 },
             },
           },
           nil },
@@ -256,8 +257,8 @@ func TestTableSection(t *testing.T) {
           []byte{ 0x02, 0x70, 0x00, 0x01, 0x6F, 0x00, 0x02 },
           TableSection{
             []Table{
-                { Limit{ 0x01, 0 }, 0x70 },
-                { Limit{ 0x02, 0 }, 0x6F },
+                { Limit{ 0x01, 0, 0x00 }, 0x70 },
+                { Limit{ 0x02, 0, 0x00 }, 0x6F },
             },
           },
           nil },
@@ -266,7 +267,7 @@ func TestTableSection(t *testing.T) {
     for _, test := range testCases {
         t.Run(test.name, func(t *testing.T) {
             section, err := readTableSection(test.encoded)
-            if (err != test.status) {
+            if (!errors.Is(err, test.status)) {
                 t.Error("Unexpected decoding status: ", err)
             }
             if (err == nil) {
@@ -331,7 +332,7 @@ func TestTypeSection(t *testing.T) {
     for _, test := range testCases {
         t.Run(test.name, func(t *testing.T) {
             section, err := readTypeSection(test.encoded)
-            if (err != test.status) {
+            if (!errors.Is(err, test.status)) {
                 t.Error("Unexpected decoding status: ", err)
             }
             if (err == nil) {
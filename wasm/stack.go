@@ -24,7 +24,7 @@ func (stack Stack) IsEmpty() bool {
 // Peek at a specific item without modifying the stack.  No side effects.
 // Useful for reading local variables (e.g., "local.get 0" instruction)
 func (stack Stack) Peek(index int) (interface{}, error) {
-	if (index < stack.top) {
+	if (index >= 0 && index < stack.top) {
 		return stack.data[index], nil
 	} else {
 		return nil, StackUnderflow
@@ -46,8 +46,13 @@ func (stack *Stack) Pop() (interface{}, error) {
 	}
 }
 
+// Push a new item.  The stack grows as necessary, beyond its initial capacity
 func (stack *Stack) Push(value interface{}) {
-	stack.data[stack.top] = value
+	if (stack.top == len(stack.data)) {
+		stack.data = append(stack.data, value)
+	} else {
+		stack.data[stack.top] = value
+	}
 	stack.top++
 }
 
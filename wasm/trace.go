@@ -0,0 +1,132 @@
+package wasm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+
+//
+// Execution tracing.  Logs each executed instruction: the thread, function,
+// offset, instruction (mnemonic + immediates) and the resulting change in
+// depth of the operand stack, plus the value on top of the stack.  Traces are
+// either plain text, or JSON Lines (one object per instruction), e.g. for
+// comparison against the traces of other engines
+//
+type TraceFormat int
+
+const (
+	TraceText	TraceFormat = iota
+	TraceJSON
+)
+
+type Tracer struct {
+	writer		io.Writer
+	format		TraceFormat
+	functions	map[uint32]bool	// Traced functions, or all if empty
+	maxLines	uint64			// Upper bound on trace lines, if nonzero
+
+	lines		uint64
+	truncated	bool
+	err			error			// First write error, if any
+}
+
+// A single trace line, in JSON form
+type TraceRecord struct {
+	Thread		uint64	`json:"thread"`
+	Function	uint32	`json:"function"`
+	Name		string	`json:"name"`
+	Offset		int		`json:"offset"`
+	Instruction	string	`json:"instruction"`
+	Delta		int		`json:"delta"`		// Change in stack depth
+	Depth		int		`json:"depth"`		// Stack depth afterwards
+	Top			string	`json:"top,omitempty"`	// Top of stack afterwards
+}
+
+//
+// Factory function for generating a Tracer that writes to the given output.
+// No side effects.
+//
+func CreateTracer(writer io.Writer, format TraceFormat) *Tracer {
+	return &Tracer{ writer: writer, format: format,
+		functions: make(map[uint32]bool) }
+}
+
+// Only trace the given function(s), rather than all functions
+func (tracer *Tracer) Filter(function uint32) {
+	tracer.functions[function] = true
+}
+
+// Stop tracing after the given number of lines, if nonzero
+func (tracer *Tracer) SetMaxLines(lines uint64) {
+	tracer.maxLines = lines
+}
+
+// Number of lines written.  No side effects.
+func (tracer *Tracer) Lines() uint64 {
+	return tracer.lines
+}
+
+// Whether any lines were dropped due to the line limit.  No side effects.
+func (tracer *Tracer) Truncated() bool {
+	return tracer.truncated
+}
+
+// First error while writing the trace, if any.  No side effects.
+func (tracer *Tracer) Err() error {
+	return tracer.err
+}
+
+//
+// Called by the interpreter after executing each instruction, given the
+// instruction + the stack depth beforehand
+//
+func (tracer *Tracer) trace(thread *WASMInterpreterThread, function uint32,
+	instr DecodedInstruction, depth int, names NameSection) {
+	if (tracer.err != nil ||
+		(len(tracer.functions) > 0 && !tracer.functions[function])) {
+		return
+	}
+	if (tracer.maxLines > 0 && tracer.lines >= tracer.maxLines) {
+		tracer.truncated = true
+		return
+	}
+
+	record := TraceRecord{
+		Thread:			thread.id,
+		Function:		function,
+		Name:			names.Function(function),
+		Offset:			instr.Offset,
+		Instruction:	formatInstruction(instr, names, function),
+		Depth:			thread.dataStack.Top() + 1,
+	}
+	record.Delta = record.Depth - depth
+	top, err := thread.dataStack.Peek(thread.dataStack.Top())
+	if (err == nil) {
+		record.Top = FormatValue(top)
+	}
+
+	if (tracer.format == TraceJSON) {
+		var encoded []byte
+		encoded, err = json.Marshal(record)
+		if (err == nil) {
+			_, err = tracer.writer.Write(append(encoded, '\n'))
+		}
+	} else {
+		_, err = fmt.Fprintf(tracer.writer, "%d %s+%#x: %-24s %+d (%d) %s\n",
+			record.Thread, record.Name, record.Offset, record.Instruction,
+			record.Delta, record.Depth, record.Top)
+	}
+	tracer.err = err
+	tracer.lines++
+}
+
+
+// Source of unique thread ids, e.g. for distinguishing threads in traces
+var threadIds uint64
+
+func nextThreadId() uint64 {
+	return atomic.AddUint64(&threadIds, 1)
+}
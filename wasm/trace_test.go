@@ -0,0 +1,74 @@
+package wasm
+
+import(
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	)
+
+
+//
+// Test tracing of each instruction, in both text + JSON formats
+//
+func TestTracer(t *testing.T) {
+	module, err := ReadWATWithOptions(strings.NewReader(`(module
+		(func $add (export "add") (param i32 i32) (result i32)
+			local.get 0 local.get 1 i32.add)
+		(func $nop (export "nop") nop))`),
+		WATOptions{ DebugNames: true })
+	if (err != nil) {
+		t.Fatal("Unexpected assembly error: ", err)
+	}
+	args := []interface{}{ int32(3), int32(4) }
+
+	var output bytes.Buffer
+	tracer := CreateTracer(&output, TraceText)
+	_, err = WASMInterpreter{}.Invoke(module, "add", args,
+		VMConfig{ Tracer: tracer })
+	if (err != nil) {
+		t.Fatal("Unexpected VM status: ", err)
+	}
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if (len(lines) != 4 || tracer.Lines() != 4 ||
+		!strings.Contains(lines[2], "$add+0x4: i32.add") ||
+		!strings.HasSuffix(lines[2], "-1 (3) 7")) {
+		t.Errorf("Unexpected trace:\n%s", output.String())
+	}
+
+	// JSON Lines, limited to the first two instructions
+	output.Reset()
+	tracer = CreateTracer(&output, TraceJSON)
+	tracer.SetMaxLines(2)
+	_, err = WASMInterpreter{}.Invoke(module, "add", args,
+		VMConfig{ Tracer: tracer })
+	if (err != nil) {
+		t.Fatal("Unexpected VM status: ", err)
+	}
+	var records []TraceRecord
+	for _, line := range strings.Split(strings.TrimSpace(output.String()),
+		"\n") {
+		var record TraceRecord
+		err = json.Unmarshal([]byte(line), &record)
+		if (err != nil) {
+			t.Fatal("Unexpected trace line: ", line)
+		}
+		records = append(records, record)
+	}
+	if (len(records) != 2 || !tracer.Truncated() ||
+		records[1].Instruction != "local.get 1" || records[1].Delta != 1 ||
+		records[1].Depth != 4 || records[1].Top != "4" ||
+		records[1].Name != "$add" || records[1].Offset != 2) {
+		t.Errorf("Unexpected trace:\n%s", output.String())
+	}
+
+	// Only the filtered function is traced
+	output.Reset()
+	tracer = CreateTracer(&output, TraceText)
+	tracer.Filter(1)
+	WASMInterpreter{}.Invoke(module, "add", args, VMConfig{ Tracer: tracer })
+	WASMInterpreter{}.Invoke(module, "nop", nil, VMConfig{ Tracer: tracer })
+	if (tracer.Lines() != 2 || strings.Contains(output.String(), "$add")) {
+		t.Errorf("Unexpected trace:\n%s", output.String())
+	}
+}
@@ -0,0 +1,1345 @@
+package wasm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+
+// Validation error due to an ill-typed or malformed function body
+var InvalidFunction = errors.New("Invalid function")
+
+
+//
+// Detailed validation error for a single function body.  Identifies the
+// function (by its index in the function index space, including imports) and
+// the byte offset of the offending instruction within the function body
+//
+type ValidationError struct {
+	Function	uint32
+	Offset		int
+	Reason		string
+	Name		string	// Function name, e.g. "$fac", if known
+}
+
+func (e ValidationError) Error() string {
+	if (e.Name != "") {
+		return fmt.Sprintf("function %d (%s), offset %#x: %s",
+			e.Function, e.Name, e.Offset, e.Reason)
+	}
+	return fmt.Sprintf("function %d, offset %#x: %s",
+		e.Function, e.Offset, e.Reason)
+}
+
+func (e ValidationError) Unwrap() error {
+	return InvalidFunction
+}
+
+
+//
+// Validation context.  Summarizes the index spaces (types, functions, tables,
+// etc) of a single module, for validating references from function bodies.
+// See section 3.1.1 of WASM spec
+//
+type moduleContext struct {
+	types		[]FunctionType
+	functions	[]uint32		// Type index of each function, imports first
+	imported	int				// Number of imported functions
+	importedGlobals int			// Number of imported globals
+	tables		[]Table
+	memories	[]Memory
+	globals		[]GlobalType
+	elements	[]ValueType		// Reference type of each element segment
+	dataCount	uint32			// Number of data segments, if declared
+	hasDataCount bool
+	refs		map[uint32]bool	// Functions declared as referenceable
+}
+
+// Factory function for collecting the validation context of a module.  No
+// side effects.
+func newModuleContext(module Module) moduleContext {
+	context := moduleContext{ refs: make(map[uint32]bool) }
+
+	if section, ok := module.section(TypeSectionId).(TypeSection); ok {
+		context.types = section.ftype
+	}
+
+	// Imported resources always precede the module-defined resources in each
+	// index space
+	if section, ok := module.section(ImportSectionId).(ImportSection); ok {
+		for _, imp := range section.imports {
+			switch(imp.itype) {
+				case ExportTypeFunction:
+					context.functions = append(context.functions, imp.function)
+					context.imported++
+				case ExportTypeTable:
+					context.tables = append(context.tables, imp.table)
+				case ExportTypeMemory:
+					context.memories = append(context.memories, imp.memory)
+				case ExportTypeGlobal:
+					context.globals = append(context.globals, imp.global)
+					context.importedGlobals++
+			}
+		}
+	}
+
+	if section, ok := module.section(FunctionSectionId).(FunctionSection); ok {
+		context.functions = append(context.functions, section.function...)
+	}
+	if section, ok := module.section(TableSectionId).(TableSection); ok {
+		context.tables = append(context.tables, section.table...)
+	}
+	if section, ok := module.section(MemorySectionId).(MemorySection); ok {
+		context.memories = append(context.memories, section.memory...)
+	}
+	if section, ok := module.section(GlobalSectionId).(GlobalSection); ok {
+		for _, global := range section.global {
+			context.globals = append(context.globals, global.gtype)
+			context.addReferences(global.init)
+		}
+	}
+
+	// Element segments declare both their reference types and any functions
+	// that are referenceable via ref.func
+	if section, ok := module.section(ElementSectionId).(ElementSection); ok {
+		for _, element := range section.element {
+			context.elements = append(context.elements, element.reftype)
+			for _, index := range element.function {
+				context.refs[index] = true
+			}
+			for _, init := range element.init {
+				context.addReferences(init)
+			}
+		}
+	}
+
+	if section, ok := module.section(DataCountSectionId).(DataCountSection); ok {
+		context.dataCount = section.count
+		context.hasDataCount = true
+	}
+
+	// Any exported function is implicitly referenceable via ref.func
+	if section, ok := module.section(ExportSectionId).(ExportSection); ok {
+		for _, export := range section.export {
+			if (export.etype == ExportTypeFunction) {
+				context.refs[export.index] = true
+			}
+		}
+	}
+
+	return context
+}
+
+// Record any functions referenced by "ref.func" in a constant expression
+func (context *moduleContext) addReferences(expr []byte) {
+	instructions, _ := DecodeInstructions(expr)
+	for _, instr := range instructions {
+		if (instr.Opcode == 0xD2) {
+			context.refs[instr.Index()] = true
+		}
+	}
+}
+
+// Return the type of function at the given index, if any
+func (context moduleContext) functionType(index uint32) (FunctionType, error) {
+	if (int(index) >= len(context.functions)) {
+		return FunctionType{}, fmt.Errorf("unknown function %d", index)
+	}
+	return context.typeAt(int64(context.functions[index]))
+}
+
+// Return the function type at the given type index, if any
+func (context moduleContext) typeAt(index int64) (FunctionType, error) {
+	if (index < 0 || index >= int64(len(context.types))) {
+		return FunctionType{}, fmt.Errorf("unknown type %d", index)
+	}
+	return context.types[index], nil
+}
+
+
+//
+// Cross-section validation of the module: section ordering, index spaces,
+// exports, segments, constant expressions, etc.  See section 3.4 of the WASM
+// spec.  Function bodies are validated separately.  No side effects.
+//
+func validateModule(module Module) error {
+	err := validateSectionOrder(module.sections)
+	if (err != nil) {
+		return err
+	}
+
+	context := newModuleContext(module)
+
+	// Function types only reference valid value types
+	for i, ftype := range context.types {
+		for _, vtype := range append(append(ResultType{}, ftype.parameter...),
+			ftype.result...) {
+			if (!isValueType(vtype)) {
+				return moduleError("type %d: invalid value type %#x", i, vtype)
+			}
+		}
+	}
+
+	// Every function declaration references a valid type, and has a
+	// corresponding body
+	for i, tindex := range context.functions {
+		_, err := context.typeAt(int64(tindex))
+		if (err != nil) {
+			return moduleError("function %d: %s", i, err)
+		}
+	}
+	functionCount := len(context.functions) - context.imported
+	codeCount := 0
+	if section, ok := module.section(CodeSectionId).(CodeSection); ok {
+		codeCount = len(section.function)
+	}
+	if (functionCount != codeCount) {
+		return moduleError("function and code section counts differ (%d vs %d)",
+			functionCount, codeCount)
+	}
+
+	// Tables, memories and globals
+	for i, table := range context.tables {
+		if (!isRefType(ValueType(table.reftype))) {
+			return moduleError("table %d: invalid reference type %#x",
+				i, table.reftype)
+		}
+	}
+	if (len(context.memories) > 1) {
+		// At most 1 memory is allowed.  See section 2.5.5 of WASM spec 1.1
+		return moduleError("multiple memories")
+	}
+	for i, gtype := range context.globals {
+		if (!isValueType(gtype.vtype)) {
+			return moduleError("global %d: invalid value type %#x",
+				i, gtype.vtype)
+		}
+	}
+	if section, ok := module.section(GlobalSectionId).(GlobalSection); ok {
+		for i, global := range section.global {
+			err := context.validateConstantExpression(global.init,
+				global.gtype.vtype)
+			if (err != nil) {
+				return moduleError("global %d: %s",
+					context.importedGlobals + i, err)
+			}
+		}
+	}
+
+	// Export names are unique, and reference valid resources
+	if section, ok := module.section(ExportSectionId).(ExportSection); ok {
+		names := make(map[string]bool)
+		for _, export := range section.list {
+			if (names[export.name]) {
+				return moduleError("duplicate export name '%s'", export.name)
+			}
+			names[export.name] = true
+
+			err := context.validateExport(export)
+			if (err != nil) {
+				return moduleError("export '%s': %s", export.name, err)
+			}
+		}
+	}
+
+	// Start function, if any, takes no parameters + returns no results
+	if section, ok := module.section(StartSectionId).(StartSection); ok {
+		ftype, err := context.functionType(section.function)
+		if (err != nil) {
+			return moduleError("start function: %s", err)
+		}
+		if (len(ftype.parameter) > 0 || len(ftype.result) > 0) {
+			return moduleError("start function must have type [] => []")
+		}
+	}
+
+	// Element segments
+	if section, ok := module.section(ElementSectionId).(ElementSection); ok {
+		for i, element := range section.element {
+			err := context.validateElement(element)
+			if (err != nil) {
+				return moduleError("element %d: %s", i, err)
+			}
+		}
+	}
+
+	// Data segments, and the data count, if declared
+	dataCount := 0
+	if section, ok := module.section(DataSectionId).(DataSection); ok {
+		dataCount = len(section.data)
+		for i, data := range section.data {
+			err := context.validateData(data)
+			if (err != nil) {
+				return moduleError("data %d: %s", i, err)
+			}
+		}
+	}
+	if (context.hasDataCount && int(context.dataCount) != dataCount) {
+		return moduleError("data count and data section differ (%d vs %d)",
+			context.dataCount, dataCount)
+	}
+
+	return nil
+}
+
+// Generate a module-level validation error
+func moduleError(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", InvalidModule, fmt.Sprintf(format, args...))
+}
+
+// Required position of each known section.  Custom sections may appear
+// anywhere.  See section 5.5.2 of WASM spec
+var sectionOrder = map[uint8]int {
+	TypeSectionId:		1,
+	ImportSectionId:	2,
+	FunctionSectionId:	3,
+	TableSectionId:		4,
+	MemorySectionId:	5,
+	GlobalSectionId:	6,
+	ExportSectionId:	7,
+	StartSectionId:		8,
+	ElementSectionId:	9,
+	DataCountSectionId:	10,
+	CodeSectionId:		11,
+	DataSectionId:		12,
+}
+
+//
+// Each non-custom section occurs at most once, in the prescribed order.
+// Custom sections may appear anywhere
+//
+func validateSectionOrder(sections []Section) error {
+	previous := 0
+	for i, section := range sections {
+		id := sectionId(section)
+		if (id == CustomSectionId) {
+			continue
+		}
+		position, ok := sectionOrder[id]
+		if !ok {
+			return moduleError("section %d: unknown section id %#x", i, id)
+		}
+		if (position == previous) {
+			return moduleError("section %d: duplicate section id %#x", i, id)
+		}
+		if (position < previous) {
+			return moduleError("section %d: unexpected section id %#x, out of order",
+				i, id)
+		}
+		previous = position
+	}
+	return nil
+}
+
+// Validate a single export descriptor against the index spaces
+func (context moduleContext) validateExport(export Export) error {
+	var count int
+	switch(export.etype) {
+		case ExportTypeFunction:	count = len(context.functions)
+		case ExportTypeTable:		count = len(context.tables)
+		case ExportTypeMemory:		count = len(context.memories)
+		case ExportTypeGlobal:		count = len(context.globals)
+		default:
+			return fmt.Errorf("invalid export type %#x", export.etype)
+	}
+	if (int(export.index) >= count) {
+		return fmt.Errorf("unknown %s %d", ExportTypeMap[ int(export.etype) ],
+			export.index)
+	}
+	return nil
+}
+
+// Validate a single element segment
+func (context moduleContext) validateElement(element Element) error {
+	if (!isRefType(element.reftype)) {
+		return fmt.Errorf("invalid reference type %#x", element.reftype)
+	}
+
+	if (element.mode == SegmentModeActive) {
+		if (int(element.table) >= len(context.tables)) {
+			return fmt.Errorf("unknown table %d", element.table)
+		}
+		table := context.tables[element.table]
+		if (ValueType(table.reftype) != element.reftype) {
+			return errors.New("type mismatch: element segment and table")
+		}
+		err := context.validateConstantExpression(element.offset, NumTypei32)
+		if (err != nil) {
+			return err
+		}
+	}
+
+	for _, index := range element.function {
+		_, err := context.functionType(index)
+		if (err != nil) {
+			return err
+		}
+	}
+	for _, init := range element.init {
+		err := context.validateConstantExpression(init, element.reftype)
+		if (err != nil) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Validate a single data segment
+func (context moduleContext) validateData(data Data) error {
+	if (data.mode != SegmentModeActive) {
+		return nil
+	}
+	if (int(data.memory) >= len(context.memories)) {
+		return fmt.Errorf("unknown memory %d", data.memory)
+	}
+	return context.validateConstantExpression(data.offset, NumTypei32)
+}
+
+// Type-check a constant expression, which must produce a single value of the
+// expected type.  See section 3.3.10 of WASM spec
+func (context moduleContext) validateConstantExpression(expr []byte,
+	expected ValueType) error {
+	instructions, err := decodeConstantExpression(expr)
+	if (err != nil) {
+		return err
+	}
+	stack := make([]ValueType, 0)
+
+	for _, instr := range instructions {
+		switch(instr.Opcode) {
+			case 0x41:	stack = append(stack, NumTypei32)	// i32.const
+			case 0x42:	stack = append(stack, NumTypei64)	// i64.const
+			case 0x43:	stack = append(stack, NumTypef32)	// f32.const
+			case 0x44:	stack = append(stack, NumTypef64)	// f64.const
+
+			case 0x23:	// global.get: only imported, immutable globals
+				index := instr.Index()
+				if (int(index) >= context.importedGlobals) {
+					return fmt.Errorf("unknown global %d", index)
+				}
+				if (context.globals[index].mutable) {
+					return errors.New("constant expression required")
+				}
+				stack = append(stack, context.globals[index].vtype)
+
+			case 0xD0:	// ref.null
+				rtype := instr.Types[0]
+				if (!isRefType(rtype)) {
+					return fmt.Errorf("invalid reference type %#x", uint8(rtype))
+				}
+				stack = append(stack, rtype)
+
+			case 0xD2:	// ref.func
+				_, err := context.functionType(instr.Index())
+				if (err != nil) {
+					return err
+				}
+				stack = append(stack, RefTypeFunction)
+
+			default:
+				return errors.New("constant expression required")
+		}
+	}
+
+	if (len(stack) != 1 || stack[0] != expected) {
+		return fmt.Errorf("type mismatch: constant expression, expected %s",
+			typeName(expected))
+	}
+	return nil
+}
+
+
+//
+// Validate all function bodies in the module.  See section 3.3 of the WASM
+// spec, and the validation algorithm in appendix 7.3.  No side effects.
+//
+func validateCode(module Module) error {
+	codeSection, ok := module.section(CodeSectionId).(CodeSection)
+	if !ok {
+		// No function bodies
+		return nil
+	}
+
+	context := newModuleContext(module)
+	names := module.Names()
+	for i, function := range codeSection.function {
+		index := uint32(context.imported + i)
+		ftype, err := context.functionType(index)
+		if (err != nil) {
+			return ValidationError{ index, 0, err.Error(), names.Function(index) }
+		}
+
+		err = validateFunction(&context, index, ftype, function)
+		if verr, ok := err.(ValidationError); ok {
+			// Identify the function by name in any diagnostics
+			verr.Name = names.Function(index)
+			return verr
+		} else if (err != nil) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+
+// Placeholder type for operands of unknown type, in unreachable code.  Never
+// a legal value type
+const unknownType ValueType = 0
+
+// Block type delimiter for blocks with no parameters and no results
+const emptyBlockType = 0x40
+
+// Frame on the control stack: one for each block, loop, if, etc
+type controlFrame struct {
+	opcode		uint8
+	start		ResultType	// Block parameters
+	end			ResultType	// Block results
+	height		int			// Operand stack height on entry
+	unreachable	bool		// Remainder of block is unreachable
+}
+
+// Type-checking state for a single function body
+type codeValidator struct {
+	context		*moduleContext
+	locals		[]ValueType
+	operands	[]ValueType
+	controls	[]controlFrame
+}
+
+// Validate a single function body against its declared type
+func validateFunction(context *moduleContext, index uint32, ftype FunctionType,
+	function Function) error {
+	validator := codeValidator{ context: context }
+	reader := bytes.NewReader(function.body)
+
+	// Parameters are the leading locals
+	validator.locals = append(validator.locals, ftype.parameter...)
+	validator.locals = append(validator.locals, function.local...)
+	for _, vtype := range function.local {
+		if (!isValueType(vtype)) {
+			return ValidationError{ index, 0,
+				fmt.Sprintf("invalid local type %#x", uint8(vtype)), "" }
+		}
+	}
+
+	// The function body is an implicit block, with the function results
+	validator.pushControl(0x02, ResultType{}, ftype.result)
+
+	for len(validator.controls) > 0 {
+		offset := len(function.body) - reader.Len()
+		if (reader.Len() == 0) {
+			return ValidationError{ index, offset, "missing end of function", "" }
+		}
+		instr, err := readInstruction(reader)
+		if (err != nil) {
+			return ValidationError{ index, offset, err.Error(), "" }
+		}
+		err = validator.validateInstruction(instr)
+		if (err != nil) {
+			return ValidationError{ index, offset, err.Error(), "" }
+		}
+	}
+
+	if (reader.Len() > 0) {
+		offset := len(function.body) - reader.Len()
+		return ValidationError{ index, offset, "unexpected bytes after end",
+			"" }
+	}
+
+	return nil
+}
+
+
+//
+// Operand + control stack manipulation.  See appendix 7.3
+//
+
+func (v *codeValidator) pushOperand(vtype ValueType) {
+	v.operands = append(v.operands, vtype)
+}
+
+func (v *codeValidator) pushOperands(types ResultType) {
+	for _, vtype := range types {
+		v.pushOperand(vtype)
+	}
+}
+
+func (v *codeValidator) popOperand() (ValueType, error) {
+	frame := v.controls[len(v.controls) - 1]
+	if (len(v.operands) == frame.height) {
+		if (frame.unreachable) {
+			return unknownType, nil
+		}
+		return unknownType, errors.New("type mismatch: operand stack underflow")
+	}
+
+	vtype := v.operands[len(v.operands) - 1]
+	v.operands = v.operands[:len(v.operands) - 1]
+	return vtype, nil
+}
+
+func (v *codeValidator) popExpected(expected ValueType) (ValueType, error) {
+	actual, err := v.popOperand()
+	if (err != nil) {
+		return actual, err
+	}
+	if (actual == unknownType) {
+		return expected, nil
+	}
+	if (expected != unknownType && actual != expected) {
+		return actual, fmt.Errorf("type mismatch: expected %s, found %s",
+			typeName(expected), typeName(actual))
+	}
+	return actual, nil
+}
+
+func (v *codeValidator) popOperands(types ResultType) (ResultType, error) {
+	popped := make(ResultType, len(types))
+	for i := len(types) - 1; i >= 0; i-- {
+		vtype, err := v.popExpected(types[i])
+		if (err != nil) {
+			return popped, err
+		}
+		popped[i] = vtype
+	}
+	return popped, nil
+}
+
+func (v *codeValidator) pushControl(opcode uint8, start, end ResultType) {
+	frame := controlFrame{
+		opcode: opcode,
+		start:  start,
+		end:    end,
+		height: len(v.operands),
+	}
+	v.controls = append(v.controls, frame)
+	v.pushOperands(start)
+}
+
+func (v *codeValidator) popControl() (controlFrame, error) {
+	frame := v.controls[len(v.controls) - 1]
+	_, err := v.popOperands(frame.end)
+	if (err != nil) {
+		return frame, err
+	}
+	if (len(v.operands) != frame.height) {
+		return frame, errors.New("type mismatch: values remaining on stack at end of block")
+	}
+	v.controls = v.controls[:len(v.controls) - 1]
+	return frame, nil
+}
+
+// Types expected by a branch to the given frame
+func (frame controlFrame) labelTypes() ResultType {
+	if (frame.opcode == 0x03) {
+		// Branch to a loop restarts the loop
+		return frame.start
+	}
+	return frame.end
+}
+
+// Remainder of the current block is unreachable (e.g., after br, return)
+func (v *codeValidator) setUnreachable() {
+	frame := &v.controls[len(v.controls) - 1]
+	v.operands = v.operands[:frame.height]
+	frame.unreachable = true
+}
+
+// Locate the control frame targeted by a branch to the given label
+func (v *codeValidator) label(depth uint32) (controlFrame, error) {
+	if (int(depth) >= len(v.controls)) {
+		return controlFrame{}, fmt.Errorf("unknown label %d", depth)
+	}
+	return v.controls[len(v.controls) - 1 - int(depth)], nil
+}
+
+
+//
+// Immediate operands.  Each resolves an immediate against the module context
+//
+
+func (v *codeValidator) local(index uint32) (ValueType, error) {
+	if (int(index) >= len(v.locals)) {
+		return unknownType, fmt.Errorf("unknown local %d", index)
+	}
+	return v.locals[index], nil
+}
+
+func (v *codeValidator) global(index uint32) (GlobalType, error) {
+	if (int(index) >= len(v.context.globals)) {
+		return GlobalType{}, fmt.Errorf("unknown global %d", index)
+	}
+	return v.context.globals[index], nil
+}
+
+func (v *codeValidator) table(index uint32) (Table, error) {
+	if (int(index) >= len(v.context.tables)) {
+		return Table{}, fmt.Errorf("unknown table %d", index)
+	}
+	return v.context.tables[index], nil
+}
+
+func (v *codeValidator) element(index uint32) (ValueType, error) {
+	if (int(index) >= len(v.context.elements)) {
+		return unknownType, fmt.Errorf("unknown element segment %d", index)
+	}
+	return v.context.elements[index], nil
+}
+
+func (v *codeValidator) data(index uint32) error {
+	if (!v.context.hasDataCount) {
+		return errors.New("data count section required")
+	}
+	if (index >= v.context.dataCount) {
+		return fmt.Errorf("unknown data segment %d", index)
+	}
+	return nil
+}
+
+// Memory instructions carry a reserved memory index, always zero in WASM 1.x
+func (v *codeValidator) memory(indices []uint32) error {
+	for _, index := range indices {
+		if (index != 0) {
+			return errors.New("zero byte expected")
+		}
+	}
+	return v.checkMemory()
+}
+
+func (v *codeValidator) checkMemory() error {
+	if (len(v.context.memories) == 0) {
+		return errors.New("unknown memory 0")
+	}
+	return nil
+}
+
+// Memory argument: alignment (log2) + offset.  Alignment may not exceed the
+// natural alignment of the access, in bytes
+func (v *codeValidator) memarg(memarg Memarg, width uint32) error {
+	if (memarg.Align >= 32 || (uint32(1) << memarg.Align) > width) {
+		return errors.New("alignment must not be larger than natural")
+	}
+	return v.checkMemory()
+}
+
+// Block type: empty, a single value type, or an index into the type section
+func (v *codeValidator) blockType(btype BlockType) (FunctionType, error) {
+	if (btype.Empty()) {
+		return FunctionType{ ResultType{}, ResultType{} }, nil
+	}
+	if (btype.Index < 0) {
+		if (!isValueType(btype.Result)) {
+			return FunctionType{}, fmt.Errorf("invalid block type %#x",
+				uint8(btype.Result))
+		}
+		return FunctionType{ ResultType{}, ResultType{ btype.Result } }, nil
+	}
+	return v.context.typeAt(btype.Index)
+}
+
+func (v *codeValidator) valueType(vtype ValueType) (ValueType, error) {
+	if (!isValueType(vtype)) {
+		return unknownType, fmt.Errorf("invalid value type %#x", uint8(vtype))
+	}
+	return vtype, nil
+}
+
+func (v *codeValidator) refType(vtype ValueType) (ValueType, error) {
+	if (!isRefType(vtype)) {
+		return unknownType, fmt.Errorf("invalid reference type %#x", uint8(vtype))
+	}
+	return vtype, nil
+}
+
+
+//
+// Instruction typing
+//
+
+// Pop the parameters and push the results of a simple instruction
+func (v *codeValidator) apply(parameter ResultType, result ResultType) error {
+	_, err := v.popOperands(parameter)
+	if (err != nil) {
+		return err
+	}
+	v.pushOperands(result)
+	return nil
+}
+
+// Shorthand for unary, binary, etc operators
+func (v *codeValidator) unary(in, out ValueType) error {
+	return v.apply(ResultType{ in }, ResultType{ out })
+}
+
+func (v *codeValidator) binary(in, out ValueType) error {
+	return v.apply(ResultType{ in, in }, ResultType{ out })
+}
+
+// Memory loads + stores: (opcode) => (value type, natural width in bytes)
+var memoryAccess = map[uint8]struct{ vtype ValueType; width uint32 } {
+	0x28: { NumTypei32, 4 },	0x29: { NumTypei64, 8 },
+	0x2A: { NumTypef32, 4 },	0x2B: { NumTypef64, 8 },
+	0x2C: { NumTypei32, 1 },	0x2D: { NumTypei32, 1 },
+	0x2E: { NumTypei32, 2 },	0x2F: { NumTypei32, 2 },
+	0x30: { NumTypei64, 1 },	0x31: { NumTypei64, 1 },
+	0x32: { NumTypei64, 2 },	0x33: { NumTypei64, 2 },
+	0x34: { NumTypei64, 4 },	0x35: { NumTypei64, 4 },
+
+	0x36: { NumTypei32, 4 },	0x37: { NumTypei64, 8 },
+	0x38: { NumTypef32, 4 },	0x39: { NumTypef64, 8 },
+	0x3A: { NumTypei32, 1 },	0x3B: { NumTypei32, 2 },
+	0x3C: { NumTypei64, 1 },	0x3D: { NumTypei64, 2 },
+	0x3E: { NumTypei64, 4 },
+}
+
+// Conversion operators: (opcode) => (operand type, result type)
+var conversion = map[uint8][2]ValueType {
+	0xA7: { NumTypei64, NumTypei32 },
+	0xA8: { NumTypef32, NumTypei32 },	0xA9: { NumTypef32, NumTypei32 },
+	0xAA: { NumTypef64, NumTypei32 },	0xAB: { NumTypef64, NumTypei32 },
+	0xAC: { NumTypei32, NumTypei64 },	0xAD: { NumTypei32, NumTypei64 },
+	0xAE: { NumTypef32, NumTypei64 },	0xAF: { NumTypef32, NumTypei64 },
+	0xB0: { NumTypef64, NumTypei64 },	0xB1: { NumTypef64, NumTypei64 },
+	0xB2: { NumTypei32, NumTypef32 },	0xB3: { NumTypei32, NumTypef32 },
+	0xB4: { NumTypei64, NumTypef32 },	0xB5: { NumTypei64, NumTypef32 },
+	0xB6: { NumTypef64, NumTypef32 },
+	0xB7: { NumTypei32, NumTypef64 },	0xB8: { NumTypei32, NumTypef64 },
+	0xB9: { NumTypei64, NumTypef64 },	0xBA: { NumTypei64, NumTypef64 },
+	0xBB: { NumTypef32, NumTypef64 },
+	0xBC: { NumTypef32, NumTypei32 },	0xBD: { NumTypef64, NumTypei64 },
+	0xBE: { NumTypei32, NumTypef32 },	0xBF: { NumTypei64, NumTypef64 },
+	0xC0: { NumTypei32, NumTypei32 },	0xC1: { NumTypei32, NumTypei32 },
+	0xC2: { NumTypei64, NumTypei64 },	0xC3: { NumTypei64, NumTypei64 },
+	0xC4: { NumTypei64, NumTypei64 },
+}
+
+// Type-check a single decoded instruction, including its immediates
+func (v *codeValidator) validateInstruction(instr DecodedInstruction) error {
+	opcode := instr.Opcode
+
+	// Memory loads + stores
+	if access, ok := memoryAccess[opcode]; ok {
+		err := v.memarg(instr.Memarg, access.width)
+		if (err != nil) {
+			return err
+		}
+		if (opcode <= 0x35) {
+			return v.unary(NumTypei32, access.vtype)
+		}
+		return v.apply(ResultType{ NumTypei32, access.vtype }, ResultType{})
+	}
+
+	// Conversions
+	if types, ok := conversion[opcode]; ok {
+		return v.unary(types[0], types[1])
+	}
+
+	// Numeric comparisons + arithmetic
+	switch {
+		case opcode == 0x45:					return v.unary(NumTypei32, NumTypei32)
+		case opcode >= 0x46 && opcode <= 0x4F:	return v.binary(NumTypei32, NumTypei32)
+		case opcode == 0x50:					return v.unary(NumTypei64, NumTypei32)
+		case opcode >= 0x51 && opcode <= 0x5A:	return v.binary(NumTypei64, NumTypei32)
+		case opcode >= 0x5B && opcode <= 0x60:	return v.binary(NumTypef32, NumTypei32)
+		case opcode >= 0x61 && opcode <= 0x66:	return v.binary(NumTypef64, NumTypei32)
+		case opcode >= 0x67 && opcode <= 0x69:	return v.unary(NumTypei32, NumTypei32)
+		case opcode >= 0x6A && opcode <= 0x78:	return v.binary(NumTypei32, NumTypei32)
+		case opcode >= 0x79 && opcode <= 0x7B:	return v.unary(NumTypei64, NumTypei64)
+		case opcode >= 0x7C && opcode <= 0x8A:	return v.binary(NumTypei64, NumTypei64)
+		case opcode >= 0x8B && opcode <= 0x91:	return v.unary(NumTypef32, NumTypef32)
+		case opcode >= 0x92 && opcode <= 0x98:	return v.binary(NumTypef32, NumTypef32)
+		case opcode >= 0x99 && opcode <= 0x9F:	return v.unary(NumTypef64, NumTypef64)
+		case opcode >= 0xA0 && opcode <= 0xA6:	return v.binary(NumTypef64, NumTypef64)
+	}
+
+	switch(opcode) {
+		//
+		// Control instructions
+		//
+		case 0x00:	// unreachable
+			v.setUnreachable()
+			return nil
+
+		case 0x01:	// nop
+			return nil
+
+		case 0x02, 0x03:	// block, loop
+			btype, err := v.blockType(instr.BlockType)
+			if (err != nil) {
+				return err
+			}
+			_, err = v.popOperands(btype.parameter)
+			if (err != nil) {
+				return err
+			}
+			v.pushControl(opcode, btype.parameter, btype.result)
+			return nil
+
+		case 0x04:	// if
+			btype, err := v.blockType(instr.BlockType)
+			if (err != nil) {
+				return err
+			}
+			_, err = v.popExpected(NumTypei32)
+			if (err != nil) {
+				return err
+			}
+			_, err = v.popOperands(btype.parameter)
+			if (err != nil) {
+				return err
+			}
+			v.pushControl(opcode, btype.parameter, btype.result)
+			return nil
+
+		case 0x05:	// else
+			frame, err := v.popControl()
+			if (err != nil) {
+				return err
+			}
+			if (frame.opcode != 0x04) {
+				return errors.New("else without matching if")
+			}
+			v.pushControl(opcode, frame.start, frame.end)
+			return nil
+
+		case 0x0B:	// end
+			frame, err := v.popControl()
+			if (err != nil) {
+				return err
+			}
+			if (frame.opcode == 0x04 && !equalTypes(frame.start, frame.end)) {
+				return errors.New("type mismatch: if without else must not change the stack")
+			}
+			v.pushOperands(frame.end)
+			return nil
+
+		case 0x0C:	// br
+			frame, err := v.label(instr.Index())
+			if (err != nil) {
+				return err
+			}
+			_, err = v.popOperands(frame.labelTypes())
+			if (err != nil) {
+				return err
+			}
+			v.setUnreachable()
+			return nil
+
+		case 0x0D:	// br_if
+			frame, err := v.label(instr.Index())
+			if (err != nil) {
+				return err
+			}
+			_, err = v.popExpected(NumTypei32)
+			if (err != nil) {
+				return err
+			}
+			return v.apply(frame.labelTypes(), frame.labelTypes())
+
+		case 0x0E:	// br_table
+			return v.validateBranchTable(instr.Indices)
+
+		case 0x0F:	// return
+			_, err := v.popOperands(v.controls[0].end)
+			if (err != nil) {
+				return err
+			}
+			v.setUnreachable()
+			return nil
+
+		case 0x10:	// call
+			ftype, err := v.context.functionType(instr.Index())
+			if (err != nil) {
+				return err
+			}
+			return v.apply(ftype.parameter, ftype.result)
+
+		case 0x11:	// call_indirect
+			table, err := v.table(instr.Indices[1])
+			if (err != nil) {
+				return err
+			}
+			if (table.reftype != RefTypeFunction) {
+				return errors.New("call_indirect requires a funcref table")
+			}
+			ftype, err := v.context.typeAt(int64(instr.Indices[0]))
+			if (err != nil) {
+				return err
+			}
+			_, err = v.popExpected(NumTypei32)
+			if (err != nil) {
+				return err
+			}
+			return v.apply(ftype.parameter, ftype.result)
+
+		//
+		// Reference instructions
+		//
+		case 0xD0:	// ref.null
+			rtype, err := v.refType(instr.Types[0])
+			if (err != nil) {
+				return err
+			}
+			v.pushOperand(rtype)
+			return nil
+
+		case 0xD1:	// ref.is_null
+			vtype, err := v.popOperand()
+			if (err != nil) {
+				return err
+			}
+			if (vtype != unknownType && !isRefType(vtype)) {
+				return fmt.Errorf("type mismatch: expected reference, found %s",
+					typeName(vtype))
+			}
+			v.pushOperand(NumTypei32)
+			return nil
+
+		case 0xD2:	// ref.func
+			index := instr.Index()
+			_, err := v.context.functionType(index)
+			if (err != nil) {
+				return err
+			}
+			if (!v.context.refs[index]) {
+				return fmt.Errorf("undeclared function reference %d", index)
+			}
+			v.pushOperand(RefTypeFunction)
+			return nil
+
+		//
+		// Parametric instructions
+		//
+		case 0x1A:	// drop
+			_, err := v.popOperand()
+			return err
+
+		case 0x1B:	// select
+			_, err := v.popExpected(NumTypei32)
+			if (err != nil) {
+				return err
+			}
+			t1, err := v.popOperand()
+			if (err != nil) {
+				return err
+			}
+			t2, err := v.popOperand()
+			if (err != nil) {
+				return err
+			}
+			if (isRefType(t1) || isRefType(t2)) {
+				return errors.New("type mismatch: untyped select requires numeric operands")
+			}
+			if (t1 != t2 && t1 != unknownType && t2 != unknownType) {
+				return fmt.Errorf("type mismatch: select operands %s and %s",
+					typeName(t1), typeName(t2))
+			}
+			if (t1 == unknownType) {
+				t1 = t2
+			}
+			v.pushOperand(t1)
+			return nil
+
+		case 0x1C:	// select t*
+			if (len(instr.Types) != 1) {
+				return errors.New("invalid result arity for select")
+			}
+			vtype, err := v.valueType(instr.Types[0])
+			if (err != nil) {
+				return err
+			}
+			return v.apply(ResultType{ vtype, vtype, NumTypei32 },
+				ResultType{ vtype })
+
+		//
+		// Variable instructions
+		//
+		case 0x20:	// local.get
+			vtype, err := v.local(instr.Index())
+			if (err != nil) {
+				return err
+			}
+			v.pushOperand(vtype)
+			return nil
+
+		case 0x21:	// local.set
+			vtype, err := v.local(instr.Index())
+			if (err != nil) {
+				return err
+			}
+			_, err = v.popExpected(vtype)
+			return err
+
+		case 0x22:	// local.tee
+			vtype, err := v.local(instr.Index())
+			if (err != nil) {
+				return err
+			}
+			return v.unary(vtype, vtype)
+
+		case 0x23:	// global.get
+			gtype, err := v.global(instr.Index())
+			if (err != nil) {
+				return err
+			}
+			v.pushOperand(gtype.vtype)
+			return nil
+
+		case 0x24:	// global.set
+			gtype, err := v.global(instr.Index())
+			if (err != nil) {
+				return err
+			}
+			if (!gtype.mutable) {
+				return errors.New("global is immutable")
+			}
+			_, err = v.popExpected(gtype.vtype)
+			return err
+
+		//
+		// Table instructions
+		//
+		case 0x25:	// table.get
+			table, err := v.table(instr.Index())
+			if (err != nil) {
+				return err
+			}
+			return v.unary(NumTypei32, ValueType(table.reftype))
+
+		case 0x26:	// table.set
+			table, err := v.table(instr.Index())
+			if (err != nil) {
+				return err
+			}
+			return v.apply(ResultType{ NumTypei32, ValueType(table.reftype) },
+				ResultType{})
+
+		//
+		// Memory instructions
+		//
+		case 0x3F:	// memory.size
+			err := v.memory(instr.Indices)
+			if (err != nil) {
+				return err
+			}
+			v.pushOperand(NumTypei32)
+			return nil
+
+		case 0x40:	// memory.grow
+			err := v.memory(instr.Indices)
+			if (err != nil) {
+				return err
+			}
+			return v.unary(NumTypei32, NumTypei32)
+
+		//
+		// Numeric constants
+		//
+		case 0x41:	// i32.const
+			v.pushOperand(NumTypei32)
+			return nil
+
+		case 0x42:	// i64.const
+			v.pushOperand(NumTypei64)
+			return nil
+
+		case 0x43:	// f32.const
+			v.pushOperand(NumTypef32)
+			return nil
+
+		case 0x44:	// f64.const
+			v.pushOperand(NumTypef64)
+			return nil
+
+		//
+		// Prefixed instructions
+		//
+		case PrefixMisc:
+			return v.validatePrefixedInstruction(instr)
+	}
+
+	//@SIMD (0xFD) instructions
+	return fmt.Errorf("unsupported instruction %s", instr.Name)
+}
+
+// Type-check a "br_table" instruction, given its labels + default label
+func (v *codeValidator) validateBranchTable(depths []uint32) error {
+	_, err := v.popExpected(NumTypei32)
+	if (err != nil) {
+		return err
+	}
+
+	// Last entry is the default target
+	defaultFrame, err := v.label(depths[len(depths) - 1])
+	if (err != nil) {
+		return err
+	}
+	arity := len(defaultFrame.labelTypes())
+
+	for _, depth := range depths[:len(depths) - 1] {
+		frame, err := v.label(depth)
+		if (err != nil) {
+			return err
+		}
+		if (len(frame.labelTypes()) != arity) {
+			return errors.New("type mismatch: br_table targets have inconsistent arity")
+		}
+		popped, err := v.popOperands(frame.labelTypes())
+		if (err != nil) {
+			return err
+		}
+		v.pushOperands(popped)
+	}
+
+	_, err = v.popOperands(defaultFrame.labelTypes())
+	if (err != nil) {
+		return err
+	}
+	v.setUnreachable()
+	return nil
+}
+
+// Type-check a single 0xFC-prefixed instruction (saturating truncation, bulk
+// memory, table operations)
+func (v *codeValidator) validatePrefixedInstruction(
+	instr DecodedInstruction) error {
+	i32x3 := ResultType{ NumTypei32, NumTypei32, NumTypei32 }
+
+	switch(instr.Subopcode) {
+		// Saturating truncation
+		case 0, 1:	return v.unary(NumTypef32, NumTypei32)
+		case 2, 3:	return v.unary(NumTypef64, NumTypei32)
+		case 4, 5:	return v.unary(NumTypef32, NumTypei64)
+		case 6, 7:	return v.unary(NumTypef64, NumTypei64)
+
+		case 8:		// memory.init
+			err := v.data(instr.Indices[0])
+			if (err != nil) {
+				return err
+			}
+			err = v.memory(instr.Indices[1:])
+			if (err != nil) {
+				return err
+			}
+			return v.apply(i32x3, ResultType{})
+
+		case 9:		// data.drop
+			return v.data(instr.Index())
+
+		case 10:	// memory.copy
+			err := v.memory(instr.Indices)
+			if (err != nil) {
+				return err
+			}
+			return v.apply(i32x3, ResultType{})
+
+		case 11:	// memory.fill
+			err := v.memory(instr.Indices)
+			if (err != nil) {
+				return err
+			}
+			return v.apply(i32x3, ResultType{})
+
+		case 12:	// table.init
+			etype, err := v.element(instr.Indices[0])
+			if (err != nil) {
+				return err
+			}
+			table, err := v.table(instr.Indices[1])
+			if (err != nil) {
+				return err
+			}
+			if (etype != ValueType(table.reftype)) {
+				return errors.New("type mismatch: element segment and table")
+			}
+			return v.apply(i32x3, ResultType{})
+
+		case 13:	// elem.drop
+			_, err := v.element(instr.Index())
+			return err
+
+		case 14:	// table.copy
+			dst, err := v.table(instr.Indices[0])
+			if (err != nil) {
+				return err
+			}
+			src, err := v.table(instr.Indices[1])
+			if (err != nil) {
+				return err
+			}
+			if (dst.reftype != src.reftype) {
+				return errors.New("type mismatch: table.copy between tables")
+			}
+			return v.apply(i32x3, ResultType{})
+
+		case 15:	// table.grow
+			table, err := v.table(instr.Index())
+			if (err != nil) {
+				return err
+			}
+			return v.apply(ResultType{ ValueType(table.reftype), NumTypei32 },
+				ResultType{ NumTypei32 })
+
+		case 16:	// table.size
+			_, err := v.table(instr.Index())
+			if (err != nil) {
+				return err
+			}
+			v.pushOperand(NumTypei32)
+			return nil
+
+		case 17:	// table.fill
+			table, err := v.table(instr.Index())
+			if (err != nil) {
+				return err
+			}
+			return v.apply(
+				ResultType{ NumTypei32, ValueType(table.reftype), NumTypei32 },
+				ResultType{})
+	}
+
+	return fmt.Errorf("invalid opcode 0xfc %#x", instr.Subopcode)
+}
+
+
+//
+// Value type helpers
+//
+
+func isValueType(vtype ValueType) bool {
+	switch(vtype) {
+		case NumTypei32, NumTypei64, NumTypef32, NumTypef64:
+			return true
+	}
+	return isRefType(vtype)
+}
+
+func isRefType(vtype ValueType) bool {
+	return (vtype == RefTypeFunction || vtype == RefTypeExtern)
+}
+
+func typeName(vtype ValueType) string {
+	if (vtype == unknownType) {
+		return "unknown"
+	}
+	name, ok := TypeMap[ int(vtype) ]
+	if !ok {
+		return fmt.Sprintf("%#x", uint8(vtype))
+	}
+	return name
+}
+
+func equalTypes(a, b ResultType) bool {
+	if (len(a) != len(b)) {
+		return false
+	}
+	for i := range a {
+		if (a[i] != b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,221 @@
+package wasm
+
+import(
+	"bytes"
+	"errors"
+	"testing"
+	)
+
+
+//
+// Test type-checking of individual function bodies
+//
+func TestFunctionValidation(t *testing.T) {
+	// Context shared by all test cases: a handful of types, one function per
+	// type, one memory, one funcref table and two globals
+	context := moduleContext{
+		types: []FunctionType{
+			{ ResultType{}, ResultType{} },
+			{ ResultType{ NumTypei32, NumTypei32 }, ResultType{ NumTypei32 } },
+			{ ResultType{ NumTypei32, NumTypei32 },
+			  ResultType{ NumTypei32, NumTypei32 } },
+		},
+		functions:	[]uint32{ 0, 1, 2 },
+		tables:		[]Table{ { Limit{ 1, 0, 0x00 }, RefTypeFunction } },
+		memories:	[]Memory{ { Limit{ 1, 0, 0x00 } } },
+		globals:	[]GlobalType{ { NumTypei32, false }, { NumTypei64, true } },
+		refs:		map[uint32]bool{ 1: true },
+	}
+
+	testCases := []struct{
+		name		string
+		ftype		uint32
+		local		[]ValueType
+		body		[]byte
+		offset		int		// Offset of expected error, or -1 if valid
+	}{
+		// Valid bodies
+		{ "fnop",			0, nil, []byte{ 0x01, 0x01, 0x0B },		-1 },
+		{ "addTwo",			1, nil,
+		  []byte{ 0x20, 0x00, 0x20, 0x01, 0x6A, 0x0B },				-1 },
+		{ "swap",			2, nil,
+		  []byte{ 0x20, 0x01, 0x20, 0x00, 0x0B },						-1 },
+		{ "call-multi-value", 1, nil,
+		  []byte{ 0x20, 0x00, 0x20, 0x01, 0x10, 0x02, 0x6B, 0x0B },	-1 },
+		{ "locals",			0, []ValueType{ NumTypei64 },
+		  []byte{ 0x42, 0x7F, 0x21, 0x00, 0x0B },						-1 },
+		{ "if-else",		1, nil,
+		  []byte{ 0x20, 0x00, 0x04, 0x7F, 0x41, 0x01, 0x05, 0x41, 0x02,
+				  0x0B, 0x0B },										-1 },
+		{ "block-br",		1, nil,
+		  []byte{ 0x02, 0x7F, 0x41, 0x01, 0x0C, 0x00, 0x0B, 0x0B },	-1 },
+		{ "loop-br-if",		0, nil,
+		  []byte{ 0x03, 0x40, 0x41, 0x00, 0x0D, 0x00, 0x0B, 0x0B },	-1 },
+		{ "br-table",		1, nil,
+		  []byte{ 0x02, 0x7F, 0x41, 0x07, 0x20, 0x00, 0x0E, 0x01, 0x00,
+				  0x01, 0x0B, 0x0B },									-1 },
+		{ "unreachable-polymorphic", 1, nil,
+		  []byte{ 0x00, 0x6A, 0x0B },									-1 },
+		{ "return-polymorphic", 1, nil,
+		  []byte{ 0x41, 0x01, 0x0F, 0x1A, 0x0B },						-1 },
+		{ "memory-load-store", 0, nil,
+		  []byte{ 0x41, 0x00, 0x41, 0x00, 0x28, 0x02, 0x00, 0x36, 0x02,
+				  0x00, 0x0B },										-1 },
+		{ "memory-fill",	0, nil,
+		  []byte{ 0x41, 0x00, 0x41, 0x00, 0x41, 0x00, 0xFC, 0x0B, 0x00,
+				  0x0B },												-1 },
+		{ "global-set",		0, nil,
+		  []byte{ 0x42, 0x00, 0x24, 0x01, 0x0B },						-1 },
+		{ "call-indirect",	0, nil,
+		  []byte{ 0x41, 0x00, 0x11, 0x00, 0x00, 0x0B },				-1 },
+		{ "ref-func",		0, nil,
+		  []byte{ 0xD2, 0x01, 0xD1, 0x1A, 0x0B },						-1 },
+
+		// Invalid bodies
+		{ "missing-end",	0, nil, []byte{ 0x01 },						1 },
+		{ "trailing-bytes",	0, nil, []byte{ 0x0B, 0x01 },				1 },
+		{ "stack-underflow", 1, nil, []byte{ 0x6A, 0x0B },				0 },
+		{ "type-mismatch",	1, nil,
+		  []byte{ 0x42, 0x00, 0x41, 0x00, 0x6A, 0x0B },				4 },
+		{ "missing-result",	1, nil, []byte{ 0x0B },						0 },
+		{ "extra-result",	0, nil, []byte{ 0x41, 0x00, 0x0B },			2 },
+		{ "unknown-local",	0, nil, []byte{ 0x20, 0x00, 0x1A, 0x0B },	0 },
+		{ "unknown-global",	0, nil, []byte{ 0x23, 0x05, 0x1A, 0x0B },	0 },
+		{ "immutable-global", 0, nil,
+		  []byte{ 0x41, 0x00, 0x24, 0x00, 0x0B },						2 },
+		{ "unknown-function", 0, nil, []byte{ 0x10, 0x09, 0x0B },		0 },
+		{ "unknown-label",	0, nil, []byte{ 0x0C, 0x01, 0x0B },			0 },
+		{ "unknown-type",	0, nil,
+		  []byte{ 0x02, 0x09, 0x0B, 0x0B },							0 },
+		{ "bad-alignment",	0, nil,
+		  []byte{ 0x41, 0x00, 0x28, 0x03, 0x00, 0x1A, 0x0B },			2 },
+		{ "if-without-else", 1, nil,
+		  []byte{ 0x41, 0x00, 0x04, 0x7F, 0x41, 0x01, 0x0B, 0x0B },	6 },
+		{ "br-table-arity",	1, nil,
+		  []byte{ 0x02, 0x40, 0x20, 0x00, 0x0E, 0x01, 0x00, 0x01, 0x0B,
+				  0x41, 0x00, 0x0B },									4 },
+		{ "else-without-if", 0, nil, []byte{ 0x05, 0x0B },				0 },
+		{ "undeclared-ref",	0, nil,
+		  []byte{ 0xD2, 0x00, 0x1A, 0x0B },							0 },
+		{ "invalid-opcode",	0, nil, []byte{ 0xFF, 0x0B },				0 },
+		{ "invalid-local-type", 0, []ValueType{ 0x00 },
+		  []byte{ 0x20, 0x00, 0x20, 0x00, 0x6A, 0x1A, 0x0B },			0 },
+		{ "unknown-local-type", 0, []ValueType{ 0x55 },
+		  []byte{ 0x20, 0x00, 0x1A, 0x0B },							0 },
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			function := Function{ body: test.body, local: test.local }
+			err := validateFunction(&context, 0, context.types[test.ftype],
+				function)
+
+			if (test.offset < 0) {
+				if (err != nil) {
+					t.Error("Unexpected validation error: ", err)
+				}
+				return
+			}
+
+			var verr ValidationError
+			if (!errors.As(err, &verr)) {
+				t.Fatal("Expected validation error, got: ", err)
+			}
+			if (!errors.Is(err, InvalidFunction)) {
+				t.Error("Validation error does not wrap InvalidFunction: ", err)
+			}
+			if (verr.Offset != test.offset) {
+				t.Error("Unexpected error offset: ", err)
+			}
+		})
+	}
+}
+
+
+//
+// Test module-level (cross-section) validation
+//
+func TestModuleValidation(t *testing.T) {
+	preamble	:= []byte{ 0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00 }
+	types		:= []byte{ 0x01, 0x04, 0x01, 0x60, 0x00, 0x00 }
+	functions	:= []byte{ 0x03, 0x02, 0x01, 0x00 }
+	exports		:= []byte{ 0x07, 0x08, 0x01, 0x04, 'f', 'n', 'o', 'p', 0x00, 0x00 }
+	code		:= []byte{ 0x0a, 0x06, 0x01, 0x04, 0x00, 0x01, 0x01, 0x0b }
+	custom		:= []byte{ 0x00, 0x03, 0x01, 'c', 0xff }
+
+	module := func(sections ...[]byte) []byte {
+		encoded := append([]byte{}, preamble...)
+		for _, section := range sections {
+			encoded = append(encoded, section...)
+		}
+		return encoded
+	}
+
+	testCases := []struct{
+		name		string
+		encoded		[]byte
+		status		error
+	}{
+		{ "empty-module",		module(),								nil },
+		{ "fnop",	module(types, functions, exports, code),			nil },
+
+		{ "function-code-mismatch",
+		  module(types, functions),										InvalidModule },
+		{ "unknown-type",
+		  module(types, []byte{ 0x03, 0x02, 0x01, 0x05 }, code),		InvalidModule },
+		{ "duplicate-export",
+		  module(types, functions,
+			  []byte{ 0x07, 0x0f, 0x02,
+					  0x04, 'f', 'n', 'o', 'p', 0x00, 0x00,
+					  0x04, 'f', 'n', 'o', 'p', 0x00, 0x00 },
+			  code),														InvalidModule },
+		{ "export-out-of-range",
+		  module(types, functions,
+			  []byte{ 0x07, 0x08, 0x01, 0x04, 'f', 'n', 'o', 'p', 0x00, 0x01 },
+			  code),														InvalidModule },
+		{ "custom-sections-anywhere",
+		  module(custom, types, custom, functions, exports, code, custom),
+																		nil },
+		{ "section-out-of-order",
+		  module(functions, types, code),								InvalidModule },
+		{ "duplicate-section",
+		  module(types, types, functions, code),							InvalidModule },
+		{ "unknown-section",
+		  module([]byte{ 0x20, 0x00 }),									InvalidModule },
+		{ "start-signature",
+		  module([]byte{ 0x01, 0x05, 0x01, 0x60, 0x01, 0x7f, 0x00 },
+			  functions,
+			  []byte{ 0x08, 0x01, 0x00 },
+			  []byte{ 0x0a, 0x04, 0x01, 0x02, 0x00, 0x0b }),				InvalidModule },
+		{ "data-count-mismatch",
+		  module([]byte{ 0x0c, 0x01, 0x01 }),							InvalidModule },
+		{ "global-type-mismatch",
+		  module([]byte{ 0x06, 0x06, 0x01, 0x7f, 0x00, 0x42, 0x00, 0x0b }),
+																		InvalidModule },
+		{ "invalid-param-type",
+		  module([]byte{ 0x01, 0x05, 0x01, 0x60, 0x01, 0x55, 0x00 }),	InvalidModule },
+		{ "invalid-result-type",
+		  module([]byte{ 0x01, 0x05, 0x01, 0x60, 0x00, 0x01, 0x00 }),	InvalidModule },
+		{ "invalid-local-type",
+		  module(types, functions,
+			  []byte{ 0x0a, 0x0c, 0x01, 0x0a, 0x01, 0x01, 0x00,
+					  0x20, 0x00, 0x20, 0x00, 0x6a, 0x1a, 0x0b }),		InvalidFunction },
+		{ "invalid-body",
+		  module(types, functions,
+			  []byte{ 0x0a, 0x05, 0x01, 0x03, 0x00, 0x6a, 0x0b }),			InvalidFunction },
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			module, err := ReadModule(bytes.NewReader(test.encoded))
+			if (err != nil) {
+				t.Fatal("Unexpected decoding status: ", err)
+			}
+
+			err = module.Validate()
+			if (!errors.Is(err, test.status) || (err == nil) != (test.status == nil)) {
+				t.Error("Unexpected validation status: ", err)
+			}
+		})
+	}
+}
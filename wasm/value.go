@@ -0,0 +1,178 @@
+package wasm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+
+//
+// Typed values, e.g. invocation arguments + results.  Values are represented
+// by their native Go types: int32 (i32), int64 (i64), float32 (f32), float64
+// (f64) and [16]byte (v128)
+//
+
+// Invocation arguments that do not match the function signature
+var InvalidArgument = errors.New("Invalid argument")
+
+//
+// Factory function for parsing + returning a single value of the given type,
+// in WAT literal form: e.g. "-1", "0xFF", "1.5", "0x1p-3", "inf", "nan:0x1",
+// or "i32x4 1 2 3 4" for v128.  No side effects.
+//
+func ParseValue(vtype ValueType, text string) (interface{}, error) {
+	node := watNode{ token: watToken{ kind: watTokenKeyword, text: text,
+		line: 1, column: 1 } }
+
+	var err error
+	var bits uint64
+	switch(vtype) {
+		case NumTypei32:
+			bits, err = parseWatInteger(node, 32)
+			if (err == nil) {
+				return int32(bits), nil
+			}
+		case NumTypei64:
+			bits, err = parseWatInteger(node, 64)
+			if (err == nil) {
+				return int64(bits), nil
+			}
+		case NumTypef32:
+			bits, err = parseWatFloat(node, 32)
+			if (err == nil) {
+				return math.Float32frombits(uint32(bits)), nil
+			}
+		case NumTypef64:
+			bits, err = parseWatFloat(node, 64)
+			if (err == nil) {
+				return math.Float64frombits(bits), nil
+			}
+		case VecTypev128:
+			return parseV128Value(text)
+		default:
+			return nil, fmt.Errorf("unsupported type %s", typeName(vtype))
+	}
+
+	return nil, fmt.Errorf("invalid %s \"%s\"", typeName(vtype), text)
+}
+
+// Vector value, e.g. "i32x4 1 2 3 4"
+func parseV128Value(text string) (interface{}, error) {
+	var value [16]byte
+	invalid := fmt.Errorf("invalid v128 \"%s\"", text)
+
+	tokens, err := lexWAT(text)
+	if (err != nil) {
+		return nil, invalid
+	}
+	nodes, err := parseWatNodes(tokens)
+	if (err != nil) {
+		return nil, invalid
+	}
+	cursor := &watCursor{ nodes: nodes }
+	lanes, err := parseWatV128(cursor)
+	if (err != nil || !cursor.done()) {
+		return nil, invalid
+	}
+	copy(value[:], lanes)
+	return value, nil
+}
+
+//
+// Format a single value in WAT literal form, such that ParseValue() yields
+// the same value.  v128 values are shown as 4 x i32 lanes.  No side effects.
+//
+func FormatValue(value interface{}) string {
+	switch v := value.(type) {
+		case int32:
+			return fmt.Sprintf("%d", v)
+		case int64:
+			return fmt.Sprintf("%d", v)
+		case float32:
+			return formatFloat(float64(v), 32, uint64(math.Float32bits(v)))
+		case float64:
+			return formatFloat(v, 64, math.Float64bits(v))
+		case [16]byte:
+			lanes := make([]string, 4)
+			for i := range lanes {
+				lanes[i] = fmt.Sprintf("%#08x",
+					binary.LittleEndian.Uint32(v[i * 4:]))
+			}
+			return "i32x4 " + strings.Join(lanes, " ")
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// Name of the type of a value, e.g. "i32".  No side effects.
+func ValueTypeName(value interface{}) string {
+	return typeName(valueType(value))
+}
+
+// Type of a value, or unknownType.  No side effects.
+func valueType(value interface{}) ValueType {
+	switch value.(type) {
+		case int32:		return NumTypei32
+		case int64:		return NumTypei64
+		case float32:	return NumTypef32
+		case float64:	return NumTypef64
+		case [16]byte:	return VecTypev128
+	}
+	return unknownType
+}
+
+
+//
+// Parse the arguments of an exported function, in text form, according to
+// its parameter types.  Fails on any arity or type mismatch.  No side
+// effects.
+//
+func (module Module) ParseArguments(name string, args []string) ([]interface{},
+	error) {
+	function, err := exportedFunction(module, name)
+	if (err != nil) {
+		return nil, err
+	}
+	ftype := module.functionType(function)
+	if (len(args) != len(ftype.parameter)) {
+		return nil, fmt.Errorf("%w: %s expects %d argument(s) (%s), found %d",
+			InvalidArgument, name, len(ftype.parameter),
+			strings.TrimSpace(ftype.parameter.String()), len(args))
+	}
+
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		values[i], err = ParseValue(ftype.parameter[i], arg)
+		if (err != nil) {
+			return nil, fmt.Errorf("%w %d: %s", InvalidArgument, i + 1, err)
+		}
+	}
+	return values, nil
+}
+
+// Check typed arguments against the parameter types of a function
+func checkArguments(ftype FunctionType, args []interface{}) error {
+	if (len(args) != len(ftype.parameter)) {
+		return fmt.Errorf("%w: expected %d argument(s), found %d",
+			InvalidArgument, len(ftype.parameter), len(args))
+	}
+	for i, arg := range args {
+		if (valueType(arg) != ftype.parameter[i]) {
+			return fmt.Errorf("%w %d: expected %s, found %T", InvalidArgument,
+				i, typeName(ftype.parameter[i]), arg)
+		}
+	}
+	return nil
+}
+
+// Type of the function with the given index, or the empty type if the module
+// is malformed.  No side effects.
+func (module Module) functionType(function uint32) FunctionType {
+	context := newModuleContext(module)
+	if (int(function) >= len(context.functions)) {
+		return FunctionType{}
+	}
+	return typeOrEmpty(context, context.functions[function])
+}
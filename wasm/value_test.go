@@ -0,0 +1,102 @@
+package wasm
+
+import(
+	"errors"
+	"math"
+	"testing"
+	)
+
+
+//
+// Test parsing + formatting of typed values
+//
+func TestParseValue(t *testing.T) {
+	var lanes [16]byte
+	for i := 0; i < 4; i++ {
+		lanes[i * 4] = byte(i + 1)
+	}
+
+	testCases := []struct{
+		vtype		ValueType
+		text		string
+		value		interface{}
+		valid		bool
+	}{
+		{ NumTypei32,	"-1",				int32(-1),				true },
+		{ NumTypei32,	"0xFFFFFFFF",		int32(-1),				true },
+		{ NumTypei32,	"4_294_967_295",	int32(-1),				true },
+		{ NumTypei32,	"0x100000000",		nil,					false },
+		{ NumTypei32,	"1.0",				nil,					false },
+		{ NumTypei64,	"-9223372036854775808",
+											int64(math.MinInt64),	true },
+		{ NumTypef32,	"0x1p-3",			float32(0.125),			true },
+		{ NumTypef32,	"-inf",		float32(math.Inf(-1)),			true },
+		{ NumTypef64,	"5.0",				float64(5),				true },
+		{ NumTypef64,	"nan:0x1",
+						math.Float64frombits(0x7FF0000000000001),	true },
+		{ NumTypef64,	"five",				nil,					false },
+		{ VecTypev128,	"i32x4 1 2 3 4",	lanes,					true },
+		{ VecTypev128,	"i32x4 1 2 3",		nil,					false },
+		{ VecTypev128,	"1",				nil,					false },
+	}
+
+	for _, test := range testCases {
+		t.Run(test.text, func(t *testing.T) {
+			value, err := ParseValue(test.vtype, test.text)
+			if ((err == nil) != test.valid) {
+				t.Fatal("Unexpected parsing status: ", err)
+			}
+			if (!test.valid) {
+				return
+			}
+
+			// Compare bit patterns, so that NaN values also match
+			if (FormatValue(value) != FormatValue(test.value) ||
+				valueType(value) != test.vtype) {
+				t.Errorf("Unexpected value %v (%T)", value, value)
+			}
+
+			// Formatted values are parsed back to the same value
+			again, err := ParseValue(test.vtype, FormatValue(value))
+			if (err != nil || FormatValue(again) != FormatValue(value)) {
+				t.Errorf("Unexpected round trip: %s, %v", FormatValue(value),
+					err)
+			}
+		})
+	}
+}
+
+//
+// Test parsing of the arguments to an exported function
+//
+func TestParseArguments(t *testing.T) {
+	ftype := FunctionType{ ResultType{ NumTypei32, NumTypef64 }, ResultType{} }
+	module := CreateModuleBuilder().
+		Function("f", ftype, nil, CreateExpression().Nop()).
+		Module()
+
+	testCases := []struct{
+		name		string
+		function	string
+		args		[]string
+		status		error
+	}{
+		{ "valid",		"f",	[]string{ "1", "-0x1p2" },	nil },
+		{ "missing",	"g",	[]string{},					MissingFunction },
+		{ "arity",		"f",	[]string{ "1" },			InvalidArgument },
+		{ "type",		"f",	[]string{ "1.5", "1.5" },	InvalidArgument },
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			values, err := module.ParseArguments(test.function, test.args)
+			if (!errors.Is(err, test.status)) {
+				t.Fatal("Unexpected parsing status: ", err)
+			}
+			if (err == nil && (values[0] != int32(1) ||
+				values[1] != float64(-4))) {
+				t.Errorf("Unexpected arguments: %v", values)
+			}
+		})
+	}
+}
@@ -2,7 +2,28 @@ package wasm
 
 import "io"
 
+//
+// Read the length of a vector.  Every vector element occupies at least one
+// byte, so reject any length that exceeds the remaining input, rather than
+// allowing a bogus length to drive a huge allocation
+//
 func readVectorLength(reader io.Reader) (uint32, error) {
-	return(readULEB128(reader))
+	length, err := readULEB128(reader)
+	if (err != nil) {
+		return length, err
+	}
+
+	if remaining, ok := reader.(interface{ Len() int }); ok {
+		if (uint64(length) > uint64(remaining.Len())) {
+			return length, io.ErrUnexpectedEOF
+		}
+	}
+
+	return length, nil
 }
 
+// Append the binary encoding of a vector length to a byte slice.  No side
+// effects.
+func appendVectorLength(buffer []byte, length int) []byte {
+	return appendULEB128(buffer, uint64(length))
+}
@@ -1,12 +1,27 @@
 package wasm
 
 import (
-	"log"
 	"errors"
+	"fmt"
+	"log"
+	"time"
 )
 
 
 var MissingFunction = errors.New("Unable to find function")
+var InstructionLimit = errors.New("Instruction limit exceeded")
+var InvalidIP = errors.New("Instruction pointer out of range")
+var CallStackExhausted = errors.New("Call stack exhausted")
+
+//
+// WASM features/proposals supported by the VM, by target_features name.  Any
+// module that uses other features may fail to validate or execute.  The
+// interpreter only executes a handful of MVP instructions so far (see
+// Opcode), so no proposals are supported yet
+//
+var SupportedFeatures = map[string]bool {
+	//@add each proposal once the interpreter executes all of its instructions
+}
 
 //
 // VM configuration
@@ -14,6 +29,14 @@ var MissingFunction = errors.New("Unable to find function")
 type VMConfig struct {
 	StartFn		string
 	StartStack	[]int32
+	MaxInstructions	uint64	// Upper bound on instructions executed, if nonzero
+	MaxCallDepth	int		// Upper bound on nested calls, if nonzero
+	Stats		*ExecutionStats	// Filled in on exit, if non-nil
+	Debugger	*Debugger	// Pause at breakpoints, etc, if non-nil
+	Tracer		*Tracer		// Log each instruction executed, if non-nil
+	Profiler	*Profiler	// Record call stacks, if non-nil
+	Fuel		*Fuel		// Charge for each instruction, if non-nil
+	Trap		*Trap		// Location of any trap, if non-nil
 	//@JIT?
 	//@resource allocation/sizing
 }
@@ -25,19 +48,34 @@ type VMConfig struct {
 type WASMVM interface {
 	//@id()
 	Execute(Module, VMConfig) error
+	Invoke(module Module, name string, args []interface{},
+		config VMConfig) ([]interface{}, error)
 }
 
 
 //
 // Bytecode instruction pointer (IP).  Wrapper for current function index
-// and offset into the block of bytecode for that function.
+// and index into the decoded instructions of that function.
 //
 type InstructionPointer struct {
-	bytecode	[]byte		// Cached slice of code[function]
+	code		[]DecodedInstruction	// Cached, decoded code[function]
 	function	int
 	ip			int
 }
 
+// Byte offset of the current instruction within the function body, for
+// diagnostics.  No side effects.
+func (ip InstructionPointer) offset() int {
+	if (ip.ip < len(ip.code)) {
+		return ip.code[ip.ip].Offset
+	}
+	if (len(ip.code) > 0) {
+		last := ip.code[len(ip.code) - 1]
+		return last.Offset + last.Length
+	}
+	return 0
+}
+
 //
 // Context for a single interpreter thread: stacks, current IP, etc
 //
@@ -45,18 +83,107 @@ type WASMInterpreterThread struct {
 	callStack	Stack
 	current		InstructionPointer
 	dataStack	Stack
-	//@logger + unique id
+	id			uint64		// Unique, e.g. for tracing
+	instance	*Instance	// Globals + memory, if any
+	maxCalls	int			// Upper bound on call stack depth, if nonzero
+
+	stats		ExecutionStats
+}
+
+//
+// Execution statistics for a single invocation.  Calls are counted per
+// function as each frame is entered, but the interpreter does not execute
+// call yet, so only the entry function is counted so far
+//
+type ExecutionStats struct {
+	Start			time.Time
+	Stop			time.Time
+	Instructions	uint64				// Instructions retired
+	Opcodes			map[string]uint64	// Instructions retired, by mnemonic
+	Calls			map[uint32]uint64	// Calls, by function index
+	MaxCallDepth	int
+	MaxStackDepth	int					// Operand stack, in values
+	MemoryHighWater	int					// Peak size of instance memories,
+										// in bytes
+}
 
-	stats struct {
-		//@start
-		//@stop
-		//@instructions
+// Wall time of the invocation.  No side effects.
+func (stats ExecutionStats) Elapsed() time.Duration {
+	return stats.Stop.Sub(stats.Start)
+}
+
+// Record a single instruction + the resulting thread state
+func (stats *ExecutionStats) update(thread *WASMInterpreterThread,
+	instr DecodedInstruction, depth int) {
+	stats.Instructions++
+	stats.Opcodes[instr.Name]++
+
+	// Any new frame implies a call into the current function
+	//@call is not yet executed, so only the entry function is ever counted
+	calls := thread.callStack.Top() + 1
+	if (calls > depth) {
+		stats.Calls[uint32(thread.current.function)]++
+	}
+	if (calls > stats.MaxCallDepth) {
+		stats.MaxCallDepth = calls
+	}
+	if (thread.dataStack.Top() + 1 > stats.MaxStackDepth) {
+		stats.MaxStackDepth = thread.dataStack.Top() + 1
+	}
+	stats.updateMemory(thread.instance)
+}
+
+// Record the current size of the instance memories, if a new peak
+func (stats *ExecutionStats) updateMemory(instance *Instance) {
+	if (instance == nil) {
+		return
+	}
+	size := 0
+	for _, memory := range instance.memories {
+		size += len(memory)
 	}
+	if (size > stats.MemoryHighWater) {
+		stats.MemoryHighWater = size
+	}
+}
+
+//
+// Location + cause of a trap or other runtime error, e.g. for diagnostics.
+// The interpreter reports these via VMConfig, rather than logging them, so
+// that the caller decides how to present them
+//
+type Trap struct {
+	Err			error
+	Function	uint32
+	Name		string
+	Offset		int
+	Backtrace	[]string	// Call stack, innermost first, e.g. "$fac+0x1a"
+}
+
+func (trap Trap) Error() string {
+	return fmt.Sprintf("%s in %s at IP %#x", trap.Err, trap.Name, trap.Offset)
+}
+
+func (trap Trap) Unwrap() error {
+	return trap.Err
+}
+
+// Record the current location as that of a trap, if requested
+func (thread *WASMInterpreterThread) trap(err error, config VMConfig,
+	names NameSection) {
+	if (config.Trap == nil) {
+		return
+	}
+	function := uint32(thread.current.function)
+	*config.Trap = Trap{ Err: err, Function: function,
+		Name: names.Function(function), Offset: thread.current.offset(),
+		Backtrace: thread.backtrace(names) }
 }
 
 type StackFrame struct {
 	caller	InstructionPointer
-	locals	int
+	locals	int		// Data stack index of local 0
+	count	int		// Number of locals, incl. parameters
 }
 
 // Jump to new function/instruction, as a result of call or return
@@ -64,20 +191,53 @@ func (thread *WASMInterpreterThread) jump(ip InstructionPointer) {
 	thread.current = ip
 }
 
-// Save the current stack frame in preparation for a function call
-func (thread *WASMInterpreterThread) pushFrame() {
-	stackFrame := StackFrame{}
+//
+// Save the current stack frame in preparation for a function call, where the
+// topmost count values on the data stack are the locals of the callee, in
+// order (i.e., local 0 is deepest).  Fails if the call stack is already at
+// its maximum depth, e.g. on unbounded recursion
+//
+func (thread *WASMInterpreterThread) pushFrame(count int) error {
+	if (thread.maxCalls > 0 && thread.callStack.Top() + 1 >= thread.maxCalls) {
+		return CallStackExhausted
+	}
+	stackFrame := StackFrame{ count: count }
 
 	// Save the current bytecode context
-	stackFrame.caller.bytecode	= thread.current.bytecode
+	stackFrame.caller.code		= thread.current.code
 	stackFrame.caller.function	= thread.current.function
 	stackFrame.caller.ip		= thread.current.ip //@plus calling instruction
 
-	// Save the current stack location (i.e., the stack base pointer), since
-	// locals are relative to this offset
-	stackFrame.locals = thread.dataStack.Top()
+	// Save the stack location of the locals (i.e., the stack base pointer),
+	// since locals are relative to this offset
+	stackFrame.locals = thread.dataStack.Top() + 1 - count
 
 	thread.callStack.Push(stackFrame)
+	return nil
+}
+
+//
+// Describe the current call stack, innermost frame first, for diagnostics.
+// Each frame is identified by function name + offset.  No side effects.
+//
+func (thread *WASMInterpreterThread) backtrace(names NameSection) []string {
+	frames := []string{ fmt.Sprintf("%s+%#x",
+		names.Function(uint32(thread.current.function)),
+		thread.current.offset()) }
+
+	// The outermost frame is the simulated call into the entry point, so has
+	// no meaningful caller
+	for i := thread.callStack.Top(); i > 0; i-- {
+		value, err := thread.callStack.Peek(i)
+		if (err != nil) {
+			break
+		}
+		caller := value.(StackFrame).caller
+		frames = append(frames, fmt.Sprintf("%s+%#x",
+			names.Function(uint32(caller.function)), caller.offset()))
+	}
+
+	return frames
 }
 
 // Unwind the stack frame created by pushFrame()
@@ -99,72 +259,271 @@ type WASMInterpreter struct {
 
 
 //
-// Run the actual interpreter
+// Run the actual interpreter, starting at the exported function named by the
+// configuration.  Any values left on the data stack are logged on exit
 //
 func (vm WASMInterpreter) Execute(module Module, config VMConfig) error {
-	var err error
+	function, err := exportedFunction(module, config.StartFn)
+	if (err != nil) {
+		return err
+	}
 
-	//
-	// Locate the named start function / entry point
-	//
-	exportSection, ok := module.section[ExportSectionId].(ExportSection)
+	// Preload the data stack if necessary
+	args := make([]interface{}, len(config.StartStack))
+	for i, value := range config.StartStack {
+		args[i] = value
+	}
+
+	// Dump any data left on the stack, in the assumption that these are
+	// the result(s) of some function/calculation
+	stack, err := vm.invoke(module, nil, function, args, config)
+	for _, value := range stack {
+		log.Printf("Thread stack: %v\n", value)
+	}
+
+	return err
+}
+
+//
+// Invoke the exported function with the given name.  The arguments must
+// match the parameter types of the function; see ParseArguments().  Returns
+// the results of the function, in order
+//
+func (vm WASMInterpreter) Invoke(module Module, name string,
+	args []interface{}, config VMConfig) ([]interface{}, error) {
+	return vm.invokeExport(module, nil, name, args, config)
+}
+
+// Invoke an exported function, against the state of an instance, if any
+func (vm WASMInterpreter) invokeExport(module Module, instance *Instance,
+	name string, args []interface{}, config VMConfig) ([]interface{}, error) {
+	function, err := exportedFunction(module, name)
+	if (err != nil) {
+		return nil, err
+	}
+	ftype := module.functionType(function)
+	err = checkArguments(ftype, args)
+	if (err != nil) {
+		return nil, err
+	}
+
+	stack, err := vm.invoke(module, instance, function, args, config)
+	if (err != nil) {
+		return nil, err
+	}
+
+	// The results are the topmost values, with the last result on top
+	count := len(ftype.result)
+	if (len(stack) < count) {
+		return nil, fmt.Errorf("%w: expected %d result(s), found %d",
+			StackUnderflow, count, len(stack))
+	}
+	results := make([]interface{}, count)
+	for i := range results {
+		results[i] = stack[count - 1 - i]
+	}
+	return results, nil
+}
+
+// Locate the index of the exported function with the given name.  No side
+// effects.
+func exportedFunction(module Module, name string) (uint32, error) {
+	exportSection, ok := module.section(ExportSectionId).(ExportSection)
 	if !ok {
 		// No exported resources
-		return MissingFunction
+		return 0, MissingFunction
 	}
-	export, ok := exportSection.export[ config.StartFn ]
+	export, ok := exportSection.export[ name ]
 	if !ok {
 		// No resource with this name
-		return MissingFunction
+		return 0, MissingFunction
 	}
 	if export.etype != ExportTypeFunction {
 		// Wrong resource type
-		return MissingFunction
+		return 0, MissingFunction
 	}
+	return export.index, nil
+}
 
-	codeSection, ok := module.section[CodeSectionId].(CodeSection)
+// Locate the import of the given (imported) function.  No side effects.
+func importedFunction(module Module, function uint32) (Import, bool) {
+	section, ok := module.section(ImportSectionId).(ImportSection)
+	if !ok {
+		return Import{}, false
+	}
+	for _, imp := range section.imports {
+		if (imp.itype != ExportTypeFunction) {
+			continue
+		} else if (function == 0) {
+			return imp, true
+		}
+		function--
+	}
+	return Import{}, false
+}
+
+
+
+//
+// Invoke a single function, with the given arguments pushed onto the data
+// stack in order.  Returns the contents of the data stack on exit, top first,
+// even if execution fails
+//
+func (vm WASMInterpreter) invoke(module Module, instance *Instance,
+	function uint32, args []interface{}, config VMConfig) ([]interface{},
+	error) {
+	var err error
+
+	// Imported functions precede the module-defined functions.  Host calls
+	// are metered on entry, but cannot be executed (yet)
+	//@link host functions, so that imports can be called
+	imported := newModuleContext(module).imported
+	if (int(function) < imported) {
+		if (config.Fuel != nil) {
+			host, _ := importedFunction(module, function)
+			err = config.Fuel.charge(config.Fuel.HostCost(host.module,
+				host.name))
+			if (err != nil) {
+				return nil, err
+			}
+		}
+		return nil, fmt.Errorf("%w: imported function %d", MissingFunction,
+			function)
+	}
+
+	codeSection, ok := module.section(CodeSectionId).(CodeSection)
 	if !ok {
 		// No code
-		return MissingFunction
+		return nil, MissingFunction
 	}
-	if (int(export.index) >= len(codeSection.function)) {
+	if (int(function) - imported >= len(codeSection.function)) {
 		// Function index is out of range
-		return MissingFunction
+		return nil, MissingFunction
+	}
+
+	// Function names, for any diagnostics
+	names := module.Names()
+
+	// Each function is decoded on first use.  A malformed function yields
+	// the instructions preceding the malformed one, so execution only fails
+	// if it actually reaches the malformed instruction
+	decoded := make(map[int][]DecodedInstruction)
+	decodeErrors := make(map[int]error)
+	decode := func(function int) []DecodedInstruction {
+		code, ok := decoded[function]
+		if (!ok) {
+			code, decodeErrors[function] =
+				codeSection.function[function - imported].Instructions()
+			decoded[function] = code
+		}
+		return code
 	}
 
-	// Initialize the initial VM thread context.  Preload the data stack if
-	// necessary
+	// Initialize the initial VM thread context
 	thread := WASMInterpreterThread{
 		callStack: CreateStack(32),
 		dataStack: CreateStack(256),
+		id: nextThreadId(),
+		instance: instance,
+		maxCalls: config.MaxCallDepth,
+		stats: ExecutionStats{ Start: time.Now(),
+			Opcodes: make(map[string]uint64),
+			Calls: map[uint32]uint64{ function: 1 },
+			MaxCallDepth: 1 },
 	}
-	for _, value := range config.StartStack {
+	// The declared locals follow the parameters, zero-initialized
+	locals := codeSection.function[int(function) - imported].local
+	for _, value := range args {
 		thread.dataStack.Push(value)
 	}
-	
+	for _, vtype := range locals {
+		thread.dataStack.Push(zeroValue(vtype))
+	}
+	thread.stats.MaxStackDepth = len(args) + len(locals)
+	thread.stats.updateMemory(instance)
+	defer func() {
+		thread.stats.Stop = time.Now()
+		if (config.Stats != nil) {
+			*config.Stats = thread.stats
+		}
+	}()
+
 	// Simulate a function call to the entry function, so that exit/unwinding
 	// behaves properly
-	thread.pushFrame()
-	entryfn	:= codeSection.function[ int(export.index) ].body[:]
-	thread.jump( InstructionPointer{ entryfn, int(export.index), 0 } )
-	//@handle functions.local[]
+	err = thread.pushFrame(len(args) + len(locals))
+	if (err != nil) {
+		return nil, err
+	}
+	entryfn	:= decode(int(function))
+	thread.jump( InstructionPointer{ entryfn, int(function), 0 } )
+	if (config.Profiler != nil) {
+		defer config.Profiler.begin()()
+	}
 
 
 	//
 	// Main execution loop
 	//
-	for {
-		// (Re)locate the next opcode in the bytecode, based on prior jumps, etc
-		opcode := thread.current.bytecode[ thread.current.ip ]
+	for count := uint64(0); ; count++ {
+		// Bound the total amount of work, if necessary
+		if (config.MaxInstructions > 0 && count >= config.MaxInstructions) {
+			err = InstructionLimit
+			thread.trap(err, config, names)
+			break
+		}
+
+		// (Re)locate the next instruction, based on prior jumps, etc
+		if (thread.current.ip >= len(thread.current.code)) {
+			decodeErr := decodeErrors[thread.current.function]
+			if (decodeErr != nil) {
+				thread.trap(fmt.Errorf("%w: %s", InvalidOpcode, decodeErr),
+					config, names)
+				return nil, InvalidOpcode
+			}
+			err = InvalidIP
+			thread.trap(err, config, names)
+			break
+		}
+
+		// Pause at any breakpoint, etc
+		if (config.Debugger != nil) {
+			err = config.Debugger.check(&thread, names)
+			if (err != nil) {
+				thread.trap(err, config, names)
+				break
+			}
+		}
+		if (config.Profiler != nil) {
+			config.Profiler.check(&thread, names)
+		}
+		current := thread.current.code[ thread.current.ip ]
+		function := uint32(thread.current.function)
+		depth := thread.dataStack.Top() + 1
+		calls := thread.callStack.Top() + 1
+		opcode := current.Opcode
 
 		// Execute the actual bytecode instruction
 		instruction, ok := Opcode[ opcode ]
 		if (!ok) {
-			log.Printf("VM invalid opcode %#x at IP %#x\n",
-				opcode, thread.current.ip)
-			return InvalidOpcode
+			thread.trap(fmt.Errorf("%w %#x (%s)", InvalidOpcode, opcode,
+				current.Name), config, names)
+			return nil, InvalidOpcode
+		}
+		if (config.Fuel != nil) {
+			err = config.Fuel.charge(config.Fuel.Cost(current.Name))
+			if (err != nil) {
+				thread.trap(err, config, names)
+				break
+			}
 		}
 		err = instruction.function(&thread)
+		if (err == nil || err == EndOfBlock || err == ReloadBytecode) {
+			// Retired, rather than trapped
+			thread.stats.update(&thread, current, calls)
+		}
+		if (config.Tracer != nil) {
+			config.Tracer.trace(&thread, function, current, depth, names)
+		}
 
 		// Deal with errors, branches, etc
 		if (err == EndOfBlock && thread.callStack.IsEmpty()) {
@@ -173,30 +532,31 @@ func (vm WASMInterpreter) Execute(module Module, config VMConfig) error {
 			break
 		} else if (err == ReloadBytecode) {
 			// Recache a new bytecode block after a call/ret/jump
-			thread.current.bytecode =
-				codeSection.function[ thread.current.function ].body[:]
+			thread.current.code = decode(thread.current.function)
 		} else if (err != nil) {
-			log.Printf("VM runtime error at IP %#x: %s\n", thread.current.ip, err)
+			thread.trap(err, config, names)
 			break
 		}
 		// else, no error.  Continue executing at next linear IP
 	}
 
-	// Dump any data left on the stack, in the assumption that these are
-	// the result(s) of some function/calculation
+	// Collect any data left on the stack
+	var stack []interface{}
 	for {
 		if (thread.dataStack.IsEmpty()) {
 			break
 		}
-		value, err := thread.dataStack.Pop()
-		if (err != nil) {
-			log.Printf("Thread stack error on exit: %s\n", err)
+		value, popErr := thread.dataStack.Pop()
+		if (popErr != nil) {
+			if (err == nil) {
+				err = popErr
+			}
 			break
 		}
-		log.Printf("Thread stack: %d\n", value.(int32)) //@assume int32 results
+		stack = append(stack, value)
 	}
 	
-	return err
+	return stack, err
 }
 
 
@@ -2,6 +2,8 @@ package wasm
 
 import(
 	"bytes"
+	"errors"
+	"strings"
 	"testing"
     )
 
@@ -18,29 +20,36 @@ func TestVMExecution(t *testing.T) {
     }{
 		// Empty/null module, see samples/empty.wat
         { "empty-module",
-          []byte{ 0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00 },
+          CreateModuleBuilder().Bytes(),
 		  "InvalidFunction",
           MissingFunction },
 
 		// single "nop" function, see samples/fnop.wat
         { "fnop-invalid-entry",
-          []byte{ 0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
-				  0x01, 0x04, 0x01, 0x60, 0x00, 0x00, 0x03, 0x02,
-				  0x01, 0x00, 0x07, 0x08, 0x01, 0x04, 0x66, 0x6e,
-				  0x6f, 0x70, 0x00, 0x00, 0x0a, 0x06, 0x01, 0x04,
-				  0x00, 0x01, 0x01, 0x0b },
+          CreateModuleBuilder().
+			Function("fnop", FunctionType{}, nil,
+				CreateExpression().Nop().Nop()).
+			Bytes(),
 		  "InvalidFunction",
           MissingFunction },
 
 		// single "nop" function, see samples/fnop.wat
         { "fnop",
-          []byte{ 0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
-				  0x01, 0x04, 0x01, 0x60, 0x00, 0x00, 0x03, 0x02,
-				  0x01, 0x00, 0x07, 0x08, 0x01, 0x04, 0x66, 0x6e,
-				  0x6f, 0x70, 0x00, 0x00, 0x0a, 0x06, 0x01, 0x04,
-				  0x00, 0x01, 0x01, 0x0b },
+          CreateModuleBuilder().
+			Function("fnop", FunctionType{}, nil,
+				CreateExpression().Nop().Nop()).
+			Bytes(),
 		  "fnop",
           nil },
+
+		// Trap on "unreachable"
+        { "unreachable",
+          CreateModuleBuilder().
+			Function("trap", FunctionType{}, nil,
+				CreateExpression().Unreachable()).
+			Bytes(),
+		  "trap",
+          UnreachableCode },
 	}
 
     for _, test := range testCases {
@@ -67,3 +76,227 @@ func TestVMExecution(t *testing.T) {
         })
     }
 }
+
+
+//
+// "local.get" with a multi-byte LEB128 index.  The second byte of the index
+// (0x02) must not be executed as an opcode
+//
+func TestVMLocalGetIndex(t *testing.T) {
+	encoded := CreateModuleBuilder().
+		Function("get", FunctionType{}, nil,
+			CreateExpression().LocalGet(256)).
+		Bytes()
+	module, err := ReadModule(bytes.NewReader(encoded))
+	if (err != nil) {
+		t.Fatal("Unexpected decoding status: ", err)
+	}
+
+	config := VMConfig{ StartFn: "get", StartStack: make([]int32, 300) }
+	vm, err := CreateVM(config)
+	if (err != nil) {
+		t.Fatal("Unexpected VM creation error: ", err)
+	}
+	err = vm.Execute(module, config)
+	if (err != nil) {
+		t.Error("Unexpected VM status: ", err)
+	}
+}
+
+
+//
+// Frames beyond the maximum call depth exhaust the call stack
+//
+func TestVMCallDepth(t *testing.T) {
+	thread := WASMInterpreterThread{ callStack: CreateStack(1),
+		dataStack: CreateStack(1), maxCalls: 2 }
+	for depth := 1; depth <= 2; depth++ {
+		err := thread.pushFrame(0)
+		if (err != nil) {
+			t.Fatalf("Unexpected status at depth %d: %v", depth, err)
+		}
+	}
+	err := thread.pushFrame(0)
+	if (err != CallStackExhausted || thread.callStack.Top() != 1) {
+		t.Errorf("Unexpected status %v, depth %d", err,
+			thread.callStack.Top() + 1)
+	}
+
+	// No limit
+	thread.maxCalls = 0
+	err = thread.pushFrame(0)
+	if (err != nil) {
+		t.Error("Unexpected status without limit: ", err)
+	}
+}
+
+//
+// Test invocation with typed arguments + results
+//
+func TestVMInvoke(t *testing.T) {
+	addTwo := FunctionType{ ResultType{ NumTypei32, NumTypei32 },
+		ResultType{ NumTypei32 } }
+	encoded := CreateModuleBuilder().
+		Function("addTwo", addTwo, nil,
+			CreateExpression().LocalGet(0).LocalGet(1).Op(0x6A)).
+		Function("fnop", FunctionType{}, nil, CreateExpression().Nop()).
+		Function("addWide", FunctionType{ ResultType{ NumTypei64, NumTypei64 },
+			ResultType{ NumTypei32 } }, nil,
+			CreateExpression().LocalGet(0).LocalGet(1).Op(0x6A)).
+		Function("first", addTwo, nil, CreateExpression().LocalGet(0)).
+		Function("second", addTwo, nil, CreateExpression().LocalGet(1)).
+		Function("mixed", FunctionType{ ResultType{ NumTypei32, NumTypei64 },
+			ResultType{ NumTypei32 } }, nil,
+			CreateExpression().LocalGet(0).LocalGet(0).Op(0x6A)).
+		Function("declared", FunctionType{ nil, ResultType{ NumTypei32 } },
+			[]ValueType{ NumTypei32 }, CreateExpression().LocalGet(0)).
+		Function("param", FunctionType{ ResultType{ NumTypei32 },
+			ResultType{ NumTypei32 } }, []ValueType{ NumTypei64 },
+			CreateExpression().LocalGet(0)).
+		Function("zero", FunctionType{ ResultType{ NumTypei32 },
+			ResultType{ NumTypei64 } }, []ValueType{ NumTypei64 },
+			CreateExpression().LocalGet(1)).
+		Bytes()
+	module, err := ReadModule(bytes.NewReader(encoded))
+	if (err != nil) {
+		t.Fatal("Unexpected decoding status: ", err)
+	}
+
+	testCases := []struct{
+		name		string
+		function	string
+		args		[]interface{}
+		results		[]interface{}
+		status		error
+	}{
+		{ "addTwo",		"addTwo",	[]interface{}{ int32(3), int32(4) },
+						[]interface{}{ int32(7) },	nil },
+		{ "fnop",		"fnop",		nil,	[]interface{}{},	nil },
+		{ "arity",		"addTwo",	[]interface{}{ int32(3) },
+						nil,		InvalidArgument },
+		{ "type",		"addTwo",	[]interface{}{ int32(3), int64(4) },
+						nil,		InvalidArgument },
+		{ "missing",	"nope",		nil,	nil,	MissingFunction },
+		{ "invalid",	"addWide",	[]interface{}{ int64(1), int64(2) },
+						nil,		TypeMismatch },
+		{ "first",		"first",	[]interface{}{ int32(1), int32(2) },
+						[]interface{}{ int32(1) },	nil },
+		{ "second",		"second",	[]interface{}{ int32(1), int32(2) },
+						[]interface{}{ int32(2) },	nil },
+		{ "mixed",		"mixed",	[]interface{}{ int32(5), int64(9) },
+						[]interface{}{ int32(10) },	nil },
+
+		// Declared locals follow the parameters, zeroed (see also the
+		// FuzzExecute seed local-get-declared-local)
+		{ "declared",	"declared",	nil,	[]interface{}{ int32(0) },	nil },
+		{ "param",		"param",	[]interface{}{ int32(3) },
+						[]interface{}{ int32(3) },	nil },
+		{ "zero",		"zero",		[]interface{}{ int32(3) },
+						[]interface{}{ int64(0) },	nil },
+	}
+
+	vm := WASMInterpreter{}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			results, err := vm.Invoke(module, test.function, test.args,
+				VMConfig{})
+			if (!errors.Is(err, test.status)) {
+				t.Fatal("Unexpected VM status: ", err)
+			}
+			if (len(results) != len(test.results)) {
+				t.Fatal("Unexpected results: ", results)
+			}
+			for i := range results {
+				if (results[i] != test.results[i]) {
+					t.Error("Unexpected results: ", results)
+				}
+			}
+		})
+	}
+}
+
+
+//
+// Test execution statistics
+//
+func TestVMStats(t *testing.T) {
+	module, err := ReadWAT(strings.NewReader(`(module
+		(memory 2)
+		(func (export "add") (param i32 i32) (result i32)
+			local.get 0 local.get 1 i32.add)
+		(func (export "trap") nop unreachable))`))
+	if (err != nil) {
+		t.Fatal("Unexpected assembly error: ", err)
+	}
+
+	// Memory is only allocated by an instance
+	var stats ExecutionStats
+	instance, err := CreateInstance(module, VMConfig{ Stats: &stats })
+	if (err != nil) {
+		t.Fatal("Unexpected instantiation error: ", err)
+	}
+	_, err = instance.Invoke("add", []interface{}{ int32(3), int32(4) })
+	if (err != nil) {
+		t.Fatal("Unexpected VM status: ", err)
+	}
+	if (stats.Instructions != 4 || stats.Opcodes["local.get"] != 2 ||
+		stats.Opcodes["i32.add"] != 1 || stats.Opcodes["end"] != 1 ||
+		len(stats.Calls) != 1 || stats.Calls[0] != 1 ||
+		stats.MaxCallDepth != 1 || stats.MaxStackDepth != 4 ||
+		stats.MemoryHighWater != 2 * PageSize || stats.Elapsed() < 0) {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+
+	// Trapping instructions are not retired
+	_, err = WASMInterpreter{}.Invoke(module, "trap", nil,
+		VMConfig{ Stats: &stats })
+	if (!errors.Is(err, UnreachableCode)) {
+		t.Fatal("Unexpected VM status: ", err)
+	}
+	if (stats.Instructions != 1 || stats.Calls[1] != 1 ||
+		stats.MaxStackDepth != 0 || stats.MemoryHighWater != 0) {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+
+	// The high-water mark is the peak size, rather than the final size
+	stats.updateMemory(instance)
+	instance.memories[0] = instance.memories[0][:PageSize]
+	stats.updateMemory(instance)
+	if (stats.MemoryHighWater != 2 * PageSize) {
+		t.Errorf("Unexpected memory high-water mark %d",
+			stats.MemoryHighWater)
+	}
+}
+
+
+//
+// Test reporting of trap locations
+//
+func TestVMTrap(t *testing.T) {
+	module, err := ReadWATWithOptions(strings.NewReader(`(module
+		(func $trap (export "trap") nop unreachable)
+		(func $bad (export "bad") i32.const 1 drop))`),
+		WATOptions{ DebugNames: true })
+	if (err != nil) {
+		t.Fatal("Unexpected assembly error: ", err)
+	}
+
+	var trap Trap
+	_, err = WASMInterpreter{}.Invoke(module, "trap", nil,
+		VMConfig{ Trap: &trap })
+	if (err != UnreachableCode || !errors.Is(trap, UnreachableCode) ||
+		trap.Function != 0 || trap.Name != "$trap" || trap.Offset != 1 ||
+		len(trap.Backtrace) != 1 || trap.Backtrace[0] != "$trap+0x1") {
+		t.Errorf("Unexpected trap %+v, status %v", trap, err)
+	}
+
+	// Unimplemented instructions
+	trap = Trap{}
+	_, err = WASMInterpreter{}.Invoke(module, "bad", nil,
+		VMConfig{ Trap: &trap })
+	if (err != InvalidOpcode || !errors.Is(trap, InvalidOpcode) ||
+		trap.Name != "$bad" || trap.Offset != 0 ||
+		trap.Error() != "Invalid opcode 0x41 (i32.const) in $bad at IP 0x0") {
+		t.Errorf("Unexpected trap %+v, status %v", trap, err)
+	}
+}
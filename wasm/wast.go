@@ -0,0 +1,723 @@
+package wasm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+
+//
+// WAST script runner.  Executes the scripts of the official WebAssembly spec
+// test suite (.wast files): module definitions, plus actions + assertions
+// against these modules.  Every command is scored as pass or fail, so that
+// conformance can be tracked as the VM matures.  See the "Scripts" section of
+// the reference interpreter README, in the WebAssembly/spec repository
+//
+
+// Summary of a single script
+type WASTResult struct {
+	Passed		int
+	Failed		int
+	Skipped		int			// Unsupported commands, e.g. "(module definition)"
+	Failures	[]string	// Description of each failure, incl. line number
+}
+
+// Upper bound on the instructions executed per action, so that runaway
+// functions eventually fail
+const wastMaxInstructions = 10000000
+
+// Upper bound on the call depth per action, so that unbounded recursion
+// exhausts the call stack (well before the instruction limit)
+const wastMaxCallDepth = 10000
+
+//
+// Host module of the test suite.  Spec scripts import these functions (which
+// only print their arguments), globals, table + memory as "spectest"
+//
+const wastSpectest = `(module
+	(func (export "print"))
+	(func (export "print_i32") (param i32))
+	(func (export "print_i64") (param i64))
+	(func (export "print_f32") (param f32))
+	(func (export "print_f64") (param f64))
+	(func (export "print_i32_f32") (param i32 f32))
+	(func (export "print_f64_f64") (param f64 f64))
+	(global (export "global_i32") i32 (i32.const 666))
+	(global (export "global_i64") i64 (i64.const 666))
+	(global (export "global_f32") f32 (f32.const 666.6))
+	(global (export "global_f64") f64 (f64.const 666.6))
+	(table (export "table") 10 20 funcref)
+	(memory (export "memory") 1 2))`
+
+
+//
+// Run an entire script.  Fails only if the script itself is malformed (e.g.,
+// unbalanced parentheses); the outcome of each command is recorded in the
+// result instead
+//
+func RunWAST(reader io.Reader) (WASTResult, error) {
+	source, err := io.ReadAll(reader)
+	if (err != nil) {
+		return WASTResult{}, err
+	}
+	tokens, err := lexWAT(string(source))
+	if (err != nil) {
+		return WASTResult{}, err
+	}
+	commands, err := parseWatNodes(tokens)
+	if (err != nil) {
+		return WASTResult{}, err
+	}
+
+	runner, err := createWastRunner()
+	if (err != nil) {
+		return WASTResult{}, err
+	}
+	for _, command := range commands {
+		if (!command.list || len(command.children) == 0) {
+			return runner.result, command.errorf("expected command, found %s",
+				command)
+		}
+		err = runner.run(command)
+		if (errors.Is(err, errWastSkipped)) {
+			runner.result.Skipped++
+		} else if (err != nil) {
+			runner.result.Failed++
+			runner.result.Failures = append(runner.result.Failures,
+				fmt.Sprintf("line %d: %s: %s", command.token.line,
+					command.children[0].token.text, err))
+		} else {
+			runner.result.Passed++
+		}
+	}
+
+	return runner.result, nil
+}
+
+var errWastSkipped = errors.New("unsupported command")
+
+
+// Script state: the defined modules, by $id + by registered name
+type wastRunner struct {
+	current		*Module					// Most recently defined module
+	modules		map[string]*Module		// By $id
+	registered	map[string]*Module		// By import (module) name
+	instances	map[*Module]*Instance	// Runtime state of each module
+	result		WASTResult
+}
+
+// Factory function for generating a runner with only the spectest module
+// registered.  No side effects.
+func createWastRunner() (*wastRunner, error) {
+	spectest, err := ReadWAT(strings.NewReader(wastSpectest))
+	if (err != nil) {
+		return nil, err
+	}
+	return &wastRunner{
+		modules:	make(map[string]*Module),
+		registered:	map[string]*Module{ "spectest": &spectest },
+		instances:	make(map[*Module]*Instance),
+	}, nil
+}
+
+// Run a single command, returning nil if it passed
+func (runner *wastRunner) run(command watNode) error {
+	cursor := listCursor(command)
+	keyword := command.children[0].token.text
+	if (strings.HasPrefix(keyword, "assert_") && cursor.done()) {
+		return cursor.errorf("expected module or action")
+	}
+
+	switch(keyword) {
+		case "module":
+			id, module, err := runner.define(command)
+			if (err != nil) {
+				return err
+			}
+			err = runner.instantiate(module)
+			if (err != nil) {
+				return err
+			}
+			runner.current = module
+			if (id != "") {
+				runner.modules[id] = module
+			}
+			return nil
+
+		case "register":
+			name, ok := cursor.atom(watTokenString)
+			if !ok {
+				return cursor.errorf("expected module name")
+			}
+			module, err := runner.module(cursor)
+			if (err != nil) {
+				return err
+			}
+			runner.registered[name.text] = module
+			return nil
+
+		case "invoke", "get":
+			_, err := runner.action(command)
+			return err
+
+		case "assert_return":
+			return runner.assertReturn(cursor)
+
+		case "assert_trap":
+			// Either a trapping action, or a module whose start function traps
+			action := cursor.next()
+			if (action.isList("module")) {
+				return runner.assertUninstantiable(action, cursor)
+			}
+			_, err := runner.action(action)
+			return matchWastTrap(err, cursor, wastTraps)
+
+		case "assert_exhaustion":
+			// Call stack exhaustion, i.e. unbounded recursion
+			_, err := runner.action(cursor.next())
+			return matchWastTrap(err, cursor, wastExhaustion)
+
+		case "assert_malformed":
+			// Rejected by the decoder (binary) or assembler (text)
+			_, _, err := runner.load(cursor.next())
+			if (errors.Is(err, errWastSkipped)) {
+				return err
+			} else if (errors.Is(err, UnsupportedWAT)) {
+				return fmt.Errorf("%w: %s", errWastSkipped, err)
+			} else if (err == nil) {
+				return errors.New("expected malformed module")
+			} else if (!errors.Is(err, InvalidModule) &&
+				!errors.Is(err, InvalidWAT)) {
+				return fmt.Errorf("expected malformed module, failed with %w",
+					err)
+			}
+			return nil
+
+		case "assert_invalid":
+			// Well-formed, but rejected by validation
+			_, module, err := runner.load(cursor.next())
+			if (errors.Is(err, errWastSkipped)) {
+				return err
+			} else if (errors.Is(err, UnsupportedWAT)) {
+				return fmt.Errorf("%w: %s", errWastSkipped, err)
+			} else if (err != nil) {
+				return fmt.Errorf("expected invalid module, found malformed " +
+					"module: %w", err)
+			} else if (module.Validate() == nil) {
+				return errors.New("expected invalid module")
+			}
+			return nil
+
+		case "assert_unlinkable":
+			_, module, err := runner.define(cursor.next())
+			if (err != nil) {
+				return err
+			}
+			err = runner.link(*module)
+			if (err == nil) {
+				return errors.New("expected unlinkable module")
+			}
+			return nil
+
+		case "assert_uninstantiable":
+			return runner.assertUninstantiable(cursor.next(), cursor)
+	}
+
+	//@"(module definition)", "(module instance)", threads, etc
+	return errWastSkipped
+}
+
+
+//
+// Modules
+//
+
+//
+// Decode or assemble the module of a module command.  Returns its $id, if
+// any.  No side effects.
+//
+func (runner *wastRunner) load(node watNode) (string, *Module, error) {
+	if (!node.isList("module")) {
+		return "", nil, node.errorf("expected module, found %s", node)
+	}
+	cursor := listCursor(node)
+	id := cursor.id()
+
+	var module Module
+	var err error
+	if (cursor.keyword("binary")) {
+		var encoded []byte
+		encoded, err = parseWatStrings(cursor)
+		if (err == nil) {
+			module, err = ReadModule(bytes.NewReader(encoded))
+		}
+	} else if (cursor.keyword("quote")) {
+		var source []byte
+		source, err = parseWatStrings(cursor)
+		if (err == nil) {
+			module, err = ReadWAT(bytes.NewReader(source))
+		}
+	} else if (cursor.keyword("definition") || cursor.keyword("instance")) {
+		return "", nil, errWastSkipped
+	} else {
+		module, err = assembleWatModule(node, WATOptions{})
+	}
+
+	return id, &module, err
+}
+
+// Load + validate a module
+func (runner *wastRunner) define(node watNode) (string, *Module, error) {
+	id, module, err := runner.load(node)
+	if (err != nil) {
+		return "", nil, err
+	}
+	err = module.Validate()
+	if (err != nil) {
+		return "", nil, err
+	}
+	return id, module, nil
+}
+
+// Link + instantiate a module, running its start function, if any
+func (runner *wastRunner) instantiate(module *Module) error {
+	instance, err := runner.createInstance(module)
+	if (err != nil) {
+		return err
+	}
+	runner.instances[module] = instance
+	return nil
+}
+
+//
+// Link a module, then instantiate it against the instances of the registered
+// modules that it imports from
+//
+func (runner *wastRunner) createInstance(module *Module) (*Instance, error) {
+	err := runner.link(*module)
+	if (err != nil) {
+		return nil, err
+	}
+	imports := make(map[string]*Instance)
+	section, _ := module.section(ImportSectionId).(ImportSection)
+	for _, imp := range section.imports {
+		imports[imp.module], err =
+			runner.instance(runner.registered[imp.module])
+		if (err != nil) {
+			return nil, err
+		}
+	}
+	return CreateLinkedInstance(*module,
+		VMConfig{ MaxInstructions: wastMaxInstructions,
+			MaxCallDepth: wastMaxCallDepth }, imports)
+}
+
+// A module whose instantiation traps, followed by the expected message
+func (runner *wastRunner) assertUninstantiable(node watNode,
+	cursor *watCursor) error {
+	_, module, err := runner.define(node)
+	if (err != nil) {
+		return err
+	}
+	_, err = runner.createInstance(module)
+	return matchWastTrap(err, cursor, wastTraps)
+}
+
+// Module named by an optional $id, else the current module
+func (runner *wastRunner) module(cursor *watCursor) (*Module, error) {
+	if id := cursor.id(); id != "" {
+		module, ok := runner.modules[id]
+		if !ok {
+			return nil, fmt.Errorf("unknown module %s", id)
+		}
+		return module, nil
+	}
+	if (runner.current == nil) {
+		return nil, errors.New("no current module")
+	}
+	return runner.current, nil
+}
+
+
+//
+// Resolve every import of a module against the exports of the registered
+// modules.  Each import must match the kind + type of the export.  See
+// section 4.5.4 of WASM spec
+//
+func (runner *wastRunner) link(module Module) error {
+	section, ok := module.section(ImportSectionId).(ImportSection)
+	if !ok {
+		return nil
+	}
+	context := newModuleContext(module)
+
+	for _, imp := range section.imports {
+		exporter, ok := runner.registered[imp.module]
+		if !ok {
+			return fmt.Errorf("%w: unknown module \"%s\"", UnlinkableImport,
+				imp.module)
+		}
+		exports, _ := exporter.section(ExportSectionId).(ExportSection)
+		export, ok := exports.export[imp.name]
+		if (!ok || export.etype != imp.itype) {
+			return fmt.Errorf("%w: unknown import \"%s\" \"%s\"",
+				UnlinkableImport, imp.module, imp.name)
+		}
+
+		exporterContext := newModuleContext(*exporter)
+		var match bool
+		switch(imp.itype) {
+			case ExportTypeFunction:
+				match = equalFunctionTypes(
+					typeOrEmpty(context, imp.function),
+					typeOrEmpty(exporterContext,
+						exporterContext.functions[export.index]))
+			case ExportTypeTable:
+				table := exporterContext.tables[export.index]
+				match = (table.reftype == imp.table.reftype &&
+					matchLimits(table.limit, imp.table.limit))
+			case ExportTypeMemory:
+				memory := exporterContext.memories[export.index]
+				match = matchLimits(memory.limit, imp.memory.limit)
+			case ExportTypeGlobal:
+				match = (exporterContext.globals[export.index] == imp.global)
+		}
+		if (!match) {
+			return fmt.Errorf("%w: incompatible import type \"%s\" \"%s\"",
+				UnlinkableImport, imp.module, imp.name)
+		}
+	}
+
+	return nil
+}
+
+// Does the limit of an export satisfy the limit of an import?  No side
+// effects.
+func matchLimits(actual, expected Limit) bool {
+	if (actual.min < expected.min) {
+		return false
+	}
+	if (expected.flags == 0) {
+		return true
+	}
+	return (actual.flags != 0 && actual.max <= expected.max)
+}
+
+
+//
+// Actions + results
+//
+
+//
+// Run an "(invoke $id? name arg*)" or "(get $id? name)" action, returning the
+// results in order
+//
+func (runner *wastRunner) action(node watNode) ([]interface{}, error) {
+	if (!node.list || len(node.children) == 0) {
+		return nil, node.errorf("expected action, found %s", node)
+	}
+	cursor := listCursor(node)
+	module, err := runner.module(cursor)
+	if (err != nil) {
+		return nil, err
+	}
+	name, ok := cursor.atom(watTokenString)
+	if !ok {
+		return nil, cursor.errorf("expected export name")
+	}
+
+	switch(node.children[0].token.text) {
+		case "invoke":
+			var args []interface{}
+			for !cursor.done() {
+				value, err := parseWastValue(cursor.next())
+				if (err != nil) {
+					return nil, err
+				}
+				arg, err := value.vm()
+				if (err != nil) {
+					return nil, err
+				}
+				args = append(args, arg)
+			}
+			return runner.invoke(module, name.text, args)
+
+		case "get":
+			return runner.get(module, name.text)
+	}
+
+	return nil, node.errorf("unknown action %s", node)
+}
+
+func (runner *wastRunner) invoke(module *Module, name string,
+	args []interface{}) ([]interface{}, error) {
+	instance, err := runner.instance(module)
+	if (err != nil) {
+		return nil, err
+	}
+	results, err := instance.Invoke(name, args)
+	if (errors.Is(err, MissingFunction)) {
+		return nil, fmt.Errorf("%w \"%s\"", err, name)
+	}
+	return results, err
+}
+
+// Current value of an exported global
+func (runner *wastRunner) get(module *Module, name string) ([]interface{},
+	error) {
+	instance, err := runner.instance(module)
+	if (err != nil) {
+		return nil, err
+	}
+	section, _ := module.section(ExportSectionId).(ExportSection)
+	export, ok := section.export[name]
+	if (!ok || export.etype != ExportTypeGlobal) {
+		return nil, fmt.Errorf("%w \"%s\"", MissingGlobal, name)
+	}
+	value, err := instance.Global(export.index)
+	if (err != nil) {
+		return nil, err
+	}
+	return []interface{}{ value }, nil
+}
+
+// Instance of a module, e.g. for an action.  Registered modules that were
+// never defined by a script (i.e., spectest) are instantiated on first use
+func (runner *wastRunner) instance(module *Module) (*Instance, error) {
+	instance, ok := runner.instances[module]
+	if ok {
+		return instance, nil
+	}
+	instance, err := runner.createInstance(module)
+	if (err != nil) {
+		return nil, err
+	}
+	runner.instances[module] = instance
+	return instance, nil
+}
+
+func (runner *wastRunner) assertReturn(cursor *watCursor) error {
+	if (cursor.done()) {
+		return cursor.errorf("expected action")
+	}
+	results, err := runner.action(cursor.next())
+	if (err != nil) {
+		return err
+	}
+
+	var expected []wastValue
+	for !cursor.done() {
+		value, err := parseWastValue(cursor.next())
+		if (err != nil) {
+			return err
+		}
+		expected = append(expected, value)
+	}
+
+	if (len(results) != len(expected)) {
+		return fmt.Errorf("expected %d results, found %d", len(expected),
+			len(results))
+	}
+	for i, value := range expected {
+		if (!value.match(results[i])) {
+			return fmt.Errorf("result %d: expected %s, found %v", i, value,
+				results[i])
+		}
+	}
+	return nil
+}
+
+// Runtime error, with its message in the spec test suite
+type wastTrap struct {
+	err		error
+	message	string
+}
+
+// Runtime traps.  Any other error is a failure of the VM (or script), rather
+// than a trap
+var wastTraps = []wastTrap{
+	{ UnreachableCode,	"unreachable" },
+	{ OutOfBounds,		"out of bounds memory access" },
+}
+
+// Resource exhaustion, which is distinct from a trap
+var wastExhaustion = []wastTrap{
+	{ CallStackExhausted,	"call stack exhausted" },
+}
+
+//
+// Check that an action failed with one of the given traps, identified by the
+// expected message (the next string of the command), which must be a prefix
+// of the message of the actual trap.  Instructions not yet implemented skip
+// the assertion.  No side effects.
+//
+func matchWastTrap(err error, cursor *watCursor, traps []wastTrap) error {
+	expected, ok := cursor.atom(watTokenString)
+	if !ok {
+		return cursor.errorf("expected failure message")
+	}
+	if (err == nil) {
+		return fmt.Errorf("expected trap \"%s\"", expected.text)
+	} else if (errors.Is(err, InvalidOpcode)) {
+		return fmt.Errorf("%w: %s", errWastSkipped, err)
+	}
+	for _, trap := range traps {
+		if (!errors.Is(err, trap.err)) {
+			continue
+		} else if (!strings.HasPrefix(trap.message, expected.text)) {
+			return fmt.Errorf("expected trap \"%s\", found \"%s\"",
+				expected.text, trap.message)
+		}
+		return nil
+	}
+	return fmt.Errorf("expected trap \"%s\", failed with %w", expected.text,
+		err)
+}
+
+
+//
+// Constant argument or expected result, e.g. "(i32.const 1)" or
+// "(f32.const nan:canonical)"
+//
+type wastValue struct {
+	vtype	ValueType
+	bits	uint64
+	v128	[16]byte
+	nan		string		// "canonical" or "arithmetic", for results only
+	text	string		// Original literal, for diagnostics
+}
+
+// Factory function for parsing + returning a single constant.  No side
+// effects.
+func parseWastValue(node watNode) (wastValue, error) {
+	if (!node.list || len(node.children) < 2 || node.children[0].list) {
+		return wastValue{}, node.errorf("expected constant, found %s", node)
+	}
+	literal := node.children[1]
+	value := wastValue{ text: node.children[0].token.text + " " +
+		literal.token.text }
+
+	// Vectors: shape + lanes
+	if (node.children[0].isKeyword("v128.const")) {
+		value.vtype = VecTypev128
+		cursor := listCursor(node)
+		lanes, err := parseWatV128(cursor)
+		if (err == nil) {
+			err = cursor.end()
+		}
+		copy(value.v128[:], lanes)
+		return value, err
+	} else if (len(node.children) != 2) {
+		return wastValue{}, node.errorf("expected constant, found %s", node)
+	}
+
+	var err error
+	switch(node.children[0].token.text) {
+		case "i32.const":
+			value.vtype = NumTypei32
+			value.bits, err = parseWatInteger(literal, 32)
+			value.bits &= math.MaxUint32
+		case "i64.const":
+			value.vtype = NumTypei64
+			value.bits, err = parseWatInteger(literal, 64)
+		case "f32.const":
+			value.vtype = NumTypef32
+			value.nan = wastNaNPattern(literal)
+			if (value.nan == "") {
+				value.bits, err = parseWatFloat(literal, 32)
+			}
+		case "f64.const":
+			value.vtype = NumTypef64
+			value.nan = wastNaNPattern(literal)
+			if (value.nan == "") {
+				value.bits, err = parseWatFloat(literal, 64)
+			}
+		default:
+			//@reference values + v128 lane patterns
+			return value, node.errorf("unsupported constant %s", node)
+	}
+
+	return value, err
+}
+
+// NaN pattern of an expected result, if any
+func wastNaNPattern(literal watNode) string {
+	text := strings.TrimLeft(literal.token.text, "+-")
+	if (text == "nan:canonical" || text == "nan:arithmetic") {
+		return text[len("nan:"):]
+	}
+	return ""
+}
+
+func (value wastValue) String() string {
+	return value.text
+}
+
+// Equivalent VM value.  No side effects.
+func (value wastValue) vm() (interface{}, error) {
+	if (value.nan != "") {
+		return nil, fmt.Errorf("invalid argument %s", value)
+	}
+	switch(value.vtype) {
+		case NumTypei32:	return int32(value.bits), nil
+		case NumTypei64:	return int64(value.bits), nil
+		case NumTypef32:	return math.Float32frombits(uint32(value.bits)), nil
+		case NumTypef64:	return math.Float64frombits(value.bits), nil
+		case VecTypev128:	return value.v128, nil
+	}
+	return nil, fmt.Errorf("unsupported argument %s", value)
+}
+
+//
+// Does a VM result match this expected value?  Floats are compared by bit
+// pattern, or by NaN pattern: a canonical NaN has only the top bit of its
+// payload set, an arithmetic NaN has at least this bit set.  No side effects.
+//
+func (value wastValue) match(result interface{}) bool {
+	var bits uint64
+	var payload, quiet uint64
+
+	switch(value.vtype) {
+		case NumTypei32:
+			actual, ok := result.(int32)
+			return (ok && uint32(actual) == uint32(value.bits))
+		case NumTypei64:
+			actual, ok := result.(int64)
+			return (ok && uint64(actual) == value.bits)
+		case VecTypev128:
+			actual, ok := result.([16]byte)
+			return (ok && actual == value.v128)
+		case NumTypef32:
+			actual, ok := result.(float32)
+			if !ok {
+				return false
+			}
+			bits = uint64(math.Float32bits(actual))
+			payload, quiet = bits & 0x7FFFFF, 0x400000
+			if (value.nan != "" && !math.IsNaN(float64(actual))) {
+				return false
+			}
+		case NumTypef64:
+			actual, ok := result.(float64)
+			if !ok {
+				return false
+			}
+			bits = math.Float64bits(actual)
+			payload, quiet = bits & 0xFFFFFFFFFFFFF, 0x8000000000000
+			if (value.nan != "" && !math.IsNaN(actual)) {
+				return false
+			}
+		default:
+			return false
+	}
+
+	switch(value.nan) {
+		case "canonical":	return (payload == quiet)
+		case "arithmetic":	return (payload & quiet != 0)
+	}
+	return (bits == value.bits)
+}
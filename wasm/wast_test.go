@@ -0,0 +1,217 @@
+package wasm
+
+import(
+	"math"
+	"strings"
+	"testing"
+	)
+
+
+// Module for exercising the script runner, within the limits of the VM
+const wastTestModule = `(module $m
+	(func (export "add") (param i32 i32) (result i32)
+		local.get 0 local.get 1 i32.add)
+	(func (export "trap") unreachable)
+	(func (export "unsupported") (result i32)
+		i32.const 1 i32.const 2 i32.sub)
+	(global (export "answer") i64 (i64.const 42)))
+`
+
+//
+// Test scoring of each script command
+//
+func TestRunWAST(t *testing.T) {
+	testCases := []struct{
+		name		string
+		script		string
+		passed		int
+		failed		int
+		skipped		int
+	}{
+		{ "return",
+		  `(assert_return (invoke "add" (i32.const 1) (i32.const 2))
+			(i32.const 3))`,								2, 0, 0 },
+		{ "return-mismatch",
+		  `(assert_return (invoke "add" (i32.const 1) (i32.const 2))
+			(i32.const 4))`,								1, 1, 0 },
+		{ "return-count",
+		  `(assert_return (invoke "add" (i32.const 1) (i32.const 2)))`,
+															1, 1, 0 },
+		{ "return-unsupported",
+		  `(assert_return (invoke "unsupported") (i32.const -1))`,
+															1, 1, 0 },
+		{ "return-by-id",
+		  `(module) (assert_return (invoke $m "add" (i32.const 1)
+			(i32.const -1)) (i32.const 0))`,				3, 0, 0 },
+		{ "trap",
+		  `(assert_trap (invoke "trap") "unreachable")`,	2, 0, 0 },
+		{ "trap-missing",
+		  `(assert_trap (invoke "add" (i32.const 0) (i32.const 0))
+			"unreachable")`,								1, 1, 0 },
+		{ "trap-unsupported",
+		  `(assert_trap (invoke "unsupported") "overflow")`,	1, 0, 1 },
+		{ "trap-prefix",
+		  `(assert_trap (invoke "trap") "unreach")`,		2, 0, 0 },
+		{ "trap-message",
+		  `(assert_trap (invoke "trap") "integer overflow")`,	1, 1, 0 },
+		{ "invoke",
+		  `(invoke "trap") (invoke "nope")`,				1, 2, 0 },
+		{ "get",
+		  `(assert_return (get "answer") (i64.const 42))
+			(assert_return (get $m "answer") (i64.const 41))`,	2, 1, 0 },
+		{ "get-missing",
+		  `(get "add") (assert_return (get "nope") (i32.const 0))`,
+															1, 2, 0 },
+		{ "invalid",
+		  `(assert_invalid (module (func (result i32) i64.const 0))
+			"type mismatch")`,								2, 0, 0 },
+		{ "invalid-valid",
+		  `(assert_invalid (module (func)) "type mismatch")`,	1, 1, 0 },
+		{ "invalid-malformed",
+		  `(assert_invalid (module binary "\00asm\02\00\00\00")
+			"type mismatch")`,								1, 1, 0 },
+		{ "invalid-unsupported",
+		  `(assert_invalid (module (func return_call 0))
+			"type mismatch")`,								1, 0, 1 },
+		{ "malformed-quote",
+		  `(assert_malformed (module quote "(func i32.bogus)")
+			"unknown operator")`,							2, 0, 0 },
+		{ "malformed-binary",
+		  `(assert_malformed (module binary "\00asm\02\00\00\00")
+			"unknown binary version")`,						2, 0, 0 },
+		{ "malformed-unsupported",
+		  `(assert_malformed (module quote "(memory 1 shared)")
+			"shared memory must have maximum")`,			1, 0, 1 },
+		{ "malformed-valid",
+		  `(assert_malformed (module binary "\00asm\01\00\00\00")
+			"unknown binary version")`,						1, 1, 0 },
+		{ "register",
+		  `(register "lib" $m)
+			(module (import "lib" "add" (func (param i32 i32) (result i32))))`,
+															3, 0, 0 },
+		{ "spectest",
+		  `(module (import "spectest" "print_i32" (func (param i32)))
+			(import "spectest" "memory" (memory 1))
+			(import "spectest" "global_i32" (global i32)))`,	2, 0, 0 },
+		{ "linked-globals",
+		  `(module $a (global (export "g") i32 (i32.const 7))
+			(global (export "m") (mut i32) (i32.const 1)))
+			(register "a" $a)
+			(module (import "a" "g" (global i32))
+			(import "a" "m" (global (mut i32)))
+			(import "spectest" "global_i32" (global i32))
+			(export "g" (global 0)) (export "s" (global 2))
+			(func (export "set") (param i32) local.get 0 global.set 1))
+			(assert_return (get "g") (i32.const 7))
+			(assert_return (get "s") (i32.const 666))
+			(invoke "set" (i32.const 5))
+			(assert_return (get $a "m") (i32.const 5))`,	8, 0, 0 },
+		{ "unlinkable-name",
+		  `(assert_unlinkable (module (import "spectest" "nope" (func)))
+			"unknown import")`,								2, 0, 0 },
+		{ "unlinkable-type",
+		  `(assert_unlinkable (module (import "spectest" "print_i32"
+			(func (param i64)))) "incompatible import type")`,	2, 0, 0 },
+		{ "unlinkable-limits",
+		  `(assert_unlinkable (module (import "spectest" "memory"
+			(memory 1 1))) "incompatible import type")`,	2, 0, 0 },
+		{ "unlinkable-linked",
+		  `(assert_unlinkable (module (import "spectest" "table"
+			(table 5 funcref))) "incompatible import type")`,	1, 1, 0 },
+		{ "uninstantiable",
+		  `(assert_trap (module (func $f unreachable) (start $f))
+			"unreachable")`,								2, 0, 0 },
+		{ "uninstantiable-data",
+		  `(assert_trap (module (memory 0) (data (i32.const 0) "a"))
+			"out of bounds memory access")`,				2, 0, 0 },
+		{ "uninstantiable-message",
+		  `(assert_trap (module (func $f unreachable) (start $f))
+			"out of bounds")`,								1, 1, 0 },
+		{ "exhaustion",
+		  `(assert_exhaustion (invoke "add" (i32.const 0) (i32.const 0))
+			"call stack exhausted")`,						1, 1, 0 },
+		{ "exhaustion-unsupported",
+		  `(module (func $f (export "f") call $f))
+			(assert_exhaustion (invoke "f")
+			"call stack exhausted")`,						2, 0, 1 },
+		{ "skipped",
+		  `(module definition $d) (assert_malformed (module definition)
+			"") (thread $t)`,								1, 0, 3 },
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := RunWAST(strings.NewReader(wastTestModule +
+				test.script))
+			if (err != nil) {
+				t.Fatal("Unexpected script error: ", err)
+			}
+			if (result.Passed != test.passed || result.Failed != test.failed ||
+				result.Skipped != test.skipped ||
+				len(result.Failures) != test.failed) {
+				t.Errorf("Unexpected result: %+v", result)
+			}
+		})
+	}
+
+	// Malformed scripts
+	for _, script := range []string{ "(module", "module", "()" } {
+		_, err := RunWAST(strings.NewReader(script))
+		if (err == nil) {
+			t.Errorf("Unexpected success for %s", script)
+		}
+	}
+}
+
+
+//
+// Test matching of expected results, including NaN patterns
+//
+func TestWASTResultMatching(t *testing.T) {
+	canonical32 := math.Float32frombits(0x7FC00000)
+	arithmetic32 := math.Float32frombits(0xFFC00001)
+	signaling64 := math.Float64frombits(0x7FF0000000000001)
+
+	testCases := []struct{
+		expected	string
+		result		interface{}
+		match		bool
+	}{
+		{ "(i32.const -1)",				int32(-1),				true },
+		{ "(i32.const 0xFFFFFFFF)",		int32(-1),				true },
+		{ "(i32.const 1)",				int64(1),				false },
+		{ "(i64.const -2)",				int64(-2),				true },
+		{ "(f32.const 1.5)",			float32(1.5),			true },
+		{ "(f32.const -0)",				float32(0),				false },
+		{ "(f64.const nan:0x1)",		signaling64,			true },
+		{ "(f32.const nan:canonical)",	canonical32,			true },
+		{ "(f32.const nan:canonical)",	arithmetic32,			false },
+		{ "(f32.const nan:arithmetic)",	arithmetic32,			true },
+		{ "(f64.const nan:arithmetic)",	signaling64,			false },
+		{ "(f64.const nan:canonical)",	float64(1),				false },
+	}
+
+	for _, test := range testCases {
+		t.Run(test.expected, func(t *testing.T) {
+			nodes, err := parseWatNodes(mustLexWAT(t, test.expected))
+			if (err != nil) {
+				t.Fatal("Unexpected parsing error: ", err)
+			}
+			value, err := parseWastValue(nodes[0])
+			if (err != nil) {
+				t.Fatal("Unexpected parsing error: ", err)
+			}
+			if (value.match(test.result) != test.match) {
+				t.Errorf("Unexpected match status for %v", test.result)
+			}
+		})
+	}
+}
+
+func mustLexWAT(t *testing.T, source string) []watToken {
+	tokens, err := lexWAT(source)
+	if (err != nil) {
+		t.Fatal("Unexpected lexing error: ", err)
+	}
+	return tokens
+}
@@ -0,0 +1,631 @@
+package wasm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+
+//
+// WAT emitter.  Prints an entire module in the WebAssembly text format, from
+// its decoded sections, such that the output can be re-assembled into an
+// equivalent binary module (e.g., via wat2wasm).  Entities named by the name
+// section are given symbolic $identifiers, and are referenced by these; any
+// other entity is referenced by index.  See chapter 6 of WASM spec
+//
+
+// Instruction styles for function bodies
+type WATStyle uint8
+
+const (
+	// Linear sequence of instructions, with explicit "else" + "end"
+	WATFlat WATStyle = iota
+
+	// Structured instructions (block, loop, if) as nested s-expressions.
+	// Plain instructions are left unfolded, i.e., operands are still
+	// pushed by the preceding instructions
+	WATFolded
+)
+
+var errMalformedBody = errors.New("malformed function body")
+
+
+// Symbolic identifiers for each index space, derived from the name section
+type watIds struct {
+	module		string
+	types		map[uint32]string
+	functions	map[uint32]string
+	tables		map[uint32]string
+	memories	map[uint32]string
+	globals		map[uint32]string
+	elements	map[uint32]string
+	data		map[uint32]string
+	locals		map[uint32]map[uint32]string
+}
+
+// Factory function for generating the identifiers of a module.  Names that
+// are not valid (or not unique) WAT identifiers are dropped, so that the
+// corresponding entities fall back to numeric indices.  No side effects.
+func createWatIds(names NameSection) watIds {
+	ids := watIds{
+		types:		watIdMap(names.Types),
+		functions:	watIdMap(names.Functions),
+		tables:		watIdMap(names.Tables),
+		memories:	watIdMap(names.Memories),
+		globals:	watIdMap(names.Globals),
+		elements:	watIdMap(names.Elements),
+		data:		watIdMap(names.Data),
+		locals:		make(map[uint32]map[uint32]string),
+	}
+	if (isWatId(names.Module)) {
+		ids.module = "$" + names.Module
+	}
+	for function, locals := range names.Locals {
+		ids.locals[function] = watIdMap(locals)
+	}
+	return ids
+}
+
+func watIdMap(names NameMap) map[uint32]string {
+	ids := make(map[uint32]string)
+	seen := make(map[string]bool)
+	for _, index := range names.indices() {
+		name := names[index]
+		if (isWatId(name) && !seen[name]) {
+			ids[index] = "$" + name
+			seen[name] = true
+		}
+	}
+	return ids
+}
+
+// Is this name a valid WAT identifier (following the "$")?  See section
+// 6.3.5 of WASM spec
+func isWatId(name string) bool {
+	if (name == "") {
+		return false
+	}
+	for _, c := range []byte(name) {
+		if (c <= ' ' || c >= 0x7F || strings.IndexByte("\"(),;[]{}", c) >= 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// Reference to an entity: its identifier if any, or else its index
+func watRef(ids map[uint32]string, index uint32) string {
+	if id, ok := ids[index]; ok {
+		return id
+	}
+	return strconv.FormatUint(uint64(index), 10)
+}
+
+// Definition of an entity: its identifier if any, or else an index comment
+func watDef(ids map[uint32]string, index uint32) string {
+	if id, ok := ids[index]; ok {
+		return id
+	}
+	return fmt.Sprintf("(;%d;)", index)
+}
+
+
+//
+// Accumulates the output lines, with indentation.  Closing parentheses are
+// appended to the preceding line, in the usual WAT/Lisp style
+//
+type watWriter struct {
+	lines	[]string
+	indent	int
+}
+
+func (writer *watWriter) line(format string, args ...interface{}) {
+	writer.lines = append(writer.lines, strings.Repeat("  ", writer.indent) +
+		fmt.Sprintf(format, args...))
+}
+
+func (writer *watWriter) open(format string, args ...interface{}) {
+	writer.line("(" + format, args...)
+	writer.indent++
+}
+
+func (writer *watWriter) close() {
+	writer.indent--
+	writer.lines[len(writer.lines) - 1] += ")"
+}
+
+
+//
+// Write the entire module as WAT, with function bodies in the given style.
+// Fails only if the writer fails.  Any malformed function body is emitted up
+// to the malformed instruction, followed by a comment
+//
+func (module Module) WriteWAT(w io.Writer, style WATStyle) error {
+	context := newModuleContext(module)
+	ids := createWatIds(module.Names())
+	writer := &watWriter{}
+
+	if (ids.module != "") {
+		writer.open("module %s", ids.module)
+	} else {
+		writer.open("module")
+	}
+
+	for i, ftype := range context.types {
+		writer.line("(type %s (func%s))", watDef(ids.types, uint32(i)),
+			watSignature(ftype, nil))
+	}
+
+	// Imports.  Each index space begins with its imported entities
+	var functions, tables, memories, globals uint32
+	if section, ok := module.section(ImportSectionId).(ImportSection); ok {
+		for _, imp := range section.imports {
+			var desc string
+			switch(imp.itype) {
+				case ExportTypeFunction:
+					desc = fmt.Sprintf("func %s (type %s)%s",
+						watDef(ids.functions, functions),
+						watRef(ids.types, imp.function),
+						watSignature(typeOrEmpty(context, imp.function), nil))
+					functions++
+				case ExportTypeTable:
+					desc = fmt.Sprintf("table %s %s",
+						watDef(ids.tables, tables), watTableType(imp.table))
+					tables++
+				case ExportTypeMemory:
+					desc = fmt.Sprintf("memory %s %s",
+						watDef(ids.memories, memories), watLimit(imp.memory.limit))
+					memories++
+				case ExportTypeGlobal:
+					desc = fmt.Sprintf("global %s %s",
+						watDef(ids.globals, globals), watGlobalType(imp.global))
+					globals++
+			}
+			writer.line("(import %s %s (%s))", watString([]byte(imp.module)),
+				watString([]byte(imp.name)), desc)
+		}
+	}
+
+	// Functions
+	if section, ok := module.section(CodeSectionId).(CodeSection); ok {
+		for i, function := range section.function {
+			index := functions + uint32(i)
+			tindex := uint32(0)
+			if (int(index) < len(context.functions)) {
+				tindex = context.functions[index]
+			}
+			writeWatFunction(writer, ids, index, tindex,
+				typeOrEmpty(context, tindex), function, style)
+		}
+	}
+
+	if section, ok := module.section(TableSectionId).(TableSection); ok {
+		for i, table := range section.table {
+			writer.line("(table %s %s)", watDef(ids.tables, tables + uint32(i)),
+				watTableType(table))
+		}
+	}
+	if section, ok := module.section(MemorySectionId).(MemorySection); ok {
+		for i, memory := range section.memory {
+			writer.line("(memory %s %s)",
+				watDef(ids.memories, memories + uint32(i)),
+				watLimit(memory.limit))
+		}
+	}
+	if section, ok := module.section(GlobalSectionId).(GlobalSection); ok {
+		for i, global := range section.global {
+			writer.line("(global %s %s %s)",
+				watDef(ids.globals, globals + uint32(i)),
+				watGlobalType(global.gtype), watExpression(ids, global.init))
+		}
+	}
+
+	if section, ok := module.section(ExportSectionId).(ExportSection); ok {
+		for _, export := range section.list {
+			var desc string
+			switch(export.etype) {
+				case ExportTypeFunction:
+					desc = "func " + watRef(ids.functions, export.index)
+				case ExportTypeTable:
+					desc = "table " + watRef(ids.tables, export.index)
+				case ExportTypeMemory:
+					desc = "memory " + watRef(ids.memories, export.index)
+				case ExportTypeGlobal:
+					desc = "global " + watRef(ids.globals, export.index)
+			}
+			writer.line("(export %s (%s))", watString([]byte(export.name)),
+				desc)
+		}
+	}
+
+	if section, ok := module.section(StartSectionId).(StartSection); ok {
+		writer.line("(start %s)", watRef(ids.functions, section.function))
+	}
+
+	if section, ok := module.section(ElementSectionId).(ElementSection); ok {
+		for i, element := range section.element {
+			writer.line("(elem %s%s)", watDef(ids.elements, uint32(i)),
+				watElement(ids, element))
+		}
+	}
+	if section, ok := module.section(DataSectionId).(DataSection); ok {
+		for i, data := range section.data {
+			writer.line("(data %s%s)", watDef(ids.data, uint32(i)),
+				watData(ids, data))
+		}
+	}
+
+	writer.close()
+
+	_, err := io.WriteString(w, strings.Join(writer.lines, "\n") + "\n")
+	return err
+}
+
+// Function type at the given index, or the empty type if none
+func typeOrEmpty(context moduleContext, index uint32) FunctionType {
+	ftype, _ := context.typeAt(int64(index))
+	return ftype
+}
+
+// Write a single function definition: signature, locals + body
+func writeWatFunction(writer *watWriter, ids watIds, index uint32,
+	tindex uint32, ftype FunctionType, function Function, style WATStyle) {
+	locals := ids.locals[index]
+
+	writer.open("func %s (type %s)%s", watDef(ids.functions, index),
+		watRef(ids.types, tindex), watSignature(ftype, locals))
+
+	// Declared locals follow the parameters in the local index space
+	if (len(function.local) > 0) {
+		first := uint32(len(ftype.parameter))
+		writer.line("(local%s)", watValueTypes(function.local, locals, first,
+			"local"))
+	}
+
+	instructions, err := function.Instructions()
+	body := instructions
+	if (err == nil && len(body) > 0 && body[len(body) - 1].Opcode == 0x0B) {
+		// The final "end" is implicit in WAT
+		body = body[:len(body) - 1]
+	}
+
+	if (style == WATFolded) {
+		writeWatFolded(writer, ids, index, body)
+	} else {
+		writeWatFlat(writer, ids, index, body)
+	}
+	if (err != nil) {
+		writer.line(";; %s: %s", errMalformedBody, err)
+	}
+
+	writer.close()
+}
+
+// Write a linear sequence of instructions, indenting nested blocks
+func writeWatFlat(writer *watWriter, ids watIds, function uint32,
+	instructions []DecodedInstruction) {
+	base := writer.indent
+	for _, instr := range instructions {
+		if ((instr.Opcode == 0x05 || instr.Opcode == 0x0B) &&
+			writer.indent > base) {
+			writer.indent--
+		}
+		writer.line("%s", watInstruction(instr, ids, function))
+		switch(instr.Opcode) {
+			case 0x02, 0x03, 0x04, 0x05:	// block, loop, if, else
+				writer.indent++
+		}
+	}
+	writer.indent = base
+}
+
+//
+// Write a sequence of instructions with block, loop + if nested as
+// s-expressions.  Returns the number of instructions consumed, i.e., up to
+// and including any "else" or "end" that terminates the enclosing block
+//
+func writeWatFolded(writer *watWriter, ids watIds, function uint32,
+	instructions []DecodedInstruction) int {
+	for i := 0; i < len(instructions); i++ {
+		instr := instructions[i]
+		switch(instr.Opcode) {
+			case 0x05, 0x0B:	// else, end
+				return i + 1
+
+			case 0x02, 0x03:	// block, loop
+				writer.open("%s%s", instr.Name, watBlockType(ids, instr))
+				i += writeWatFolded(writer, ids, function, instructions[i + 1:])
+				writer.close()
+
+			case 0x04:			// if
+				writer.open("if%s", watBlockType(ids, instr))
+				writer.open("then")
+				consumed := writeWatFolded(writer, ids, function,
+					instructions[i + 1:])
+				i += consumed
+				writer.close()
+				if (i < len(instructions) && instructions[i].Opcode == 0x05) {
+					writer.open("else")
+					i += writeWatFolded(writer, ids, function,
+						instructions[i + 1:])
+					writer.close()
+				}
+				writer.close()
+
+			default:
+				writer.line("%s", watInstruction(instr, ids, function))
+		}
+	}
+	return len(instructions)
+}
+
+
+//
+// Format a single instruction in WAT form.  Unlike the disassembler, this
+// omits any immediates that are implicit in the text format (e.g., memory 0)
+// and references entities by identifier.  No side effects.
+//
+func watInstruction(instr DecodedInstruction, ids watIds,
+	function uint32) string {
+	var immediates string
+
+	switch(instr.Immediate) {
+		case ImmediateNone:
+			return instr.Name
+
+		case ImmediateBlockType:
+			return instr.Name + watBlockType(ids, instr)
+
+		case ImmediateIndex:
+			immediates = watIndex(instr, ids, function)
+
+		case ImmediateIndex2:
+			switch(instr.Opcode) {
+				case 0x11, 0x13:	// call_indirect, return_call_indirect
+					immediates = fmt.Sprintf("(type %s)",
+						watRef(ids.types, instr.Indices[0]))
+					if (instr.Indices[1] != 0) {
+						immediates = watRef(ids.tables, instr.Indices[1]) + " " +
+							immediates
+					}
+				default:
+					if (instr.Subopcode == 12) {
+						// table.init: table before element in WAT
+						immediates = watRef(ids.elements, instr.Indices[0])
+						if (instr.Indices[1] != 0) {
+							immediates = watRef(ids.tables, instr.Indices[1]) +
+								" " + immediates
+						}
+					} else if (instr.Indices[0] != 0 || instr.Indices[1] != 0) {
+						// table.copy
+						immediates = watRef(ids.tables, instr.Indices[0]) + " " +
+							watRef(ids.tables, instr.Indices[1])
+					}
+			}
+
+		case ImmediateBranchTable:
+			labels := make([]string, len(instr.Indices))
+			for i, label := range instr.Indices {
+				labels[i] = strconv.FormatUint(uint64(label), 10)
+			}
+			immediates = strings.Join(labels, " ")
+
+		case ImmediateMemory, ImmediateMemory2:
+			// Implicit memory 0
+
+		case ImmediateIndexMemory:
+			immediates = watRef(ids.data, instr.Indices[0])
+
+		case ImmediateValueTypes:
+			immediates = "(result" + watValueTypes(instr.Types, nil, 0, "") + ")"
+
+		default:
+			immediates = formatImmediates(instr, NameSection{}, function)
+	}
+
+	if (immediates == "") {
+		return instr.Name
+	}
+	return instr.Name + " " + immediates
+}
+
+// Single index immediate, by identifier where applicable
+func watIndex(instr DecodedInstruction, ids watIds, function uint32) string {
+	index := instr.Index()
+	if (instr.Opcode == PrefixMisc) {
+		switch(instr.Subopcode) {
+			case 9:					return watRef(ids.data, index)		// data.drop
+			case 13:				return watRef(ids.elements, index)	// elem.drop
+			case 15, 16, 17:		return watRef(ids.tables, index)	// table.*
+		}
+		return strconv.FormatUint(uint64(index), 10)
+	}
+
+	switch(instr.Opcode) {
+		case 0x10, 0x12, 0xD2:	return watRef(ids.functions, index)
+		case 0x20, 0x21, 0x22:	return watRef(ids.locals[function], index)
+		case 0x23, 0x24:		return watRef(ids.globals, index)
+		case 0x25, 0x26:		return watRef(ids.tables, index)
+	}
+	return strconv.FormatUint(uint64(index), 10)
+}
+
+// Block type, with a leading space if non-empty
+func watBlockType(ids watIds, instr DecodedInstruction) string {
+	btype := instr.BlockType
+	if (btype.Empty()) {
+		return ""
+	} else if (btype.Index < 0) {
+		return fmt.Sprintf(" (result %s)", watValueType(btype.Result))
+	}
+	return fmt.Sprintf(" (type %s)", watRef(ids.types, uint32(btype.Index)))
+}
+
+
+//
+// Types
+//
+
+func watValueType(vtype ValueType) string {
+	switch(vtype) {
+		case RefTypeFunction:	return "funcref"
+		case RefTypeExtern:		return "externref"
+	}
+	return typeName(vtype)
+}
+
+//
+// Space-prefixed list of value types, e.g. " i32 i64".  If any type is named
+// (e.g., a parameter), each is emitted separately, e.g. " $n i32) (param i64"
+// within the enclosing keyword
+//
+func watValueTypes(types []ValueType, names map[uint32]string, first uint32,
+	keyword string) string {
+	var builder strings.Builder
+	named := false
+	for i := range types {
+		if _, ok := names[first + uint32(i)]; ok {
+			named = true
+		}
+	}
+
+	for i, vtype := range types {
+		if (named && i > 0) {
+			builder.WriteString(") (" + keyword)
+		}
+		if id, ok := names[first + uint32(i)]; ok {
+			builder.WriteString(" " + id)
+		}
+		builder.WriteString(" " + watValueType(vtype))
+	}
+	return builder.String()
+}
+
+// Parameters + results, with a leading space, e.g. " (param i32) (result i32)"
+func watSignature(ftype FunctionType, locals map[uint32]string) string {
+	var builder strings.Builder
+	if (len(ftype.parameter) > 0) {
+		builder.WriteString(" (param" +
+			watValueTypes(ftype.parameter, locals, 0, "param") + ")")
+	}
+	if (len(ftype.result) > 0) {
+		builder.WriteString(" (result" +
+			watValueTypes(ftype.result, nil, 0, "result") + ")")
+	}
+	return builder.String()
+}
+
+func watLimit(limit Limit) string {
+	if (limit.flags & 0x1 != 0) {
+		return fmt.Sprintf("%d %d", limit.min, limit.max)
+	}
+	return strconv.FormatUint(uint64(limit.min), 10)
+}
+
+func watTableType(table Table) string {
+	return watLimit(table.limit) + " " + watValueType(ValueType(table.reftype))
+}
+
+func watGlobalType(gtype GlobalType) string {
+	if (gtype.mutable) {
+		return fmt.Sprintf("(mut %s)", watValueType(gtype.vtype))
+	}
+	return watValueType(gtype.vtype)
+}
+
+
+//
+// Constant expressions + segments
+//
+
+//
+// Constant expression, as a sequence of folded instructions, e.g.
+// "(i32.const 0)".  A malformed expression is emitted as a comment
+//
+func watExpression(ids watIds, expr []byte) string {
+	instructions, err := decodeConstantExpression(expr)
+	if (err != nil) {
+		return fmt.Sprintf("(; malformed: %s ;)", err)
+	}
+	folded := make([]string, len(instructions))
+	for i, instr := range instructions {
+		folded[i] = "(" + watInstruction(instr, ids, 0) + ")"
+	}
+	return strings.Join(folded, " ")
+}
+
+// Offset of an active segment, as a single folded instruction if possible
+func watOffset(ids watIds, expr []byte) string {
+	instructions, err := decodeConstantExpression(expr)
+	if (err == nil && len(instructions) == 1) {
+		return watExpression(ids, expr)
+	}
+	return "(offset " + watExpression(ids, expr) + ")"
+}
+
+// Mode, offset + initializer of an element segment, with a leading space
+func watElement(ids watIds, element Element) string {
+	var builder strings.Builder
+
+	switch(element.mode) {
+		case SegmentModeActive:
+			if (element.table != 0) {
+				builder.WriteString(" (table " +
+					watRef(ids.tables, element.table) + ")")
+			}
+			builder.WriteString(" " + watOffset(ids, element.offset))
+		case SegmentModeDeclarative:
+			builder.WriteString(" declare")
+	}
+
+	if (element.init == nil && element.reftype == RefTypeFunction) {
+		builder.WriteString(" func")
+		for _, function := range element.function {
+			builder.WriteString(" " + watRef(ids.functions, function))
+		}
+		return builder.String()
+	}
+
+	builder.WriteString(" " + watValueType(element.reftype))
+	for _, init := range element.init {
+		instructions, _ := decodeConstantExpression(init)
+		if (len(instructions) == 1) {
+			builder.WriteString(" " + watExpression(ids, init))
+		} else {
+			builder.WriteString(" (item " + watExpression(ids, init) + ")")
+		}
+	}
+	return builder.String()
+}
+
+// Mode, offset + bytes of a data segment, with a leading space
+func watData(ids watIds, data Data) string {
+	var builder strings.Builder
+	if (data.mode == SegmentModeActive) {
+		if (data.memory != 0) {
+			builder.WriteString(" (memory " +
+				watRef(ids.memories, data.memory) + ")")
+		}
+		builder.WriteString(" " + watOffset(ids, data.offset))
+	}
+	builder.WriteString(" " + watString(data.init))
+	return builder.String()
+}
+
+// Quoted string, with any non-printable bytes, quotes, etc. escaped
+func watString(raw []byte) string {
+	var builder strings.Builder
+	builder.WriteByte('"')
+	for _, b := range raw {
+		if (b >= 0x20 && b < 0x7F && b != '"' && b != '\\') {
+			builder.WriteByte(b)
+		} else {
+			builder.WriteString(fmt.Sprintf("\\%02x", b))
+		}
+	}
+	builder.WriteByte('"')
+	return builder.String()
+}
@@ -0,0 +1,204 @@
+package wasm
+
+import(
+	"bytes"
+	"strings"
+	"testing"
+	)
+
+
+// Module with (nearly) every kind of section, plus function + local names
+func watSampleModule(t *testing.T) Module {
+	fac := FunctionType{ ResultType{ NumTypef64 }, ResultType{ NumTypef64 } }
+	encoded := CreateModuleBuilder().
+		ImportFunction("env", "log",
+			FunctionType{ ResultType{ NumTypei32 }, ResultType{} }).
+		ImportGlobal("env", "g", NumTypei32, false).
+		Function("fac", fac, []ValueType{ NumTypei32 },
+			CreateExpression().
+				LocalGet(0).F64Const(1).Op(0x63).
+				If(NumTypef64).
+					F64Const(1).
+				Else().
+					LocalGet(0).LocalGet(0).F64Const(1).Op(0xA1).
+					Call(1).Op(0xA2).
+				End().
+				Block(0x40).Loop(0x40).Br(1).End().End()).
+		Function("", FunctionType{}, nil, CreateExpression()).
+		Table(1, 0).
+		Memory(1, 2).
+		Global(NumTypei64, true, CreateExpression().I64Const(-1)).
+		Start(2).
+		Element(0, 1, 2).
+		Data(16, []byte("hi\"\x00")).
+		Bytes()
+	encoded = append(encoded, customSection(NameSectionName,
+		0x01, 0x06, 0x01, 0x01, 0x03, 'f', 'a', 'c',
+		0x02, 0x06, 0x01, 0x01, 0x01, 0x00, 0x01, 'n')...)
+
+	module, err := ReadModule(bytes.NewReader(encoded))
+	if (err != nil) {
+		t.Fatal("Unexpected decoding status: ", err)
+	}
+	return module
+}
+
+
+//
+// Test WAT output of an entire module, in both flat + folded styles
+//
+func TestWriteWAT(t *testing.T) {
+	header := []string{
+		`(module`,
+		`  (type (;0;) (func (param i32)))`,
+		`  (type (;1;) (func (param f64) (result f64)))`,
+		`  (type (;2;) (func))`,
+		`  (import "env" "log" (func (;0;) (type 0) (param i32)))`,
+		`  (import "env" "g" (global (;0;) i32))`,
+		`  (func $fac (type 1) (param $n f64) (result f64)`,
+		`    (local i32)`,
+		`    local.get $n`,
+		`    f64.const 1`,
+		`    f64.lt`,
+	}
+	trailer := []string{
+		`  (func (;2;) (type 2))`,
+		`  (table (;0;) 1 funcref)`,
+		`  (memory (;0;) 1 2)`,
+		`  (global (;1;) (mut i64) (i64.const -1))`,
+		`  (export "fac" (func $fac))`,
+		`  (start 2)`,
+		`  (elem (;0;) (i32.const 0) func $fac 2)`,
+		`  (data (;0;) (i32.const 16) "hi\22\00"))`,
+		``,
+	}
+
+	testCases := []struct{
+		name		string
+		style		WATStyle
+		body		[]string
+	}{
+		{ "flat",
+		  WATFlat,
+		  []string{
+			`    if (result f64)`,
+			`      f64.const 1`,
+			`    else`,
+			`      local.get $n`,
+			`      local.get $n`,
+			`      f64.const 1`,
+			`      f64.sub`,
+			`      call $fac`,
+			`      f64.mul`,
+			`    end`,
+			`    block`,
+			`      loop`,
+			`        br 1`,
+			`      end`,
+			`    end)`,
+		  } },
+
+		{ "folded",
+		  WATFolded,
+		  []string{
+			`    (if (result f64)`,
+			`      (then`,
+			`        f64.const 1)`,
+			`      (else`,
+			`        local.get $n`,
+			`        local.get $n`,
+			`        f64.const 1`,
+			`        f64.sub`,
+			`        call $fac`,
+			`        f64.mul))`,
+			`    (block`,
+			`      (loop`,
+			`        br 1)))`,
+		  } },
+	}
+
+	module := watSampleModule(t)
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			var output bytes.Buffer
+			err := module.WriteWAT(&output, test.style)
+			if (err != nil) {
+				t.Fatal("Unexpected WAT error: ", err)
+			}
+
+			lines := append(append(append([]string{}, header...),
+				test.body...), trailer...)
+			expected := strings.Join(lines, "\n")
+			if (output.String() != expected) {
+				t.Errorf("Unexpected WAT:\n%s", output.String())
+			}
+		})
+	}
+}
+
+
+//
+// Test WAT formatting of instructions whose text form differs from the
+// disassembly
+//
+func TestWATInstructions(t *testing.T) {
+	ids := watIds{
+		types:		map[uint32]string{ 1: "$sig" },
+		functions:	map[uint32]string{},
+		tables:		map[uint32]string{ 1: "$t" },
+		elements:	map[uint32]string{},
+		data:		map[uint32]string{ 0: "$d" },
+	}
+
+	testCases := []struct{
+		name		string
+		encoded		[]byte
+		formatted	string
+	}{
+		{ "block-typeidx",	[]byte{ 0x02, 0x01 },				"block (type $sig)" },
+		{ "call_indirect",	[]byte{ 0x11, 0x01, 0x00 },			"call_indirect (type $sig)" },
+		{ "call_indirect-table",
+							[]byte{ 0x11, 0x00, 0x01 },			"call_indirect $t (type 0)" },
+		{ "memory.size",	[]byte{ 0x3F, 0x00 },				"memory.size" },
+		{ "memory.init",	[]byte{ 0xFC, 0x08, 0x00, 0x00 },	"memory.init $d" },
+		{ "memory.copy",	[]byte{ 0xFC, 0x0A, 0x00, 0x00 },	"memory.copy" },
+		{ "table.init",		[]byte{ 0xFC, 0x0C, 0x02, 0x01 },	"table.init $t 2" },
+		{ "table.copy",		[]byte{ 0xFC, 0x0E, 0x00, 0x00 },	"table.copy" },
+		{ "select-typed",	[]byte{ 0x1C, 0x01, 0x70 },			"select (result funcref)" },
+		{ "i32.store",		[]byte{ 0x36, 0x02, 0x08 },
+												"i32.store offset=8 align=4" },
+		{ "f64.const",
+		  []byte{ 0x44, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xF0, 0x7F },
+		  "f64.const inf" },
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			instructions, err := DecodeInstructions(test.encoded)
+			if (err != nil || len(instructions) != 1) {
+				t.Fatal("Unexpected decoding status: ", err)
+			}
+			formatted := watInstruction(instructions[0], ids, 0)
+			if (formatted != test.formatted) {
+				t.Errorf("Unexpected formatting: %s (expected %s)", formatted,
+					test.formatted)
+			}
+		})
+	}
+}
+
+
+//
+// Names that are not valid WAT identifiers are dropped
+//
+func TestWATIds(t *testing.T) {
+	ids := createWatIds(NameSection{
+		Functions: NameMap{ 0: "ok", 1: "has space", 2: "ok", 3: "" },
+	})
+	if (watDef(ids.functions, 0) != "$ok" ||
+		watDef(ids.functions, 1) != "(;1;)" ||
+		watRef(ids.functions, 2) != "2" ||
+		watRef(ids.functions, 3) != "3") {
+		t.Errorf("Unexpected identifiers: %v", ids.functions)
+	}
+}
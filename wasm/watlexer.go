@@ -0,0 +1,440 @@
+package wasm
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+
+// Assembly error due to malformed or unsupported WAT source
+var InvalidWAT = errors.New("Invalid WAT")
+
+// Assembly error due to syntax of a proposal the assembler does not support
+// yet (e.g., threads), rather than malformed source.  Also an InvalidWAT
+var UnsupportedWAT = errors.New("Unsupported WAT")
+
+
+//
+// Detailed assembly error.  Identifies the position (1-based line + column)
+// of the offending token within the WAT source
+//
+type WATError struct {
+	Line		int
+	Column		int
+	Reason		string
+	Unsupported	bool	// Valid syntax, just not supported yet
+}
+
+func (e WATError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Reason)
+}
+
+func (e WATError) Unwrap() error {
+	return InvalidWAT
+}
+
+func (e WATError) Is(target error) bool {
+	return (target == UnsupportedWAT && e.Unsupported)
+}
+
+
+//
+// Keywords of the proposals that the assembler does not support yet, i.e.
+// threads, tail calls, exception handling, typed function references + GC.
+// Errors at these keywords are reported as UnsupportedWAT
+//
+var watUnsupportedKeywords = map[string]bool {
+	"shared": true, "tag": true, "ref": true, "rec": true, "sub": true,
+	"struct": true, "array": true, "anyref": true, "eqref": true,
+	"i31ref": true, "structref": true, "arrayref": true, "nullref": true,
+	"nullfuncref": true, "nullexternref": true, "exnref": true,
+}
+var watUnsupportedPrefixes = []string{
+	"i32.atomic.", "i64.atomic.", "memory.atomic.", "atomic.",
+	"return_call", "call_ref", "ref.as_non_null", "br_on_",
+	"throw", "rethrow", "try", "catch", "delegate",
+	"struct.", "array.", "i31.", "ref.test", "ref.cast", "ref.i31", "ref.eq",
+	"any.convert_extern", "extern.convert_any",
+}
+
+// Is this keyword from an unsupported proposal?  No side effects.
+func isWatUnsupported(keyword string) bool {
+	if (watUnsupportedKeywords[keyword]) {
+		return true
+	}
+	for _, prefix := range watUnsupportedPrefixes {
+		if (strings.HasPrefix(keyword, prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+
+//
+// WAT lexer.  Splits the source text into tokens: parentheses, keywords
+// (including numbers and "offset=" style immediates), $identifiers and
+// strings.  Comments + whitespace are discarded.  See section 6.2 of WASM
+// spec
+//
+const (
+	watTokenLParen	= iota
+	watTokenRParen
+	watTokenKeyword			// Keywords, numbers, etc: any other idchars
+	watTokenId				// $identifier, including the "$"
+	watTokenString			// Decoded string content, as raw bytes
+)
+
+type watToken struct {
+	kind	uint8
+	text	string
+	line	int
+	column	int
+}
+
+// Error at the position of this token
+func (token watToken) errorf(format string, args ...interface{}) error {
+	return WATError{ token.line, token.column, fmt.Sprintf(format, args...),
+		false }
+}
+
+// Characters that may appear in keywords + identifiers
+func isWatIdChar(c byte) bool {
+	return (c > ' ' && c < 0x7F && strings.IndexByte("\"(),;[]{}", c) < 0)
+}
+
+// Factory function for tokenizing + returning the entire WAT source.  No side
+// effects.
+func lexWAT(source string) ([]watToken, error) {
+	var tokens []watToken
+	line, lineStart := 1, 0
+
+	for i := 0; i < len(source); {
+		c := source[i]
+		position := watToken{ line: line, column: i - lineStart + 1 }
+
+		switch {
+			case c == '\n':
+				line++
+				i++
+				lineStart = i
+
+			case c == ' ' || c == '\t' || c == '\r':
+				i++
+
+			// Line comment
+			case strings.HasPrefix(source[i:], ";;"):
+				for i < len(source) && source[i] != '\n' {
+					i++
+				}
+
+			// Block comment, possibly nested
+			case strings.HasPrefix(source[i:], "(;"):
+				depth := 0
+				for {
+					if (i >= len(source)) {
+						return nil, position.errorf("unterminated block comment")
+					}
+					if (strings.HasPrefix(source[i:], "(;")) {
+						depth++
+						i += 2
+					} else if (strings.HasPrefix(source[i:], ";)")) {
+						depth--
+						i += 2
+						if (depth == 0) {
+							break
+						}
+					} else {
+						if (source[i] == '\n') {
+							line++
+							lineStart = i + 1
+						}
+						i++
+					}
+				}
+
+			case c == '(':
+				position.kind = watTokenLParen
+				position.text = "("
+				tokens = append(tokens, position)
+				i++
+
+			case c == ')':
+				position.kind = watTokenRParen
+				position.text = ")"
+				tokens = append(tokens, position)
+				i++
+
+			case c == '"':
+				text, length, err := readWatString(source[i:])
+				if (err != nil) {
+					return nil, position.errorf("%s", err)
+				}
+				position.kind = watTokenString
+				position.text = text
+				tokens = append(tokens, position)
+				i += length
+
+			case isWatIdChar(c):
+				start := i
+				for i < len(source) && isWatIdChar(source[i]) {
+					i++
+				}
+				position.text = source[start:i]
+				position.kind = watTokenKeyword
+				if (c == '$') {
+					if (len(position.text) == 1) {
+						return nil, position.errorf("empty identifier")
+					}
+					position.kind = watTokenId
+				}
+				tokens = append(tokens, position)
+
+			default:
+				return nil, position.errorf("unexpected character %q", c)
+		}
+	}
+
+	return tokens, nil
+}
+
+//
+// Decode a single quoted string, including its escapes.  Returns the raw
+// content plus the length of the quoted string within the source
+//
+func readWatString(source string) (string, int, error) {
+	var builder strings.Builder
+
+	for i := 1; i < len(source); {
+		c := source[i]
+		switch {
+			case c == '"':
+				return builder.String(), i + 1, nil
+
+			case c == '\n' || c < 0x20 || c == 0x7F:
+				return "", 0, errors.New("invalid character in string")
+
+			case c != '\\':
+				builder.WriteByte(c)
+				i++
+
+			case i + 1 >= len(source):
+				return "", 0, errors.New("unterminated string")
+
+			default:
+				escape := source[i + 1]
+				i += 2
+				switch(escape) {
+					case 't':	builder.WriteByte('\t')
+					case 'n':	builder.WriteByte('\n')
+					case 'r':	builder.WriteByte('\r')
+					case '"':	builder.WriteByte('"')
+					case '\'':	builder.WriteByte('\'')
+					case '\\':	builder.WriteByte('\\')
+
+					case 'u':
+						// Unicode code point, e.g. "\u{1F600}"
+						end := strings.IndexByte(source[i:], '}')
+						if (!strings.HasPrefix(source[i:], "{") || end < 0) {
+							return "", 0, errors.New("invalid unicode escape")
+						}
+						value, err := strconv.ParseUint(
+							strings.ReplaceAll(source[i + 1:i + end], "_", ""), 16, 32)
+						if (err != nil || !utf8.ValidRune(rune(value))) {
+							return "", 0, errors.New("invalid unicode escape")
+						}
+						builder.WriteRune(rune(value))
+						i += end + 1
+
+					default:
+						// Raw byte, as two hex digits
+						if (i >= len(source)) {
+							return "", 0, errors.New("unterminated string")
+						}
+						value, err := strconv.ParseUint(source[i - 1:i + 1], 16, 8)
+						if (err != nil) {
+							return "", 0, fmt.Errorf("invalid escape \\%c", escape)
+						}
+						builder.WriteByte(byte(value))
+						i++
+				}
+		}
+	}
+
+	return "", 0, errors.New("unterminated string")
+}
+
+
+//
+// S-expressions.  Each node is either a single token (atom) or a
+// parenthesized list of nodes
+//
+type watNode struct {
+	token		watToken	// The atom itself, or the "(" of a list
+	list		bool
+	children	[]watNode
+}
+
+// Factory function for parsing + returning all top-level s-expressions.  No
+// side effects.
+func parseWatNodes(tokens []watToken) ([]watNode, error) {
+	var stack [][]watNode
+	var opens []watToken
+	var current []watNode
+
+	for _, token := range tokens {
+		switch(token.kind) {
+			case watTokenLParen:
+				stack = append(stack, current)
+				opens = append(opens, token)
+				current = nil
+
+			case watTokenRParen:
+				if (len(stack) == 0) {
+					return nil, token.errorf("unexpected )")
+				}
+				node := watNode{ token: opens[len(opens) - 1], list: true,
+					children: current }
+				current = append(stack[len(stack) - 1], node)
+				stack = stack[:len(stack) - 1]
+				opens = opens[:len(opens) - 1]
+
+			default:
+				current = append(current, watNode{ token: token })
+		}
+	}
+
+	if (len(stack) > 0) {
+		return nil, opens[len(opens) - 1].errorf("unmatched (")
+	}
+	return current, nil
+}
+
+// Is this an atom of the given kind + text?
+func (node watNode) isKeyword(keyword string) bool {
+	return (!node.list && node.token.kind == watTokenKeyword &&
+		node.token.text == keyword)
+}
+
+// Is this a list that begins with the given keyword, e.g. "(param ...)"?
+func (node watNode) isList(keyword string) bool {
+	return (node.list && len(node.children) > 0 &&
+		node.children[0].isKeyword(keyword))
+}
+
+//
+// Error at the position of this node.  Marked as unsupported if the node is
+// (or starts with) a keyword of an unsupported proposal
+//
+func (node watNode) errorf(format string, args ...interface{}) error {
+	keyword := node
+	if (node.list && len(node.children) > 0) {
+		keyword = node.children[0]
+	}
+	if (!keyword.list && keyword.token.kind == watTokenKeyword &&
+		isWatUnsupported(keyword.token.text)) {
+		return node.unsupportedf(format, args...)
+	}
+	return node.token.errorf(format, args...)
+}
+
+// Error at the position of this node, due to unsupported (but valid) syntax
+func (node watNode) unsupportedf(format string, args ...interface{}) error {
+	return WATError{ node.token.line, node.token.column,
+		fmt.Sprintf(format, args...), true }
+}
+
+// Description of this node, for error messages
+func (node watNode) String() string {
+	if (node.list) {
+		if (len(node.children) > 0 && !node.children[0].list) {
+			return "(" + node.children[0].token.text + " ...)"
+		}
+		return "(...)"
+	}
+	if (node.token.kind == watTokenString) {
+		return strconv.Quote(node.token.text)
+	}
+	return node.token.text
+}
+
+
+//
+// Cursor over a sequence of sibling nodes, e.g. the children of a list
+//
+type watCursor struct {
+	nodes	[]watNode
+	pos		int
+	parent	watNode		// Enclosing list, for errors at the end of the list
+}
+
+func (cursor *watCursor) done() bool {
+	return (cursor.pos >= len(cursor.nodes))
+}
+
+// Next node, without consuming it.  Only valid if !done()
+func (cursor *watCursor) peek() watNode {
+	return cursor.nodes[cursor.pos]
+}
+
+func (cursor *watCursor) next() watNode {
+	node := cursor.nodes[cursor.pos]
+	cursor.pos++
+	return node
+}
+
+// Consume the next node if it is the given keyword
+func (cursor *watCursor) keyword(keyword string) bool {
+	if (!cursor.done() && cursor.peek().isKeyword(keyword)) {
+		cursor.pos++
+		return true
+	}
+	return false
+}
+
+// Consume the next node if it is a list beginning with the given keyword
+func (cursor *watCursor) list(keyword string) (watNode, bool) {
+	if (!cursor.done() && cursor.peek().isList(keyword)) {
+		return cursor.next(), true
+	}
+	return watNode{}, false
+}
+
+// Consume + return the next node if it is an atom of the given kind
+func (cursor *watCursor) atom(kind uint8) (watToken, bool) {
+	if (!cursor.done() && !cursor.peek().list &&
+		cursor.peek().token.kind == kind) {
+		return cursor.next().token, true
+	}
+	return watToken{}, false
+}
+
+// Consume an optional $identifier, returning "" if absent
+func (cursor *watCursor) id() string {
+	token, _ := cursor.atom(watTokenId)
+	return token.text
+}
+
+// Error at the current position: the next node, or the enclosing list
+func (cursor *watCursor) errorf(format string, args ...interface{}) error {
+	if (!cursor.done()) {
+		return cursor.peek().errorf(format, args...)
+	}
+	return cursor.parent.errorf(format, args...)
+}
+
+// Fail unless all nodes have been consumed
+func (cursor *watCursor) end() error {
+	if (!cursor.done()) {
+		return cursor.errorf("unexpected %s", cursor.peek())
+	}
+	return nil
+}
+
+// Cursor over the children of a list, following its leading keyword
+func listCursor(node watNode) *watCursor {
+	return &watCursor{ nodes: node.children, pos: 1, parent: node }
+}
@@ -0,0 +1,2066 @@
+package wasm
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+
+//
+// WAT assembler.  Translates a module in the WebAssembly text format into
+// the equivalent Module, as if decoded from a binary produced by wat2wasm.
+// Supports s-expressions + folded instructions, symbolic $identifiers (incl.
+// block labels), inline imports + exports, inline table elements + memory
+// data, and data strings.  See chapter 6 of WASM spec
+//
+
+// Assembly options
+type WATOptions struct {
+	// Generate a name section from any $identifiers of the module, functions,
+	// locals + globals, like "wat2wasm --debug-names"
+	DebugNames bool
+}
+
+// Factory function for assembling + returning a Module from WAT source.  No
+// side effects.
+func ReadWAT(reader io.Reader) (Module, error) {
+	return ReadWATWithOptions(reader, WATOptions{})
+}
+
+// Same as ReadWAT(), but with explicit options.  No side effects.
+func ReadWATWithOptions(reader io.Reader, options WATOptions) (Module, error) {
+	source, err := io.ReadAll(reader)
+	if (err != nil) {
+		return Module{}, err
+	}
+	tokens, err := lexWAT(string(source))
+	if (err != nil) {
+		return Module{}, err
+	}
+	nodes, err := parseWatNodes(tokens)
+	if (err != nil) {
+		return Module{}, err
+	}
+
+	// Either a single "(module ...)", or a bare sequence of module fields
+	if (len(nodes) == 1 && nodes[0].isList("module")) {
+		return assembleWatModule(nodes[0], options)
+	}
+	return assembleWatFields(nodes, "", options)
+}
+
+// Factory function for assembling + returning the Module described by a
+// "(module $id? field*)" s-expression.  No side effects.
+func assembleWatModule(node watNode, options WATOptions) (Module, error) {
+	cursor := listCursor(node)
+	id := cursor.id()
+	return assembleWatFields(cursor.nodes[cursor.pos:], id, options)
+}
+
+func assembleWatFields(fields []watNode, id string,
+	options WATOptions) (Module, error) {
+	assembler := createWatAssembler(options)
+	assembler.moduleId = id
+
+	err := assembler.assemble(fields)
+	if (err != nil) {
+		return Module{}, err
+	}
+	return assembler.module(), nil
+}
+
+
+// Index space (functions, tables, etc), with any $identifiers
+type watSpace struct {
+	kind		string
+	ids			map[string]uint32
+	names		NameMap
+	count		uint32
+	imported	uint32
+}
+
+func createWatSpace(kind string) *watSpace {
+	return &watSpace{ kind: kind, ids: make(map[string]uint32),
+		names: make(NameMap) }
+}
+
+// Allocate the next index, binding it to the given identifier, if any
+func (space *watSpace) define(node watNode, id string) (uint32, error) {
+	index := space.count
+	if (id != "") {
+		if _, ok := space.ids[id]; ok {
+			return 0, node.errorf("duplicate %s %s", space.kind, id)
+		}
+		space.ids[id] = index
+		space.names[index] = id[1:]
+	}
+	space.count++
+	return index, nil
+}
+
+// Allocate the next index for an import, which must precede all definitions
+func (space *watSpace) defineImport(node watNode, id string) (uint32, error) {
+	if (space.imported != space.count) {
+		return 0, node.errorf("import after %s definition", space.kind)
+	}
+	space.imported++
+	return space.define(node, id)
+}
+
+// Resolve a reference, by $identifier or index
+func (space *watSpace) resolve(node watNode) (uint32, error) {
+	if (node.list) {
+		return 0, node.errorf("expected %s index, found %s", space.kind, node)
+	}
+	if (node.token.kind == watTokenId) {
+		index, ok := space.ids[node.token.text]
+		if !ok {
+			return 0, node.errorf("unknown %s %s", space.kind, node.token.text)
+		}
+		return index, nil
+	}
+	return parseWatU32(node)
+}
+
+// Resolve the next node of a cursor, if it is a reference
+func (space *watSpace) optional(cursor *watCursor) (uint32, bool, error) {
+	if (cursor.done() || !isWatReference(cursor.peek())) {
+		return 0, false, nil
+	}
+	index, err := space.resolve(cursor.next())
+	return index, true, err
+}
+
+// Is this node an index or $identifier?
+func isWatReference(node watNode) bool {
+	if (node.list) {
+		return false
+	}
+	if (node.token.kind == watTokenId) {
+		return true
+	}
+	return (node.token.kind == watTokenKeyword && len(node.token.text) > 0 &&
+		node.token.text[0] >= '0' && node.token.text[0] <= '9')
+}
+
+
+//
+// Assembler state.  Assembly is two passes over the module fields: the first
+// allocates every index space (so that references may precede definitions),
+// the second generates function bodies, initializers, segments + exports
+//
+type watAssembler struct {
+	options		WATOptions
+	moduleId	string
+
+	types		[]FunctionType
+	typeSpace	*watSpace
+	functions	*watSpace
+	tables		*watSpace
+	memories	*watSpace
+	globals		*watSpace
+	elements	*watSpace
+	data		*watSpace
+
+	imports		[]Import
+	signatures	[]uint32		// Type index of each defined function
+	code		[]Function
+	tableDefs	[]Table
+	memoryDefs	[]Memory
+	globalDefs	[]Global
+	exports		[]Export
+	start		*uint32
+	elementDefs	[]Element
+	dataDefs	[]Data
+	dataCount	bool			// Whether any instruction references data
+	localNames	IndirectNameMap
+
+	// Second-pass work, in order of the corresponding fields
+	pending		[]func() error
+}
+
+func createWatAssembler(options WATOptions) *watAssembler {
+	return &watAssembler{
+		options:	options,
+		typeSpace:	createWatSpace("type"),
+		functions:	createWatSpace("function"),
+		tables:		createWatSpace("table"),
+		memories:	createWatSpace("memory"),
+		globals:	createWatSpace("global"),
+		elements:	createWatSpace("elem"),
+		data:		createWatSpace("data"),
+		localNames:	make(IndirectNameMap),
+	}
+}
+
+func (asm *watAssembler) assemble(fields []watNode) error {
+	// Explicit type definitions always occupy the first type indices, ahead of
+	// any types implied by type uses
+	for _, field := range fields {
+		if (field.isList("type")) {
+			err := asm.typeField(field)
+			if (err != nil) {
+				return err
+			}
+		}
+	}
+
+	for _, field := range fields {
+		if (!field.list || len(field.children) == 0 ||
+			field.children[0].list) {
+			return field.errorf("expected module field, found %s", field)
+		}
+
+		var err error
+		switch(field.children[0].token.text) {
+			case "type":	// Already defined above
+			case "import":	err = asm.importField(field)
+			case "func":	err = asm.funcField(field)
+			case "table":	err = asm.tableField(field)
+			case "memory":	err = asm.memoryField(field)
+			case "global":	err = asm.globalField(field)
+			case "export":	err = asm.exportField(field)
+			case "start":	err = asm.startField(field)
+			case "elem":	err = asm.elemField(field)
+			case "data":	err = asm.dataField(field)
+			default:
+				err = field.errorf("unknown module field %s", field)
+		}
+		if (err != nil) {
+			return err
+		}
+	}
+
+	for _, work := range asm.pending {
+		err := work()
+		if (err != nil) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Generate the Module assembled so far.  No side effects.
+func (asm *watAssembler) module() Module {
+	exports := ExportSection{ export: make(map[string]Export),
+		list: asm.exports }
+	for _, export := range asm.exports {
+		exports.export[export.name] = export
+	}
+	start := StartSection{}
+	if (asm.start != nil) {
+		start.function = *asm.start
+	}
+
+	// Emit each non-empty section, in the prescribed order
+	candidates := []struct{
+		present	bool
+		section	Section
+	}{
+		{ len(asm.types) > 0,		TypeSection{ asm.types } },
+		{ len(asm.imports) > 0,		ImportSection{ asm.imports } },
+		{ len(asm.signatures) > 0,	FunctionSection{ asm.signatures } },
+		{ len(asm.tableDefs) > 0,	TableSection{ asm.tableDefs } },
+		{ len(asm.memoryDefs) > 0,	MemorySection{ asm.memoryDefs } },
+		{ len(asm.globalDefs) > 0,	GlobalSection{ asm.globalDefs } },
+		{ len(asm.exports) > 0,		exports },
+		{ asm.start != nil,			start },
+		{ len(asm.elementDefs) > 0,	ElementSection{ asm.elementDefs } },
+		{ asm.dataCount,			DataCountSection{ uint32(len(asm.dataDefs)) } },
+		{ len(asm.code) > 0,		CodeSection{ asm.code } },
+		{ len(asm.dataDefs) > 0,	DataSection{ asm.dataDefs } },
+	}
+
+	module := Module{}
+	for _, candidate := range candidates {
+		if (candidate.present) {
+			module.sections = append(module.sections, candidate.section)
+		}
+	}
+
+	if (asm.options.DebugNames) {
+		names := NameSection{ Functions: asm.functions.names,
+			Locals: asm.localNames, Globals: asm.globals.names }
+		if (asm.moduleId != "") {
+			names.Module = asm.moduleId[1:]
+		}
+		if (names.Module != "" || len(names.Functions) > 0 ||
+			len(names.Locals) > 0 || len(names.Globals) > 0) {
+			content := appendName(nil, NameSectionName)
+			module.sections = append(module.sections,
+				CustomSection{ append(content, names.encode()...),
+					NameSectionName })
+		}
+	}
+
+	return module
+}
+
+
+//
+// Types + type uses
+//
+
+// (type $id? (func (param ...)* (result ...)*))
+func (asm *watAssembler) typeField(field watNode) error {
+	cursor := listCursor(field)
+	id := cursor.id()
+	function, ok := cursor.list("func")
+	if !ok {
+		return cursor.errorf("expected (func ...)")
+	}
+	ftype, _, err := parseWatSignature(listCursor(function))
+	if (err != nil) {
+		return err
+	}
+	err = cursor.end()
+	if (err != nil) {
+		return err
+	}
+
+	_, err = asm.typeSpace.define(field, id)
+	asm.types = append(asm.types, ftype)
+	return err
+}
+
+//
+// Parameters + results, e.g. "(param $x i32) (param f64) (result i32)".
+// Returns the type, plus the $identifiers of any named parameters
+//
+func parseWatSignature(cursor *watCursor) (FunctionType, map[string]uint32,
+	error) {
+	ftype := FunctionType{ ResultType{}, ResultType{} }
+	ids := make(map[string]uint32)
+
+	for !cursor.done() {
+		if param, ok := cursor.list("param"); ok {
+			inner := listCursor(param)
+			if id := inner.id(); id != "" {
+				// Named parameter: exactly one type
+				vtype, err := parseWatValueType(inner)
+				if (err != nil) {
+					return ftype, nil, err
+				}
+				if _, ok := ids[id]; ok {
+					return ftype, nil, param.errorf("duplicate local %s", id)
+				}
+				ids[id] = uint32(len(ftype.parameter))
+				ftype.parameter = append(ftype.parameter, vtype)
+				err = inner.end()
+				if (err != nil) {
+					return ftype, nil, err
+				}
+				continue
+			}
+			types, err := parseWatValueTypes(inner)
+			if (err != nil) {
+				return ftype, nil, err
+			}
+			ftype.parameter = append(ftype.parameter, types...)
+
+		} else if result, ok := cursor.list("result"); ok {
+			types, err := parseWatValueTypes(listCursor(result))
+			if (err != nil) {
+				return ftype, nil, err
+			}
+			ftype.result = append(ftype.result, types...)
+
+		} else {
+			break
+		}
+	}
+
+	return ftype, ids, nil
+}
+
+//
+// Type use: "(type x)", optionally followed by a matching signature, or a
+// signature alone.  Returns the type index, plus any parameter identifiers.
+// A signature without an explicit type reuses the first identical type, or
+// else defines a new one
+//
+func (asm *watAssembler) typeUse(cursor *watCursor) (uint32, map[string]uint32,
+	error) {
+	var index uint32
+	explicit := false
+	if typeNode, ok := cursor.list("type"); ok {
+		inner := listCursor(typeNode)
+		if (inner.done()) {
+			return 0, nil, typeNode.errorf("missing type index")
+		}
+		var err error
+		index, err = asm.typeSpace.resolve(inner.next())
+		if (err != nil) {
+			return 0, nil, err
+		}
+		err = inner.end()
+		if (err != nil) {
+			return 0, nil, err
+		}
+		if (int(index) >= len(asm.types)) {
+			return 0, nil, typeNode.errorf("unknown type %d", index)
+		}
+		explicit = true
+	}
+
+	position := cursor.pos
+	ftype, ids, err := parseWatSignature(cursor)
+	if (err != nil) {
+		return 0, nil, err
+	}
+	hasSignature := (cursor.pos != position)
+
+	if (explicit) {
+		if (hasSignature && !equalFunctionTypes(ftype, asm.types[index])) {
+			return 0, nil, cursor.nodes[position].errorf(
+				"inline signature does not match type %d", index)
+		}
+		return index, ids, nil
+	}
+	return asm.typeIndex(ftype), ids, nil
+}
+
+// Index of the first identical type, adding it to the type section if
+// necessary
+func (asm *watAssembler) typeIndex(ftype FunctionType) uint32 {
+	for i, existing := range asm.types {
+		if (equalFunctionTypes(existing, ftype)) {
+			return uint32(i)
+		}
+	}
+	asm.types = append(asm.types, ftype)
+	asm.typeSpace.count++
+	return uint32(len(asm.types) - 1)
+}
+
+func equalFunctionTypes(a, b FunctionType) bool {
+	return (equalTypes(a.parameter, b.parameter) &&
+		equalTypes(a.result, b.result))
+}
+
+func parseWatValueType(cursor *watCursor) (ValueType, error) {
+	token, ok := cursor.atom(watTokenKeyword)
+	if !ok {
+		return unknownType, cursor.errorf("expected value type")
+	}
+	switch(token.text) {
+		case "i32":					return NumTypei32, nil
+		case "i64":					return NumTypei64, nil
+		case "f32":					return NumTypef32, nil
+		case "f64":					return NumTypef64, nil
+		case "v128":				return VecTypev128, nil
+		case "funcref", "anyfunc":	return RefTypeFunction, nil
+		case "externref":			return RefTypeExtern, nil
+	}
+	return unknownType, token.errorf("unknown value type %s", token.text)
+}
+
+// Zero or more value types, to the end of the cursor
+func parseWatValueTypes(cursor *watCursor) ([]ValueType, error) {
+	types := []ValueType{}
+	for !cursor.done() {
+		vtype, err := parseWatValueType(cursor)
+		if (err != nil) {
+			return nil, err
+		}
+		types = append(types, vtype)
+	}
+	return types, nil
+}
+
+func parseWatRefType(cursor *watCursor) (ValueType, error) {
+	vtype, err := parseWatValueType(cursor)
+	if (err == nil && !isRefType(vtype)) {
+		return unknownType, cursor.nodes[cursor.pos - 1].errorf(
+			"expected reference type")
+	}
+	return vtype, err
+}
+
+// Limits: min, plus optional max
+func parseWatLimit(cursor *watCursor) (Limit, error) {
+	if (cursor.done()) {
+		return Limit{}, cursor.errorf("expected limits")
+	} else if (cursor.peek().isKeyword("i64")) {
+		return Limit{}, cursor.peek().unsupportedf("64-bit address type")
+	}
+	min, err := parseWatU32(cursor.next())
+	if (err != nil) {
+		return Limit{}, err
+	}
+	if (!cursor.done() && isWatReference(cursor.peek())) {
+		max, err := parseWatU32(cursor.next())
+		return Limit{ min, max, 0x01 }, err
+	}
+	return Limit{ min, 0, 0x00 }, nil
+}
+
+// Global type: "t" or "(mut t)"
+func parseWatGlobalType(cursor *watCursor) (GlobalType, error) {
+	if mutable, ok := cursor.list("mut"); ok {
+		inner := listCursor(mutable)
+		vtype, err := parseWatValueType(inner)
+		if (err != nil) {
+			return GlobalType{}, err
+		}
+		return GlobalType{ vtype, true }, inner.end()
+	}
+	vtype, err := parseWatValueType(cursor)
+	return GlobalType{ vtype, false }, err
+}
+
+
+//
+// Imports + exports
+//
+
+// Inline "(import "module" "name")" abbreviation, if any
+func parseWatInlineImport(cursor *watCursor) (*Import, error) {
+	node, ok := cursor.list("import")
+	if !ok {
+		return nil, nil
+	}
+	inner := listCursor(node)
+	module, ok := inner.atom(watTokenString)
+	if !ok {
+		return nil, inner.errorf("expected module name")
+	}
+	name, ok := inner.atom(watTokenString)
+	if !ok {
+		return nil, inner.errorf("expected import name")
+	}
+	return &Import{ module: module.text, name: name.text }, inner.end()
+}
+
+// Any inline "(export "name")" abbreviations, for the given entity
+func (asm *watAssembler) inlineExports(cursor *watCursor, etype uint8,
+	index uint32) error {
+	for {
+		node, ok := cursor.list("export")
+		if !ok {
+			return nil
+		}
+		inner := listCursor(node)
+		name, ok := inner.atom(watTokenString)
+		if !ok {
+			return inner.errorf("expected export name")
+		}
+		asm.exports = append(asm.exports, Export{ name.text, etype, index })
+		err := inner.end()
+		if (err != nil) {
+			return err
+		}
+	}
+}
+
+// (import "module" "name" (func|table|memory|global $id? ...))
+func (asm *watAssembler) importField(field watNode) error {
+	cursor := listCursor(field)
+	module, ok := cursor.atom(watTokenString)
+	if !ok {
+		return cursor.errorf("expected module name")
+	}
+	name, ok := cursor.atom(watTokenString)
+	if !ok {
+		return cursor.errorf("expected import name")
+	}
+	if (cursor.done() || !cursor.peek().list ||
+		len(cursor.peek().children) == 0) {
+		return cursor.errorf("expected import description")
+	}
+	desc := cursor.next()
+	err := cursor.end()
+	if (err != nil) {
+		return err
+	}
+
+	imp := Import{ module: module.text, name: name.text }
+	inner := listCursor(desc)
+	id := inner.id()
+	switch(desc.children[0].token.text) {
+		case "func":
+			err = asm.importFunction(desc, inner, id, imp)
+		case "table":
+			err = asm.importTable(desc, inner, id, imp)
+		case "memory":
+			err = asm.importMemory(desc, inner, id, imp)
+		case "global":
+			err = asm.importGlobal(desc, inner, id, imp)
+		default:
+			return desc.errorf("unknown import kind %s", desc)
+	}
+	if (err != nil) {
+		return err
+	}
+	return inner.end()
+}
+
+func (asm *watAssembler) importFunction(node watNode, cursor *watCursor,
+	id string, imp Import) error {
+	index, err := asm.functions.defineImport(node, id)
+	if (err != nil) {
+		return err
+	}
+	imp.itype = ExportTypeFunction
+	imp.function, _, err = asm.typeUse(cursor)
+	if (err != nil) {
+		return err
+	}
+	asm.imports = append(asm.imports, imp)
+	return asm.inlineExports(cursor, ExportTypeFunction, index)
+}
+
+func (asm *watAssembler) importTable(node watNode, cursor *watCursor,
+	id string, imp Import) error {
+	_, err := asm.tables.defineImport(node, id)
+	if (err != nil) {
+		return err
+	}
+	imp.itype = ExportTypeTable
+	imp.table.limit, err = parseWatLimit(cursor)
+	if (err != nil) {
+		return err
+	}
+	reftype, err := parseWatRefType(cursor)
+	imp.table.reftype = uint8(reftype)
+	asm.imports = append(asm.imports, imp)
+	return err
+}
+
+func (asm *watAssembler) importMemory(node watNode, cursor *watCursor,
+	id string, imp Import) error {
+	_, err := asm.memories.defineImport(node, id)
+	if (err != nil) {
+		return err
+	}
+	imp.itype = ExportTypeMemory
+	imp.memory.limit, err = parseWatLimit(cursor)
+	asm.imports = append(asm.imports, imp)
+	return err
+}
+
+func (asm *watAssembler) importGlobal(node watNode, cursor *watCursor,
+	id string, imp Import) error {
+	_, err := asm.globals.defineImport(node, id)
+	if (err != nil) {
+		return err
+	}
+	imp.itype = ExportTypeGlobal
+	imp.global, err = parseWatGlobalType(cursor)
+	asm.imports = append(asm.imports, imp)
+	return err
+}
+
+// (export "name" (func|table|memory|global x))
+func (asm *watAssembler) exportField(field watNode) error {
+	cursor := listCursor(field)
+	name, ok := cursor.atom(watTokenString)
+	if !ok {
+		return cursor.errorf("expected export name")
+	}
+	if (cursor.done() || !cursor.peek().list ||
+		len(cursor.peek().children) == 0) {
+		return cursor.errorf("expected export description")
+	}
+	desc := cursor.next()
+	err := cursor.end()
+	if (err != nil) {
+		return err
+	}
+
+	var etype uint8
+	var space *watSpace
+	switch(desc.children[0].token.text) {
+		case "func":	etype, space = ExportTypeFunction, asm.functions
+		case "table":	etype, space = ExportTypeTable, asm.tables
+		case "memory":	etype, space = ExportTypeMemory, asm.memories
+		case "global":	etype, space = ExportTypeGlobal, asm.globals
+		default:
+			return desc.errorf("unknown export kind %s", desc)
+	}
+
+	// Reserve the export's position now, so that exports remain in source
+	// order, but resolve its reference once all identifiers are known
+	position := len(asm.exports)
+	asm.exports = append(asm.exports, Export{ name: name.text, etype: etype })
+	asm.pending = append(asm.pending, func() error {
+		inner := listCursor(desc)
+		if (inner.done()) {
+			return desc.errorf("missing %s index", space.kind)
+		}
+		index, err := space.resolve(inner.next())
+		if (err != nil) {
+			return err
+		}
+		asm.exports[position].index = index
+		return inner.end()
+	})
+	return nil
+}
+
+// (start x)
+func (asm *watAssembler) startField(field watNode) error {
+	if (asm.start != nil) {
+		return field.errorf("multiple start functions")
+	}
+	asm.start = new(uint32)
+	asm.pending = append(asm.pending, func() error {
+		cursor := listCursor(field)
+		if (cursor.done()) {
+			return cursor.errorf("missing start function")
+		}
+		var err error
+		*asm.start, err = asm.functions.resolve(cursor.next())
+		if (err != nil) {
+			return err
+		}
+		return cursor.end()
+	})
+	return nil
+}
+
+
+//
+// Functions, tables, memories + globals
+//
+
+// (func $id? (export ...)* (import ...)? typeuse (local ...)* instr*)
+func (asm *watAssembler) funcField(field watNode) error {
+	cursor := listCursor(field)
+	id := cursor.id()
+
+	// Exports precede any import, but apply to the same index
+	exports := cursor.pos
+	for {
+		if _, ok := cursor.list("export"); !ok {
+			break
+		}
+	}
+	imp, err := parseWatInlineImport(cursor)
+	if (err != nil) {
+		return err
+	}
+
+	var index uint32
+	if (imp != nil) {
+		index, err = asm.functions.defineImport(field, id)
+	} else {
+		index, err = asm.functions.define(field, id)
+	}
+	if (err != nil) {
+		return err
+	}
+	err = asm.inlineExports(&watCursor{ nodes: cursor.nodes[:cursor.pos],
+		pos: exports, parent: field }, ExportTypeFunction, index)
+	if (err != nil) {
+		return err
+	}
+
+	tindex, params, err := asm.typeUse(cursor)
+	if (err != nil) {
+		return err
+	}
+	if (imp != nil) {
+		imp.itype = ExportTypeFunction
+		imp.function = tindex
+		asm.imports = append(asm.imports, *imp)
+		return cursor.end()
+	}
+
+	// Reserve this function's slot; its body is generated in the second pass
+	slot := len(asm.code)
+	asm.signatures = append(asm.signatures, tindex)
+	asm.code = append(asm.code, Function{})
+	asm.pending = append(asm.pending, func() error {
+		function, err := asm.function(cursor, index, asm.types[tindex], params)
+		asm.code[slot] = function
+		return err
+	})
+	return nil
+}
+
+// Generate a single function: locals + body
+func (asm *watAssembler) function(cursor *watCursor, index uint32,
+	ftype FunctionType, params map[string]uint32) (Function, error) {
+	function := Function{}
+	compiler := createWatCompiler(asm)
+	for id, local := range params {
+		compiler.locals[id] = local
+	}
+	count := uint32(len(ftype.parameter))
+
+	for {
+		node, ok := cursor.list("local")
+		if !ok {
+			break
+		}
+		inner := listCursor(node)
+		if id := inner.id(); id != "" {
+			if _, ok := compiler.locals[id]; ok {
+				return function, node.errorf("duplicate local %s", id)
+			}
+			compiler.locals[id] = count
+			vtype, err := parseWatValueType(inner)
+			if (err != nil) {
+				return function, err
+			}
+			function.local = append(function.local, vtype)
+			count++
+			err = inner.end()
+			if (err != nil) {
+				return function, err
+			}
+			continue
+		}
+		types, err := parseWatValueTypes(inner)
+		if (err != nil) {
+			return function, err
+		}
+		function.local = append(function.local, types...)
+		count += uint32(len(types))
+	}
+
+	err := compiler.sequence(cursor)
+	if (err != nil) {
+		return function, err
+	}
+	if (!cursor.done()) {
+		return function, cursor.errorf("unexpected %s", cursor.peek())
+	}
+	function.body = append(compiler.code, 0x0B)
+
+	if (len(compiler.locals) > 0) {
+		names := make(NameMap)
+		for id, local := range compiler.locals {
+			names[local] = id[1:]
+		}
+		asm.localNames[index] = names
+	}
+	return function, nil
+}
+
+// (table $id? (export ...)* (import ...)? limits reftype), or
+// (table $id? (export ...)* reftype (elem ...))
+func (asm *watAssembler) tableField(field watNode) error {
+	cursor := listCursor(field)
+	id := cursor.id()
+	exports := cursor.pos
+	for {
+		if _, ok := cursor.list("export"); !ok {
+			break
+		}
+	}
+	imp, err := parseWatInlineImport(cursor)
+	if (err != nil) {
+		return err
+	}
+
+	var index uint32
+	if (imp != nil) {
+		index, err = asm.tables.defineImport(field, id)
+	} else {
+		index, err = asm.tables.define(field, id)
+	}
+	if (err != nil) {
+		return err
+	}
+	err = asm.inlineExports(&watCursor{ nodes: cursor.nodes[:cursor.pos],
+		pos: exports, parent: field }, ExportTypeTable, index)
+	if (err != nil) {
+		return err
+	}
+
+	// Inline element segment: the table is sized to fit exactly
+	if (!cursor.done() && !isWatReference(cursor.peek())) {
+		reftype, err := parseWatRefType(cursor)
+		if (err != nil) {
+			return err
+		}
+		elem, ok := cursor.list("elem")
+		if (!ok || imp != nil) {
+			return cursor.errorf("expected (elem ...)")
+		}
+		err = cursor.end()
+		if (err != nil) {
+			return err
+		}
+
+		_, err = asm.elements.define(elem, "")
+		if (err != nil) {
+			return err
+		}
+		slot := len(asm.elementDefs)
+		asm.elementDefs = append(asm.elementDefs, Element{})
+		asm.tableDefs = append(asm.tableDefs, Table{ reftype: uint8(reftype) })
+		table := len(asm.tableDefs) - 1
+		asm.pending = append(asm.pending, func() error {
+			element := Element{ mode: SegmentModeActive, table: index,
+				offset: []byte{ 0x41, 0x00, 0x0B }, reftype: reftype }
+			err := asm.elementList(listCursor(elem), &element, true)
+			if (err != nil) {
+				return err
+			}
+			if (index != 0) {
+				element.flags |= 0x2
+			}
+			size := uint32(element.length())
+			asm.tableDefs[table].limit = Limit{ size, size, 0x01 }
+			asm.elementDefs[slot] = element
+			return nil
+		})
+		return nil
+	}
+
+	table := Table{}
+	table.limit, err = parseWatLimit(cursor)
+	if (err != nil) {
+		return err
+	}
+	reftype, err := parseWatRefType(cursor)
+	if (err != nil) {
+		return err
+	}
+	table.reftype = uint8(reftype)
+
+	if (imp != nil) {
+		imp.itype = ExportTypeTable
+		imp.table = table
+		asm.imports = append(asm.imports, *imp)
+	} else {
+		asm.tableDefs = append(asm.tableDefs, table)
+	}
+	return cursor.end()
+}
+
+// (memory $id? (export ...)* (import ...)? limits), or
+// (memory $id? (export ...)* (data "..."*))
+func (asm *watAssembler) memoryField(field watNode) error {
+	cursor := listCursor(field)
+	id := cursor.id()
+	exports := cursor.pos
+	for {
+		if _, ok := cursor.list("export"); !ok {
+			break
+		}
+	}
+	imp, err := parseWatInlineImport(cursor)
+	if (err != nil) {
+		return err
+	}
+
+	var index uint32
+	if (imp != nil) {
+		index, err = asm.memories.defineImport(field, id)
+	} else {
+		index, err = asm.memories.define(field, id)
+	}
+	if (err != nil) {
+		return err
+	}
+	err = asm.inlineExports(&watCursor{ nodes: cursor.nodes[:cursor.pos],
+		pos: exports, parent: field }, ExportTypeMemory, index)
+	if (err != nil) {
+		return err
+	}
+
+	// Inline data segment: the memory is sized to fit, in 64KB pages
+	if data, ok := cursor.list("data"); ok {
+		if (imp != nil) {
+			return data.errorf("unexpected inline data for imported memory")
+		}
+		init, err := parseWatStrings(listCursor(data))
+		if (err != nil) {
+			return err
+		}
+		_, err = asm.data.define(data, "")
+		if (err != nil) {
+			return err
+		}
+		pages := uint32((len(init) + 65535) / 65536)
+		asm.memoryDefs = append(asm.memoryDefs,
+			Memory{ Limit{ pages, pages, 0x01 } })
+		segment := Data{ mode: SegmentModeActive, memory: index,
+			offset: []byte{ 0x41, 0x00, 0x0B }, init: init }
+		if (index != 0) {
+			segment.flags = 2
+		}
+		asm.dataDefs = append(asm.dataDefs, segment)
+		return cursor.end()
+	}
+
+	memory := Memory{}
+	memory.limit, err = parseWatLimit(cursor)
+	if (err != nil) {
+		return err
+	}
+	if (imp != nil) {
+		imp.itype = ExportTypeMemory
+		imp.memory = memory
+		asm.imports = append(asm.imports, *imp)
+	} else {
+		asm.memoryDefs = append(asm.memoryDefs, memory)
+	}
+	return cursor.end()
+}
+
+// (global $id? (export ...)* (import ...)? globaltype expr)
+func (asm *watAssembler) globalField(field watNode) error {
+	cursor := listCursor(field)
+	id := cursor.id()
+	exports := cursor.pos
+	for {
+		if _, ok := cursor.list("export"); !ok {
+			break
+		}
+	}
+	imp, err := parseWatInlineImport(cursor)
+	if (err != nil) {
+		return err
+	}
+
+	var index uint32
+	if (imp != nil) {
+		index, err = asm.globals.defineImport(field, id)
+	} else {
+		index, err = asm.globals.define(field, id)
+	}
+	if (err != nil) {
+		return err
+	}
+	err = asm.inlineExports(&watCursor{ nodes: cursor.nodes[:cursor.pos],
+		pos: exports, parent: field }, ExportTypeGlobal, index)
+	if (err != nil) {
+		return err
+	}
+
+	gtype, err := parseWatGlobalType(cursor)
+	if (err != nil) {
+		return err
+	}
+	if (imp != nil) {
+		imp.itype = ExportTypeGlobal
+		imp.global = gtype
+		asm.imports = append(asm.imports, *imp)
+		return cursor.end()
+	}
+
+	slot := len(asm.globalDefs)
+	asm.globalDefs = append(asm.globalDefs, Global{ gtype: gtype })
+	asm.pending = append(asm.pending, func() error {
+		init, err := asm.expression(cursor)
+		asm.globalDefs[slot].init = init
+		return err
+	})
+	return nil
+}
+
+
+//
+// Element + data segments
+//
+
+// (elem $id? declare? (table x)? (offset ...)|(instr)? elemlist)
+func (asm *watAssembler) elemField(field watNode) error {
+	cursor := listCursor(field)
+	_, err := asm.elements.define(field, cursor.id())
+	if (err != nil) {
+		return err
+	}
+
+	slot := len(asm.elementDefs)
+	asm.elementDefs = append(asm.elementDefs, Element{})
+	asm.pending = append(asm.pending, func() error {
+		element := Element{ mode: SegmentModePassive, flags: 0x1,
+			reftype: RefTypeFunction }
+
+		if (cursor.keyword("declare")) {
+			element.mode = SegmentModeDeclarative
+			element.flags = 0x3
+		} else {
+			tableNode, explicit := cursor.list("table")
+			if (explicit) {
+				inner := listCursor(tableNode)
+				if (inner.done()) {
+					return tableNode.errorf("missing table index")
+				}
+				var err error
+				element.table, err = asm.tables.resolve(inner.next())
+				if (err != nil) {
+					return err
+				}
+				err = inner.end()
+				if (err != nil) {
+					return err
+				}
+			}
+
+			offset, ok, err := asm.offset(cursor)
+			if (err != nil) {
+				return err
+			}
+			if (ok) {
+				element.mode = SegmentModeActive
+				element.offset = offset
+				element.flags = 0
+				if (element.table != 0) {
+					element.flags = 0x2
+				}
+			} else if (explicit) {
+				return cursor.errorf("expected offset expression")
+			}
+		}
+
+		err := asm.elementList(cursor, &element,
+			element.mode == SegmentModeActive)
+		asm.elementDefs[slot] = element
+		return err
+	})
+	return nil
+}
+
+//
+// Element list: "func x*", "reftype (item ...)*", or (for active segments)
+// the legacy bare "x*".  Sets the reference type, contents + encoding flags
+//
+func (asm *watAssembler) elementList(cursor *watCursor, element *Element,
+	legacy bool) error {
+	if (!cursor.done() && !cursor.peek().list &&
+		cursor.peek().token.kind == watTokenKeyword &&
+		!isWatReference(cursor.peek())) {
+		if (!cursor.keyword("func")) {
+			// Reference type, followed by expressions
+			reftype, err := parseWatRefType(cursor)
+			if (err != nil) {
+				return err
+			}
+			element.reftype = reftype
+			element.flags |= 0x4
+			element.init = [][]byte{}
+			for !cursor.done() {
+				node := cursor.next()
+				if (!node.list) {
+					return node.errorf("expected element expression")
+				}
+				var expr []byte
+				var err error
+				if (node.isList("item")) {
+					expr, err = asm.expression(listCursor(node))
+				} else {
+					expr, err = asm.expression(&watCursor{
+						nodes: []watNode{ node }, parent: node })
+				}
+				if (err != nil) {
+					return err
+				}
+				element.init = append(element.init, expr)
+			}
+			return nil
+		}
+	} else if (!legacy && !cursor.done()) {
+		return cursor.errorf("expected element list")
+	}
+
+	for !cursor.done() {
+		index, err := asm.functions.resolve(cursor.next())
+		if (err != nil) {
+			return err
+		}
+		element.function = append(element.function, index)
+	}
+	return nil
+}
+
+// (data $id? (memory x)? (offset ...)|(instr)? "..."*)
+func (asm *watAssembler) dataField(field watNode) error {
+	cursor := listCursor(field)
+	_, err := asm.data.define(field, cursor.id())
+	if (err != nil) {
+		return err
+	}
+
+	slot := len(asm.dataDefs)
+	asm.dataDefs = append(asm.dataDefs, Data{})
+	asm.pending = append(asm.pending, func() error {
+		data := Data{ mode: SegmentModePassive, flags: 1 }
+
+		memoryNode, explicit := cursor.list("memory")
+		if (explicit) {
+			inner := listCursor(memoryNode)
+			if (inner.done()) {
+				return memoryNode.errorf("missing memory index")
+			}
+			var err error
+			data.memory, err = asm.memories.resolve(inner.next())
+			if (err != nil) {
+				return err
+			}
+			err = inner.end()
+			if (err != nil) {
+				return err
+			}
+		}
+
+		offset, ok, err := asm.offset(cursor)
+		if (err != nil) {
+			return err
+		}
+		if (ok) {
+			data.mode = SegmentModeActive
+			data.offset = offset
+			data.flags = 0
+			if (data.memory != 0) {
+				data.flags = 2
+			}
+		} else if (explicit) {
+			return cursor.errorf("expected offset expression")
+		}
+
+		data.init, err = parseWatStrings(cursor)
+		asm.dataDefs[slot] = data
+		return err
+	})
+	return nil
+}
+
+// Offset of an active segment: "(offset instr*)" or a single folded
+// instruction, if any
+func (asm *watAssembler) offset(cursor *watCursor) ([]byte, bool, error) {
+	if (cursor.done() || !cursor.peek().list) {
+		return nil, false, nil
+	}
+	node := cursor.next()
+	if (node.isList("offset")) {
+		expr, err := asm.expression(listCursor(node))
+		return expr, true, err
+	}
+	expr, err := asm.expression(&watCursor{ nodes: []watNode{ node },
+		parent: node })
+	return expr, true, err
+}
+
+// Constant expression: instructions to the end of the cursor, plus "end"
+func (asm *watAssembler) expression(cursor *watCursor) ([]byte, error) {
+	compiler := createWatCompiler(asm)
+	err := compiler.sequence(cursor)
+	if (err != nil) {
+		return nil, err
+	}
+	if (!cursor.done()) {
+		return nil, cursor.errorf("unexpected %s", cursor.peek())
+	}
+	return append(compiler.code, 0x0B), nil
+}
+
+// Concatenation of zero or more strings, to the end of the cursor
+func parseWatStrings(cursor *watCursor) ([]byte, error) {
+	content := []byte{}
+	for !cursor.done() {
+		token, ok := cursor.atom(watTokenString)
+		if !ok {
+			return nil, cursor.errorf("expected string")
+		}
+		content = append(content, token.text...)
+	}
+	return content, nil
+}
+
+
+//
+// Instructions
+//
+
+// Opcode + immediate encoding, by mnemonic
+type watOpcode struct {
+	opcode		uint8
+	subopcode	uint32
+	immediate	uint8
+}
+
+var watOpcodes = createWatOpcodes()
+
+// Invert the decoder's opcode tables.  No side effects.
+func createWatOpcodes() map[string]watOpcode {
+	opcodes := make(map[string]watOpcode)
+	for opcode, info := range opcodeTable {
+		if (opcode == 0x1C) {
+			// Typed "select" shares its mnemonic; see watCompiler.plain()
+			continue
+		}
+		opcodes[info.name] = watOpcode{ opcode, 0, info.immediate }
+	}
+	for subopcode, info := range miscOpcodeTable {
+		opcodes[info.name] = watOpcode{ PrefixMisc, subopcode, info.immediate }
+	}
+	for subopcode, info := range simdOpcodeTable {
+		opcodes[info.name] = watOpcode{ PrefixSIMD, subopcode, info.immediate }
+	}
+	return opcodes
+}
+
+// Binary encoding of the opcode itself
+func (op watOpcode) encode() []byte {
+	if (op.opcode == PrefixMisc || op.opcode == PrefixSIMD) {
+		return appendULEB128([]byte{ op.opcode }, uint64(op.subopcode))
+	}
+	return []byte{ op.opcode }
+}
+
+
+//
+// Instruction compiler for a single function body or constant expression.
+// Tracks the locals + enclosing block labels, for resolving identifiers
+//
+type watCompiler struct {
+	asm		*watAssembler
+	locals	map[string]uint32
+	labels	[]string	// Innermost last; "" for unnamed blocks
+	code	[]byte
+}
+
+func createWatCompiler(asm *watAssembler) *watCompiler {
+	return &watCompiler{ asm: asm, locals: make(map[string]uint32) }
+}
+
+// Compile instructions until the end of the cursor, or any of the given
+// terminating keywords (e.g., "end"), which is left unconsumed
+func (c *watCompiler) sequence(cursor *watCursor, terminators ...string) error {
+	for !cursor.done() {
+		node := cursor.peek()
+		if (node.list) {
+			cursor.next()
+			err := c.folded(node)
+			if (err != nil) {
+				return err
+			}
+			continue
+		}
+
+		if (node.token.kind != watTokenKeyword) {
+			return node.errorf("expected instruction, found %s", node)
+		}
+		for _, terminator := range terminators {
+			if (node.token.text == terminator) {
+				return nil
+			}
+		}
+
+		cursor.next()
+		var err error
+		switch(node.token.text) {
+			case "block", "loop", "if":
+				err = c.flatBlock(node, cursor)
+			default:
+				err = c.plain(node, cursor, nil)
+		}
+		if (err != nil) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flat block: "block label? blocktype instr* end label?", incl. "else"
+func (c *watCompiler) flatBlock(node watNode, cursor *watCursor) error {
+	op := watOpcodes[node.token.text]
+	label := cursor.id()
+	btype, err := c.blockType(cursor)
+	if (err != nil) {
+		return err
+	}
+	c.code = append(append(c.code, op.opcode), btype...)
+	c.labels = append(c.labels, label)
+
+	err = c.sequence(cursor, "end", "else")
+	if (err == nil && op.opcode == 0x04 && cursor.keyword("else")) {
+		err = c.closingLabel(cursor, label)
+		if (err == nil) {
+			c.code = append(c.code, 0x05)
+			err = c.sequence(cursor, "end", "else")
+		}
+	}
+	if (err != nil) {
+		return err
+	}
+	if (!cursor.keyword("end")) {
+		return cursor.errorf("missing end of %s", node.token.text)
+	}
+	c.code = append(c.code, 0x0B)
+	c.labels = c.labels[:len(c.labels) - 1]
+	return c.closingLabel(cursor, label)
+}
+
+// Optional label after "else" or "end", which must match the block label
+func (c *watCompiler) closingLabel(cursor *watCursor, label string) error {
+	if (cursor.done() || cursor.peek().list ||
+		cursor.peek().token.kind != watTokenId) {
+		return nil
+	}
+	token := cursor.next().token
+	if (token.text != label) {
+		return token.errorf("mismatched label %s", token.text)
+	}
+	return nil
+}
+
+// Folded instruction, e.g. "(i32.add (local.get 0) (i32.const 1))"
+func (c *watCompiler) folded(node watNode) error {
+	if (len(node.children) == 0 || node.children[0].list ||
+		node.children[0].token.kind != watTokenKeyword) {
+		return node.errorf("expected instruction, found %s", node)
+	}
+	keyword := node.children[0]
+	cursor := listCursor(node)
+
+	switch(keyword.token.text) {
+		case "block", "loop":
+			op := watOpcodes[keyword.token.text]
+			label := cursor.id()
+			btype, err := c.blockType(cursor)
+			if (err != nil) {
+				return err
+			}
+			c.code = append(append(c.code, op.opcode), btype...)
+			c.labels = append(c.labels, label)
+			err = c.sequence(cursor)
+			if (err != nil) {
+				return err
+			}
+			c.code = append(c.code, 0x0B)
+			c.labels = c.labels[:len(c.labels) - 1]
+			return nil
+
+		case "if":
+			// (if label? blocktype foldedinstr* (then instr*) (else instr*)?)
+			label := cursor.id()
+			btype, err := c.blockType(cursor)
+			if (err != nil) {
+				return err
+			}
+			for !cursor.done() && cursor.peek().list &&
+				!cursor.peek().isList("then") {
+				err = c.folded(cursor.next())
+				if (err != nil) {
+					return err
+				}
+			}
+			then, ok := cursor.list("then")
+			if !ok {
+				return cursor.errorf("expected (then ...)")
+			}
+
+			c.code = append(append(c.code, 0x04), btype...)
+			c.labels = append(c.labels, label)
+			err = c.sequence(listCursor(then))
+			if (err != nil) {
+				return err
+			}
+			if elseNode, ok := cursor.list("else"); ok {
+				c.code = append(c.code, 0x05)
+				err = c.sequence(listCursor(elseNode))
+				if (err != nil) {
+					return err
+				}
+			}
+			c.code = append(c.code, 0x0B)
+			c.labels = c.labels[:len(c.labels) - 1]
+			return cursor.end()
+	}
+
+	// Plain instruction: immediates, then operands as folded instructions,
+	// which are evaluated first
+	return c.plain(keyword, cursor, func() error {
+		for !cursor.done() {
+			err := c.folded(cursor.next())
+			if (err != nil) {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+//
+// Plain instruction + its immediates.  For folded instructions, the given
+// operands are generated after the immediates are parsed, but before the
+// instruction itself
+//
+func (c *watCompiler) plain(node watNode, cursor *watCursor,
+	operands func() error) error {
+	op, ok := watOpcodes[node.token.text]
+	if !ok {
+		return node.errorf("unknown instruction %s", node.token.text)
+	}
+
+	immediates, err := c.immediates(node, op, cursor)
+	if (err != nil) {
+		return err
+	}
+	if (op.opcode == 0x1B && len(immediates) > 0) {
+		// Typed select
+		op = watOpcode{ 0x1C, 0, ImmediateValueTypes }
+	}
+
+	if (operands != nil) {
+		err = operands()
+		if (err != nil) {
+			return err
+		}
+	}
+	if (op.opcode == PrefixMisc && (op.subopcode == 8 || op.subopcode == 9)) {
+		// memory.init + data.drop require a data count section
+		c.asm.dataCount = true
+	}
+	c.code = append(append(c.code, op.encode()...), immediates...)
+	return nil
+}
+
+// Encode the immediates of a single instruction
+func (c *watCompiler) immediates(node watNode, op watOpcode,
+	cursor *watCursor) ([]byte, error) {
+	asm := c.asm
+
+	switch(op.immediate) {
+		case ImmediateNone:
+			if (op.opcode == 0x1B) {
+				// Untyped select, unless followed by "(result t*)"
+				var types []ValueType
+				for {
+					result, ok := cursor.list("result")
+					if !ok {
+						break
+					}
+					more, err := parseWatValueTypes(listCursor(result))
+					if (err != nil) {
+						return nil, err
+					}
+					types = append(types, more...)
+				}
+				if (types == nil) {
+					return nil, nil
+				}
+				immediates := appendVectorLength(nil, len(types))
+				for _, vtype := range types {
+					immediates = append(immediates, byte(vtype))
+				}
+				return immediates, nil
+			}
+			return nil, nil
+
+		case ImmediateBlockType:
+			return c.blockType(cursor)
+
+		case ImmediateIndex:
+			index, err := c.index(node, op, cursor)
+			return appendULEB128(nil, uint64(index)), err
+
+		case ImmediateIndex2:
+			return c.index2(node, op, cursor)
+
+		case ImmediateBranchTable:
+			var labels []uint32
+			for !cursor.done() && isWatReference(cursor.peek()) {
+				label, err := c.label(cursor.next())
+				if (err != nil) {
+					return nil, err
+				}
+				labels = append(labels, label)
+			}
+			if (len(labels) == 0) {
+				return nil, cursor.errorf("missing br_table label")
+			}
+			immediates := appendVectorLength(nil, len(labels) - 1)
+			for _, label := range labels {
+				immediates = appendULEB128(immediates, uint64(label))
+			}
+			return immediates, nil
+
+		case ImmediateMemarg:
+			return c.memarg(node, cursor)
+
+		case ImmediateMemory:
+			index, _, err := asm.memories.optional(cursor)
+			return []byte{ byte(index) }, err
+
+		case ImmediateMemory2:
+			dst, ok, err := asm.memories.optional(cursor)
+			if (err != nil) {
+				return nil, err
+			}
+			src := uint32(0)
+			if (ok) {
+				src, err = asm.memories.resolve(cursor.next())
+			}
+			return []byte{ byte(dst), byte(src) }, err
+
+		case ImmediateIndexMemory:
+			// memory.init memory? data
+			first, ok, err := asm.data.optional(cursor)
+			if (err != nil || !ok) {
+				return nil, cursor.errorf("missing data index")
+			}
+			memory := uint32(0)
+			if (!cursor.done() && isWatReference(cursor.peek())) {
+				cursor.pos--
+				memory, err = asm.memories.resolve(cursor.next())
+				if (err != nil) {
+					return nil, err
+				}
+				first, err = asm.data.resolve(cursor.next())
+			}
+			return append(appendULEB128(nil, uint64(first)), byte(memory)), err
+
+		case ImmediateI32:
+			if (cursor.done()) {
+				return nil, cursor.errorf("missing i32 value")
+			}
+			value, err := parseWatInteger(cursor.next(), 32)
+			return appendSLEB128(nil, int64(int32(value))), err
+
+		case ImmediateI64:
+			if (cursor.done()) {
+				return nil, cursor.errorf("missing i64 value")
+			}
+			value, err := parseWatInteger(cursor.next(), 64)
+			return appendSLEB128(nil, int64(value)), err
+
+		case ImmediateF32:
+			if (cursor.done()) {
+				return nil, cursor.errorf("missing f32 value")
+			}
+			bits, err := parseWatFloat(cursor.next(), 32)
+			var buffer [4]byte
+			binary.LittleEndian.PutUint32(buffer[:], uint32(bits))
+			return buffer[:], err
+
+		case ImmediateF64:
+			if (cursor.done()) {
+				return nil, cursor.errorf("missing f64 value")
+			}
+			bits, err := parseWatFloat(cursor.next(), 64)
+			var buffer [8]byte
+			binary.LittleEndian.PutUint64(buffer[:], bits)
+			return buffer[:], err
+
+		case ImmediateRefType:
+			if (cursor.keyword("func")) {
+				return []byte{ RefTypeFunction }, nil
+			} else if (cursor.keyword("extern")) {
+				return []byte{ RefTypeExtern }, nil
+			}
+			return nil, cursor.errorf("expected heap type")
+
+		case ImmediateV128:
+			return parseWatV128(cursor)
+
+		case ImmediateShuffle:
+			immediates := make([]byte, 16)
+			for i := range immediates {
+				lane, err := c.lane(cursor)
+				if (err != nil) {
+					return nil, err
+				}
+				immediates[i] = lane
+			}
+			return immediates, nil
+
+		case ImmediateLane:
+			lane, err := c.lane(cursor)
+			return []byte{ lane }, err
+
+		case ImmediateMemargLane:
+			immediates, err := c.memarg(node, cursor)
+			if (err != nil) {
+				return nil, err
+			}
+			lane, err := c.lane(cursor)
+			return append(immediates, lane), err
+	}
+
+	return nil, node.errorf("unsupported instruction %s", node.token.text)
+}
+
+// Single index immediate, resolved in the index space of the instruction
+func (c *watCompiler) index(node watNode, op watOpcode,
+	cursor *watCursor) (uint32, error) {
+	asm := c.asm
+
+	var space *watSpace
+	optional := false
+	if (op.opcode == PrefixMisc) {
+		switch(op.subopcode) {
+			case 9:				space = asm.data			// data.drop
+			case 13:			space = asm.elements		// elem.drop
+			case 15, 16, 17:	space, optional = asm.tables, true
+		}
+	} else {
+		switch(op.opcode) {
+			case 0x0C, 0x0D:
+				if (cursor.done()) {
+					return 0, cursor.errorf("missing label")
+				}
+				return c.label(cursor.next())
+			case 0x10, 0x12, 0xD2:	space = asm.functions
+			case 0x20, 0x21, 0x22:	return c.local(cursor)
+			case 0x23, 0x24:		space = asm.globals
+			case 0x25, 0x26:		space, optional = asm.tables, true
+		}
+	}
+	if (space == nil) {
+		return 0, node.errorf("unsupported instruction %s", node.token.text)
+	}
+
+	index, ok, err := space.optional(cursor)
+	if (err == nil && !ok && !optional) {
+		return 0, cursor.errorf("missing %s index", space.kind)
+	}
+	return index, err
+}
+
+// Pair of index immediates: call_indirect, table.init + table.copy
+func (c *watCompiler) index2(node watNode, op watOpcode,
+	cursor *watCursor) ([]byte, error) {
+	asm := c.asm
+
+	switch {
+		case op.opcode == 0x11 || op.opcode == 0x13:
+			// call_indirect table? typeuse
+			table, _, err := asm.tables.optional(cursor)
+			if (err != nil) {
+				return nil, err
+			}
+			tindex, _, err := asm.typeUse(cursor)
+			if (err != nil) {
+				return nil, err
+			}
+			return appendULEB128(appendULEB128(nil, uint64(tindex)),
+				uint64(table)), nil
+
+		case op.opcode == PrefixMisc && op.subopcode == 12:
+			// table.init table? elem
+			first, ok, err := asm.elements.optional(cursor)
+			if (err != nil || !ok) {
+				return nil, cursor.errorf("missing elem index")
+			}
+			table := uint32(0)
+			if (!cursor.done() && isWatReference(cursor.peek())) {
+				cursor.pos--
+				table, err = asm.tables.resolve(cursor.next())
+				if (err != nil) {
+					return nil, err
+				}
+				first, err = asm.elements.resolve(cursor.next())
+			}
+			return appendULEB128(appendULEB128(nil, uint64(first)),
+				uint64(table)), err
+
+		case op.opcode == PrefixMisc && op.subopcode == 14:
+			// table.copy (dst src)?
+			dst, ok, err := asm.tables.optional(cursor)
+			if (err != nil) {
+				return nil, err
+			}
+			src := uint32(0)
+			if (ok) {
+				if (cursor.done()) {
+					return nil, cursor.errorf("missing source table")
+				}
+				src, err = asm.tables.resolve(cursor.next())
+			}
+			return appendULEB128(appendULEB128(nil, uint64(dst)),
+				uint64(src)), err
+	}
+
+	return nil, node.errorf("unsupported instruction %s", node.token.text)
+}
+
+// Local index, by $identifier or index
+func (c *watCompiler) local(cursor *watCursor) (uint32, error) {
+	if (cursor.done()) {
+		return 0, cursor.errorf("missing local index")
+	}
+	node := cursor.next()
+	if (!node.list && node.token.kind == watTokenId) {
+		index, ok := c.locals[node.token.text]
+		if !ok {
+			return 0, node.errorf("unknown local %s", node.token.text)
+		}
+		return index, nil
+	}
+	return parseWatU32(node)
+}
+
+// Branch target: label $identifier (innermost first), or relative depth
+func (c *watCompiler) label(node watNode) (uint32, error) {
+	if (!node.list && node.token.kind == watTokenId) {
+		for i := len(c.labels) - 1; i >= 0; i-- {
+			if (c.labels[i] == node.token.text) {
+				return uint32(len(c.labels) - 1 - i), nil
+			}
+		}
+		return 0, node.errorf("unknown label %s", node.token.text)
+	}
+	return parseWatU32(node)
+}
+
+// Block type: "(type x)", "(param ...)* (result ...)*", or nothing
+func (c *watCompiler) blockType(cursor *watCursor) ([]byte, error) {
+	if (!cursor.done() && cursor.peek().isList("type")) {
+		tindex, _, err := c.asm.typeUse(cursor)
+		return appendSLEB128(nil, int64(tindex)), err
+	}
+
+	position := cursor.pos
+	ftype, ids, err := parseWatSignature(cursor)
+	if (err != nil) {
+		return nil, err
+	}
+	if (len(ids) > 0) {
+		return nil, cursor.nodes[position].errorf(
+			"unexpected named block parameter")
+	}
+
+	switch {
+		case len(ftype.parameter) == 0 && len(ftype.result) == 0:
+			return []byte{ 0x40 }, nil
+		case len(ftype.parameter) == 0 && len(ftype.result) == 1:
+			return []byte{ byte(ftype.result[0]) }, nil
+	}
+	return appendSLEB128(nil, int64(c.asm.typeIndex(ftype))), nil
+}
+
+// Memory argument: "offset=N"? "align=N"?.  Alignment defaults to the
+// natural alignment of the access
+func (c *watCompiler) memarg(node watNode, cursor *watCursor) ([]byte,
+	error) {
+	offset := uint64(0)
+	align := watNaturalAlignment(node.token.text)
+
+	if (!cursor.done() && !cursor.peek().list &&
+		strings.HasPrefix(cursor.peek().token.text, "offset=")) {
+		token := cursor.next().token
+		value, err := parseWatUnsigned(token.text[len("offset="):], 32)
+		if (err != nil) {
+			return nil, token.errorf("invalid offset %s", token.text)
+		}
+		offset = value
+	}
+	if (!cursor.done() && !cursor.peek().list &&
+		strings.HasPrefix(cursor.peek().token.text, "align=")) {
+		token := cursor.next().token
+		value, err := parseWatUnsigned(token.text[len("align="):], 32)
+		if (err != nil || value == 0 || value & (value - 1) != 0) {
+			return nil, token.errorf("invalid alignment %s", token.text)
+		}
+		align = value
+	}
+
+	// Alignment is encoded as its base-2 logarithm
+	log2 := uint64(0)
+	for (uint64(1) << log2) < align {
+		log2++
+	}
+	return appendULEB128(appendULEB128(nil, log2), offset), nil
+}
+
+// Natural alignment of a memory access, in bytes, derived from its mnemonic
+func watNaturalAlignment(name string) uint64 {
+	if op, ok := watOpcodes[name]; ok && op.opcode != PrefixSIMD {
+		if access, ok := memoryAccess[op.opcode]; ok {
+			return uint64(access.width)
+		}
+	}
+
+	// SIMD loads + stores, e.g. "v128.load8x8_s", "v128.load32_splat"
+	switch {
+		case strings.Contains(name, "x"):
+			return 8
+		case strings.Contains(name, "load8_"), strings.Contains(name, "store8_"):
+			return 1
+		case strings.Contains(name, "load16_"), strings.Contains(name, "store16_"):
+			return 2
+		case strings.Contains(name, "load32_"), strings.Contains(name, "store32_"):
+			return 4
+		case strings.Contains(name, "load64_"), strings.Contains(name, "store64_"):
+			return 8
+	}
+	return 16
+}
+
+// Single SIMD lane index
+func (c *watCompiler) lane(cursor *watCursor) (uint8, error) {
+	if (cursor.done()) {
+		return 0, cursor.errorf("missing lane index")
+	}
+	node := cursor.next()
+	value, err := parseWatU32(node)
+	if (err == nil && value > 0xFF) {
+		return 0, node.errorf("invalid lane index %d", value)
+	}
+	return uint8(value), err
+}
+
+
+//
+// Literals
+//
+
+// Unsigned 32-bit index or limit
+func parseWatU32(node watNode) (uint32, error) {
+	if (node.list || node.token.kind != watTokenKeyword) {
+		return 0, node.errorf("expected number, found %s", node)
+	}
+	value, err := parseWatUnsigned(node.token.text, 32)
+	if (err != nil) {
+		return 0, node.errorf("invalid number %s", node.token.text)
+	}
+	return uint32(value), nil
+}
+
+// Unsigned integer, decimal or hex, with optional "_" separators
+func parseWatUnsigned(text string, bits int) (uint64, error) {
+	if (strings.HasPrefix(text, "_") || strings.HasSuffix(text, "_") ||
+		strings.Contains(text, "__")) {
+		return 0, strconv.ErrSyntax
+	}
+	text = strings.ReplaceAll(text, "_", "")
+	if (strings.HasPrefix(text, "0x")) {
+		return strconv.ParseUint(text[2:], 16, bits)
+	}
+	if (text == "" || text[0] < '0' || text[0] > '9') {
+		return 0, strconv.ErrSyntax
+	}
+	return strconv.ParseUint(text, 10, bits)
+}
+
+//
+// Integer constant: signed or unsigned, in the range of the given bit width.
+// Returns the two's complement bit pattern
+//
+func parseWatInteger(node watNode, bits int) (uint64, error) {
+	if (node.list || node.token.kind != watTokenKeyword) {
+		return 0, node.errorf("expected integer, found %s", node)
+	}
+	text := node.token.text
+	negative := strings.HasPrefix(text, "-")
+	text = strings.TrimLeft(text, "+-")
+
+	magnitude, err := parseWatUnsigned(text, bits)
+	if (err != nil) {
+		return 0, node.errorf("invalid integer %s", node.token.text)
+	}
+	if (negative) {
+		if (magnitude > uint64(1) << (bits - 1)) {
+			return 0, node.errorf("integer out of range %s", node.token.text)
+		}
+		return -magnitude, nil
+	}
+	return magnitude, nil
+}
+
+//
+// Floating-point constant: decimal, hex, "inf", "nan" or "nan:0x...".
+// Returns the IEEE-754 bit pattern of the given width
+//
+func parseWatFloat(node watNode, bits int) (uint64, error) {
+	if (node.list || node.token.kind != watTokenKeyword) {
+		return 0, node.errorf("expected float, found %s", node)
+	}
+	text := node.token.text
+	negative := strings.HasPrefix(text, "-")
+	text = strings.TrimLeft(text, "+-")
+
+	mantissaBits := 52
+	if (bits == 32) {
+		mantissaBits = 23
+	}
+	exponent := uint64(1) << (bits - 1) - (uint64(1) << mantissaBits)
+	sign := uint64(0)
+	if (negative) {
+		sign = uint64(1) << (bits - 1)
+	}
+
+	switch {
+		case text == "inf":
+			return sign | exponent, nil
+
+		case text == "nan":
+			return sign | exponent | (uint64(1) << (mantissaBits - 1)), nil
+
+		case strings.HasPrefix(text, "nan:0x"):
+			payload, err := parseWatUnsigned(text[len("nan:"):], 64)
+			if (err != nil || payload == 0 ||
+				payload >= uint64(1) << mantissaBits) {
+				return 0, node.errorf("invalid NaN payload %s", node.token.text)
+			}
+			return sign | exponent | payload, nil
+	}
+
+	if (strings.HasPrefix(text, "_") || strings.Contains(text, "__")) {
+		return 0, node.errorf("invalid float %s", node.token.text)
+	}
+	text = strings.ReplaceAll(text, "_", "")
+	if (strings.HasPrefix(text, "0x") && !strings.ContainsAny(text, "pP")) {
+		// Go requires an explicit binary exponent for hex floats
+		text += "p0"
+	}
+	if (text == "" || (text[0] < '0' || text[0] > '9')) {
+		return 0, node.errorf("invalid float %s", node.token.text)
+	}
+
+	value, err := strconv.ParseFloat(text, bits)
+	if (err != nil) {
+		return 0, node.errorf("invalid float %s", node.token.text)
+	}
+	if (negative) {
+		value = -value
+	}
+	if (bits == 32) {
+		return uint64(math.Float32bits(float32(value))), nil
+	}
+	return math.Float64bits(value), nil
+}
+
+// v128 constant: shape, then one literal per lane, e.g. "i32x4 1 2 3 4"
+func parseWatV128(cursor *watCursor) ([]byte, error) {
+	shape, ok := cursor.atom(watTokenKeyword)
+	if !ok {
+		return nil, cursor.errorf("missing v128 shape")
+	}
+
+	var lanes, width int
+	float := false
+	switch(shape.text) {
+		case "i8x16":	lanes, width = 16, 1
+		case "i16x8":	lanes, width = 8, 2
+		case "i32x4":	lanes, width = 4, 4
+		case "i64x2":	lanes, width = 2, 8
+		case "f32x4":	lanes, width, float = 4, 4, true
+		case "f64x2":	lanes, width, float = 2, 8, true
+		default:
+			return nil, shape.errorf("unknown v128 shape %s", shape.text)
+	}
+
+	immediates := make([]byte, 16)
+	for i := 0; i < lanes; i++ {
+		if (cursor.done()) {
+			return nil, cursor.errorf("missing v128 lane")
+		}
+		var value uint64
+		var err error
+		if (float) {
+			value, err = parseWatFloat(cursor.next(), width * 8)
+		} else {
+			value, err = parseWatInteger(cursor.next(), width * 8)
+		}
+		if (err != nil) {
+			return nil, err
+		}
+		for j := 0; j < width; j++ {
+			immediates[i * width + j] = byte(value >> (8 * j))
+		}
+	}
+	return immediates, nil
+}
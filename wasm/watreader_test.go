@@ -0,0 +1,254 @@
+package wasm
+
+import(
+	"bytes"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	)
+
+
+//
+// Test assembly of the sample sources.  Each should match the binary
+// encoding generated by wat2wasm
+//
+func TestReadWATSamples(t *testing.T) {
+	for name, expected := range fuzzSeeds {
+		t.Run(name, func(t *testing.T) {
+			source, err := os.ReadFile(filepath.Join("..", "samples", name))
+			if (err != nil) {
+				t.Skip("Sample unavailable: ", err)
+			}
+			module, err := ReadWAT(bytes.NewReader(source))
+			if (err != nil) {
+				t.Fatal("Unexpected assembly error: ", err)
+			}
+			encoded := encodeModule(t, module)
+			if (!bytes.Equal(encoded, expected)) {
+				t.Errorf("Unexpected encoding:\n% x\n(expected)\n% x", encoded,
+					expected)
+			}
+		})
+	}
+
+	// Legacy syntax: anyfunc, inline data, explicit types after imports, etc
+	source, err := os.ReadFile(filepath.Join("..", "samples", "stuff.wat"))
+	if (err != nil) {
+		t.Skip("Sample unavailable: ", err)
+	}
+	module, err := ReadWAT(bytes.NewReader(source))
+	if (err != nil) {
+		t.Fatal("Unexpected assembly error: ", err)
+	}
+	err = module.Validate()
+	if (err != nil) {
+		t.Error("Unexpected validation error: ", err)
+	}
+}
+
+func encodeModule(t *testing.T, module Module) []byte {
+	var encoded bytes.Buffer
+	_, err := module.WriteTo(&encoded)
+	if (err != nil) {
+		t.Fatal("Unexpected encoding error: ", err)
+	}
+	return encoded.Bytes()
+}
+
+
+//
+// Test that the WAT output of a module assembles back to the same binary
+// encoding, in both flat + folded styles
+//
+func TestWATRoundTrip(t *testing.T) {
+	module := watSampleModule(t)
+	expected := encodeModule(t, module)
+
+	for _, style := range []WATStyle{ WATFlat, WATFolded } {
+		var source bytes.Buffer
+		err := module.WriteWAT(&source, style)
+		if (err != nil) {
+			t.Fatal("Unexpected WAT error: ", err)
+		}
+
+		assembled, err := ReadWATWithOptions(&source,
+			WATOptions{ DebugNames: true })
+		if (err != nil) {
+			t.Fatalf("Unexpected assembly error (style %d): %s", style, err)
+		}
+		encoded := encodeModule(t, assembled)
+		if (!bytes.Equal(encoded, expected)) {
+			t.Errorf("Unexpected encoding (style %d):\n% x\n(expected)\n% x",
+				style, encoded, expected)
+		}
+	}
+}
+
+
+//
+// Test assembly of individual instructions, including folded forms, labels
+// + numeric literals
+//
+func TestWATAssembly(t *testing.T) {
+	testCases := []struct{
+		name		string
+		source		string
+		encoded		[]byte
+	}{
+		{ "plain",			"i32.const 1 i32.const 2 i32.add",
+							[]byte{ 0x41, 0x01, 0x41, 0x02, 0x6A } },
+		{ "folded",			"(i32.add (i32.const 1) (i32.const 2))",
+							[]byte{ 0x41, 0x01, 0x41, 0x02, 0x6A } },
+		{ "local-id",		"local.get $x local.get 1",
+							[]byte{ 0x20, 0x00, 0x20, 0x01 } },
+		{ "label",			"(block $out (loop $top br $out br $top br 0))",
+							[]byte{ 0x02, 0x40, 0x03, 0x40,
+								0x0C, 0x01, 0x0C, 0x00, 0x0C, 0x00,
+								0x0B, 0x0B } },
+		{ "if-folded",		"(if (result i32) (local.get 0) " +
+								"(then (i32.const 1)) (else (i32.const 2)))",
+							[]byte{ 0x20, 0x00, 0x04, 0x7F, 0x41, 0x01,
+								0x05, 0x41, 0x02, 0x0B } },
+		{ "i32-hex",		"i32.const 0xFFFF_FFFF",	[]byte{ 0x41, 0x7F } },
+		{ "i32-negative",	"i32.const -0x80000000",
+							[]byte{ 0x41, 0x80, 0x80, 0x80, 0x80, 0x78 } },
+		{ "i64",			"i64.const 1_000",	[]byte{ 0x42, 0xE8, 0x07 } },
+		{ "f32-hex",		"f32.const 0x1.8p1",
+							[]byte{ 0x43, 0x00, 0x00, 0x40, 0x40 } },
+		{ "f32-neg-inf",	"f32.const -inf",
+							[]byte{ 0x43, 0x00, 0x00, 0x80, 0xFF } },
+		{ "f32-nan",		"f32.const nan",
+							[]byte{ 0x43, 0x00, 0x00, 0xC0, 0x7F } },
+		{ "f64-nan-payload",	"f64.const nan:0x1",
+							[]byte{ 0x44, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00,
+								0xF0, 0x7F } },
+		{ "memarg",			"i32.load offset=16 align=2",
+							[]byte{ 0x28, 0x01, 0x10 } },
+		{ "memarg-natural",	"i64.store",	[]byte{ 0x37, 0x03, 0x00 } },
+		{ "select-typed",	"select (result i32)",
+							[]byte{ 0x1C, 0x01, 0x7F } },
+		{ "prefixed",		"i32.trunc_sat_f32_s",	[]byte{ 0xFC, 0x00 } },
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			source := "(memory 1) (func (param $x i32) (param i32) " +
+				test.source + " drop)"
+			module, err := ReadWAT(strings.NewReader(source))
+			if (err != nil) {
+				t.Fatal("Unexpected assembly error: ", err)
+			}
+			code := module.section(CodeSectionId).(CodeSection)
+			body := code.function[0].body
+			expected := append(append([]byte{}, test.encoded...), 0x1A, 0x0B)
+			if (!bytes.Equal(body, expected)) {
+				t.Errorf("Unexpected encoding: % x (expected % x)", body,
+					expected)
+			}
+		})
+	}
+}
+
+
+//
+// Test parsing of float literals, including the WAT-specific forms
+//
+func TestWATFloatLiterals(t *testing.T) {
+	testCases := []struct{
+		literal		string
+		expected	float64
+	}{
+		{ "1.5",		1.5 },
+		{ "-0x1p-1",	-0.5 },
+		{ "0x10",		16 },
+		{ "1e3",		1000 },
+		{ "1_000.5",	1000.5 },
+		{ "inf",		math.Inf(1) },
+		{ "+inf",		math.Inf(1) },
+	}
+
+	for _, test := range testCases {
+		t.Run(test.literal, func(t *testing.T) {
+			token := watToken{ watTokenKeyword, test.literal, 1, 1 }
+			bits, err := parseWatFloat(watNode{ token: token }, 64)
+			if (err != nil) {
+				t.Fatal("Unexpected parsing error: ", err)
+			}
+			if (math.Float64frombits(bits) != test.expected) {
+				t.Errorf("Unexpected value %v (expected %v)",
+					math.Float64frombits(bits), test.expected)
+			}
+		})
+	}
+}
+
+
+//
+// Test that malformed sources are rejected, at the position of the
+// offending token
+//
+func TestMalformedWAT(t *testing.T) {
+	testCases := []struct{
+		name		string
+		source		string
+		line		int
+		column		int
+	}{
+		{ "unmatched",		"(module\n  (func)",					1, 1 },
+		{ "unexpected",		"(module))",							1, 9 },
+		{ "bad-string",		"(module (data \"\\zz\"))",				1, 15 },
+		{ "unknown-field",	"(module\n  (bogus))",					2, 3 },
+		{ "unknown-instr",	"(func\n  i32.bogus)",					2, 3 },
+		{ "undefined-id",	"(func call $missing)",					1, 12 },
+		{ "duplicate-id",	"(func $f) (func $f)",					1, 11 },
+		{ "i32-range",		"(func i32.const 0x1_0000_0000 drop)",	1, 17 },
+		{ "bad-label",		"(func br $missing)",					1, 10 },
+		{ "late-import",
+		  "(func) (import \"a\" \"b\" (func))",						1, 24 },
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ReadWAT(strings.NewReader(test.source))
+			if (!errors.Is(err, InvalidWAT)) {
+				t.Fatal("Unexpected assembly status: ", err)
+			}
+			var watErr WATError
+			if (!errors.As(err, &watErr) || watErr.Line != test.line ||
+				watErr.Column != test.column) {
+				t.Errorf("Unexpected error position: %s", err)
+			}
+		})
+	}
+}
+
+
+func TestUnsupportedWAT(t *testing.T) {
+	testCases := []struct{
+		name		string
+		source		string
+		unsupported	bool
+	}{
+		{ "atomics",		"(func i32.atomic.rmw.add)",	true },
+		{ "memory64",		"(memory i64 1)",				true },
+		{ "shared",			"(memory 1 2 shared)",			true },
+		{ "gc-type",		"(type (struct))",				true },
+		{ "unknown-instr",	"(func i32.bogus)",				false },
+		{ "unknown-field",	"(bogus)",						false },
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ReadWAT(strings.NewReader(test.source))
+			if (!errors.Is(err, InvalidWAT)) {
+				t.Fatal("Unexpected assembly status: ", err)
+			}
+			if (errors.Is(err, UnsupportedWAT) != test.unsupported) {
+				t.Errorf("Unexpected unsupported status: %s", err)
+			}
+		})
+	}
+}